@@ -1,15 +1,25 @@
 package authen
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"time"
 
 	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
 	"github.com/seenark/super-backend-temp/config"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
+const RefreshTokenLifetime = 7 * 24 * time.Hour
+
+// ACCESS_TOKEN_TYPE is the typ header of an access token
+const ACCESS_TOKEN_TYPE = "JWT"
+
 type TokenData struct {
 	Token string `json:"token"`
 	Exp   int64  `json:"exp"`
@@ -25,22 +35,52 @@ func VerifyPassword(hashed string, password string) bool {
 	return err == nil
 }
 
-func GenerateJWT(id string, email string, metamask string, role string, expire int64) (tokenData *TokenData, err error) {
-	claim := jwt.MapClaims{}
-	claim["id"] = id
-	claim["email"] = email
-	claim["metamask_address"] = metamask
-	claim["role"] = role
-	exp := time.Now().Add(1 * 24 * time.Hour).Unix()
+// Claims is the payload of an access token
+type Claims struct {
+	Email           string `json:"email"`
+	MetamaskAddress string `json:"metamask_address"` // only a wallet proven with SIWE
+	Role            string `json:"role"`
+	SessionId       string `json:"sid,omitempty"`
+	SuperAdmin      bool   `json:"super_admin,omitempty"`
+	jwt.StandardClaims
+}
+
+// RefreshClaims is the payload of a refresh token. Id (jti) changes on every
+// rotation so a used refresh token can be detected.
+type RefreshClaims struct {
+	Email       string `json:"email"`
+	AccessToken string `json:"jwt"`
+	SessionId   string `json:"sid"`
+	jwt.StandardClaims
+}
+
+func GenerateJWT(id string, email string, metamask string, role string, superAdmin bool, sessionId string, expire int64) (tokenData *TokenData, err error) {
+	cfg := config.GetConfig()
+	now := time.Now()
+	exp := now.Add(1 * 24 * time.Hour).Unix()
 	if expire > 0 {
 		exp = expire
 	}
-	// exp := time.Now().Add(5 * time.Second).Unix()
-	claim["exp"] = exp
-	at := jwt.NewWithClaims(jwt.SigningMethodHS256, claim)
-	cfg := config.GetConfig()
-	fmt.Printf("cfg: %v\n", cfg.App.SecretKey)
-	token, err := at.SignedString([]byte(cfg.App.SecretKey))
+	claims := Claims{
+		Email:           email,
+		MetamaskAddress: metamask,
+		Role:            role,
+		SessionId:       sessionId,
+		SuperAdmin:      superAdmin,
+		StandardClaims: jwt.StandardClaims{
+			Subject:   id,
+			Id:        uuid.NewString(),
+			IssuedAt:  now.Unix(),
+			ExpiresAt: exp,
+			Issuer:    cfg.App.Issuer,
+			Audience:  cfg.App.Audience,
+		},
+	}
+	keys, err := GetKeySet()
+	if err != nil {
+		return nil, err
+	}
+	token, err := keys.Sign(claims)
 	if err != nil {
 		return nil, err
 	}
@@ -50,15 +90,26 @@ func GenerateJWT(id string, email string, metamask string, role string, expire i
 	}, nil
 }
 
-func GenerateRefreshJWT(id string, email string, jwtToken string) (*TokenData, error) {
+// GenerateRefreshJWT signs a refresh token for session sessionId. Refresh
+// tokens are only read by this server so they stay HS256.
+func GenerateRefreshJWT(id string, email string, jwtToken string, sessionId string, tokenId string) (*TokenData, error) {
 	cfg := config.GetConfig()
-	claim := jwt.MapClaims{}
-	claim["id"] = id
-	claim["email"] = email
-	claim["jwt"] = jwtToken
-	exp := time.Now().Add(7 * 24 * time.Hour).Unix()
-	claim["exp"] = exp
-	at := jwt.NewWithClaims(jwt.SigningMethodHS256, claim)
+	now := time.Now()
+	exp := now.Add(RefreshTokenLifetime).Unix()
+	claims := RefreshClaims{
+		Email:       email,
+		AccessToken: jwtToken,
+		SessionId:   sessionId,
+		StandardClaims: jwt.StandardClaims{
+			Subject:   id,
+			Id:        tokenId,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: exp,
+			Issuer:    cfg.App.Issuer,
+			Audience:  cfg.App.Audience,
+		},
+	}
+	at := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	token, err := at.SignedString([]byte(cfg.App.Refresh))
 	if err != nil {
 		return nil, err
@@ -69,38 +120,91 @@ func GenerateRefreshJWT(id string, email string, jwtToken string) (*TokenData, e
 	}, nil
 }
 
-func ValidateJWT(jwtToken string) (jwt.MapClaims, error) {
-	token, err := jwt.Parse(jwtToken, func(t *jwt.Token) (interface{}, error) {
-		cfg := config.GetConfig()
-		return []byte(cfg.App.SecretKey), nil
-	})
-
+func ValidateJWT(jwtToken string) (*Claims, error) {
+	cfg := config.GetConfig()
+	keys, err := GetKeySet()
 	if err != nil {
 		return nil, err
 	}
-
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !token.Valid || !ok {
-		return claims, fmt.Errorf("jwt token invalid")
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(jwtToken, claims, keys.Keyfunc)
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid || token.Header["typ"] != ACCESS_TOKEN_TYPE {
+		return nil, fmt.Errorf("jwt token invalid")
+	}
+	err = verifyIssuerAndAudience(&claims.StandardClaims, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("jwt token has no subject")
 	}
 	return claims, nil
 }
 
-func ValidateRefreshJWT(refreshToken string) (jwt.MapClaims, error) {
-	token, err := jwt.Parse(refreshToken, func(t *jwt.Token) (interface{}, error) {
-		cfg := config.GetConfig()
-		return []byte(cfg.App.Refresh), nil
-	})
-
+func ValidateRefreshJWT(refreshToken string) (*RefreshClaims, error) {
+	cfg := config.GetConfig()
+	claims := &RefreshClaims{}
+	err := parseHS256(refreshToken, claims, cfg.App.Refresh)
 	if err != nil {
 		return nil, err
 	}
-	if !token.Valid {
-		return nil, fmt.Errorf("jwt token invalid")
+	err = verifyIssuerAndAudience(&claims.StandardClaims, cfg)
+	if err != nil {
+		return nil, err
 	}
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
+	if claims.Subject == "" || claims.Id == "" || claims.SessionId == "" {
 		return nil, fmt.Errorf("jwt token invalid")
 	}
 	return claims, nil
 }
+
+// parseHS256 only accepts HS256 so a token cannot pick its own algorithm
+func parseHS256(tokenString string, claims jwt.Claims, secret string) error {
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
+		if t.Method != jwt.SigningMethodHS256 {
+			return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+		}
+		return []byte(secret), nil
+	})
+	if err != nil {
+		return err
+	}
+	if !token.Valid {
+		return fmt.Errorf("jwt token invalid")
+	}
+	return nil
+}
+
+func verifyIssuerAndAudience(claims *jwt.StandardClaims, cfg config.Configuration) error {
+	if !claims.VerifyIssuer(cfg.App.Issuer, true) {
+		return fmt.Errorf("jwt token issuer invalid")
+	}
+	if !claims.VerifyAudience(cfg.App.Audience, true) {
+		return fmt.Errorf("jwt token audience invalid")
+	}
+	return nil
+}
+
+const apiKeyPrefix = "sbk_"
+
+// GenerateApiKey returns a new random API key and the short prefix shown to
+// admins so they can tell keys apart. Only the hash of the key is stored.
+func GenerateApiKey() (key string, prefix string, err error) {
+	secret := make([]byte, 32)
+	_, err = rand.Read(secret)
+	if err != nil {
+		return "", "", err
+	}
+	key = apiKeyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+	return key, key[:len(apiKeyPrefix)+8], nil
+}
+
+// HashApiKey hashes an API key for lookup. API keys are long random strings
+// so a fast hash is enough, unlike passwords.
+func HashApiKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,248 @@
+package authen
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
+	"github.com/seenark/super-backend-temp/config"
+)
+
+const (
+	RS256_ALG = "RS256"
+	EDDSA_ALG = "EdDSA"
+)
+
+// signingKey is one entry of the key set. Retired keys have no private key,
+// they are only kept to verify tokens issued before the rotation.
+type signingKey struct {
+	kid      string
+	alg      string
+	method   jwt.SigningMethod
+	private  crypto.PrivateKey
+	public   crypto.PublicKey
+	notAfter time.Time
+}
+
+func (k *signingKey) usable(now time.Time) bool {
+	return k.notAfter.IsZero() || now.Before(k.notAfter)
+}
+
+// KeySet holds the keys access tokens are signed and verified with
+type KeySet struct {
+	signing *signingKey
+	keys    map[string]*signingKey
+	order   []string
+}
+
+// JWK is a public key in JSON Web Key format (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+var (
+	keySet     *KeySet
+	keySetErr  error
+	keySetOnce sync.Once
+)
+
+// GetKeySet loads the key set from config once
+func GetKeySet() (*KeySet, error) {
+	keySetOnce.Do(func() {
+		cfg := config.GetConfig()
+		keySet, keySetErr = LoadKeySet(cfg.Jwt, cfg.Environment == "production")
+	})
+	return keySet, keySetErr
+}
+
+// LoadKeySet builds the key set from config. Without configured keys an
+// ephemeral Ed25519 key is generated unless required is true.
+func LoadKeySet(cfg config.JwtConfig, required bool) (*KeySet, error) {
+	set := &KeySet{keys: map[string]*signingKey{}}
+	for _, keyCfg := range cfg.Keys {
+		key, err := loadKey(keyCfg)
+		if err != nil {
+			return nil, fmt.Errorf("jwt key %s: %v", keyCfg.Kid, err)
+		}
+		if _, ok := set.keys[key.kid]; ok {
+			return nil, fmt.Errorf("jwt key %s: duplicate kid", key.kid)
+		}
+		set.keys[key.kid] = key
+		set.order = append(set.order, key.kid)
+	}
+
+	if len(set.keys) == 0 {
+		if required {
+			return nil, fmt.Errorf("no jwt signing key configured")
+		}
+		public, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		kid := "ephemeral-" + uuid.NewString()
+		fmt.Printf("no jwt key configured, using ephemeral key %s\n", kid)
+		set.keys[kid] = &signingKey{
+			kid:     kid,
+			alg:     EDDSA_ALG,
+			method:  jwt.SigningMethodEdDSA,
+			private: private,
+			public:  public,
+		}
+		set.order = append(set.order, kid)
+		set.signing = set.keys[kid]
+		return set, nil
+	}
+
+	signingKid := cfg.SigningKey
+	if signingKid == "" {
+		signingKid = set.order[0]
+	}
+	signing, ok := set.keys[signingKid]
+	if !ok || signing.private == nil {
+		return nil, fmt.Errorf("jwt signing key %s has no private key", signingKid)
+	}
+	// its tokens would not verify, not even here
+	if !signing.usable(time.Now()) {
+		return nil, fmt.Errorf("jwt signing key %s expired at %s", signingKid, signing.notAfter.Format(time.RFC3339))
+	}
+	set.signing = signing
+	return set, nil
+}
+
+func loadKey(keyCfg config.JwtKeyConfig) (*signingKey, error) {
+	if keyCfg.Kid == "" {
+		return nil, fmt.Errorf("kid is empty")
+	}
+	key := &signingKey{kid: keyCfg.Kid, alg: keyCfg.Alg}
+	if keyCfg.NotAfter > 0 {
+		key.notAfter = time.Unix(keyCfg.NotAfter, 0)
+	}
+
+	privatePem, err := pemFromConfig(keyCfg.PrivateKey, keyCfg.PrivateKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	publicPem, err := pemFromConfig(keyCfg.PublicKey, keyCfg.PublicKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	if privatePem == nil && publicPem == nil {
+		return nil, fmt.Errorf("no private or public key")
+	}
+
+	switch keyCfg.Alg {
+	case RS256_ALG:
+		key.method = jwt.SigningMethodRS256
+		if privatePem != nil {
+			private, err := jwt.ParseRSAPrivateKeyFromPEM(privatePem)
+			if err != nil {
+				return nil, err
+			}
+			key.private = private
+			key.public = &private.PublicKey
+		} else {
+			key.public, err = jwt.ParseRSAPublicKeyFromPEM(publicPem)
+		}
+	case EDDSA_ALG:
+		key.method = jwt.SigningMethodEdDSA
+		if privatePem != nil {
+			private, err := jwt.ParseEdPrivateKeyFromPEM(privatePem)
+			if err != nil {
+				return nil, err
+			}
+			key.private = private
+			key.public = private.(ed25519.PrivateKey).Public()
+		} else {
+			key.public, err = jwt.ParseEdPublicKeyFromPEM(publicPem)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported alg %q, use %s or %s", keyCfg.Alg, RS256_ALG, EDDSA_ALG)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func pemFromConfig(inline string, file string) ([]byte, error) {
+	if inline != "" {
+		return []byte(inline), nil
+	}
+	if file != "" {
+		return ioutil.ReadFile(file)
+	}
+	return nil, nil
+}
+
+// Sign signs claims with the current signing key and sets the kid header
+func (k *KeySet) Sign(claims jwt.Claims) (string, error) {
+	return k.sign(claims, ACCESS_TOKEN_TYPE)
+}
+
+// sign is Sign with the typ header telling what kind of token it is
+func (k *KeySet) sign(claims jwt.Claims, typ string) (string, error) {
+	token := jwt.NewWithClaims(k.signing.method, claims)
+	token.Header["kid"] = k.signing.kid
+	token.Header["typ"] = typ
+	return token.SignedString(k.signing.private)
+}
+
+// Keyfunc picks the verification key by kid and refuses any other algorithm
+func (k *KeySet) Keyfunc(t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header["kid"].(string)
+	key, ok := k.keys[kid]
+	if !ok || !key.usable(time.Now()) {
+		return nil, fmt.Errorf("unknown jwt key %q", kid)
+	}
+	if t.Method != key.method {
+		return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+	}
+	return key.public, nil
+}
+
+// JWKS returns the public part of every usable key
+func (k *KeySet) JWKS() JWKS {
+	jwks := JWKS{Keys: []JWK{}}
+	now := time.Now()
+	for _, kid := range k.order {
+		key := k.keys[kid]
+		if !key.usable(now) {
+			continue
+		}
+		jwk := JWK{Kid: key.kid, Use: "sig", Alg: key.alg}
+		switch public := key.public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		default:
+			continue
+		}
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+	return jwks
+}
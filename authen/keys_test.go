@@ -0,0 +1,179 @@
+package authen_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/seenark/super-backend-temp/authen"
+	"github.com/seenark/super-backend-temp/config"
+)
+
+func pemOf(t *testing.T, typ string, der []byte, err error) string {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}))
+}
+
+func edKey(t *testing.T, kid string, notAfter int64) (config.JwtKeyConfig, ed25519.PublicKey) {
+	t.Helper()
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	return config.JwtKeyConfig{Kid: kid, Alg: authen.EDDSA_ALG, PrivateKey: pemOf(t, "PRIVATE KEY", der, err), NotAfter: notAfter}, public
+}
+
+func rsaKey(t *testing.T, kid string) (config.JwtKeyConfig, *rsa.PublicKey) {
+	t.Helper()
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der := x509.MarshalPKCS1PrivateKey(private)
+	return config.JwtKeyConfig{Kid: kid, Alg: authen.RS256_ALG, PrivateKey: pemOf(t, "RSA PRIVATE KEY", der, nil)}, &private.PublicKey
+}
+
+// publicOnly is a retired key, only its public key is still configured
+func publicOnly(t *testing.T, key config.JwtKeyConfig, public interface{}) config.JwtKeyConfig {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(public)
+	key.PrivateKey = ""
+	key.PublicKey = pemOf(t, "PUBLIC KEY", der, err)
+	return key
+}
+
+func sign(t *testing.T, keys *authen.KeySet) string {
+	t.Helper()
+	token, err := keys.Sign(jwt.StandardClaims{Subject: "user", ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func verify(keys *authen.KeySet, token string) error {
+	_, err := jwt.Parse(token, keys.Keyfunc)
+	return err
+}
+
+func TestLoadKeySet(t *testing.T) {
+	past := time.Now().Add(-time.Hour).Unix()
+	future := time.Now().Add(time.Hour).Unix()
+	current, _ := edKey(t, "current", 0)
+	expired, _ := edKey(t, "expired", past)
+	retiring, _ := edKey(t, "retiring", future)
+	tests := []struct {
+		name    string
+		cfg     config.JwtConfig
+		wantErr string
+	}{
+		{"first key signs", config.JwtConfig{Keys: []config.JwtKeyConfig{current, expired}}, ""},
+		{"configured signing key", config.JwtConfig{SigningKey: "retiring", Keys: []config.JwtKeyConfig{current, retiring}}, ""},
+		{"expired signing key", config.JwtConfig{SigningKey: "expired", Keys: []config.JwtKeyConfig{current, expired}}, "expired"},
+		{"expired first key", config.JwtConfig{Keys: []config.JwtKeyConfig{expired, current}}, "expired"},
+		{"unknown signing key", config.JwtConfig{SigningKey: "nope", Keys: []config.JwtKeyConfig{current}}, "no private key"},
+		{"duplicate kid", config.JwtConfig{Keys: []config.JwtKeyConfig{current, current}}, "duplicate kid"},
+		{"no keys when required", config.JwtConfig{}, "no jwt signing key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := authen.LoadKeySet(tt.cfg, true)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("err = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestKeySetRotation(t *testing.T) {
+	oldKey, oldPublic := edKey(t, "old", 0)
+	newKey, _ := rsaKey(t, "new")
+	other, _ := edKey(t, "other", 0)
+
+	before, err := authen.LoadKeySet(config.JwtConfig{Keys: []config.JwtKeyConfig{oldKey}}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unknown, err := authen.LoadKeySet(config.JwtConfig{Keys: []config.JwtKeyConfig{other}}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldToken := sign(t, before)
+	unknownToken := sign(t, unknown)
+
+	rotate := func(notAfter int64) *authen.KeySet {
+		t.Helper()
+		retired := publicOnly(t, oldKey, oldPublic)
+		retired.NotAfter = notAfter
+		keys, err := authen.LoadKeySet(config.JwtConfig{SigningKey: "new", Keys: []config.JwtKeyConfig{retired, newKey}}, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return keys
+	}
+	rotated := rotate(time.Now().Add(time.Hour).Unix())
+	expired := rotate(time.Now().Add(-time.Hour).Unix())
+
+	tests := []struct {
+		name  string
+		keys  *authen.KeySet
+		token string
+		valid bool
+	}{
+		{"new token", rotated, sign(t, rotated), true},
+		{"token of the old key", rotated, oldToken, true},
+		{"token of an expired key", expired, oldToken, false},
+		{"token of an unknown key", rotated, unknownToken, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verify(tt.keys, tt.token)
+			if (err == nil) != tt.valid {
+				t.Fatalf("err = %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestKeySetJWKS(t *testing.T) {
+	edCfg, edPublic := edKey(t, "ed", 0)
+	rsaCfg, rsaPublic := rsaKey(t, "rsa")
+	expiredCfg, _ := edKey(t, "expired", time.Now().Add(-time.Hour).Unix())
+	keys, err := authen.LoadKeySet(config.JwtConfig{SigningKey: "rsa", Keys: []config.JwtKeyConfig{edCfg, rsaCfg, expiredCfg}}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []authen.JWK{
+		{Kty: "OKP", Kid: "ed", Use: "sig", Alg: authen.EDDSA_ALG, Crv: "Ed25519", X: base64.RawURLEncoding.EncodeToString(edPublic)},
+		{
+			Kty: "RSA", Kid: "rsa", Use: "sig", Alg: authen.RS256_ALG,
+			N: base64.RawURLEncoding.EncodeToString(rsaPublic.N.Bytes()),
+			E: base64.RawURLEncoding.EncodeToString(big.NewInt(int64(rsaPublic.E)).Bytes()),
+		},
+	}
+	got := keys.JWKS().Keys
+	if len(got) != len(want) {
+		t.Fatalf("keys = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("key %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
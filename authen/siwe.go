@@ -0,0 +1,211 @@
+package authen
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// SiweMessage is a parsed EIP-4361 "Sign-In with Ethereum" message.
+type SiweMessage struct {
+	Domain         string
+	Address        string
+	Statement      string
+	URI            string
+	Version        string
+	ChainID        int64
+	Nonce          string
+	IssuedAt       time.Time
+	ExpirationTime *time.Time
+	NotBefore      *time.Time
+	RequestID      string
+	Resources      []string
+}
+
+const (
+	siweHeaderSuffix = " wants you to sign in with your Ethereum account:"
+	nonceAlphabet    = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+	// allowed clock difference between the wallet and the server
+	siweClockSkew = 1 * time.Minute
+)
+
+// GenerateNonce returns a random alphanumeric nonce as required by EIP-4361.
+func GenerateNonce() (string, error) {
+	max := big.NewInt(int64(len(nonceAlphabet)))
+	nonce := make([]byte, 17)
+	for i := range nonce {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		nonce[i] = nonceAlphabet[n.Int64()]
+	}
+	return string(nonce), nil
+}
+
+func ParseSiweMessage(message string) (*SiweMessage, error) {
+	lines := strings.Split(strings.ReplaceAll(message, "\r\n", "\n"), "\n")
+	if len(lines) < 2 || !strings.HasSuffix(lines[0], siweHeaderSuffix) {
+		return nil, fmt.Errorf("siwe: invalid header")
+	}
+	siwe := SiweMessage{}
+	siwe.Domain = strings.TrimSuffix(lines[0], siweHeaderSuffix)
+	if i := strings.Index(siwe.Domain, "://"); i >= 0 {
+		siwe.Domain = siwe.Domain[i+3:]
+	}
+	siwe.Address = strings.TrimSpace(lines[1])
+	if !common.IsHexAddress(siwe.Address) {
+		return nil, fmt.Errorf("siwe: invalid address")
+	}
+
+	i := 2
+	for i < len(lines) && lines[i] == "" {
+		i++
+	}
+	if i < len(lines) && !strings.HasPrefix(lines[i], "URI: ") {
+		siwe.Statement = lines[i]
+		i++
+	}
+
+	var err error
+	inResources := false
+	for ; i < len(lines); i++ {
+		line := lines[i]
+		if line == "" {
+			continue
+		}
+		if inResources {
+			if !strings.HasPrefix(line, "- ") {
+				return nil, fmt.Errorf("siwe: invalid resource line %q", line)
+			}
+			siwe.Resources = append(siwe.Resources, strings.TrimPrefix(line, "- "))
+			continue
+		}
+		if line == "Resources:" {
+			inResources = true
+			continue
+		}
+		parts := strings.SplitN(line, ": ", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("siwe: invalid line %q", line)
+		}
+		value := parts[1]
+		switch parts[0] {
+		case "URI":
+			siwe.URI = value
+		case "Version":
+			siwe.Version = value
+		case "Chain ID":
+			siwe.ChainID, err = strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("siwe: invalid chain id")
+			}
+		case "Nonce":
+			siwe.Nonce = value
+		case "Issued At":
+			siwe.IssuedAt, err = time.Parse(time.RFC3339, value)
+			if err != nil {
+				return nil, fmt.Errorf("siwe: invalid issued at")
+			}
+		case "Expiration Time":
+			exp, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return nil, fmt.Errorf("siwe: invalid expiration time")
+			}
+			siwe.ExpirationTime = &exp
+		case "Not Before":
+			nbf, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return nil, fmt.Errorf("siwe: invalid not before")
+			}
+			siwe.NotBefore = &nbf
+		case "Request ID":
+			siwe.RequestID = value
+		default:
+			return nil, fmt.Errorf("siwe: unknown field %q", parts[0])
+		}
+	}
+
+	if siwe.URI == "" || siwe.Version == "" || siwe.Nonce == "" || siwe.IssuedAt.IsZero() {
+		return nil, fmt.Errorf("siwe: missing required field")
+	}
+	return &siwe, nil
+}
+
+// Validate checks the message fields against what this server expects.
+// chainId 0 accepts any chain.
+func (m *SiweMessage) Validate(domain string, chainId int64, now time.Time) error {
+	if m.Version != "1" {
+		return fmt.Errorf("siwe: unsupported version %s", m.Version)
+	}
+	if !strings.EqualFold(m.Domain, domain) {
+		return fmt.Errorf("siwe: domain %s is not %s", m.Domain, domain)
+	}
+	if chainId != 0 && m.ChainID != chainId {
+		return fmt.Errorf("siwe: chain id %d is not %d", m.ChainID, chainId)
+	}
+	if len(m.Nonce) < 8 {
+		return fmt.Errorf("siwe: nonce too short")
+	}
+	if m.IssuedAt.After(now.Add(siweClockSkew)) {
+		return fmt.Errorf("siwe: issued in the future")
+	}
+	if m.ExpirationTime != nil && !now.Before(*m.ExpirationTime) {
+		return fmt.Errorf("siwe: message expired")
+	}
+	if m.NotBefore != nil && now.Add(siweClockSkew).Before(*m.NotBefore) {
+		return fmt.Errorf("siwe: message not yet valid")
+	}
+	return nil
+}
+
+// RecoverAddress returns the checksummed address that produced an EIP-191
+// personal_sign signature over message.
+func RecoverAddress(message string, signature string) (string, error) {
+	sig, err := hexutil.Decode(signature)
+	if err != nil {
+		return "", fmt.Errorf("siwe: invalid signature encoding")
+	}
+	if len(sig) != crypto.SignatureLength {
+		return "", fmt.Errorf("siwe: invalid signature length")
+	}
+	// wallets return v as 27/28, go-ethereum expects 0/1
+	if sig[crypto.RecoveryIDOffset] >= 27 {
+		sig[crypto.RecoveryIDOffset] -= 27
+	}
+	pub, err := crypto.SigToPub(accounts.TextHash([]byte(message)), sig)
+	if err != nil {
+		return "", fmt.Errorf("siwe: %v", err)
+	}
+	return crypto.PubkeyToAddress(*pub).Hex(), nil
+}
+
+// VerifySiwe parses message, validates it and checks that signature was
+// made by the address in the message. The nonce is left to the caller.
+func VerifySiwe(message string, signature string, domain string, chainId int64) (*SiweMessage, error) {
+	siwe, err := ParseSiweMessage(message)
+	if err != nil {
+		return nil, err
+	}
+	err = siwe.Validate(domain, chainId, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	address, err := RecoverAddress(message, signature)
+	if err != nil {
+		return nil, err
+	}
+	if common.HexToAddress(siwe.Address) != common.HexToAddress(address) {
+		return nil, fmt.Errorf("siwe: signature does not match address")
+	}
+	siwe.Address = address
+	return siwe, nil
+}
@@ -0,0 +1,71 @@
+package authen
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
+	"github.com/seenark/super-backend-temp/config"
+)
+
+const (
+	// STATEMENT_AUDIENCE is the audience of every statement, it is never the
+	// audience of an access token so neither passes for the other
+	STATEMENT_AUDIENCE = "super-backend-verify"
+	// STATEMENT_TYPE is the typ header of a statement
+	STATEMENT_TYPE = "statement+jwt"
+)
+
+// StatementClaims is a signed statement of the server about data. Anyone
+// can check it offline with the public keys served at /.well-known/jwks.json.
+type StatementClaims struct {
+	Data interface{} `json:"data"`
+	jwt.StandardClaims
+}
+
+// SignStatement signs data with the access token key set. The statement
+// does not expire, it proves what the server said at the time it was issued.
+func SignStatement(subject string, data interface{}) (string, error) {
+	cfg := config.GetConfig()
+	keys, err := GetKeySet()
+	if err != nil {
+		return "", err
+	}
+	claims := StatementClaims{
+		Data: data,
+		StandardClaims: jwt.StandardClaims{
+			Subject:  subject,
+			Id:       uuid.NewString(),
+			IssuedAt: time.Now().Unix(),
+			Issuer:   cfg.App.Issuer,
+			Audience: STATEMENT_AUDIENCE,
+		},
+	}
+	return keys.sign(claims, STATEMENT_TYPE)
+}
+
+// VerifyStatement checks a statement signed by SignStatement and returns
+// its claims, an access token is refused
+func VerifyStatement(statement string) (*StatementClaims, error) {
+	cfg := config.GetConfig()
+	keys, err := GetKeySet()
+	if err != nil {
+		return nil, err
+	}
+	claims := &StatementClaims{}
+	token, err := jwt.ParseWithClaims(statement, claims, keys.Keyfunc)
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid || token.Header["typ"] != STATEMENT_TYPE {
+		return nil, fmt.Errorf("statement invalid")
+	}
+	if !claims.VerifyIssuer(cfg.App.Issuer, true) {
+		return nil, fmt.Errorf("statement issuer invalid")
+	}
+	if !claims.VerifyAudience(STATEMENT_AUDIENCE, true) {
+		return nil, fmt.Errorf("statement audience invalid")
+	}
+	return claims, nil
+}
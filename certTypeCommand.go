@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/seenark/super-backend-temp/cloudstorage"
+	"github.com/seenark/super-backend-temp/config"
+	"github.com/seenark/super-backend-temp/handler"
+	"github.com/seenark/super-backend-temp/repository"
+	"github.com/seenark/super-backend-temp/validation"
+)
+
+const certTypeUsage = `usage: certtype import -file types.json|types.csv [-update] [flags]
+
+a JSON file is an array of objects, a CSV file has a header row. Both have
+type_code, type_name, type_of_unit, unit, vintage_year and logo_file, the
+path of a logo image relative to the file. Existing type codes are skipped
+unless -update is given.`
+
+// certTypeRow is a digital cert type of an import file
+type certTypeRow struct {
+	TypeCode    string `json:"type_code"`
+	TypeName    string `json:"type_name"`
+	TypeOfUnit  string `json:"type_of_unit"`
+	Unit        string `json:"unit"`
+	VintageYear string `json:"vintage_year"`
+	LogoFile    string `json:"logo_file"`
+}
+
+func certTypeCommand(cfg config.Configuration, args []string) error {
+	name, args, err := subcommand(args, certTypeUsage)
+	if err != nil {
+		return err
+	}
+	if name != "import" {
+		return fmt.Errorf("unknown certtype command %q\n%s", name, certTypeUsage)
+	}
+	flags := flag.NewFlagSet("certtype import", flag.ExitOnError)
+	storageFlags(flags, &cfg)
+	file := flags.String("file", "", "JSON or CSV file of the cert types")
+	update := flags.Bool("update", false, "update cert types that exist")
+	flags.Parse(args)
+
+	if *file == "" {
+		return errors.New("-file is required")
+	}
+	rows, err := readCertTypeRows(*file)
+	if err != nil {
+		return err
+	}
+	// check every row before writing any
+	failed := false
+	for i, row := range rows {
+		err := validateCertTypeRow(row, filepath.Dir(*file))
+		if err != nil {
+			fmt.Printf("row %d %s: %v\n", i+1, row.TypeCode, err)
+			failed = true
+		}
+	}
+	if failed {
+		return errors.New("nothing is imported, fix the rows above")
+	}
+
+	memoryCloudStorage(&cfg)
+	uploader, err := cloudstorage.NewStorage(cfg)
+	if err != nil {
+		return fmt.Errorf("create cloud storage: %w", err)
+	}
+	repos := newRepositories(cfg)
+	ctx := context.Background()
+	created, updated, skipped := 0, 0, 0
+	for i, row := range rows {
+		result, err := importCertType(ctx, repos.CertTypes, uploader, row, filepath.Dir(*file), *update)
+		if err != nil {
+			return fmt.Errorf("row %d %s: %w", i+1, row.TypeCode, err)
+		}
+		fmt.Printf("row %d %s: %s\n", i+1, row.TypeCode, result)
+		switch result {
+		case "created":
+			created++
+		case "updated":
+			updated++
+		default:
+			skipped++
+		}
+	}
+	fmt.Printf("%d created, %d updated, %d skipped\n", created, updated, skipped)
+	return nil
+}
+
+// readCertTypeRows reads a JSON or CSV import file by its extension
+func readCertTypeRows(path string) ([]certTypeRow, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		decoder := json.NewDecoder(f)
+		decoder.DisallowUnknownFields()
+		rows := []certTypeRow{}
+		err := decoder.Decode(&rows)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return rows, nil
+	case ".csv":
+		return readCertTypeCSV(f)
+	}
+	return nil, fmt.Errorf("%s: use a .json or .csv file", path)
+}
+
+func readCertTypeCSV(r io.Reader) ([]certTypeRow, error) {
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("csv header: %w", err)
+	}
+	columns := map[string]int{}
+	for i, v := range header {
+		// excel writes a byte order mark first
+		columns[strings.TrimSpace(strings.TrimPrefix(v, "\xEF\xBB\xBF"))] = i
+	}
+	known := []string{"type_code", "type_name", "type_of_unit", "unit", "vintage_year", "logo_file"}
+	for v := range columns {
+		if !containsString(known, v) {
+			return nil, fmt.Errorf("unknown csv column %q", v)
+		}
+	}
+	rows := []certTypeRow{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		cell := func(column string) string {
+			i, ok := columns[column]
+			if !ok || i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+		rows = append(rows, certTypeRow{
+			TypeCode:    cell("type_code"),
+			TypeName:    cell("type_name"),
+			TypeOfUnit:  cell("type_of_unit"),
+			Unit:        cell("unit"),
+			VintageYear: cell("vintage_year"),
+			LogoFile:    cell("logo_file"),
+		})
+	}
+}
+
+// validateCertTypeRow checks a row with the rules of the api, and that its
+// logo can be read
+func validateCertTypeRow(row certTypeRow, dir string) error {
+	err := validation.Struct(handler.CertTypeRequest{
+		TypeCode:    row.TypeCode,
+		TypeName:    row.TypeName,
+		TypeOfUnit:  row.TypeOfUnit,
+		Unit:        row.Unit,
+		VintageYear: row.VintageYear,
+	})
+	if err != nil {
+		return err
+	}
+	if row.LogoFile != "" {
+		_, err := os.Stat(filepath.Join(dir, row.LogoFile))
+		if err != nil {
+			return fmt.Errorf("logo_file: %w", err)
+		}
+	}
+	return nil
+}
+
+// importCertType creates the cert type of row, or updates it when it
+// exists and update is set. It returns what was done.
+func importCertType(ctx context.Context, certTypeDb repository.IDigitalCertTypeRepository, uploader cloudstorage.Storage, row certTypeRow, dir string, update bool) (string, error) {
+	existing, err := certTypeDb.GetByTypeCode(ctx, row.TypeCode)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		return "", err
+	}
+	if existing != nil && !update {
+		return "skipped, exists", nil
+	}
+
+	logoName := ""
+	if row.LogoFile != "" {
+		logoName, err = uploadLogo(uploader, filepath.Join(dir, row.LogoFile))
+		if err != nil {
+			return "", err
+		}
+	}
+	if existing == nil {
+		_, err := certTypeDb.Create(ctx, row.TypeCode, row.TypeName, logoName, row.TypeOfUnit, row.Unit, row.VintageYear)
+		if err != nil {
+			return "", err
+		}
+		return "created", nil
+	}
+
+	oldLogo := existing.LogoImageName
+	existing.TypeName = row.TypeName
+	existing.TypeOfUnit = row.TypeOfUnit
+	existing.Unit = row.Unit
+	existing.VintageYear = row.VintageYear
+	if logoName != "" {
+		existing.LogoImageName = logoName
+	}
+	_, err = certTypeDb.Update(ctx, row.TypeCode, *existing)
+	if err != nil {
+		return "", err
+	}
+	if logoName != "" && oldLogo != "" {
+		err := uploader.Delete(oldLogo)
+		if err != nil {
+			fmt.Printf("delete old logo %s: %v\n", oldLogo, err)
+		}
+	}
+	return "updated", nil
+}
+
+// uploadLogo uploads a logo under a new name like the api does
+func uploadLogo(uploader cloudstorage.Storage, path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	name := fmt.Sprintf("%s%s", uuid.New(), filepath.Ext(path))
+	err = uploader.Upload(f, name)
+	if err != nil {
+		return "", fmt.Errorf("upload %s: %w", path, err)
+	}
+	return name, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
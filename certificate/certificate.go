@@ -0,0 +1,117 @@
+package certificate
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"time"
+
+	"github.com/jung-kurt/gofpdf"
+	"github.com/skip2/go-qrcode"
+)
+
+// Data is what a redemption certificate shows
+type Data struct {
+	IssuerName  string
+	RedeemId    int
+	Company     string
+	TaxID       string
+	Amount      int
+	TypeName    string
+	TypeOfUnit  string
+	Unit        string
+	VintageYear string
+	ProjectName string
+	TxHash      string
+	VerifyURL   string
+	DeliveredAt time.Time
+}
+
+// Render writes the certificate of data as an A4 landscape PDF. The core PDF
+// fonts have no Thai glyphs, so fontFile should point to a UTF-8 TrueType
+// font such as Sarabun when company names can be Thai.
+func Render(w io.Writer, data Data, fontFile string) error {
+	pdf := gofpdf.New("L", "mm", "A4", "")
+	pdf.SetTitle(fmt.Sprintf("Redemption certificate #%d", data.RedeemId), true)
+	pdf.SetAutoPageBreak(false, 0)
+
+	font := "Helvetica"
+	if fontFile != "" {
+		fontBytes, err := ioutil.ReadFile(fontFile)
+		if err != nil {
+			return fmt.Errorf("read font: %v", err)
+		}
+		font = "certificate"
+		pdf.AddUTF8FontFromBytes(font, "", fontBytes)
+		pdf.AddUTF8FontFromBytes(font, "B", fontBytes)
+	}
+
+	qr, err := qrcode.Encode(data.VerifyURL, qrcode.Medium, 512)
+	if err != nil {
+		return fmt.Errorf("qr code: %v", err)
+	}
+	pdf.RegisterImageOptionsReader("verify-qr", gofpdf.ImageOptions{ImageType: "PNG"}, bytes.NewReader(qr))
+
+	pdf.AddPage()
+	width, height := pdf.GetPageSize()
+
+	pdf.SetDrawColor(20, 90, 60)
+	pdf.SetLineWidth(1.5)
+	pdf.Rect(10, 10, width-20, height-20, "D")
+	pdf.SetLineWidth(0.3)
+	pdf.Rect(14, 14, width-28, height-28, "D")
+
+	pdf.SetY(28)
+	pdf.SetFont(font, "B", 28)
+	pdf.CellFormat(0, 14, "Certificate of Redemption", "", 1, "C", false, 0, "")
+	pdf.SetFont(font, "", 13)
+	pdf.CellFormat(0, 8, fmt.Sprintf("Issued by %s", data.IssuerName), "", 1, "C", false, 0, "")
+	pdf.Ln(8)
+
+	pdf.SetFont(font, "", 14)
+	pdf.CellFormat(0, 8, "This certifies that", "", 1, "C", false, 0, "")
+	pdf.SetFont(font, "B", 22)
+	pdf.CellFormat(0, 12, data.Company, "", 1, "C", false, 0, "")
+	pdf.SetFont(font, "", 12)
+	pdf.CellFormat(0, 7, fmt.Sprintf("Tax ID %s", data.TaxID), "", 1, "C", false, 0, "")
+	pdf.Ln(4)
+	pdf.SetFont(font, "", 14)
+	pdf.CellFormat(0, 8, "has redeemed", "", 1, "C", false, 0, "")
+	pdf.SetFont(font, "B", 20)
+	pdf.CellFormat(0, 11, fmt.Sprintf("%s %s", strconv.Itoa(data.Amount), data.Unit), "", 1, "C", false, 0, "")
+	pdf.Ln(6)
+
+	rows := [][2]string{
+		{"Certificate type", data.TypeName},
+		{"Type of unit", data.TypeOfUnit},
+		{"Vintage year", data.VintageYear},
+		{"Project", data.ProjectName},
+		{"Redeem ID", strconv.Itoa(data.RedeemId)},
+		{"Delivered at", data.DeliveredAt.Format("2 January 2006 15:04 MST")},
+		{"Transaction", data.TxHash},
+	}
+	left := 40.0
+	pdf.SetFont(font, "", 11)
+	for _, row := range rows {
+		if row[1] == "" {
+			continue
+		}
+		pdf.SetX(left)
+		pdf.SetFont(font, "B", 11)
+		pdf.CellFormat(40, 7, row[0], "", 0, "L", false, 0, "")
+		pdf.SetFont(font, "", 11)
+		pdf.CellFormat(0, 7, row[1], "", 1, "L", false, 0, "")
+	}
+
+	qrSize := 42.0
+	qrX := width - 24 - qrSize
+	qrY := height - 24 - qrSize - 6
+	pdf.ImageOptions("verify-qr", qrX, qrY, qrSize, qrSize, false, gofpdf.ImageOptions{ImageType: "PNG"}, 0, data.VerifyURL)
+	pdf.SetFont(font, "", 8)
+	pdf.SetXY(qrX-10, qrY+qrSize)
+	pdf.CellFormat(qrSize+20, 5, "Scan to verify", "", 0, "C", false, 0, data.VerifyURL)
+
+	return pdf.Output(w)
+}
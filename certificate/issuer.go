@@ -0,0 +1,157 @@
+package certificate
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/seenark/super-backend-temp/cloudstorage"
+	"github.com/seenark/super-backend-temp/config"
+	"github.com/seenark/super-backend-temp/repository"
+)
+
+// Issuer renders the certificate of a delivered redemption and stores it
+type Issuer struct {
+	issuerName   string
+	fontFile     string
+	verifyURL    string
+	location     *time.Location
+	redeemedRepo repository.IRedeemedRepository
+	metadataRepo repository.IMetadataRepository
+	certTypeRepo repository.IDigitalCertTypeRepository
+	store        cloudstorage.Storage
+}
+
+func NewIssuer(cfg config.Configuration, redeemedRepo repository.IRedeemedRepository, metadataRepo repository.IMetadataRepository, certTypeRepo repository.IDigitalCertTypeRepository, store cloudstorage.Storage) *Issuer {
+	verifyURL := cfg.Certificate.VerifyURL
+	if verifyURL == "" {
+		verifyURL = strings.TrimRight(cfg.App.Domain, "/") + "/api/verify/{code}"
+	}
+	location, err := time.LoadLocation(cfg.Export.TimeZone)
+	if err != nil {
+		log.Printf("certificate time zone: %v\n", err)
+		location = time.Local
+	}
+	return &Issuer{
+		issuerName:   cfg.Certificate.IssuerName,
+		fontFile:     cfg.Certificate.FontFile,
+		verifyURL:    verifyURL,
+		location:     location,
+		redeemedRepo: redeemedRepo,
+		metadataRepo: metadataRepo,
+		certTypeRepo: certTypeRepo,
+		store:        store,
+	}
+}
+
+// Issue renders and uploads the certificate of redeemed and records its
+// object name. An earlier certificate of the redemption is replaced.
+func (i *Issuer) Issue(ctx context.Context, redeemed repository.Redeemed) (*repository.Redeemed, error) {
+	if redeemed.ApproveStatus != repository.DELIVERED_STATUS {
+		return nil, fmt.Errorf("redeem %d is not delivered", redeemed.RedeemId)
+	}
+
+	if redeemed.VerificationCode == "" {
+		code, err := NewVerificationCode()
+		if err != nil {
+			return nil, err
+		}
+		redeemed.VerificationCode = code
+	}
+
+	data := Data{
+		IssuerName:  i.issuerName,
+		RedeemId:    redeemed.RedeemId,
+		Company:     redeemed.Company,
+		TaxID:       redeemed.TaxID,
+		Amount:      redeemed.Amount,
+		TxHash:      redeemed.TxHash,
+		VerifyURL:   i.VerifyURL(redeemed),
+		DeliveredAt: deliveredAt(redeemed).In(i.location),
+	}
+	metadata, err := i.metadataRepo.GetByDigitalCertId(ctx, redeemed.CertId)
+	if err != nil {
+		log.Printf("certificate of redeem %d: metadata %d: %v\n", redeemed.RedeemId, redeemed.CertId, err)
+	} else {
+		data.ProjectName = metadata.ProjectName
+		certType, err := i.certTypeRepo.GetByTypeCode(ctx, metadata.TypeCode)
+		if err != nil {
+			log.Printf("certificate of redeem %d: cert type %s: %v\n", redeemed.RedeemId, metadata.TypeCode, err)
+		} else {
+			data.TypeName = certType.TypeName
+			data.TypeOfUnit = certType.TypeOfUnit
+			data.Unit = certType.Unit
+			data.VintageYear = certType.VintageYear
+		}
+	}
+
+	buf := bytes.Buffer{}
+	err = Render(&buf, data, i.fontFile)
+	if err != nil {
+		return nil, err
+	}
+	// the images route does not serve private objects, the certificate has
+	// the tax id of the customer
+	object := fmt.Sprintf("%scertificates/redeem-%d-%s.pdf", cloudstorage.PRIVATE_PREFIX, redeemed.RedeemId, uuid.NewString())
+	err = i.store.Upload(&buf, object)
+	if err != nil {
+		return nil, err
+	}
+	err = i.redeemedRepo.SetCertificate(ctx, redeemed.RedeemId, object, redeemed.VerificationCode)
+	if err != nil {
+		if err := i.store.Delete(object); err != nil {
+			log.Println(err)
+		}
+		return nil, err
+	}
+	if redeemed.CertificateObject != "" {
+		if err := i.store.Delete(redeemed.CertificateObject); err != nil {
+			log.Println(err)
+		}
+	}
+	redeemed.CertificateObject = object
+	return &redeemed, nil
+}
+
+// VerifyURL is the page the QR code of the certificate of redeemed opens
+func (i *Issuer) VerifyURL(redeemed repository.Redeemed) string {
+	code := redeemed.VerificationCode
+	if code == "" {
+		code = redeemed.TxHash
+	}
+	return strings.ReplaceAll(i.verifyURL, "{code}", code)
+}
+
+// verification codes avoid letters that are easy to misread on paper
+const verificationAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// NewVerificationCode returns a random code such as "K7QH-2MXD-9TPA"
+func NewVerificationCode() (string, error) {
+	random := make([]byte, 12)
+	_, err := rand.Read(random)
+	if err != nil {
+		return "", err
+	}
+	code := []byte{}
+	for j, v := range random {
+		if j > 0 && j%4 == 0 {
+			code = append(code, '-')
+		}
+		code = append(code, verificationAlphabet[int(v)%len(verificationAlphabet)])
+	}
+	return string(code), nil
+}
+
+func deliveredAt(redeemed repository.Redeemed) time.Time {
+	for j := len(redeemed.StatusHistory) - 1; j >= 0; j-- {
+		if redeemed.StatusHistory[j].To == repository.DELIVERED_STATUS {
+			return redeemed.StatusHistory[j].ChangedAt
+		}
+	}
+	return time.Now()
+}
@@ -2,14 +2,12 @@ package cloudstorage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
-	"log"
-	"mime/multipart"
 	"time"
 
 	"cloud.google.com/go/storage"
-	"github.com/seenark/super-backend-temp/config"
 )
 
 type ClientUploader struct {
@@ -19,26 +17,28 @@ type ClientUploader struct {
 	uploadPath string
 }
 
-func NewGoogleStorageUploader() *ClientUploader {
+func NewGoogleStorageUploader(projectID string, bucketName string, uploadPath string) (*ClientUploader, error) {
 	// os.Setenv("GOOGLE_APPLICATION_CREDENTIALS", "./aumaum-can-dlt-on-iam-setting-1a8fa2f46228.json") // FILL IN WITH YOUR FILE PATH
-	cfg := config.GetConfig()
 	client, err := storage.NewClient(context.Background())
 	if err != nil {
-		log.Fatalf("Failed to create client: %v", err)
+		return nil, fmt.Errorf("create google storage client: %v", err)
+	}
+	if uploadPath == "" {
+		uploadPath = "superx-test/"
 	}
 
 	uploader := &ClientUploader{
 		cl:         client,
-		bucketName: cfg.Google.BucketName,
-		projectID:  cfg.Google.ProjectID,
-		uploadPath: "superx-test/",
+		bucketName: bucketName,
+		projectID:  projectID,
+		uploadPath: uploadPath,
 	}
 
-	return uploader
+	return uploader, nil
 }
 
-// UploadFile uploads an object
-func (c *ClientUploader) UploadFile(file multipart.File, object string) error {
+// Upload uploads an object
+func (c *ClientUploader) Upload(file io.Reader, object string) error {
 	ctx := context.Background()
 
 	ctx, cancel := context.WithTimeout(ctx, time.Second*50)
@@ -56,15 +56,56 @@ func (c *ClientUploader) UploadFile(file multipart.File, object string) error {
 	return nil
 }
 
-func (c *ClientUploader) DeleteFile(fileName string) error {
+func (c *ClientUploader) Delete(fileName string) error {
 	ctx := context.Background()
 	ctx, cancel := context.WithTimeout(ctx, time.Second*50)
 	defer cancel()
 
 	err := c.cl.Bucket(c.bucketName).Object(c.uploadPath + fileName).Delete(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return ErrNotExist
+	}
 	if err != nil {
 		return fmt.Errorf("delete: %v", err)
 	}
 	return nil
 
 }
+
+// Open returns a reader for the object; the caller must close it.
+func (c *ClientUploader) Open(object string) (io.ReadCloser, error) {
+	reader, err := c.cl.Bucket(c.bucketName).Object(c.uploadPath + object).NewReader(context.Background())
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil, ErrNotExist
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open: %v", err)
+	}
+	return reader, nil
+}
+
+func (c *ClientUploader) Exists(object string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*50)
+	defer cancel()
+
+	_, err := c.cl.Bucket(c.bucketName).Object(c.uploadPath + object).Attrs(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("attrs: %v", err)
+	}
+	return true, nil
+}
+
+func (c *ClientUploader) SignedURL(object string, expire time.Duration) (string, error) {
+	url, err := c.cl.Bucket(c.bucketName).SignedURL(c.uploadPath+object, &storage.SignedURLOptions{
+		Method:  "GET",
+		Expires: time.Now().Add(expire),
+		Scheme:  storage.SigningSchemeV4,
+	})
+	if err != nil {
+		return "", fmt.Errorf("signed url: %v", err)
+	}
+	return url, nil
+}
@@ -0,0 +1,110 @@
+package cloudstorage
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// LocalStorage keeps objects as plain files under root so the API can run
+// without cloud credentials.
+type LocalStorage struct {
+	root    string
+	baseURL string
+}
+
+func NewLocalStorage(root string, baseURL string) (*LocalStorage, error) {
+	if root == "" {
+		root = "./static/images"
+	}
+	err := os.MkdirAll(root, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("create local storage dir: %v", err)
+	}
+	return &LocalStorage{
+		root:    root,
+		baseURL: baseURL,
+	}, nil
+}
+
+func (l *LocalStorage) filePath(object string) (string, error) {
+	name, err := cleanObjectName(object)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(l.root, filepath.FromSlash(name)), nil
+}
+
+func (l *LocalStorage) Upload(file io.Reader, object string) error {
+	fullPath, err := l.filePath(object)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(fullPath), 0755)
+	if err != nil {
+		return fmt.Errorf("mkdir: %v", err)
+	}
+	dst, err := os.Create(fullPath)
+	if err != nil {
+		return fmt.Errorf("create: %v", err)
+	}
+	if _, err := io.Copy(dst, file); err != nil {
+		dst.Close()
+		return fmt.Errorf("io.Copy: %v", err)
+	}
+	return dst.Close()
+}
+
+func (l *LocalStorage) Delete(object string) error {
+	fullPath, err := l.filePath(object)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(fullPath)
+	if os.IsNotExist(err) {
+		return ErrNotExist
+	}
+	return err
+}
+
+func (l *LocalStorage) Open(object string) (io.ReadCloser, error) {
+	fullPath, err := l.filePath(object)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(fullPath)
+	if os.IsNotExist(err) {
+		return nil, ErrNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func (l *LocalStorage) Exists(object string) (bool, error) {
+	fullPath, err := l.filePath(object)
+	if err != nil {
+		return false, err
+	}
+	info, err := os.Stat(fullPath)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return !info.IsDir(), nil
+}
+
+// SignedURL returns the public URL of the object; local files are served
+// by the /images route so the expiry is not enforced.
+func (l *LocalStorage) SignedURL(object string, expire time.Duration) (string, error) {
+	name, err := cleanObjectName(object)
+	if err != nil {
+		return "", err
+	}
+	return joinURL(l.baseURL, name), nil
+}
@@ -0,0 +1,86 @@
+package cloudstorage
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sync"
+	"time"
+)
+
+// MemoryStorage keeps objects in memory. Everything is lost on restart,
+// which is what CI and throwaway dev runs want.
+type MemoryStorage struct {
+	mu      sync.RWMutex
+	objects map[string][]byte
+	baseURL string
+}
+
+func NewMemoryStorage(baseURL string) *MemoryStorage {
+	return &MemoryStorage{
+		objects: map[string][]byte{},
+		baseURL: baseURL,
+	}
+}
+
+func (m *MemoryStorage) Upload(file io.Reader, object string) error {
+	name, err := cleanObjectName(object)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.objects[name] = data
+	return nil
+}
+
+func (m *MemoryStorage) Delete(object string) error {
+	name, err := cleanObjectName(object)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.objects[name]; !ok {
+		return ErrNotExist
+	}
+	delete(m.objects, name)
+	return nil
+}
+
+func (m *MemoryStorage) Open(object string) (io.ReadCloser, error) {
+	name, err := cleanObjectName(object)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	data, ok := m.objects[name]
+	if !ok {
+		return nil, ErrNotExist
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (m *MemoryStorage) Exists(object string) (bool, error) {
+	name, err := cleanObjectName(object)
+	if err != nil {
+		return false, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, ok := m.objects[name]
+	return ok, nil
+}
+
+func (m *MemoryStorage) SignedURL(object string, expire time.Duration) (string, error) {
+	name, err := cleanObjectName(object)
+	if err != nil {
+		return "", err
+	}
+	return joinURL(m.baseURL, name), nil
+}
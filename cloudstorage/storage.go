@@ -0,0 +1,93 @@
+package cloudstorage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/seenark/super-backend-temp/config"
+)
+
+const (
+	GCS_DRIVER    = "gcs"
+	LOCAL_DRIVER  = "local"
+	MEMORY_DRIVER = "memory"
+)
+
+// PRIVATE_PREFIX is the folder of objects the api hands out only after its
+// own checks, such as certificates. NewFileHandler does not serve it.
+const PRIVATE_PREFIX = "private/"
+
+var ErrNotExist = errors.New("object does not exist")
+
+// Storage is an object store for uploaded images and generated files.
+// Object names are relative keys such as "3f1c...e2.png".
+type Storage interface {
+	Upload(file io.Reader, object string) error
+	Delete(object string) error
+	Open(object string) (io.ReadCloser, error)
+	Exists(object string) (bool, error)
+	SignedURL(object string, expire time.Duration) (string, error)
+}
+
+// NewStorage returns the Storage selected by cfg.CloudStorage.Driver.
+// An empty driver means gcs to keep the old behaviour.
+func NewStorage(cfg config.Configuration) (Storage, error) {
+	storageCfg := cfg.CloudStorage
+	baseURL := BaseURL(cfg)
+	switch storageCfg.Driver {
+	case GCS_DRIVER, "":
+		return NewGoogleStorageUploader(cfg.Google.ProjectID, cfg.Google.BucketName, storageCfg.UploadPath)
+	case LOCAL_DRIVER:
+		return NewLocalStorage(storageCfg.LocalPath, baseURL)
+	case MEMORY_DRIVER:
+		return NewMemoryStorage(baseURL), nil
+	default:
+		return nil, fmt.Errorf("unknown cloud storage driver %q", storageCfg.Driver)
+	}
+}
+
+// BaseURL is the public url stored objects are served under, by default the
+// /images route. It works for every driver and does not expire, unlike the
+// signed urls of gcs.
+func BaseURL(cfg config.Configuration) string {
+	if cfg.CloudStorage.BaseURL != "" {
+		return cfg.CloudStorage.BaseURL
+	}
+	return strings.TrimRight(cfg.App.Domain, "/") + "/images"
+}
+
+// PublicURL returns the permanent url of object under BaseURL
+func PublicURL(cfg config.Configuration, object string) string {
+	return joinURL(BaseURL(cfg), object)
+}
+
+// cleanObjectName rejects names that would escape the storage root.
+func cleanObjectName(object string) (string, error) {
+	if object == "" {
+		return "", fmt.Errorf("object name is empty")
+	}
+	cleaned := path.Clean("/" + object)[1:]
+	if cleaned == "" || cleaned != strings.TrimPrefix(object, "/") {
+		return "", fmt.Errorf("object name %q is invalid", object)
+	}
+	return cleaned, nil
+}
+
+// IsPrivate reports whether object is under PRIVATE_PREFIX, or under
+// certificates/ where certificates were stored before
+func IsPrivate(object string) bool {
+	name, err := cleanObjectName(object)
+	if err != nil {
+		return false
+	}
+	name = strings.ToLower(name)
+	return strings.HasPrefix(name, PRIVATE_PREFIX) || strings.HasPrefix(name, "certificates/")
+}
+
+func joinURL(baseURL string, object string) string {
+	return fmt.Sprintf("%s/%s", strings.TrimRight(baseURL, "/"), object)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/seenark/super-backend-temp/config"
+)
+
+// command is a subcommand of the binary, it gets the arguments after its name
+type command struct {
+	run   func(cfg config.Configuration, args []string) error
+	usage string
+}
+
+var commands = map[string]command{
+	"serve":    {serveCommand, "run the api, the default when no command is given"},
+	"migrate":  {migrateCommand, "up|down|status of the mongo schema migrations"},
+	"user":     {userCommand, "create|reset-password of users, such as the first admin"},
+	"certtype": {certTypeCommand, "import digital cert types from a JSON or CSV file"},
+	"metadata": {metadataCommand, "import metadata from a CSV or JSON manifest and a zip of images"},
+	"redeemed": {redeemedCommand, "reindex redemptions from the chain"},
+	"config":   {configCommand, "check the config and what it points at"},
+}
+
+// runCommand runs the command named by the first argument. Flags without a
+// command are flags of serve, so `main --storage=memory` still works.
+func runCommand(cfg config.Configuration, args []string) error {
+	name := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" {
+		fmt.Println(commandUsage())
+		return nil
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		return fmt.Errorf("unknown command %q\n%s", name, commandUsage())
+	}
+	return cmd.run(cfg, args)
+}
+
+func commandUsage() string {
+	names := []string{}
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	usage := "usage: main <command> [arguments]\n\ncommands:\n"
+	for _, name := range names {
+		usage += fmt.Sprintf("  %-9s %s\n", name, commands[name].usage)
+	}
+	return usage + "\nrun a command with -h for its flags"
+}
+
+// storageFlags lets a command pick the storage of the config
+func storageFlags(flags *flag.FlagSet, cfg *config.Configuration) {
+	flags.StringVar(&cfg.Storage.Driver, "storage", cfg.Storage.Driver, "where the data is kept: mongo or memory")
+	flags.StringVar(&cfg.Storage.Fixture, "fixture", cfg.Storage.Fixture, "JSON file the memory storage is seeded with")
+}
+
+// subcommand splits the arguments of a command with subcommands such as
+// `user create`, usage lists them
+func subcommand(args []string, usage string) (string, []string, error) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return "", nil, errors.New(usage)
+	}
+	return args[0], args[1:], nil
+}
+
+// readPassword returns password, or the first line of stdin when it is
+// empty so the password is not left in the shell history
+func readPassword(password string) (string, error) {
+	if password != "" {
+		return password, nil
+	}
+	fmt.Fprint(os.Stderr, "password: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("read password: %w", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
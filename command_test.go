@@ -0,0 +1,117 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/seenark/super-backend-temp/config"
+)
+
+func TestRunCommand(t *testing.T) {
+	cfg := config.Configuration{}
+	tests := []struct {
+		name string
+		args []string
+		err  string
+	}{
+		{"help", []string{"help"}, ""},
+		{"unknown command", []string{"bogus"}, `unknown command "bogus"`},
+		{"subcommand missing", []string{"user"}, "usage: user"},
+		{"unknown subcommand", []string{"certtype", "export"}, `unknown certtype command "export"`},
+		{"metadata import without files", []string{"metadata", "import"}, "-manifest and -images are required"},
+		{"unknown migrate command", []string{"migrate", "sideways"}, `unknown migrate command "sideways"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := runCommand(cfg, tt.args)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatalf("runCommand: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("runCommand err = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestReadCertTypeRows(t *testing.T) {
+	dir := t.TempDir()
+	want := []certTypeRow{
+		{TypeCode: "REC", TypeName: "Renewable energy", TypeOfUnit: "energy", Unit: "MWh", VintageYear: "2021", LogoFile: "rec.png"},
+		{TypeCode: "CARBON", TypeName: "Carbon credit", TypeOfUnit: "carbon", Unit: "tCO2e"},
+	}
+	files := map[string]string{
+		"types.json": `[
+			{"type_code": "REC", "type_name": "Renewable energy", "type_of_unit": "energy", "unit": "MWh", "vintage_year": "2021", "logo_file": "rec.png"},
+			{"type_code": "CARBON", "type_name": "Carbon credit", "type_of_unit": "carbon", "unit": "tCO2e"}
+		]`,
+		// columns in any order, with the byte order mark of excel
+		"types.csv": "\xEF\xBB\xBFunit,type_code,type_name,type_of_unit,vintage_year,logo_file\n" +
+			"MWh,REC,Renewable energy,energy,2021,rec.png\n" +
+			"tCO2e, CARBON ,Carbon credit,carbon,,\n",
+	}
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(dir, name)
+			err := ioutil.WriteFile(path, []byte(content), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rows, err := readCertTypeRows(path)
+			if err != nil {
+				t.Fatalf("readCertTypeRows: %v", err)
+			}
+			if !reflect.DeepEqual(rows, want) {
+				t.Fatalf("rows = %+v, want %+v", rows, want)
+			}
+		})
+	}
+
+	invalid := map[string]string{
+		"unknown.csv":  "type_code,colour\nREC,green\n",
+		"unknown.json": `[{"type_code": "REC", "colour": "green"}]`,
+		"types.txt":    "REC",
+	}
+	for name, content := range invalid {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(dir, name)
+			err := ioutil.WriteFile(path, []byte(content), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = readCertTypeRows(path)
+			if err == nil {
+				t.Fatal("readCertTypeRows: want an error")
+			}
+		})
+	}
+}
+
+func TestValidateCertTypeRow(t *testing.T) {
+	dir := t.TempDir()
+	err := ioutil.WriteFile(filepath.Join(dir, "rec.png"), []byte("png"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := certTypeRow{TypeCode: "REC", TypeName: "Renewable energy", TypeOfUnit: "energy", Unit: "MWh", LogoFile: "rec.png"}
+	err = validateCertTypeRow(valid, dir)
+	if err != nil {
+		t.Fatalf("valid row: %v", err)
+	}
+	noName := valid
+	noName.TypeName = ""
+	if validateCertTypeRow(noName, dir) == nil {
+		t.Fatal("row without type_name: want an error")
+	}
+	noLogo := valid
+	noLogo.LogoFile = "missing.png"
+	if validateCertTypeRow(noLogo, dir) == nil {
+		t.Fatal("row with a missing logo: want an error")
+	}
+}
@@ -7,26 +7,49 @@ import (
 )
 
 type Configuration struct {
-	Environment string       `mapstructure:"ENVIRONMENT"`
-	Mongo       MongoConfig  `mapstructure:"MONGO"`
-	App         AppConfig    `mapstructure:"APP"`
-	Google      GoogleConfig `mapstructure:"GOOGLE"`
-	Port        int          `mapstructure:"PORT"`
+	Environment  string             `mapstructure:"ENVIRONMENT"`
+	Storage      StorageConfig      `mapstructure:"STORAGE"`
+	Mongo        MongoConfig        `mapstructure:"MONGO"`
+	App          AppConfig          `mapstructure:"APP"`
+	Google       GoogleConfig       `mapstructure:"GOOGLE"`
+	CloudStorage CloudStorageConfig `mapstructure:"CLOUD_STORAGE"`
+	Siwe         SiweConfig         `mapstructure:"SIWE"`
+	Jwt          JwtConfig          `mapstructure:"JWT"`
+	Indexer      IndexerConfig      `mapstructure:"INDEXER"`
+	Token        TokenConfig        `mapstructure:"TOKEN"`
+	Export       ExportConfig       `mapstructure:"EXPORT"`
+	Certificate  CertificateConfig  `mapstructure:"CERTIFICATE"`
+	Outbox       OutboxConfig       `mapstructure:"OUTBOX"`
+	Notification NotificationConfig `mapstructure:"NOTIFICATION"`
+	Webhook      WebhookConfig      `mapstructure:"WEBHOOK"`
+	Timeout      TimeoutConfig      `mapstructure:"TIMEOUT"`
+	Import       ImportConfig       `mapstructure:"IMPORT"`
+	Port         int                `mapstructure:"PORT"`
 	// REDISHOST   string
 }
 
 type AppConfig struct {
 	Domain      string `mapstructure:"DOMAIN"`
 	Port        int    `mapstructure:"PORT"`
-	SecretKey   string `mapstructure:"SECRETKEY"`
 	Refresh     string `mapstructure:"REFRESH_SECRET_KEY"`
 	AllowOrigin string `mapstructure:"ALLOW_ORIGIN"`
+	Issuer      string `mapstructure:"ISSUER"`   // iss of issued jwt
+	Audience    string `mapstructure:"AUDIENCE"` // aud of issued jwt
+}
+
+// StorageConfig is where the repositories keep their data. memory needs no
+// database and is lost on restart, it is for local development.
+type StorageConfig struct {
+	Driver  string `mapstructure:"DRIVER"`  // mongo or memory
+	Fixture string `mapstructure:"FIXTURE"` // JSON file the memory storage is seeded with
 }
 
 type MongoConfig struct {
+	URI      string `mapstructure:"URI"` // full connection string, the fields below are not used when set
 	Username string `mapstructure:"USERNAME"`
 	Password string `mapstructure:"PASSWORD"`
-	URL      string `mapstructure:"URL"`
+	URL      string `mapstructure:"URL"` // host:port, or the cluster host when srv
+	Srv      bool   `mapstructure:"SRV"` // mongodb+srv:// for atlas clusters
 }
 
 type GoogleConfig struct {
@@ -34,6 +57,115 @@ type GoogleConfig struct {
 	BucketName string `mapstructure:"BUCKET_NAME"`
 }
 
+type CloudStorageConfig struct {
+	Driver     string `mapstructure:"DRIVER"` // gcs, local or memory
+	UploadPath string `mapstructure:"UPLOAD_PATH"`
+	LocalPath  string `mapstructure:"LOCAL_PATH"`
+	BaseURL    string `mapstructure:"BASE_URL"`
+}
+
+// SiweConfig is for Sign-In with Ethereum (EIP-4361) wallet login
+type SiweConfig struct {
+	Domain       string `mapstructure:"DOMAIN"`       // empty means the host of app.domain
+	ChainID      int64  `mapstructure:"CHAIN_ID"`     // 0 accepts any chain
+	NonceExpire  int    `mapstructure:"NONCE_EXPIRE"` // seconds
+	AutoRegister bool   `mapstructure:"AUTO_REGISTER"`
+}
+
+// JwtConfig holds the asymmetric keys access tokens are signed with. To
+// rotate, add a new key, point signing_key at it and keep the old entry
+// (public key only is enough) until its tokens have expired.
+type JwtConfig struct {
+	SigningKey string         `mapstructure:"SIGNING_KEY"` // kid of the key new tokens are signed with
+	Keys       []JwtKeyConfig `mapstructure:"KEYS"`
+}
+
+type JwtKeyConfig struct {
+	Kid            string `mapstructure:"KID"`
+	Alg            string `mapstructure:"ALG"`              // RS256 or EdDSA
+	PrivateKey     string `mapstructure:"PRIVATE_KEY"`      // PEM
+	PrivateKeyFile string `mapstructure:"PRIVATE_KEY_FILE"` // path to PEM
+	PublicKey      string `mapstructure:"PUBLIC_KEY"`
+	PublicKeyFile  string `mapstructure:"PUBLIC_KEY_FILE"`
+	NotAfter       int64  `mapstructure:"NOT_AFTER"` // unix time the key stops validating, 0 is never
+}
+
+// TokenConfig is for the ERC-721/1155 token metadata served to wallets and
+// marketplaces
+type TokenConfig struct {
+	ExternalURL string `mapstructure:"EXTERNAL_URL"` // {id} is replaced by the cert id in 64 hex digits, {cert_id} in decimal
+}
+
+// ExportConfig is for the redemption export of the finance team
+type ExportConfig struct {
+	TimeZone      string `mapstructure:"TIME_ZONE"`      // IANA name, dates are written in this zone
+	PriceDecimals int    `mapstructure:"PRICE_DECIMALS"` // decimals of the price token, 18 for wei
+}
+
+// CertificateConfig is for the PDF certificate of a delivered redemption
+type CertificateConfig struct {
+	IssuerName string `mapstructure:"ISSUER_NAME"`
+	FontFile   string `mapstructure:"FONT_FILE"`  // UTF-8 TrueType font, needed for Thai text
+	VerifyURL  string `mapstructure:"VERIFY_URL"` // {code} is replaced by the verification code, empty means app.domain/api/verify/{code}
+}
+
+// OutboxConfig is for the worker that delivers outbox events
+type OutboxConfig struct {
+	PollInterval int `mapstructure:"POLL_INTERVAL"` // seconds
+	MaxAttempts  int `mapstructure:"MAX_ATTEMPTS"`
+	RetryDelay   int `mapstructure:"RETRY_DELAY"` // seconds before the first retry, doubled every retry
+}
+
+// NotificationConfig is for the emails sent on redemption events
+type NotificationConfig struct {
+	Driver      string     `mapstructure:"DRIVER"` // smtp, file or log, empty sends nothing
+	From        string     `mapstructure:"FROM"`
+	AdminEmails []string   `mapstructure:"ADMIN_EMAILS"`
+	Languages   []string   `mapstructure:"LANGUAGES"` // th and en, emails hold one part per language
+	FileDir     string     `mapstructure:"FILE_DIR"`  // where the file driver writes .eml files
+	Smtp        SmtpConfig `mapstructure:"SMTP"`
+}
+
+type SmtpConfig struct {
+	Host     string `mapstructure:"HOST"`
+	Port     int    `mapstructure:"PORT"`
+	Username string `mapstructure:"USERNAME"`
+	Password string `mapstructure:"PASSWORD"`
+}
+
+// WebhookConfig is for the deliveries to partner webhooks
+type WebhookConfig struct {
+	Timeout int `mapstructure:"TIMEOUT"` // seconds to wait for a webhook to answer
+}
+
+// TimeoutConfig bounds how long a request may wait on the database
+type TimeoutConfig struct {
+	Default int            `mapstructure:"DEFAULT"` // seconds
+	Routes  map[string]int `mapstructure:"ROUTES"`  // path prefix -> seconds, the longest matching prefix wins
+}
+
+// ImportConfig limits the bulk import of metadata
+type ImportConfig struct {
+	MaxRows       int `mapstructure:"MAX_ROWS"`
+	MaxImageSize  int `mapstructure:"MAX_IMAGE_SIZE"`  // MB of one image, uncompressed
+	MaxUploadSize int `mapstructure:"MAX_UPLOAD_SIZE"` // MB of a request body, the manifest and the zip come in one
+}
+
+// IndexerConfig is for the embedded indexer that reads redeem events from
+// the chain instead of waiting for the event logger to post them.
+type IndexerConfig struct {
+	Enabled         bool              `mapstructure:"ENABLED"`
+	RpcURL          string            `mapstructure:"RPC_URL"`
+	ContractAddress string            `mapstructure:"CONTRACT_ADDRESS"`
+	AbiFile         string            `mapstructure:"ABI_FILE"`
+	EventName       string            `mapstructure:"EVENT_NAME"`
+	StartBlock      uint64            `mapstructure:"START_BLOCK"`
+	Confirmations   uint64            `mapstructure:"CONFIRMATIONS"` // blocks behind head before an event is ingested
+	BatchSize       uint64            `mapstructure:"BATCH_SIZE"`    // blocks per eth_getLogs call
+	PollInterval    int               `mapstructure:"POLL_INTERVAL"` // seconds
+	Fields          map[string]string `mapstructure:"FIELDS"`        // redeemed field -> event argument
+}
+
 func initConfig() {
 	viper.SetConfigName("config")
 	viper.SetConfigType("yml")
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/seenark/super-backend-temp/authen"
+	"github.com/seenark/super-backend-temp/cloudstorage"
+	"github.com/seenark/super-backend-temp/config"
+	"github.com/seenark/super-backend-temp/indexer"
+	"github.com/seenark/super-backend-temp/migration"
+	"github.com/seenark/super-backend-temp/notification"
+	"github.com/seenark/super-backend-temp/repository"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+const configUsage = `usage: config check [flags]
+
+checks the config and what it points at before the api is started with it,
+one line per check. It fails when a check does.`
+
+// check is one thing config check looks at, run returns what was found
+type check struct {
+	name string
+	run  func() (string, error)
+}
+
+func configCommand(cfg config.Configuration, args []string) error {
+	name, args, err := subcommand(args, configUsage)
+	if err != nil {
+		return err
+	}
+	if name != "check" {
+		return fmt.Errorf("unknown config command %q\n%s", name, configUsage)
+	}
+	flags := flag.NewFlagSet("config check", flag.ExitOnError)
+	storageFlags(flags, &cfg)
+	flags.Parse(args)
+
+	failed := 0
+	for _, v := range configChecks(cfg) {
+		found, err := v.run()
+		if err != nil {
+			fmt.Printf("FAIL %-13s %v\n", v.name, err)
+			failed++
+			continue
+		}
+		fmt.Printf("OK   %-13s %s\n", v.name, found)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d checks failed", failed)
+	}
+	return nil
+}
+
+func configChecks(cfg config.Configuration) []check {
+	return []check{
+		{"jwt keys", func() (string, error) {
+			keySet, err := authen.GetKeySet()
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%d public keys", len(keySet.JWKS().Keys)), nil
+		}},
+		{"storage", func() (string, error) {
+			switch cfg.Storage.Driver {
+			case MONGO_STORAGE, "":
+				return pingMongo(cfg.Mongo)
+			case MEMORY_STORAGE:
+				if cfg.Storage.Fixture == "" {
+					return "memory, empty", nil
+				}
+				fixture, err := repository.ReadFixture(cfg.Storage.Fixture)
+				if err != nil {
+					return "", err
+				}
+				err = repository.NewMemoryStore().Seed(*fixture)
+				if err != nil {
+					return "", fmt.Errorf("seed: %w", err)
+				}
+				return "memory, seeded from " + cfg.Storage.Fixture, nil
+			}
+			return "", fmt.Errorf("unknown storage %q, use mongo or memory", cfg.Storage.Driver)
+		}},
+		{"cloud storage", func() (string, error) {
+			storageCfg := cfg
+			memoryCloudStorage(&storageCfg)
+			_, err := cloudstorage.NewStorage(storageCfg)
+			if err != nil {
+				return "", err
+			}
+			driver := storageCfg.CloudStorage.Driver
+			if driver == "" {
+				driver = cloudstorage.GCS_DRIVER
+			}
+			return driver + ", " + cloudstorage.BaseURL(storageCfg), nil
+		}},
+		{"notification", func() (string, error) {
+			sender, err := notification.NewSender(cfg.Notification)
+			if err != nil {
+				return "", err
+			}
+			if sender == nil {
+				return "off", nil
+			}
+			_, err = notification.NewEmailHandlers(cfg.Notification, sender)
+			if err != nil {
+				return "", err
+			}
+			return cfg.Notification.Driver, nil
+		}},
+		{"time zone", func() (string, error) {
+			location, err := time.LoadLocation(cfg.Export.TimeZone)
+			if err != nil {
+				return "", err
+			}
+			return location.String(), nil
+		}},
+		{"font", func() (string, error) {
+			if cfg.Certificate.FontFile == "" {
+				return "built in, no Thai glyphs", nil
+			}
+			_, err := os.Stat(cfg.Certificate.FontFile)
+			if err != nil {
+				return "", err
+			}
+			return cfg.Certificate.FontFile, nil
+		}},
+		{"indexer", func() (string, error) {
+			if !cfg.Indexer.Enabled {
+				return "off", nil
+			}
+			if cfg.Indexer.RpcURL == "" {
+				return "", errors.New("rpc url is not set")
+			}
+			// the node is only dialed when the indexer runs
+			_, err := indexer.NewIndexer(cfg.Indexer, nil, nil, nil)
+			if err != nil {
+				return "", err
+			}
+			return cfg.Indexer.ContractAddress, nil
+		}},
+	}
+}
+
+// pingMongo connects to mongo and counts the pending migrations, without
+// the log.Fatal of connectMongo so the other checks still run
+func pingMongo(cfg config.MongoConfig) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(mongoURI(cfg)))
+	if err != nil {
+		return "", err
+	}
+	defer client.Disconnect(context.Background())
+	err = client.Ping(ctx, readpref.Primary())
+	if err != nil {
+		return "", err
+	}
+	migrator, err := migration.NewMigrator(migration.Databases{
+		Accounts:     client.Database(ACCOUNTS_DATABASE),
+		Certificates: client.Database(CERTIFICATES_DATABASE),
+	}, migration.All)
+	if err != nil {
+		return "", err
+	}
+	pending, err := migrator.Pending(ctx)
+	if err != nil {
+		return "", err
+	}
+	if len(pending) > 0 {
+		return "", fmt.Errorf("%d migrations are pending, run `migrate up`", len(pending))
+	}
+	return "mongo, migrations are up to date", nil
+}
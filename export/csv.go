@@ -0,0 +1,37 @@
+package export
+
+import (
+	"encoding/csv"
+	"io"
+	"strings"
+)
+
+type CsvWriter struct {
+	w *csv.Writer
+}
+
+// NewCsvWriter writes a UTF-8 byte order mark first so Excel shows Thai
+// names correctly.
+func NewCsvWriter(w io.Writer) *CsvWriter {
+	w.Write([]byte("\xEF\xBB\xBF"))
+	return &CsvWriter{w: csv.NewWriter(w)}
+}
+
+// WriteRow implements RowWriter
+func (c *CsvWriter) WriteRow(cells []Cell) error {
+	record := make([]string, len(cells))
+	for i, v := range cells {
+		record[i] = v.Value
+		// keep spreadsheets from running user input as a formula
+		if !v.Number && v.Value != "" && strings.ContainsRune("=+-@\t\r", rune(v.Value[0])) {
+			record[i] = "'" + v.Value
+		}
+	}
+	return c.w.Write(record)
+}
+
+// Close implements RowWriter
+func (c *CsvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
@@ -0,0 +1,54 @@
+package export
+
+import (
+	"fmt"
+	"io"
+)
+
+const (
+	CSV_FORMAT  = "csv"
+	XLSX_FORMAT = "xlsx"
+)
+
+// Cell is one value of a row. Numbers are written as numbers in formats that
+// have types so spreadsheets can sum them, and as text where a spreadsheet
+// would round them.
+type Cell struct {
+	Value  string
+	Number bool
+}
+
+func Text(value string) Cell {
+	return Cell{Value: value}
+}
+
+func Number(value string) Cell {
+	return Cell{Value: value, Number: true}
+}
+
+// RowWriter writes a table row by row without holding it in memory.
+// Close must be called to finish the file.
+type RowWriter interface {
+	WriteRow(cells []Cell) error
+	Close() error
+}
+
+// NewRowWriter returns the writer of format, csv or xlsx
+func NewRowWriter(format string, w io.Writer) (RowWriter, error) {
+	switch format {
+	case CSV_FORMAT:
+		return NewCsvWriter(w), nil
+	case XLSX_FORMAT:
+		return NewXlsxWriter(w, "Sheet1")
+	default:
+		return nil, fmt.Errorf("unknown export format %q", format)
+	}
+}
+
+// ContentType returns the mime type of format
+func ContentType(format string) string {
+	if format == XLSX_FORMAT {
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	}
+	return "text/csv; charset=utf-8"
+}
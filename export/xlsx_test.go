@@ -0,0 +1,74 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestExactNumber(t *testing.T) {
+	tests := []struct {
+		value string
+		want  bool
+	}{
+		{"15", true},
+		{"-2.5", true},
+		{"123456789012345", true},
+		{"1500000000000000000", true},
+		// a price in wei with more digits is rounded by a spreadsheet
+		{"1500000000000000001", false},
+		{"1234567890123456", false},
+		{"1.50", false},
+		{"1e3", false},
+		{"", false},
+		{"abc", false},
+	}
+	for _, tt := range tests {
+		if got := exactNumber(tt.value); got != tt.want {
+			t.Errorf("exactNumber(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestXlsxWriterNumbers(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w, err := NewXlsxWriter(buf, "Redeemed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.WriteRow([]Cell{Number("15"), Number("1500000000000000001"), Text("15")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	z, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sheet := ""
+	for _, f := range z.File {
+		if f.Name == "xl/worksheets/sheet1.xml" {
+			rc, err := f.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, _ := ioutil.ReadAll(rc)
+			rc.Close()
+			sheet = string(data)
+		}
+	}
+	for _, want := range []string{
+		`<c r="A1"><v>15</v></c>`,
+		`<c r="B1" t="inlineStr"><is><t xml:space="preserve">1500000000000000001</t></is></c>`,
+		`<c r="C1" t="inlineStr"><is><t xml:space="preserve">15</t></is></c>`,
+	} {
+		if !strings.Contains(sheet, want) {
+			t.Fatalf("sheet %s has no %s", sheet, want)
+		}
+	}
+}
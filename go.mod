@@ -1,13 +1,19 @@
 module github.com/seenark/super-backend-temp
+
 // +heroku goVersion go1.16
 go 1.16
 
 require (
-	cloud.google.com/go/storage v1.22.0 // indirect
+	cloud.google.com/go/storage v1.22.0
+	github.com/ethereum/go-ethereum v1.10.17
+	github.com/go-playground/validator/v10 v10.10.0
 	github.com/gofiber/fiber/v2 v2.26.0
-	github.com/golang-jwt/jwt v3.2.2+incompatible // indirect
+	github.com/golang-jwt/jwt v3.2.2+incompatible
 	github.com/google/uuid v1.3.0
+	github.com/jung-kurt/gofpdf v1.16.2
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
 	github.com/spf13/viper v1.10.1
+	github.com/valyala/fasthttp v1.32.0
 	go.mongodb.org/mongo-driver v1.8.3
-	golang.org/x/crypto v0.0.0-20220210151621-f4118a5b28e2 // indirect
+	golang.org/x/crypto v0.0.0-20220210151621-f4118a5b28e2
 )
@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/seenark/super-backend-temp/authen"
+	"github.com/seenark/super-backend-temp/repository"
+)
+
+func NewApiKeyHandler(router fiber.Router, apiKeyDb repository.ApiKeyRepository, roleDb repository.RoleRepository) {
+	canManage := RequirePermission(roleDb, repository.API_KEY_MANAGE_PERMISSION)
+
+	/*
+		func description require body: {
+			name: <name>,
+			role: <role name, e.g. eventLogger>,
+			routes: ["POST /api/redeemed/redeem-event"],
+			expires_in_days: <0 for no expiry>
+		}
+		the plain api key is only returned here
+	*/
+	router.Post("/", RequiredValidJWT, canManage, func(c *fiber.Ctx) error {
+		body := CreateApiKeyRequest{}
+		err := bindBody(c, &body)
+		if err != nil {
+			return err
+		}
+		role, err := roleDb.GetByName(c.UserContext(), body.Role)
+		if err != nil {
+			return fieldError("role", "exists", "role does not exist")
+		}
+		principal, _ := GetPrincipal(c)
+		// a key can not do more than the one who creates it
+		for _, permission := range role.Permissions {
+			if !hasPermission(c.UserContext(), roleDb, principal, permission) {
+				return fiber.NewError(fiber.StatusForbidden, "permission denied, the role of the key has "+permission)
+			}
+		}
+
+		key, prefix, err := authen.GenerateApiKey()
+		if err != nil {
+			log.Println(err)
+			return c.SendStatus(fiber.StatusInternalServerError)
+		}
+		newApiKey := repository.ApiKey{
+			Name:      body.Name,
+			Prefix:    prefix,
+			Hash:      authen.HashApiKey(key),
+			Role:      body.Role,
+			Routes:    body.Routes,
+			CreatedBy: principal.Id,
+		}
+		if body.ExpiresInDays > 0 {
+			expiresAt := time.Now().AddDate(0, 0, body.ExpiresInDays)
+			newApiKey.ExpiresAt = &expiresAt
+		}
+		apiKey, err := apiKeyDb.Create(c.UserContext(), newApiKey)
+		if err != nil {
+			log.Println(err)
+			return fiber.NewError(fiber.StatusInternalServerError, "error while creating api key")
+		}
+		return c.JSON(fiber.Map{"api_key": key, "key": apiKey})
+	})
+
+	router.Get("/", RequiredValidJWT, canManage, func(c *fiber.Ctx) error {
+		return c.JSON(apiKeyDb.GetAll(c.UserContext()))
+	})
+
+	router.Delete("/:id", RequiredValidJWT, canManage, func(c *fiber.Ctx) error {
+		apiKey, err := apiKeyDb.Revoke(c.UserContext(), c.Params("id"))
+		if err != nil {
+			log.Println(err)
+			return fiber.NewError(fiber.StatusNotFound, "api key not found or already revoked")
+		}
+		return c.JSON(apiKey)
+	})
+}
@@ -0,0 +1,86 @@
+package handler_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/seenark/super-backend-temp/repository"
+)
+
+func TestApiKeyHandler(t *testing.T) {
+	a := newTestApp(t)
+	admin := a.token(repository.ADMIN_ROLE, false)
+	user := a.token(repository.USER_ROLE, false)
+	newKey := map[string]interface{}{"name": "indexer", "role": repository.EVENT_LOGGER_ROLE, "routes": []string{"POST /api/redeemed/redeem-event"}}
+
+	a.run([]request{
+		{"create without permission", http.MethodPost, "/api/api-key/", user, newKey, http.StatusForbidden},
+		{"create with an unknown role", http.MethodPost, "/api/api-key/", admin, map[string]string{"name": "x", "role": "nobody"}, http.StatusBadRequest},
+		{"list", http.MethodGet, "/api/api-key/", admin, nil, http.StatusOK},
+		{"revoke unknown key", http.MethodDelete, "/api/api-key/nope", admin, nil, http.StatusNotFound},
+	})
+
+	res, body := a.do(request{method: http.MethodPost, path: "/api/api-key/", token: admin, body: newKey})
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("create = %d: %s", res.StatusCode, body)
+	}
+	created := struct {
+		ApiKey string            `json:"api_key"`
+		Key    repository.ApiKey `json:"key"`
+	}{}
+	decode(t, body, &created)
+
+	event := map[string]interface{}{"tx_hash": "0x" + repeat("a", 64), "redeem_id": 1, "wallet_address": "0x" + repeat("b", 40)}
+	tests := []struct {
+		name   string
+		key    string
+		status int
+	}{
+		{"ingest with the key", created.ApiKey, http.StatusOK},
+		{"ingest with a wrong key", "nope", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, _ := json.Marshal(event)
+			req := newJSONRequest(http.MethodPost, "/api/redeemed/redeem-event", data)
+			req.Header.Set("X-API-Key", tt.key)
+			res, err := a.app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.StatusCode != tt.status {
+				t.Fatalf("status = %d, want %d", res.StatusCode, tt.status)
+			}
+		})
+	}
+
+	a.run([]request{
+		{"revoke", http.MethodDelete, "/api/api-key/" + created.Key.Id.Hex(), admin, nil, http.StatusOK},
+		{"revoke twice", http.MethodDelete, "/api/api-key/" + created.Key.Id.Hex(), admin, nil, http.StatusNotFound},
+	})
+}
+
+func TestApiKeyHandlerRole(t *testing.T) {
+	a := newTestApp(t)
+	_, err := a.repos.Roles.Upsert(ctx, repository.Role{Name: "keyManager", Permissions: []string{repository.API_KEY_MANAGE_PERMISSION, repository.REDEEM_INGEST_PERMISSION}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager := a.token("keyManager", false)
+	superAdmin := a.token(repository.ADMIN_ROLE, true)
+	newKey := func(role string) map[string]interface{} {
+		return map[string]interface{}{"name": "indexer", "role": role, "routes": []string{"POST /api/redeemed/redeem-event"}}
+	}
+
+	a.run([]request{
+		{"a role with fewer permissions", http.MethodPost, "/api/api-key/", manager, newKey(repository.EVENT_LOGGER_ROLE), http.StatusOK},
+		{"the own role", http.MethodPost, "/api/api-key/", manager, newKey("keyManager"), http.StatusOK},
+		{"a role with more permissions", http.MethodPost, "/api/api-key/", manager, newKey(repository.ADMIN_ROLE), http.StatusForbidden},
+		{"a role with other permissions", http.MethodPost, "/api/api-key/", manager, newKey(repository.USER_ROLE), http.StatusForbidden},
+		{"super admin", http.MethodPost, "/api/api-key/", superAdmin, newKey(repository.ADMIN_ROLE), http.StatusOK},
+	})
+	if keys := a.repos.ApiKeys.GetAll(ctx); len(keys) != 3 {
+		t.Fatalf("created %d keys, want 3", len(keys))
+	}
+}
@@ -1,40 +1,39 @@
 package handler
 
 import (
+	"context"
 	"fmt"
+	"log"
+	"net/url"
 	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/seenark/super-backend-temp/authen"
+	"github.com/seenark/super-backend-temp/config"
 	"github.com/seenark/super-backend-temp/repository"
 )
 
-func NewAuthHandler(router fiber.Router, authenDb repository.AuthenticationRepository) {
-
-	router.Post("/signin", func(c *fiber.Ctx) error {
-
-		email := c.FormValue("email")
-		password := c.FormValue("password")
-
-		if email == "" || password == "" {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "invalid email or password"})
-		}
-		token, err := authenDb.Signin(email, password)
-		if err != nil {
-			fmt.Printf("err: %v\n", err)
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "invalid email or password"})
+// NewAuthHandler serves sign-in and sessions. sessionDb is also the store
+// RequiredValidJWT checks the session of access tokens against.
+func NewAuthHandler(router fiber.Router, authenDb repository.AuthenticationRepository, nonceDb repository.NonceRepository, sessionDb repository.SessionRepository) {
+	liveSessions.use(sessionDb)
+	cfg := config.GetConfig()
+	siweDomain := cfg.Siwe.Domain
+	if siweDomain == "" {
+		appUrl, err := url.Parse(cfg.App.Domain)
+		if err == nil {
+			siweDomain = appUrl.Host
 		}
-		userRes := UserResponse{
-			Id:              token.User.Id.Hex(),
-			Name:            token.User.Name,
-			Email:           token.User.Email,
-			Role:            token.User.Role,
-			MetamaskAddress: token.User.MetamaskAddress,
-			Address:         token.User.Address,
-			Tel:             token.User.Tel,
-		}
-		return c.Status(fiber.StatusOK).JSON(fiber.Map{"user": userRes, "token": token.Token, "refresh": token.Refresh})
-	})
+	}
+	nonceExpire := time.Duration(cfg.Siwe.NonceExpire) * time.Second
+	if nonceExpire <= 0 {
+		nonceExpire = 5 * time.Minute
+	}
+
+	router.Post("/signin", passwordSigninHandler(authenDb.Signin))
+	router.Post("/admin/signin", passwordSigninHandler(authenDb.AdminSignin))
+	router.Post("/event-logger/signin", passwordSigninHandler(authenDb.EventLoggerSigin))
 
 	/*
 		func description require body: {
@@ -42,31 +41,33 @@ func NewAuthHandler(router fiber.Router, authenDb repository.AuthenticationRepos
 		}
 	*/
 	router.Post("/refresh-access-token", func(c *fiber.Ctx) error {
-		bearer := c.Get("Authorization")
-		if bearer == "" {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "no jwt token found in header"})
-		}
-		jwt := strings.Split(bearer, " ")[1]
+		jwt := bearerToken(c)
 		if jwt == "" {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "no jwt token found in header"})
+			return fiber.NewError(fiber.StatusUnauthorized, "no jwt token found in header")
 		}
 
-		refreshMap := map[string]string{}
-		c.BodyParser(&refreshMap)
-		rt := refreshMap["refresh"]
+		body := RefreshRequest{}
+		err := bindBody(c, &body)
+		if err != nil {
+			return err
+		}
+		rt := body.Refresh
 		claims, err := authen.ValidateRefreshJWT(rt)
 		if err != nil {
 			fmt.Printf("err: %v\n", err)
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "refresh token is invalid"})
+			return fiber.NewError(fiber.StatusBadRequest, "refresh token is invalid")
 		}
 
-		originalJWT := claims["jwt"]
-		if jwt != originalJWT {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "refresh token is invalid"})
+		if jwt != claims.AccessToken {
+			return fiber.NewError(fiber.StatusBadRequest, "refresh token is invalid")
+		}
+		newAccessToken, err := authenDb.NewAccessTokenAndRefreshToken(c.UserContext(), claims.Subject, rt, clientInfo(c))
+		if err == repository.ErrRefreshTokenReused {
+			liveSessions.forget(claims.SessionId)
+			return fiber.NewError(fiber.StatusUnauthorized, err.Error())
 		}
-		newAccessToken, err := authenDb.NewAccessTokenAndRefreshToken(fmt.Sprintf("%s", claims["id"]), rt)
 		if err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": fmt.Sprintf("refresh token is invalid -> %s", err.Error())})
+			return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("refresh token is invalid -> %s", err.Error()))
 		}
 		userRes := UserResponse{
 			Id:              newAccessToken.User.Id.Hex(),
@@ -81,13 +82,96 @@ func NewAuthHandler(router fiber.Router, authenDb repository.AuthenticationRepos
 		return c.Status(fiber.StatusOK).JSON(fiber.Map{"user": userRes, "token": newAccessToken.Token, "refresh": newAccessToken.Refresh})
 	})
 
+	// Sign-In with Ethereum, the wallet signs a message containing this nonce
+	router.Get("/siwe/nonce", func(c *fiber.Ctx) error {
+		nonce, err := authen.GenerateNonce()
+		if err != nil {
+			log.Println(err)
+			return c.SendStatus(fiber.StatusInternalServerError)
+		}
+		newNonce, err := nonceDb.Create(c.UserContext(), nonce, time.Now().Add(nonceExpire))
+		if err != nil {
+			log.Println(err)
+			return fiber.NewError(fiber.StatusInternalServerError, "could not create nonce")
+		}
+		return c.JSON(fiber.Map{"nonce": newNonce.Nonce, "exp": newNonce.ExpiresAt.Unix()})
+	})
+
+	/*
+		func description require body: {
+			message: <EIP-4361 message>,
+			signature: <0x personal_sign signature>
+		}
+	*/
+	router.Post("/siwe/verify", func(c *fiber.Ctx) error {
+		body := SiweVerifyRequest{}
+		err := bindBody(c, &body)
+		if err != nil {
+			return err
+		}
+		siwe, err := authen.VerifySiwe(body.Message, body.Signature, siweDomain, cfg.Siwe.ChainID)
+		if err != nil {
+			fmt.Printf("err: %v\n", err)
+			return fiber.NewError(fiber.StatusUnauthorized, "invalid signed message")
+		}
+		err = nonceDb.Consume(c.UserContext(), siwe.Nonce)
+		if err != nil {
+			fmt.Printf("err: %v\n", err)
+			return fiber.NewError(fiber.StatusUnauthorized, "nonce is invalid or expired")
+		}
+		token, err := authenDb.WalletSignin(c.UserContext(), siwe.Address, cfg.Siwe.AutoRegister, clientInfo(c))
+		if err != nil {
+			fmt.Printf("err: %v\n", err)
+			return fiber.NewError(fiber.StatusUnauthorized, "wallet is not registered")
+		}
+		userRes := mapUserToUserResponse(*token.User)
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{"user": userRes, "token": token.Token, "refresh": token.Refresh})
+	})
+
 	router.Post("/signout", RequiredValidJWT, func(c *fiber.Ctx) error {
-		user, ok := c.Locals("user").([]string)
+		principal, ok := GetPrincipal(c)
 		if !ok {
 			return c.SendStatus(fiber.StatusInternalServerError)
 		}
-		err := authenDb.Signout(user[0])
-		return err
+		err := authenDb.Signout(c.UserContext(), principal.Id, principal.SessionId)
+		liveSessions.forget(principal.SessionId)
+		if err != nil {
+			log.Println(err)
+			return fiber.NewError(fiber.StatusBadRequest, "session already ended")
+		}
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	// list logins of the current user
+	router.Get("/sessions", RequiredValidJWT, func(c *fiber.Ctx) error {
+		principal, ok := GetPrincipal(c)
+		if !ok {
+			return c.SendStatus(fiber.StatusInternalServerError)
+		}
+		type SessionResponse struct {
+			repository.Session
+			Current bool `json:"current"`
+		}
+		sessionsRes := []SessionResponse{}
+		for _, v := range sessionDb.GetActiveByUserId(c.UserContext(), principal.Id) {
+			sessionsRes = append(sessionsRes, SessionResponse{Session: v, Current: v.Id == principal.SessionId})
+		}
+		return c.JSON(sessionsRes)
+	})
+
+	// end one login of the current user
+	router.Delete("/sessions/:id", RequiredValidJWT, func(c *fiber.Ctx) error {
+		principal, ok := GetPrincipal(c)
+		if !ok {
+			return c.SendStatus(fiber.StatusInternalServerError)
+		}
+		err := sessionDb.Revoke(c.UserContext(), principal.Id, c.Params("id"), "revoked by user")
+		liveSessions.forget(c.Params("id"))
+		if err != nil {
+			log.Println(err)
+			return fiber.NewError(fiber.StatusNotFound, "session not found")
+		}
+		return c.SendStatus(fiber.StatusOK)
 	})
 
 	router.Get("/verify-token", RequiredValidJWT, func(c *fiber.Ctx) error {
@@ -97,50 +181,107 @@ func NewAuthHandler(router fiber.Router, authenDb repository.AuthenticationRepos
 }
 
 func RequiredValidJWT(c *fiber.Ctx) error {
-	bearer := c.Get("Authorization")
-	if bearer == "" {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "no jwt token found in header"})
-	}
-	jwt := strings.Split(bearer, " ")[1]
+	jwt := bearerToken(c)
 	if jwt == "" {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "no jwt token found in header"})
+		return fiber.NewError(fiber.StatusUnauthorized, "no jwt token found in header")
 	}
 	claims, err := authen.ValidateJWT(jwt)
 	if err != nil {
 		fmt.Printf("err: %v\n", err)
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "jwt invalid"})
+		return fiber.NewError(fiber.StatusUnauthorized, "jwt invalid")
 	}
-	id := fmt.Sprintf("%v", claims["id"])
-	fmt.Printf("id: %v\n", id)
-	email := fmt.Sprintf("%v", claims["id"])
-	metamask := fmt.Sprintf("%v", claims["metamask_address"])
-	role := fmt.Sprintf("%v", claims["role"])
-	c.Locals("user", []string{id, email, metamask, role})
+	// a signed token outlives its session, signing out must end it
+	live, err := liveSessions.isLive(c.UserContext(), claims.Subject, claims.SessionId)
+	if err != nil {
+		return err
+	}
+	if !live {
+		return fiber.NewError(fiber.StatusUnauthorized, "session has ended")
+	}
+	setPrincipal(c, &Principal{
+		Id:              claims.Subject,
+		Email:           claims.Email,
+		MetamaskAddress: claims.MetamaskAddress,
+		Role:            claims.Role,
+		SessionId:       claims.SessionId,
+		SuperAdmin:      claims.SuperAdmin,
+	})
 	return c.Next()
 }
 
-/* must call after middleware NeedValidJWT */
-func RequiredAdminRole(c *fiber.Ctx) error {
-	userData, ok := c.Locals("user").([]string)
-	if !ok {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "you are not admin"})
+// passwordSigninHandler serves every email and password sign-in route, signin
+// decides which roles are allowed to log in through it.
+func passwordSigninHandler(signin func(ctx context.Context, email string, password string, client repository.ClientInfo) (*repository.SignInData, error)) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		body := SigninRequest{}
+		err := bindBody(c, &body)
+		if err != nil {
+			return err
+		}
+		token, err := signin(c.UserContext(), body.Email, body.Password, clientInfo(c))
+		if err != nil {
+			fmt.Printf("err: %v\n", err)
+			return fiber.NewError(fiber.StatusBadRequest, "invalid email or password")
+		}
+		userRes := mapUserToUserResponse(*token.User)
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{"user": userRes, "token": token.Token, "refresh": token.Refresh})
 	}
-	role := userData[3]
-	if role != "admin" {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "you are not admin"})
+}
+
+// bearerToken returns the token of "Authorization: Bearer <token>" or ""
+func bearerToken(c *fiber.Ctx) string {
+	parts := strings.SplitN(c.Get(fiber.HeaderAuthorization), " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
+		return ""
 	}
-	return c.Next()
+	return strings.TrimSpace(parts[1])
 }
 
-/* must call after middleware NeedValidJWT */
-func RequiredEventLoggerRole(c *fiber.Ctx) error {
-	userData, ok := c.Locals("user").([]string)
-	if !ok {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "you are not eventLogger role"})
+func clientInfo(c *fiber.Ctx) repository.ClientInfo {
+	return repository.ClientInfo{
+		Device: c.Get(fiber.HeaderUserAgent),
+		IP:     c.IP(),
 	}
-	role := userData[3]
-	if role != "eventLogger" {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "you are not eventLogger role"})
+}
+
+const ApiKeyHeader = "X-API-Key"
+
+// RequiredValidAPIKey authenticates machine clients by the X-API-Key header.
+// It sets the Principal the same way as RequiredValidJWT so the role
+// middlewares work for both.
+func RequiredValidAPIKey(apiKeyDb repository.ApiKeyRepository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		key := c.Get(ApiKeyHeader)
+		if key == "" {
+			return fiber.NewError(fiber.StatusUnauthorized, "no api key found in header")
+		}
+		apiKey, err := apiKeyDb.GetByHash(c.UserContext(), authen.HashApiKey(key))
+		if err != nil || !apiKey.IsActive(time.Now()) {
+			return fiber.NewError(fiber.StatusUnauthorized, "api key invalid")
+		}
+		if !apiKey.AllowsRoute(c.Method(), c.Route().Path) {
+			return fiber.NewError(fiber.StatusForbidden, "api key is not allowed on this route")
+		}
+		err = apiKeyDb.Touch(c.UserContext(), apiKey.Id)
+		if err != nil {
+			log.Println(err)
+		}
+		setPrincipal(c, &Principal{
+			Id:     apiKey.Id.Hex(),
+			Role:   apiKey.Role,
+			ApiKey: true,
+		})
+		return c.Next()
+	}
+}
+
+// RequiredValidJWTOrAPIKey accepts either an API key or a user JWT
+func RequiredValidJWTOrAPIKey(apiKeyDb repository.ApiKeyRepository) fiber.Handler {
+	requiredValidAPIKey := RequiredValidAPIKey(apiKeyDb)
+	return func(c *fiber.Ctx) error {
+		if c.Get(ApiKeyHeader) != "" {
+			return requiredValidAPIKey(c)
+		}
+		return RequiredValidJWT(c)
 	}
-	return c.Next()
 }
@@ -0,0 +1,173 @@
+package handler_test
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/seenark/super-backend-temp/authen"
+	"github.com/seenark/super-backend-temp/config"
+	"github.com/seenark/super-backend-temp/handler"
+	"github.com/seenark/super-backend-temp/repository"
+)
+
+type signinResponse struct {
+	Token   authen.TokenData `json:"token"`
+	Refresh authen.TokenData `json:"refresh"`
+}
+
+func TestAuthHandlerSignin(t *testing.T) {
+	a := newTestApp(t)
+	for _, user := range []repository.User{
+		{Email: "user@example.com", Password: "password1", Role: repository.USER_ROLE},
+		{Email: "admin@example.com", Password: "password1", Role: repository.ADMIN_ROLE},
+		{Email: "logger@example.com", Password: "password1", Role: repository.EVENT_LOGGER_ROLE},
+	} {
+		_, err := a.repos.Users.Create(ctx, user)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	credentials := func(email string, password string) map[string]string {
+		return map[string]string{"email": email, "password": password}
+	}
+
+	a.run([]request{
+		{"user", http.MethodPost, "/api/authen/signin", "", credentials("user@example.com", "password1"), http.StatusOK},
+		{"wrong password", http.MethodPost, "/api/authen/signin", "", credentials("user@example.com", "nope"), http.StatusBadRequest},
+		{"missing password", http.MethodPost, "/api/authen/signin", "", map[string]string{"email": "user@example.com"}, http.StatusBadRequest},
+		{"admin", http.MethodPost, "/api/authen/admin/signin", "", credentials("admin@example.com", "password1"), http.StatusOK},
+		{"user on the admin route", http.MethodPost, "/api/authen/admin/signin", "", credentials("user@example.com", "password1"), http.StatusBadRequest},
+		{"event logger", http.MethodPost, "/api/authen/event-logger/signin", "", credentials("logger@example.com", "password1"), http.StatusOK},
+		{"siwe nonce", http.MethodGet, "/api/authen/siwe/nonce", "", nil, http.StatusOK},
+		{"siwe with a bad signature", http.MethodPost, "/api/authen/siwe/verify", "", map[string]string{"message": "hello", "signature": "0x00"}, http.StatusUnauthorized},
+		{"verify without a token", http.MethodGet, "/api/authen/verify-token", "", nil, http.StatusUnauthorized},
+		{"verify a bad token", http.MethodGet, "/api/authen/verify-token", "nope", nil, http.StatusUnauthorized},
+	})
+}
+
+func TestAuthHandlerSiwe(t *testing.T) {
+	a := newTestApp(t)
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := crypto.PubkeyToAddress(key.PublicKey).Hex()
+	// someone registers with the address before its owner signs in
+	a.run([]request{
+		{"register with the address", http.MethodPost, "/api/user/", "", map[string]string{"email": "squatter@example.com", "password": "password1", "metamask_address": address}, http.StatusOK},
+	})
+
+	signedMessage := func() map[string]string {
+		t.Helper()
+		_, body := a.do(request{method: http.MethodGet, path: "/api/authen/siwe/nonce"})
+		nonce := struct {
+			Nonce string `json:"nonce"`
+		}{}
+		decode(t, body, &nonce)
+		message := fmt.Sprintf("%s wants you to sign in with your Ethereum account:\n%s\n\nSign in to the test.\n\nURI: http://%[1]s\nVersion: 1\nChain ID: 1\nNonce: %[3]s\nIssued At: %[4]s",
+			config.GetConfig().Siwe.Domain, address, nonce.Nonce, time.Now().UTC().Format(time.RFC3339))
+		signature, err := crypto.Sign(accounts.TextHash([]byte(message)), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// as wallets sign, v is 27 or 28
+		signature[crypto.RecoveryIDOffset] += 27
+		return map[string]string{"message": message, "signature": hexutil.Encode(signature)}
+	}
+	signin := signedMessage()
+	res, body := a.do(request{method: http.MethodPost, path: "/api/authen/siwe/verify", body: signin})
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("verify = %d: %s", res.StatusCode, body)
+	}
+	data := struct {
+		User  handler.UserResponse `json:"user"`
+		Token authen.TokenData     `json:"token"`
+	}{}
+	decode(t, body, &data)
+	if data.User.Email != "" || data.User.MetamaskAddress != address {
+		t.Fatalf("signed in as %+v", data.User)
+	}
+	claims, err := authen.ValidateJWT(data.Token.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.MetamaskAddress != address || claims.Subject != data.User.Id {
+		t.Fatalf("claims = %+v", claims)
+	}
+
+	a.run([]request{
+		{"verify token", http.MethodGet, "/api/authen/verify-token", data.Token.Token, nil, http.StatusOK},
+		{"nonce used twice", http.MethodPost, "/api/authen/siwe/verify", "", signin, http.StatusUnauthorized},
+		{"signature of another message", http.MethodPost, "/api/authen/siwe/verify", "", func() map[string]string {
+			other := signedMessage()
+			other["signature"] = signin["signature"]
+			return other
+		}(), http.StatusUnauthorized},
+		{"sign in again", http.MethodPost, "/api/authen/siwe/verify", "", signedMessage(), http.StatusOK},
+	})
+}
+
+func TestAuthHandlerSessions(t *testing.T) {
+	a := newTestApp(t)
+	_, err := a.repos.Users.Create(ctx, repository.User{Email: "user@example.com", Password: "password1", Role: repository.USER_ROLE})
+	if err != nil {
+		t.Fatal(err)
+	}
+	signin := func() signinResponse {
+		t.Helper()
+		res, body := a.do(request{method: http.MethodPost, path: "/api/authen/signin", body: map[string]string{"email": "user@example.com", "password": "password1"}})
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("sign in = %d: %s", res.StatusCode, body)
+		}
+		data := signinResponse{}
+		decode(t, body, &data)
+		return data
+	}
+	first := signin()
+	second := signin()
+	refresh := map[string]string{"refresh": first.Refresh.Token}
+
+	a.run([]request{
+		{"verify", http.MethodGet, "/api/authen/verify-token", first.Token.Token, nil, http.StatusOK},
+		{"refresh with another access token", http.MethodPost, "/api/authen/refresh-access-token", second.Token.Token, refresh, http.StatusBadRequest},
+		{"refresh", http.MethodPost, "/api/authen/refresh-access-token", first.Token.Token, refresh, http.StatusOK},
+		{"refresh token reused", http.MethodPost, "/api/authen/refresh-access-token", first.Token.Token, refresh, http.StatusUnauthorized},
+	})
+
+	_, body := a.do(request{method: http.MethodGet, path: "/api/authen/sessions", token: second.Token.Token})
+	sessions := []struct {
+		Id      string `json:"id"`
+		Current bool   `json:"current"`
+	}{}
+	decode(t, body, &sessions)
+	if len(sessions) != 1 || !sessions[0].Current {
+		t.Fatalf("sessions = %s", body)
+	}
+
+	third := signin()
+	a.run([]request{
+		// reusing the refresh token of the first login ended it
+		{"access token of a reused session", http.MethodGet, "/api/authen/verify-token", first.Token.Token, nil, http.StatusUnauthorized},
+		{"end unknown session", http.MethodDelete, "/api/authen/sessions/nope", second.Token.Token, nil, http.StatusNotFound},
+		{"end another session", http.MethodDelete, "/api/authen/sessions/" + sessionId(t, third), second.Token.Token, nil, http.StatusOK},
+		{"access token of an ended session", http.MethodGet, "/api/authen/verify-token", third.Token.Token, nil, http.StatusUnauthorized},
+		{"still signed in", http.MethodGet, "/api/authen/verify-token", second.Token.Token, nil, http.StatusOK},
+		{"sign out", http.MethodPost, "/api/authen/signout", second.Token.Token, nil, http.StatusOK},
+		{"access token after sign out", http.MethodGet, "/api/authen/verify-token", second.Token.Token, nil, http.StatusUnauthorized},
+		{"sign out twice", http.MethodPost, "/api/authen/signout", second.Token.Token, nil, http.StatusUnauthorized},
+	})
+}
+
+func sessionId(t *testing.T, data signinResponse) string {
+	t.Helper()
+	claims, err := authen.ValidateJWT(data.Token.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return claims.SessionId
+}
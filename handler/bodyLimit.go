@@ -0,0 +1,30 @@
+package handler
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// RouteBodyLimit raises the body limit of POST requests to path, every other
+// request keeps the BodyLimit of the app. The body is read before any
+// handler runs, so it is set as the HeaderReceived of the fasthttp server.
+// A multipart body over the limit of the app is streamed to temporary files
+// instead of memory.
+func RouteBodyLimit(path string, limit int) func(header *fasthttp.RequestHeader) fasthttp.RequestConfig {
+	path = strings.TrimRight(path, "/")
+	return func(header *fasthttp.RequestHeader) fasthttp.RequestConfig {
+		if !header.IsPost() {
+			return fasthttp.RequestConfig{}
+		}
+		uri := header.RequestURI()
+		if i := bytes.IndexByte(uri, '?'); i >= 0 {
+			uri = uri[:i]
+		}
+		if string(bytes.TrimRight(uri, "/")) != path {
+			return fasthttp.RequestConfig{}
+		}
+		return fasthttp.RequestConfig{MaxRequestBodySize: limit}
+	}
+}
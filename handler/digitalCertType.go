@@ -1,10 +1,10 @@
 package handler
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"path/filepath"
-	"strings"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
@@ -12,83 +12,78 @@ import (
 	"github.com/seenark/super-backend-temp/repository"
 )
 
-func NewDigitalCertTypeHandler(router fiber.Router, certTypeRepo repository.IDigitalCertTypeRepository, uploader *cloudstorage.ClientUploader) {
+func NewDigitalCertTypeHandler(router fiber.Router, certTypeRepo repository.IDigitalCertTypeRepository, uploader cloudstorage.Storage, roleDb repository.RoleRepository) {
+	canWrite := RequirePermission(roleDb, repository.CERT_TYPE_WRITE_PERMISSION)
 
-	router.Post("/", func(c *fiber.Ctx) error {
+	router.Post("/", RequiredValidJWT, canWrite, func(c *fiber.Ctx) error {
 
-		typeCode := c.FormValue("type_code")
-		if typeCode == "" {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "type_code is invalid"})
-		}
-		typeName := c.FormValue("type_name")
-		if typeName == "" {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "type_name is invalid"})
+		body := CertTypeRequest{}
+		err := bindBody(c, &body)
+		if err != nil {
+			return err
 		}
 		logoFile, err := c.FormFile("logo_file")
 		if err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "logo_file is invalid"})
+			return fieldError("logo_file", "required", "logo_file is required")
 		}
 		newFile, err := logoFile.Open()
 		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "image file invalid"})
+			return fiber.NewError(fiber.StatusInternalServerError, "image file invalid")
 		}
 		logoName := uuid.New()
-		// fullName, err := saveImage(logoName.String(), logoFile, c)
-		// if err != nil {
-		// 	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "save logo image error"})
-		// }
-		typeOfUnit := c.FormValue("type_of_unit")
-		if typeOfUnit == "" {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "type_of_unit is invalid"})
-		}
-		unit := c.FormValue("unit")
-		if typeCode == "" {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "unit is invalid"})
-		}
-		vintageYear := c.FormValue("vintage_year")
 		ext := filepath.Ext(logoFile.Filename)
 		fullName := fmt.Sprintf("%s%s", logoName, ext)
-		certType, err := certTypeRepo.Create(typeCode, typeName, fullName, typeOfUnit, unit, vintageYear)
+		certType, err := certTypeRepo.Create(c.UserContext(), body.TypeCode, body.TypeName, fullName, body.TypeOfUnit, body.Unit, body.VintageYear)
 		if err != nil {
-			fmt.Printf("err: %v\n", err)
-			if strings.Contains(err.Error(), "E11000") {
-				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "type_code is duplicate"})
+			if errors.Is(err, repository.ErrDuplicate) {
+				return fiber.NewError(fiber.StatusConflict, "type_code is duplicate")
 			}
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "error while create new digital cert type"})
+			return err
 		}
 
-		err = uploader.UploadFile(newFile, fullName)
+		err = uploader.Upload(newFile, fullName)
 		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": fmt.Sprintf("upload image error please upload later %v", err)})
+			return fiber.NewError(fiber.StatusInternalServerError, fmt.Sprintf("upload image error please upload later %v", err))
 		}
 		return c.JSON(certType)
 	})
 
 	router.Get("/", func(c *fiber.Ctx) error {
-		allCertTypes := certTypeRepo.GetAll()
-		return c.JSON(allCertTypes)
+		query, err := parsePageQuery(c)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+		certTypes, page, err := certTypeRepo.GetPage(c.UserContext(), query)
+		if err != nil {
+			return err
+		}
+		return c.JSON(newPageResponse(certTypes, page))
 	})
 
 	router.Get("/:typeCode", func(c *fiber.Ctx) error {
 		typeCode := c.Params("typeCode")
-		certType, err := certTypeRepo.GetByTypeCode(typeCode)
+		certType, err := certTypeRepo.GetByTypeCode(c.UserContext(), typeCode)
 		if err != nil {
-			return c.Status(fiber.StatusNotFound).JSON(err)
+			return err
 		}
 		return c.JSON(certType)
 	})
 
-	router.Patch("/:typeCode", func(c *fiber.Ctx) error {
+	router.Patch("/:typeCode", RequiredValidJWT, canWrite, func(c *fiber.Ctx) error {
 		typeCode := c.Params("typeCode")
 
-		certType, err := certTypeRepo.GetByTypeCode(typeCode)
+		certType, err := certTypeRepo.GetByTypeCode(c.UserContext(), typeCode)
 		if err != nil {
-			return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"message": "type_code is not exist"})
+			return err
 		}
 
-		typeName := c.FormValue("type_name")
-		if typeName != "" {
-			certType.TypeName = typeName
+		body := UpdateCertTypeRequest{}
+		err = bindBody(c, &body)
+		if err != nil {
+			return err
+		}
+		if body.TypeName != "" {
+			certType.TypeName = body.TypeName
 		}
 		logoFile, err := c.FormFile("logo_file")
 
@@ -96,13 +91,11 @@ func NewDigitalCertTypeHandler(router fiber.Router, certTypeRepo repository.IDig
 			logoName := uuid.New()
 			ext := filepath.Ext(logoFile.Filename)
 			fullName := fmt.Sprintf("%s%s", logoName, ext)
-			// fullName, err := saveImage(logoName.String(), logoFile, c)
 			newFile, err := logoFile.Open()
 			if err == nil {
-				err = uploader.UploadFile(newFile, fullName)
+				err = uploader.Upload(newFile, fullName)
 				if err == nil {
-					// deleteImage(certType.LogoImageName)
-					err := uploader.DeleteFile(certType.LogoImageName)
+					err := uploader.Delete(certType.LogoImageName)
 					if err != nil {
 						log.Println(err)
 					}
@@ -111,36 +104,34 @@ func NewDigitalCertTypeHandler(router fiber.Router, certTypeRepo repository.IDig
 
 			}
 		}
-		typeOfUnit := c.FormValue("type_of_unit")
-		if typeOfUnit != "" {
-			certType.TypeOfUnit = typeOfUnit
+		if body.TypeOfUnit != "" {
+			certType.TypeOfUnit = body.TypeOfUnit
 		}
-		unit := c.FormValue("unit")
-		if typeCode != "" {
-			certType.Unit = unit
+		if body.Unit != "" {
+			certType.Unit = body.Unit
 		}
-		vintageYear := c.FormValue("vintage_year")
-		if vintageYear != "" {
-			certType.VintageYear = vintageYear
+		if body.VintageYear != "" {
+			certType.VintageYear = body.VintageYear
 		}
 
-		newCertType, err := certTypeRepo.Update(typeCode, *certType)
+		newCertType, err := certTypeRepo.Update(c.UserContext(), typeCode, *certType)
 		if err != nil {
-			log.Println(err)
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "error while update"})
+			return err
 		}
 		return c.JSON(newCertType)
 	})
 
-	router.Delete("/:typeCode", func(c *fiber.Ctx) error {
+	router.Delete("/:typeCode", RequiredValidJWT, canWrite, func(c *fiber.Ctx) error {
 		typeCode := c.Params("typeCode")
 
-		certType, err := certTypeRepo.Delete(typeCode)
+		certType, err := certTypeRepo.Delete(c.UserContext(), typeCode)
+		if err != nil {
+			return err
+		}
+		err = uploader.Delete(certType.LogoImageName)
 		if err != nil {
 			log.Println(err)
-			return c.Status(fiber.StatusBadRequest).JSON(err)
 		}
-		deleteImage(certType.LogoImageName)
 		return c.JSON(certType)
 	})
 }
@@ -0,0 +1,36 @@
+package handler_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/seenark/super-backend-temp/repository"
+)
+
+func TestDigitalCertTypeHandler(t *testing.T) {
+	a := newTestApp(t)
+	admin := a.token(repository.ADMIN_ROLE, false)
+	user := a.token(repository.USER_ROLE, false)
+	newType := func(files map[string]string) *form {
+		return &form{
+			fields: map[string]string{"type_code": "REC", "type_name": "Renewable", "type_of_unit": "energy", "unit": "MWh", "vintage_year": "2022"},
+			files:  files,
+		}
+	}
+	logo := map[string]string{"logo_file": "logo.png"}
+
+	a.run([]request{
+		{"create without permission", http.MethodPost, "/api/cert-type/", user, newType(logo), http.StatusForbidden},
+		{"create without a logo", http.MethodPost, "/api/cert-type/", admin, newType(nil), http.StatusBadRequest},
+		{"create without a type name", http.MethodPost, "/api/cert-type/", admin, &form{fields: map[string]string{"type_code": "REC"}, files: logo}, http.StatusBadRequest},
+		{"create", http.MethodPost, "/api/cert-type/", admin, newType(logo), http.StatusOK},
+		{"create a taken type code", http.MethodPost, "/api/cert-type/", admin, newType(logo), http.StatusConflict},
+		{"list", http.MethodGet, "/api/cert-type/", "", nil, http.StatusOK},
+		{"get", http.MethodGet, "/api/cert-type/REC", "", nil, http.StatusOK},
+		{"get unknown", http.MethodGet, "/api/cert-type/NOPE", "", nil, http.StatusNotFound},
+		{"update", http.MethodPatch, "/api/cert-type/REC", admin, &form{fields: map[string]string{"unit": "kWh"}}, http.StatusOK},
+		{"update unknown", http.MethodPatch, "/api/cert-type/NOPE", admin, &form{fields: map[string]string{"unit": "kWh"}}, http.StatusNotFound},
+		{"delete", http.MethodDelete, "/api/cert-type/REC", admin, nil, http.StatusOK},
+		{"delete twice", http.MethodDelete, "/api/cert-type/REC", admin, nil, http.StatusNotFound},
+	})
+}
@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/seenark/super-backend-temp/repository"
+	"github.com/seenark/super-backend-temp/validation"
+)
+
+// codes of ErrorResponse, clients may rely on them
+const (
+	BAD_REQUEST_CODE       = "bad_request"
+	INVALID_BODY_CODE      = "invalid_body"
+	VALIDATION_FAILED_CODE = "validation_failed"
+	UNAUTHORIZED_CODE      = "unauthorized"
+	FORBIDDEN_CODE         = "forbidden"
+	NOT_FOUND_CODE         = "not_found"
+	DUPLICATE_CODE         = "duplicate"
+	CONFLICT_CODE          = "conflict"
+	NO_CHANGE_CODE         = "no_change"
+	TIMEOUT_CODE           = "timeout"
+	TOO_MANY_REQUESTS_CODE = "too_many_requests"
+	INTERNAL_CODE          = "internal"
+	ERROR_CODE             = "error"
+)
+
+// ErrorResponse is the body of every failed request. Fields lists the
+// invalid fields of a validation_failed error, RequestID is the
+// X-Request-ID header to look the request up in the logs.
+type ErrorResponse struct {
+	Code      string                  `json:"code"`
+	Message   string                  `json:"message"`
+	Fields    []validation.FieldError `json:"fields"`
+	RequestID string                  `json:"request_id"`
+}
+
+// ErrorHandler answers every error returned by a handler with an
+// ErrorResponse. Repository errors map to their status, unknown errors are
+// logged and hidden behind a 500.
+func ErrorHandler(c *fiber.Ctx, err error) error {
+	status, response := errorResponse(err)
+	response.RequestID = c.GetRespHeader(fiber.HeaderXRequestID)
+	if response.Fields == nil {
+		response.Fields = []validation.FieldError{}
+	}
+	if status == fiber.StatusInternalServerError {
+		log.Printf("request %s %s %s: %v\n", response.RequestID, c.Method(), c.Path(), err)
+	}
+	return c.Status(status).JSON(response)
+}
+
+func errorResponse(err error) (int, ErrorResponse) {
+	fields := validation.Errors{}
+	fiberErr := &fiber.Error{}
+	switch {
+	case errors.As(err, &fields):
+		return fiber.StatusBadRequest, ErrorResponse{Code: VALIDATION_FAILED_CODE, Message: "request is invalid", Fields: fields}
+	case errors.Is(err, errInvalidBody):
+		return fiber.StatusBadRequest, ErrorResponse{Code: INVALID_BODY_CODE, Message: err.Error()}
+	case errors.As(err, &fiberErr):
+		return fiberErr.Code, ErrorResponse{Code: statusCode(fiberErr.Code), Message: fmt.Sprint(fiberErr.Message)}
+	case errors.Is(err, repository.ErrNotFound):
+		return fiber.StatusNotFound, ErrorResponse{Code: NOT_FOUND_CODE, Message: err.Error()}
+	case errors.Is(err, repository.ErrDuplicate):
+		// the driver error stays in the logs
+		log.Println(err)
+		return fiber.StatusConflict, ErrorResponse{Code: DUPLICATE_CODE, Message: repository.ErrDuplicate.Error()}
+	case errors.Is(err, repository.ErrConflict):
+		return fiber.StatusConflict, ErrorResponse{Code: CONFLICT_CODE, Message: err.Error()}
+	case errors.Is(err, repository.ErrTimeout):
+		log.Println(err)
+		return fiber.StatusServiceUnavailable, ErrorResponse{Code: TIMEOUT_CODE, Message: "request timed out, try again later"}
+	case errors.Is(err, repository.ErrNoChange):
+		return fiber.StatusUnprocessableEntity, ErrorResponse{Code: NO_CHANGE_CODE, Message: err.Error()}
+	case errors.Is(err, repository.ErrNotOwner):
+		return fiber.StatusForbidden, ErrorResponse{Code: FORBIDDEN_CODE, Message: err.Error()}
+	case errors.Is(err, repository.ErrInvalidSort), errors.Is(err, repository.ErrInvalidCursor),
+		errors.Is(err, repository.ErrInvalidStatus), errors.Is(err, repository.ErrReasonRequired):
+		return fiber.StatusBadRequest, ErrorResponse{Code: BAD_REQUEST_CODE, Message: err.Error()}
+	}
+	return fiber.StatusInternalServerError, ErrorResponse{Code: INTERNAL_CODE, Message: "internal server error"}
+}
+
+// statusCode is the ErrorResponse code of a fiber.NewError status
+func statusCode(status int) string {
+	switch status {
+	case fiber.StatusBadRequest:
+		return BAD_REQUEST_CODE
+	case fiber.StatusUnauthorized:
+		return UNAUTHORIZED_CODE
+	case fiber.StatusForbidden:
+		return FORBIDDEN_CODE
+	case fiber.StatusNotFound:
+		return NOT_FOUND_CODE
+	case fiber.StatusConflict:
+		return CONFLICT_CODE
+	case fiber.StatusTooManyRequests:
+		return TOO_MANY_REQUESTS_CODE
+	case fiber.StatusInternalServerError:
+		return INTERNAL_CODE
+	}
+	return ERROR_CODE
+}
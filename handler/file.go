@@ -0,0 +1,35 @@
+package handler
+
+import (
+	"log"
+	"mime"
+	"path/filepath"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/seenark/super-backend-temp/cloudstorage"
+)
+
+// NewFileHandler serves stored objects, used by the local and memory drivers
+// which have no public bucket URL. Private objects are not found here.
+func NewFileHandler(router fiber.Router, store cloudstorage.Storage) {
+	router.Get("/*", func(c *fiber.Ctx) error {
+		object := c.Params("*")
+		if cloudstorage.IsPrivate(object) {
+			return c.SendStatus(fiber.StatusNotFound)
+		}
+		file, err := store.Open(object)
+		if err == cloudstorage.ErrNotExist {
+			return c.SendStatus(fiber.StatusNotFound)
+		}
+		if err != nil {
+			log.Println(err)
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+		contentType := mime.TypeByExtension(filepath.Ext(object))
+		if contentType != "" {
+			c.Set(fiber.HeaderContentType, contentType)
+		}
+		// fasthttp closes the stream once it has been written
+		return c.SendStream(file)
+	})
+}
@@ -0,0 +1,22 @@
+package handler
+
+import (
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/seenark/super-backend-temp/authen"
+)
+
+// NewJwksHandler publishes the public keys access tokens are signed with so
+// other services can verify them without a shared secret.
+func NewJwksHandler(router fiber.Router) {
+	router.Get("/jwks.json", func(c *fiber.Ctx) error {
+		keys, err := authen.GetKeySet()
+		if err != nil {
+			log.Println(err)
+			return c.SendStatus(fiber.StatusInternalServerError)
+		}
+		c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+		return c.JSON(keys.JWKS())
+	})
+}
@@ -0,0 +1,231 @@
+package handler_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
+	"github.com/google/uuid"
+	"github.com/seenark/super-backend-temp/authen"
+	"github.com/seenark/super-backend-temp/certificate"
+	"github.com/seenark/super-backend-temp/cloudstorage"
+	"github.com/seenark/super-backend-temp/config"
+	"github.com/seenark/super-backend-temp/handler"
+	"github.com/seenark/super-backend-temp/importer"
+	"github.com/seenark/super-backend-temp/repository"
+	"github.com/seenark/super-backend-temp/repository/repotest"
+	"github.com/seenark/super-backend-temp/webhook"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestMain(m *testing.M) {
+	// the config, and the jwt keys in it, are read relative to the repo root
+	err := os.Chdir("..")
+	if err != nil {
+		panic(err)
+	}
+	os.Exit(repotest.Run(m))
+}
+
+var ctx = context.Background()
+
+// testApp is the app of main.go on memory repositories
+type testApp struct {
+	t     *testing.T
+	app   *fiber.App
+	repos repository.Repositories
+	store cloudstorage.Storage
+}
+
+func newTestApp(t *testing.T) *testApp {
+	t.Helper()
+	cfg := config.GetConfig()
+	repos := repotest.Memory().New(t)
+	err := repos.Roles.SeedDefaults(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := cloudstorage.NewMemoryStorage("http://localhost/images")
+
+	app := fiber.New(fiber.Config{ErrorHandler: handler.ErrorHandler})
+	app.Server().HeaderReceived = handler.RouteBodyLimit("/api/metadata/import", cfg.Import.MaxUploadSize<<20)
+	app.Use(requestid.New())
+	app.Use(handler.Timeout(cfg.Timeout))
+	api := app.Group("/api")
+	handler.NewUserHandler(api.Group("/user"), repos.Users, repos.Sessions, repos.Roles)
+	handler.NewAuthHandler(api.Group("/authen"), repos.Authen, repos.Nonces, repos.Sessions)
+	handler.NewApiKeyHandler(api.Group("/api-key"), repos.ApiKeys, repos.Roles)
+	handler.NewRoleHandler(api.Group("/role"), repos.Roles)
+	handler.NewDigitalCertTypeHandler(api.Group("/cert-type"), repos.CertTypes, store, repos.Roles)
+	metadataImporter := importer.NewImporter(cfg.Import, repos.Metadata, repos.CertTypes, store)
+	handler.NewMetadataHandler(api.Group("/metadata"), repos.Metadata, repos.CertTypes, store, metadataImporter, repos.Roles)
+	handler.NewTokenHandler(api.Group("/token"), repos.Metadata, repos.CertTypes)
+	issuer := certificate.NewIssuer(cfg, repos.Redeemed, repos.Metadata, repos.CertTypes, store)
+	handler.NewRedeemedHandler(api.Group("/redeemed"), repos.Redeemed, repos.ApiKeys, repos.Roles, issuer, store)
+	handler.NewVerifyHandler(api.Group("/verify"), repos.Redeemed, repos.Metadata, repos.CertTypes)
+	dispatcher := webhook.NewDispatcher(cfg.Webhook, repos.Webhooks, repos.WebhookDeliveries, repos.Outbox)
+	handler.NewWebhookHandler(api.Group("/webhook"), repos.Webhooks, repos.WebhookDeliveries, dispatcher, repos.Roles)
+	handler.NewJwksHandler(app.Group("/.well-known"))
+	handler.NewFileHandler(app.Group("/images"), store)
+
+	return &testApp{t: t, app: app, repos: repos, store: store}
+}
+
+// request is one call to the app and what is expected back
+type request struct {
+	name   string
+	method string
+	path   string
+	token  string
+	body   interface{} // sent as json, or as is when it is a *form
+	status int
+}
+
+// form is a multipart body
+type form struct {
+	fields   map[string]string
+	files    map[string]string // field name to file name
+	contents map[string][]byte // field name to file content, "image" when not set
+}
+
+func (a *testApp) do(r request) (*http.Response, []byte) {
+	a.t.Helper()
+	var body io.Reader
+	contentType := ""
+	switch v := r.body.(type) {
+	case nil:
+	case *form:
+		buf := &bytes.Buffer{}
+		w := multipart.NewWriter(buf)
+		for name, value := range v.fields {
+			w.WriteField(name, value)
+		}
+		for name, fileName := range v.files {
+			part, err := w.CreateFormFile(name, fileName)
+			if err != nil {
+				a.t.Fatal(err)
+			}
+			content, ok := v.contents[name]
+			if !ok {
+				content = []byte("image")
+			}
+			part.Write(content)
+		}
+		w.Close()
+		body = buf
+		contentType = w.FormDataContentType()
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			a.t.Fatal(err)
+		}
+		body = bytes.NewReader(data)
+		contentType = fiber.MIMEApplicationJSON
+	}
+	req := httptest.NewRequest(r.method, r.path, body)
+	if contentType != "" {
+		req.Header.Set(fiber.HeaderContentType, contentType)
+	}
+	if r.token != "" {
+		req.Header.Set(fiber.HeaderAuthorization, "Bearer "+r.token)
+	}
+	res, err := a.app.Test(req, -1)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	return res, data
+}
+
+// run sends every request in order, each one is a subtest
+func (a *testApp) run(requests []request) {
+	a.t.Helper()
+	for _, r := range requests {
+		r := r
+		a.t.Run(r.name, func(t *testing.T) {
+			parent := a.t
+			a.t = t
+			defer func() { a.t = parent }()
+			res, body := a.do(r)
+			if res.StatusCode != r.status {
+				t.Fatalf("%s %s = %d, want %d: %s", r.method, r.path, res.StatusCode, r.status, body)
+			}
+		})
+	}
+}
+
+// token signs an access token for a user with role
+func (a *testApp) token(role string, superAdmin bool) string {
+	a.t.Helper()
+	user, err := a.repos.Users.Create(ctx, repository.User{Role: role})
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	token, err := authen.GenerateJWT(user.Id.Hex(), user.Email, "", role, superAdmin, a.session(user.Id), 0)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	return token.Token
+}
+
+// walletToken signs an access token for a user with role that signed in
+// with wallet
+func (a *testApp) walletToken(role string, wallet string) string {
+	a.t.Helper()
+	user, err := a.repos.Users.Create(ctx, repository.User{Role: role, MetamaskAddress: wallet, WalletVerified: true})
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	token, err := authen.GenerateJWT(user.Id.Hex(), user.Email, wallet, role, false, a.session(user.Id), 0)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	return token.Token
+}
+
+// session starts a login of userId, access tokens are only valid while
+// their session is
+func (a *testApp) session(userId primitive.ObjectID) string {
+	a.t.Helper()
+	session, err := a.repos.Sessions.Create(ctx, repository.Session{
+		Id:        uuid.NewString(),
+		UserId:    userId,
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	return session.Id
+}
+
+func decode(t *testing.T, data []byte, v interface{}) {
+	t.Helper()
+	err := json.Unmarshal(data, v)
+	if err != nil {
+		t.Fatalf("decode %s: %v", data, err)
+	}
+}
+
+func newJSONRequest(method string, path string, body []byte) *http.Request {
+	req := httptest.NewRequest(method, path, bytes.NewReader(body))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	return req
+}
+
+func repeat(s string, n int) string {
+	return strings.Repeat(s, n)
+}
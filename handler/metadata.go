@@ -1,6 +1,8 @@
 package handler
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"path/filepath"
@@ -11,6 +13,7 @@ import (
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
 	"github.com/seenark/super-backend-temp/cloudstorage"
+	"github.com/seenark/super-backend-temp/importer"
 	"github.com/seenark/super-backend-temp/repository"
 )
 
@@ -23,74 +26,55 @@ type MetadataAndType struct {
 	VintageYear   string `json:"vintage_year"`
 }
 
-func NewMetadataHandler(router fiber.Router, metadataRepo repository.IMetadataRepository, certTypeRepo repository.IDigitalCertTypeRepository, uploader *cloudstorage.ClientUploader) {
+func NewMetadataHandler(router fiber.Router, metadataRepo repository.IMetadataRepository, certTypeRepo repository.IDigitalCertTypeRepository, uploader cloudstorage.Storage, metadataImporter *importer.Importer, roleDb repository.RoleRepository) {
+	canWrite := RequirePermission(roleDb, repository.METADATA_WRITE_PERMISSION)
 
 	// create
-	router.Post("/", func(c *fiber.Ctx) error {
+	router.Post("/", RequiredValidJWT, canWrite, func(c *fiber.Ctx) error {
 
-		typeCode := c.FormValue("type_code")
-		if typeCode == "" {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "type_code invalid"})
+		body := MetadataRequest{}
+		err := bindBody(c, &body)
+		if err != nil {
+			return err
+		}
+		if body.CertId == nil {
+			return fieldError("cert_id", "required", "cert_id is required")
 		}
 
-		certType, err := certTypeRepo.GetByTypeCode(typeCode)
+		certType, err := certTypeRepo.GetByTypeCode(c.UserContext(), body.TypeCode)
 		if err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "not found provided type_code please create it first"})
+			return fiber.NewError(fiber.StatusBadRequest, "not found provided type_code please create it first")
 		}
 
 		imageName := uuid.New()
 		file, err := c.FormFile("image")
 		if err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "image file invalid"})
+			return fieldError("image", "required", "image is required")
 		}
 
-		// fullName, err := saveImage(imageName.String(), file, c)
-		// if err != nil {
-		// 	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "could not save image file please try again"})
-		// }
-
 		newFile, err := file.Open()
 		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "image file invalid"})
+			return fiber.NewError(fiber.StatusInternalServerError, "image file invalid")
 		}
 
-		certIdStr := c.FormValue("cert_id")
-		if certIdStr == "" {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "cert_id invalid"})
-		}
-		certId, err := strconv.Atoi(certIdStr)
-		if err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "cert_id invalid"})
-		}
-		projectName := c.FormValue("project_name")
-		// if projectName == "" {
-		// 	return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "project_name invalid"})
-		// }
-		projectType := c.FormValue("project_type")
-		// if projectType == "" {
-		// 	return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "project_type invalid"})
-		// }
-		description := c.FormValue("description")
-
 		// listedDate := c.FormValue("listed_date")
 		// if listedDate == "" {
-		// 	return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "listed_date invalid"})
+		// 	return fiber.NewError(fiber.StatusBadRequest, "listed_date invalid")
 		// }
 		listedDate := time.Now().Format(time.RFC3339)
 		ext := filepath.Ext(file.Filename)
 		fullName := fmt.Sprintf("%s%s", imageName, ext)
-		metadata, err := metadataRepo.Create(typeCode, certId, projectName, projectType, fullName, description, listedDate)
+		metadata, err := metadataRepo.Create(c.UserContext(), body.TypeCode, *body.CertId, body.ProjectName, body.ProjectType, fullName, body.Description, listedDate)
 		if err != nil {
-			// deleteImage(fullName)
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "could not create new metadata please try another certId"})
+			return err
 		}
 
-		err = uploader.UploadFile(newFile, fullName)
+		err = uploader.Upload(newFile, fullName)
 		if err != nil {
 
 			fmt.Println("upload to cloud error")
 			fmt.Printf("err: %v\n", err)
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "upload to cloud error"})
+			return fiber.NewError(fiber.StatusInternalServerError, "upload to cloud error")
 		}
 
 		combine := MetadataAndType{
@@ -105,54 +89,104 @@ func NewMetadataHandler(router fiber.Router, metadataRepo repository.IMetadataRe
 		return c.JSON(combine)
 	})
 
+	// bulk create from a manifest and a zip of images, with dry_run=true the
+	// rows are only checked. Invalid rows are answered with the report.
+	router.Post("/import", RequiredValidJWT, canWrite, func(c *fiber.Ctx) error {
+		dryRun := false
+		if value := c.FormValue("dry_run"); value != "" {
+			parsed, err := strconv.ParseBool(value)
+			if err != nil {
+				return fieldError("dry_run", "boolean", "dry_run must be true or false")
+			}
+			dryRun = parsed
+		}
+		manifestFile, err := c.FormFile("manifest")
+		if err != nil {
+			return fieldError("manifest", "required", "manifest is required")
+		}
+		imagesFile, err := c.FormFile("images")
+		if err != nil {
+			return fieldError("images", "required", "images is required")
+		}
+
+		manifest, err := manifestFile.Open()
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "manifest file invalid")
+		}
+		defer manifest.Close()
+		rows, err := metadataImporter.ReadManifest(manifestFile.Filename, manifest)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+		zipFile, err := imagesFile.Open()
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "images file invalid")
+		}
+		defer zipFile.Close()
+		images, err := metadataImporter.OpenImages(zipFile, imagesFile.Size)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+
+		if dryRun {
+			report, err := metadataImporter.Check(c.UserContext(), rows, images)
+			if err != nil {
+				return err
+			}
+			return c.JSON(report)
+		}
+		report, err := metadataImporter.Import(c.UserContext(), rows, images)
+		if errors.Is(err, importer.ErrInvalidRows) {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(report)
+		}
+		if err != nil {
+			return err
+		}
+		return c.JSON(report)
+	})
+
 	// get all
 	router.Get("/", func(c *fiber.Ctx) error {
+		query, err := parsePageQuery(c)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
 		ids := []int{}
 		idsStr := c.Query("ids")
-		idSplit := strings.Split(idsStr, ",")
-		for _, v := range idSplit {
-			id, err := strconv.Atoi(strings.Trim(v, " "))
-			if err != nil {
-				log.Println(err)
-			}
-			ids = append(ids, id)
-		}
-		metadatas := metadataRepo.GetAll(ids)
-		allCertType := certTypeRepo.GetAll()
-		combines := []MetadataAndType{}
-		for _, v := range metadatas {
-			_, certType := certTypeRepo.FindInArrayByTypeCode(v.TypeCode, allCertType)
-			metadataAndType := MetadataAndType{
-				Metadata:      v,
-				TypeName:      certType.TypeName,
-				LogoImageName: certType.LogoImageName,
-				TypeOfUnit:    certType.TypeOfUnit,
-				Unit:          certType.Unit,
-				VintageYear:   certType.VintageYear,
+		if idsStr != "" {
+			idSplit := strings.Split(idsStr, ",")
+			for _, v := range idSplit {
+				id, err := strconv.Atoi(strings.Trim(v, " "))
+				if err != nil {
+					log.Println(err)
+				}
+				ids = append(ids, id)
 			}
-			combines = append(combines, metadataAndType)
 		}
-		return c.JSON(combines)
+		metadatas, page, err := metadataRepo.GetPage(c.UserContext(), repository.MetadataFilter{Ids: ids}, query)
+		if err != nil {
+			return err
+		}
+		return c.JSON(newPageResponse(combineMetadataAndType(c.UserContext(), metadatas, certTypeRepo), page))
 	})
 
 	// get by digital_cert_id
 	router.Get("/:certId", func(c *fiber.Ctx) error {
 		certIdStr := c.Params("certId")
 		if certIdStr == "" {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "digital certificate id is empty"})
+			return fiber.NewError(fiber.StatusBadRequest, "digital certificate id is empty")
 		}
 		certId, err := strconv.Atoi(certIdStr)
 		if err != nil {
 			log.Println(err)
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "digital certificate id is invalid"})
+			return fiber.NewError(fiber.StatusBadRequest, "digital certificate id is invalid")
 		}
-		cert, err := metadataRepo.GetByDigitalCertId(certId)
+		cert, err := metadataRepo.GetByDigitalCertId(c.UserContext(), certId)
 		if err != nil {
-			log.Println(err)
-			return c.Status(fiber.StatusNotFound).JSON(err)
+			return err
 		}
 
-		certType, err := certTypeRepo.GetByTypeCode(cert.TypeCode)
+		certType, err := certTypeRepo.GetByTypeCode(c.UserContext(), cert.TypeCode)
 		if err != nil {
 			log.Println(err)
 			return c.JSON(MetadataAndType{
@@ -178,46 +212,40 @@ func NewMetadataHandler(router fiber.Router, metadataRepo repository.IMetadataRe
 	// get by type_code
 	router.Get("/by-type-code/:typeCode", func(c *fiber.Ctx) error {
 		typeCode := c.Params("typeCode")
-		fmt.Printf("typeCode: %v\n", typeCode)
 		if typeCode == "" {
 			return c.SendStatus(fiber.StatusBadRequest)
 		}
-		medataDataAndTypes := metadataRepo.GetByTypeCode(typeCode)
-		allCertType := certTypeRepo.GetAll()
-		all := []MetadataAndType{}
-		for _, v := range medataDataAndTypes {
-			_, certType := certTypeRepo.FindInArrayByTypeCode(v.TypeCode, allCertType)
-			metadaAndType := MetadataAndType{
-				Metadata:      v,
-				TypeName:      certType.TypeName,
-				LogoImageName: certType.LogoImageName,
-				TypeOfUnit:    certType.TypeOfUnit,
-				Unit:          certType.Unit,
-				VintageYear:   certType.VintageYear,
-			}
-			all = append(all, metadaAndType)
+		query, err := parsePageQuery(c)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
 		}
-		return c.JSON(all)
+		metadatas, page, err := metadataRepo.GetPage(c.UserContext(), repository.MetadataFilter{TypeCode: typeCode}, query)
+		if err != nil {
+			return err
+		}
+		return c.JSON(newPageResponse(combineMetadataAndType(c.UserContext(), metadatas, certTypeRepo), page))
 	})
 
 	// update by digital_cert_id
-	router.Patch("/:certId", func(c *fiber.Ctx) error {
+	router.Patch("/:certId", RequiredValidJWT, canWrite, func(c *fiber.Ctx) error {
 		certIdStr := c.Params("certId")
 		if certIdStr == "" {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "cert id invalid"})
+			return fiber.NewError(fiber.StatusBadRequest, "cert id invalid")
 		}
 		if certIdStr == "" {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "cert_id invalid"})
+			return fiber.NewError(fiber.StatusBadRequest, "cert_id invalid")
 		}
 		certId, err := strconv.Atoi(certIdStr)
 		if err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "cert_id invalid"})
+			return fiber.NewError(fiber.StatusBadRequest, "cert_id invalid")
 		}
 
 		notFoundMetadata := false
-		cert, err := metadataRepo.GetByDigitalCertId(certId)
+		cert, err := metadataRepo.GetByDigitalCertId(c.UserContext(), certId)
+		if err != nil && !errors.Is(err, repository.ErrNotFound) {
+			return err
+		}
 		if err != nil {
-			log.Println(err)
 			log.Println("While update metadata there is no metadata so create new one")
 			notFoundMetadata = true
 			cert = &repository.Metadata{
@@ -232,70 +260,64 @@ func NewMetadataHandler(router fiber.Router, metadataRepo repository.IMetadataRe
 			// return c.Status(fiber.StatusNotFound).JSON(err)
 		}
 
-		typeCode := c.FormValue("type_code")
-		if typeCode == "" {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "type_code invalid"})
+		body := MetadataRequest{}
+		err = bindBody(c, &body)
+		if err != nil {
+			return err
 		}
-		cert.TypeCode = typeCode
+		cert.TypeCode = body.TypeCode
 
-		certType, err := certTypeRepo.GetByTypeCode(typeCode)
+		certType, err := certTypeRepo.GetByTypeCode(c.UserContext(), body.TypeCode)
 		if err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "not found provided type_code please create it first"})
+			return fiber.NewError(fiber.StatusBadRequest, "not found provided type_code please create it first")
 		}
 
 		imageName := uuid.New()
 		file, err := c.FormFile("image")
 		oldImageName := ""
+		imageReplaced := false
 		if err == nil {
 			newFile, err := file.Open()
 			if err == nil {
-				oldImageName = cert.ImageName
 				ext := filepath.Ext(file.Filename)
 				fullName := fmt.Sprintf("%s%s", imageName, ext)
-				err := uploader.UploadFile(newFile, fullName)
-				// fullName, err := saveImage(imageName.String(), file, c)
+				err := uploader.Upload(newFile, fullName)
 				if err != nil {
 					log.Println(err)
+				} else {
+					oldImageName = cert.ImageName
+					imageReplaced = true
+					cert.ImageName = fullName
 				}
-				cert.ImageName = fullName
 			}
 		}
 
-		projectName := c.FormValue("project_name")
-		cert.ProjectName = projectName
-		// if projectName != "" {
-		// 	cert.ProjectName = projectName
-		// }
-		projectType := c.FormValue("project_type")
-		cert.ProjectType = projectType
-		// if projectType != "" {
-		// 	cert.ProjectType = projectType
-		// }
-		description := c.FormValue("project_type")
-		cert.Description = description
-		// if description != "" {
-		// 	cert.Description = description
-		// }
+		cert.ProjectName = body.ProjectName
+		cert.ProjectType = body.ProjectType
+		cert.Description = body.Description
 		// listedDate := c.FormValue("listed_date")
 		// if listedDate != "" {
 		// 	cert.ListedDate = listedDate
 		// }
 		if !notFoundMetadata {
 
-			newCert, err := metadataRepo.UpdateByDigitalCertId(certId, *cert)
+			newCert, err := metadataRepo.UpdateByDigitalCertId(c.UserContext(), certId, *cert)
 			if err != nil {
 				log.Println(err)
-				// _, err := deleteImage(cert.ImageName)
-				// if err != nil {
-				// 	log.Println(err)
-				// 	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "error while deleting new image"})
-				// }
-				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "error while update metadata"})
+				if imageReplaced {
+					// the new image was uploaded but is not referenced by any metadata
+					err := uploader.Delete(cert.ImageName)
+					if err != nil {
+						log.Println(err)
+					}
+				}
+				return err
 			}
-			// _, err = deleteImage(oldImageName)
-			err = uploader.DeleteFile(oldImageName)
-			if err != nil {
-				log.Println("error while delete old image")
+			if oldImageName != "" {
+				err = uploader.Delete(oldImageName)
+				if err != nil {
+					log.Println("error while delete old image")
+				}
 			}
 			return c.JSON(MetadataAndType{
 				Metadata:      *newCert,
@@ -306,10 +328,10 @@ func NewMetadataHandler(router fiber.Router, metadataRepo repository.IMetadataRe
 				VintageYear:   certType.VintageYear,
 			})
 		} else {
-			newCert, err := metadataRepo.Create(cert.TypeCode, cert.DigitalCertID, cert.ProjectName, cert.ProjectType, cert.ImageName, cert.Description, time.Now().Format(time.RFC3339))
+			newCert, err := metadataRepo.Create(c.UserContext(), cert.TypeCode, cert.DigitalCertID, cert.ProjectName, cert.ProjectType, cert.ImageName, cert.Description, time.Now().Format(time.RFC3339))
 			if err != nil {
 				log.Println(err)
-				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": err})
+				return err
 			}
 			return c.JSON(MetadataAndType{
 				Metadata:      *newCert,
@@ -322,3 +344,21 @@ func NewMetadataHandler(router fiber.Router, metadataRepo repository.IMetadataRe
 		}
 	})
 }
+
+func combineMetadataAndType(ctx context.Context, metadatas []repository.Metadata, certTypeRepo repository.IDigitalCertTypeRepository) []MetadataAndType {
+	allCertType := certTypeRepo.GetAll(ctx)
+	combines := []MetadataAndType{}
+	for _, v := range metadatas {
+		_, certType := certTypeRepo.FindInArrayByTypeCode(v.TypeCode, allCertType)
+		metadataAndType := MetadataAndType{
+			Metadata:      v,
+			TypeName:      certType.TypeName,
+			LogoImageName: certType.LogoImageName,
+			TypeOfUnit:    certType.TypeOfUnit,
+			Unit:          certType.Unit,
+			VintageYear:   certType.VintageYear,
+		}
+		combines = append(combines, metadataAndType)
+	}
+	return combines
+}
@@ -0,0 +1,143 @@
+package handler_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/seenark/super-backend-temp/importer"
+	"github.com/seenark/super-backend-temp/repository"
+	"github.com/valyala/fasthttp"
+)
+
+func TestMetadataHandler(t *testing.T) {
+	a := newTestApp(t)
+	admin := a.token(repository.ADMIN_ROLE, false)
+	_, err := a.repos.CertTypes.Create(ctx, "REC", "Renewable", "logo.png", "energy", "MWh", "2022")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newMetadata := func(typeCode string, certId string, files map[string]string) *form {
+		return &form{
+			fields: map[string]string{"type_code": typeCode, "cert_id": certId, "project_name": "solar farm"},
+			files:  files,
+		}
+	}
+	image := map[string]string{"image": "image.png"}
+
+	a.run([]request{
+		{"create without a token", http.MethodPost, "/api/metadata/", "", newMetadata("REC", "1", image), http.StatusUnauthorized},
+		{"create without an image", http.MethodPost, "/api/metadata/", admin, newMetadata("REC", "1", nil), http.StatusBadRequest},
+		{"create with an unknown type", http.MethodPost, "/api/metadata/", admin, newMetadata("NOPE", "1", image), http.StatusBadRequest},
+		{"create", http.MethodPost, "/api/metadata/", admin, newMetadata("REC", "1", image), http.StatusOK},
+		{"create a taken cert id", http.MethodPost, "/api/metadata/", admin, newMetadata("REC", "1", image), http.StatusConflict},
+		{"list", http.MethodGet, "/api/metadata/", "", nil, http.StatusOK},
+		{"get", http.MethodGet, "/api/metadata/1", "", nil, http.StatusOK},
+		{"get unknown", http.MethodGet, "/api/metadata/9", "", nil, http.StatusNotFound},
+		{"by type code", http.MethodGet, "/api/metadata/by-type-code/REC", "", nil, http.StatusOK},
+		{"update", http.MethodPatch, "/api/metadata/1", admin, &form{fields: map[string]string{"type_code": "REC", "description": "updated"}}, http.StatusOK},
+		{"update with nothing new", http.MethodPatch, "/api/metadata/1", admin, &form{fields: map[string]string{"type_code": "REC", "description": "updated"}}, http.StatusUnprocessableEntity},
+		{"token", http.MethodGet, "/api/token/1.json", "", nil, http.StatusOK},
+		{"unknown token", http.MethodGet, "/api/token/9.json", "", nil, http.StatusNotFound},
+		{"malformed token id", http.MethodGet, "/api/token/one.json", "", nil, http.StatusBadRequest},
+	})
+
+	metadata, err := a.repos.Metadata.GetByDigitalCertId(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.Description != "updated" {
+		t.Fatalf("metadata = %+v", metadata)
+	}
+	a.run([]request{
+		{"image", http.MethodGet, "/images/" + metadata.ImageName, "", nil, http.StatusOK},
+		{"unknown image", http.MethodGet, "/images/nope.png", "", nil, http.StatusNotFound},
+	})
+}
+
+func TestMetadataImport(t *testing.T) {
+	a := newTestApp(t)
+	admin := a.token(repository.ADMIN_ROLE, false)
+	user := a.token(repository.USER_ROLE, false)
+	_, err := a.repos.CertTypes.Create(ctx, "REC", "Renewable", "logo.png", "energy", "MWh", "2022")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	w := zip.NewWriter(buf)
+	for _, name := range []string{"1.png", "2.png"} {
+		f, err := w.Create("images/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte("png"))
+	}
+	w.Close()
+	images := buf.Bytes()
+	// larger than the body limit of other routes
+	buf = &bytes.Buffer{}
+	w = zip.NewWriter(buf)
+	for _, name := range []string{"1.png", "2.png", "padding.bin"} {
+		f, err := w.CreateHeader(&zip.FileHeader{Name: "images/" + name, Method: zip.Store})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name == "padding.bin" {
+			f.Write(bytes.Repeat([]byte("x"), fiber.DefaultBodyLimit))
+		} else {
+			f.Write([]byte("png"))
+		}
+	}
+	w.Close()
+	largeImages := buf.Bytes()
+
+	upload := func(manifest string, zipFile []byte, dryRun string) *form {
+		return &form{
+			fields:   map[string]string{"dry_run": dryRun},
+			files:    map[string]string{"manifest": "manifest.csv", "images": "images.zip"},
+			contents: map[string][]byte{"manifest": []byte(manifest), "images": zipFile},
+		}
+	}
+	valid := "type_code,cert_id,project_name,image\nREC,1,Solar farm,1.png\nREC,2,Wind farm,images/2.png\n"
+	invalid := "type_code,cert_id,image\nREC,1,1.png\nNOPE,2,3.png\n"
+
+	a.run([]request{
+		{"without a token", http.MethodPost, "/api/metadata/import", "", upload(valid, images, ""), http.StatusUnauthorized},
+		{"without the permission", http.MethodPost, "/api/metadata/import", user, upload(valid, images, ""), http.StatusForbidden},
+		{"without files", http.MethodPost, "/api/metadata/import", admin, &form{}, http.StatusBadRequest},
+		{"unknown columns", http.MethodPost, "/api/metadata/import", admin, upload("colour\ngreen\n", images, ""), http.StatusBadRequest},
+		{"images not a zip", http.MethodPost, "/api/metadata/import", admin, upload(valid, []byte("png"), ""), http.StatusBadRequest},
+		{"malformed dry_run", http.MethodPost, "/api/metadata/import", admin, upload(valid, images, "maybe"), http.StatusBadRequest},
+		{"invalid rows", http.MethodPost, "/api/metadata/import", admin, upload(invalid, images, ""), http.StatusUnprocessableEntity},
+		{"dry run", http.MethodPost, "/api/metadata/import", admin, upload(valid, images, "true"), http.StatusOK},
+		{"dry run of a large zip", http.MethodPost, "/api/metadata/import", admin, upload(valid, largeImages, "true"), http.StatusOK},
+	})
+	// the server answers 413, app.Test returns its error
+	req := newJSONRequest(http.MethodPost, "/api/metadata/", bytes.Repeat([]byte("x"), fiber.DefaultBodyLimit+1))
+	_, err = a.app.Test(req, -1)
+	if !errors.Is(err, fasthttp.ErrBodyTooLarge) {
+		t.Fatalf("large body on another route: %v", err)
+	}
+	_, err = a.repos.Metadata.GetByDigitalCertId(ctx, 1)
+	if !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("checked rows were imported: %v", err)
+	}
+
+	res, body := a.do(request{method: http.MethodPost, path: "/api/metadata/import", token: admin, body: upload(valid, images, "")})
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("import = %d: %s", res.StatusCode, body)
+	}
+	report := importer.Report{}
+	decode(t, body, &report)
+	if report.Imported != 2 || report.DryRun {
+		t.Fatalf("report = %+v", report)
+	}
+	a.run([]request{
+		{"imported metadata", http.MethodGet, "/api/metadata/2", "", nil, http.StatusOK},
+		{"imported image", http.MethodGet, "/images/" + report.Rows[1].ImageName, "", nil, http.StatusOK},
+		{"import again", http.MethodPost, "/api/metadata/import", admin, upload(valid, images, ""), http.StatusUnprocessableEntity},
+	})
+}
@@ -0,0 +1,48 @@
+package handler
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/seenark/super-backend-temp/repository"
+)
+
+// PageResponse is the envelope of every list endpoint
+type PageResponse struct {
+	Items      interface{} `json:"items"`
+	Total      int64       `json:"total"`
+	Limit      int         `json:"limit"`
+	NextCursor string      `json:"next_cursor"`
+}
+
+func newPageResponse(items interface{}, page *repository.Page) PageResponse {
+	return PageResponse{
+		Items:      items,
+		Total:      page.Total,
+		Limit:      page.Limit,
+		NextCursor: page.NextCursor,
+	}
+}
+
+// parsePageQuery reads ?limit=&page=&cursor=&sort=
+func parsePageQuery(c *fiber.Ctx) (repository.PageQuery, error) {
+	query := repository.PageQuery{
+		Cursor: c.Query("cursor"),
+		Sort:   c.Query("sort"),
+	}
+	var err error
+	if limit := c.Query("limit"); limit != "" {
+		query.Limit, err = strconv.Atoi(limit)
+		if err != nil || query.Limit < 0 {
+			return query, errors.New("limit is invalid")
+		}
+	}
+	if page := c.Query("page"); page != "" {
+		query.Page, err = strconv.Atoi(page)
+		if err != nil || query.Page < 1 {
+			return query, errors.New("page is invalid")
+		}
+	}
+	return query, nil
+}
@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"context"
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/seenark/super-backend-temp/repository"
+)
+
+// RequirePermission lets the request through when the role of the principal
+// is granted every one of permissions. Super admins have all permissions.
+// Must be used after RequiredValidJWT or RequiredValidAPIKey.
+func RequirePermission(roleDb repository.RoleRepository, permissions ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		principal, ok := GetPrincipal(c)
+		if !ok {
+			return fiber.NewError(fiber.StatusUnauthorized, "authentication required")
+		}
+		if principal.SuperAdmin {
+			return c.Next()
+		}
+		role, err := roleDb.GetByName(c.UserContext(), principal.Role)
+		if err != nil {
+			log.Println(err)
+			return fiber.NewError(fiber.StatusForbidden, "permission denied")
+		}
+		for _, permission := range permissions {
+			if !role.HasPermission(permission) {
+				return fiber.NewError(fiber.StatusForbidden, "permission denied, "+permission+" is required")
+			}
+		}
+		return c.Next()
+	}
+}
+
+// hasPermission is RequirePermission for routes that also let other callers
+// through, such as the owner of a resource
+func hasPermission(ctx context.Context, roleDb repository.RoleRepository, principal *Principal, permission string) bool {
+	if principal == nil {
+		return false
+	}
+	if principal.SuperAdmin {
+		return true
+	}
+	role, err := roleDb.GetByName(ctx, principal.Role)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	return role.HasPermission(permission)
+}
+
+/* must call after middleware RequiredValidJWT */
+func RequireSuperAdmin(c *fiber.Ctx) error {
+	principal, ok := GetPrincipal(c)
+	if !ok || !principal.SuperAdmin || principal.ApiKey {
+		return fiber.NewError(fiber.StatusForbidden, "you are not super admin")
+	}
+	return c.Next()
+}
@@ -0,0 +1,29 @@
+package handler
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+const principalLocalKey = "principal"
+
+// Principal is who is calling, set by RequiredValidJWT or RequiredValidAPIKey
+type Principal struct {
+	Id              string `json:"id"`
+	Email           string `json:"email"`
+	MetamaskAddress string `json:"metamask_address"`
+	Role            string `json:"role"`
+	SessionId       string `json:"session_id"`
+	SuperAdmin      bool   `json:"super_admin"`
+	ApiKey          bool   `json:"api_key"` // Id is the api key id, not a user id
+}
+
+func setPrincipal(c *fiber.Ctx, principal *Principal) {
+	c.Locals(principalLocalKey, principal)
+}
+
+// GetPrincipal returns the authenticated caller, ok is false when the route
+// is not behind an authentication middleware.
+func GetPrincipal(c *fiber.Ctx) (principal *Principal, ok bool) {
+	principal, ok = c.Locals(principalLocalKey).(*Principal)
+	return principal, ok && principal != nil
+}
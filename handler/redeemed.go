@@ -1,131 +1,308 @@
 package handler
 
 import (
+	"bufio"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/seenark/super-backend-temp/certificate"
+	"github.com/seenark/super-backend-temp/cloudstorage"
+	"github.com/seenark/super-backend-temp/config"
+	"github.com/seenark/super-backend-temp/export"
+	"github.com/seenark/super-backend-temp/helperfunc"
 	"github.com/seenark/super-backend-temp/repository"
 )
 
-func NewRedeemedHandler(router fiber.Router, redeemedRepo repository.IRedeemedRepository) {
+func NewRedeemedHandler(router fiber.Router, redeemedRepo repository.IRedeemedRepository, apiKeyDb repository.ApiKeyRepository, roleDb repository.RoleRepository, certIssuer *certificate.Issuer, store cloudstorage.Storage) {
+	cfg := config.GetConfig()
 
-	router.Post("/", func(c *fiber.Ctx) error {
-		redeemed := repository.Redeemed{}
-		err := c.BodyParser(&redeemed)
+	router.Post("/", RequiredValidJWT, RequirePermission(roleDb, repository.REDEEM_CREATE_PERMISSION), func(c *fiber.Ctx) error {
+		body := SubmitRedeemedRequest{}
+		err := bindBody(c, &body)
 		if err != nil {
-			log.Println(err)
-			return c.SendStatus(fiber.StatusBadRequest)
+			return err
+		}
+		// the chain fields only come from redeem events
+		redeemed := body.toRedeemed()
+		// a customer submits with the wallet that redeemed, staff for anyone
+		principal, _ := GetPrincipal(c)
+		if !hasPermission(c.UserContext(), roleDb, principal, repository.REDEEM_APPROVE_PERMISSION) {
+			if principal.MetamaskAddress == "" {
+				return fiber.NewError(fiber.StatusForbidden, "sign in with the wallet of the redemption")
+			}
+			redeemed.SubmittedBy = principal.MetamaskAddress
 		}
-		fmt.Printf("redeemed: %v\n", redeemed)
-		redeemed.Amount = 0
-		redeemed.Price = ""
-		redeemed.RedeemDate = 0
-		redeemed.RedeemId = 0
-		redeemed.WalletAddress = ""
-		newRedeemed, err := redeemedRepo.Upsert(redeemed)
+		newRedeemed, err := redeemedRepo.Upsert(c.UserContext(), redeemed)
 		if err != nil {
-			log.Println(err)
-			c.Status(fiber.StatusBadRequest).JSON(err.Error())
+			return err
 		}
 
 		return c.JSON(newRedeemed)
 	})
 
-	router.Post("/redeem-event", RequiredValidJWT, RequiredEventLoggerRole, func(c *fiber.Ctx) error {
-		redeemed := repository.Redeemed{}
-		err := c.BodyParser(&redeemed)
+	router.Post("/redeem-event", RequiredValidJWTOrAPIKey(apiKeyDb), RequirePermission(roleDb, repository.REDEEM_INGEST_PERMISSION), func(c *fiber.Ctx) error {
+		body := RedeemEventRequest{}
+		err := bindBody(c, &body)
 		if err != nil {
-			log.Println(err)
-			return c.SendStatus(fiber.StatusBadRequest)
+			return err
 		}
-		redeemed.Company = ""
-		redeemed.Email = ""
-		redeemed.Name = ""
-		redeemed.TaxID = ""
-		redeemed.Telephone = ""
-		newRedeemed, err := redeemedRepo.Upsert(redeemed)
+		// the customer fields only come from the customer
+		newRedeemed, err := redeemedRepo.Upsert(c.UserContext(), body.toRedeemed())
 		if err != nil {
-			log.Println(err)
-			c.Status(fiber.StatusBadRequest).JSON(err.Error())
+			return err
 		}
 
 		return c.JSON(newRedeemed)
 
 	})
 
-	router.Patch("/update-status", RequiredValidJWT, RequiredAdminRole, func(c *fiber.Ctx) error {
-		type CertIdAndStatus struct {
-			RedeemedId    int    `json:"redeemed_id"`
-			ApproveStatus string `json:"approve_status"`
-		}
-		certIdAndStatus := CertIdAndStatus{}
-		err := c.BodyParser(&certIdAndStatus)
+	router.Patch("/update-status", RequiredValidJWT, RequirePermission(roleDb, repository.REDEEM_APPROVE_PERMISSION), func(c *fiber.Ctx) error {
+		certIdAndStatus := UpdateRedeemedStatusRequest{}
+		err := bindBody(c, &certIdAndStatus)
 		if err != nil {
-			log.Println(err)
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "json is invalid"})
+			return err
 		}
 
-		redeemed, err := redeemedRepo.UpdateStatus(certIdAndStatus.RedeemedId, certIdAndStatus.ApproveStatus)
+		principal, _ := GetPrincipal(c)
+		redeemed, err := redeemedRepo.UpdateStatus(c.UserContext(), certIdAndStatus.RedeemedId, certIdAndStatus.ApproveStatus, principal.Id, certIdAndStatus.Note)
 		if err != nil {
-			log.Println(err)
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "could not change status or status is not changed"})
+			return err
+		}
+		if redeemed.ApproveStatus == repository.DELIVERED_STATUS {
+			// the status is changed already, a failed certificate is issued again on download
+			issued, err := certIssuer.Issue(c.UserContext(), *redeemed)
+			if err != nil {
+				log.Printf("issue certificate of redeem %d: %v\n", redeemed.RedeemId, err)
+			} else {
+				redeemed = issued
+			}
 		}
 		return c.JSON(redeemed)
 	})
 
-	router.Get("/", func(c *fiber.Ctx) error {
-		approveStatusesStr := c.Query("approveStatus")
-		statuses := []string{}
-		if approveStatusesStr != "" {
-			splitApproveStatus := strings.Split(approveStatusesStr, ",")
-			for _, v := range splitApproveStatus {
-				statuses = append(statuses, strings.Trim(v, " "))
-			}
+	// export for accounting, takes the same filters as get all
+	router.Get("/export", RequiredValidJWT, RequirePermission(roleDb, repository.REDEEM_EXPORT_PERMISSION), func(c *fiber.Ctx) error {
+		format := c.Query("format", export.CSV_FORMAT)
+		if format != export.CSV_FORMAT && format != export.XLSX_FORMAT {
+			return fiber.NewError(fiber.StatusBadRequest, "format must be csv or xlsx")
+		}
+		filter, err := parseRedeemedFilter(c)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+		location, err := time.LoadLocation(cfg.Export.TimeZone)
+		if err != nil {
+			log.Println(err)
+			return fiber.NewError(fiber.StatusInternalServerError, "export time zone is invalid")
 		}
 
-		var startDate int = 0
-		startDateUnixStr := c.Query("redeemStartDate")
-		if startDateUnixStr != "" {
-			startDateInt, err := strconv.Atoi(startDateUnixStr)
+		fileName := fmt.Sprintf("redeemed-%s.%s", time.Now().In(location).Format("20060102-150405"), format)
+		c.Set(fiber.HeaderContentType, export.ContentType(format))
+		c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s"`, fileName))
+		ctx, cancel := streamContext(c)
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			defer cancel()
+			err := writeRedeemedExport(ctx, w, format, redeemedRepo, filter, location, cfg.Export.PriceDecimals)
 			if err != nil {
-				fmt.Printf("err: %v\n", err)
-				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "redeemStartDate is invalid"})
+				// the status is already sent, the client gets a cut off file
+				log.Printf("export redeemed: %v\n", err)
 			}
-			startDate = startDateInt
+		})
+		return nil
+	})
+
+	// the customer of the redemption or anyone with redeem:read can download
+	router.Get("/:redeemId/certificate", RequiredValidJWT, func(c *fiber.Ctx) error {
+		redeemId, err := strconv.Atoi(c.Params("redeemId"))
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "redeemId is invalid")
+		}
+		redeemed, err := redeemedRepo.GetByRedeemId(c.UserContext(), redeemId)
+		if err != nil {
+			return err
+		}
+		principal, _ := GetPrincipal(c)
+		if !isRedeemedOwner(principal, *redeemed) && !hasPermission(c.UserContext(), roleDb, principal, repository.REDEEM_READ_PERMISSION) {
+			return fiber.NewError(fiber.StatusForbidden, "permission denied")
+		}
+		if redeemed.ApproveStatus != repository.DELIVERED_STATUS {
+			return fiber.NewError(fiber.StatusNotFound, "redeemed is not delivered yet")
 		}
-		var endDate int = 0
-		endDateUnixStr := c.Query("redeemEndDate")
-		if endDateUnixStr != "" {
-			endDateInt, err := strconv.Atoi(endDateUnixStr)
+		if redeemed.CertificateObject == "" {
+			redeemed, err = certIssuer.Issue(c.UserContext(), *redeemed)
 			if err != nil {
-				fmt.Printf("err: %v\n", err)
-				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "redeemEndDate is invalid"})
+				log.Println(err)
+				return fiber.NewError(fiber.StatusInternalServerError, "could not issue certificate")
 			}
-			endDate = endDateInt
-		}
-
-		redeemId := []int{}
-		redeemIdStr := c.Query("redeemIds")
-		if redeemIdStr != "" {
-			idSplit := strings.Split(redeemIdStr, ",")
-			fmt.Println(idSplit)
-			for _, v := range idSplit {
-				id, err := strconv.Atoi(strings.Trim(v, " "))
-				if err != nil {
-					log.Println(err)
-				}
-				redeemId = append(redeemId, id)
+		}
+		file, err := store.Open(redeemed.CertificateObject)
+		if err != nil {
+			log.Println(err)
+			return fiber.NewError(fiber.StatusInternalServerError, "could not open certificate")
+		}
+		c.Set(fiber.HeaderContentType, "application/pdf")
+		c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="certificate-%d.pdf"`, redeemed.RedeemId))
+		return c.SendStream(file)
+	})
+
+	router.Get("/:redeemId/history", RequiredValidJWT, RequirePermission(roleDb, repository.REDEEM_READ_PERMISSION), func(c *fiber.Ctx) error {
+		redeemId, err := strconv.Atoi(c.Params("redeemId"))
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "redeemId is invalid")
+		}
+		history, err := redeemedRepo.GetHistory(c.UserContext(), redeemId)
+		if err != nil {
+			return err
+		}
+		return c.JSON(history)
+	})
+
+	router.Get("/", RequiredValidJWT, RequirePermission(roleDb, repository.REDEEM_READ_PERMISSION), func(c *fiber.Ctx) error {
+		query, err := parsePageQuery(c)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+
+		filter, err := parseRedeemedFilter(c)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+		all, page, err := redeemedRepo.GetPage(c.UserContext(), filter, query)
+		if err != nil {
+			return err
+		}
+		return c.JSON(newPageResponse(all, page))
+	})
+}
+
+// parseRedeemedFilter reads the filters shared by the list and the export
+func parseRedeemedFilter(c *fiber.Ctx) (repository.RedeemedFilter, error) {
+	approveStatusesStr := c.Query("approveStatus")
+	statuses := []string{}
+	if approveStatusesStr != "" {
+		splitApproveStatus := strings.Split(approveStatusesStr, ",")
+		for _, v := range splitApproveStatus {
+			statuses = append(statuses, strings.Trim(v, " "))
+		}
+	}
+
+	var startDate int = 0
+	startDateUnixStr := c.Query("redeemStartDate")
+	if startDateUnixStr != "" {
+		startDateInt, err := strconv.Atoi(startDateUnixStr)
+		if err != nil {
+			fmt.Printf("err: %v\n", err)
+			return repository.RedeemedFilter{}, errors.New("redeemStartDate is invalid")
+		}
+		startDate = startDateInt
+	}
+	var endDate int = 0
+	endDateUnixStr := c.Query("redeemEndDate")
+	if endDateUnixStr != "" {
+		endDateInt, err := strconv.Atoi(endDateUnixStr)
+		if err != nil {
+			fmt.Printf("err: %v\n", err)
+			return repository.RedeemedFilter{}, errors.New("redeemEndDate is invalid")
+		}
+		endDate = endDateInt
+	}
+
+	redeemId := []int{}
+	redeemIdStr := c.Query("redeemIds")
+	if redeemIdStr != "" {
+		idSplit := strings.Split(redeemIdStr, ",")
+		for _, v := range idSplit {
+			id, err := strconv.Atoi(strings.Trim(v, " "))
+			if err != nil {
+				return repository.RedeemedFilter{}, errors.New("redeemIds is invalid")
 			}
+			redeemId = append(redeemId, id)
 		}
+	}
+
+	name := c.Query("name")
+	email := c.Query("email")
+	walletAddress := c.Query("walletAddress")
+
+	return repository.RedeemedFilter{
+		ApproveStatus: statuses,
+		Name:          name,
+		Email:         email,
+		RedeemId:      redeemId,
+		StartDate:     startDate,
+		EndDate:       endDate,
+		WalletAddress: walletAddress,
+	}, nil
+}
 
-		name := c.Query("name")
-		email := c.Query("email")
-		walletAddress := c.Query("walletAddress")
+var redeemedExportHeader = []string{
+	"Redeem ID", "Redeem Date", "Status", "Name", "Company", "Tax ID", "Email", "Telephone",
+	"Wallet Address", "Cert ID", "Amount", "Price", "Tx Hash",
+}
+
+func writeRedeemedExport(ctx context.Context, w io.Writer, format string, redeemedRepo repository.IRedeemedRepository, filter repository.RedeemedFilter, location *time.Location, priceDecimals int) error {
+	rows, err := export.NewRowWriter(format, w)
+	if err != nil {
+		return err
+	}
+	header := []export.Cell{}
+	for _, v := range redeemedExportHeader {
+		header = append(header, export.Text(v))
+	}
+	err = rows.WriteRow(header)
+	if err != nil {
+		return err
+	}
 
-		all := redeemedRepo.GetAll(statuses, name, email, redeemId, startDate, endDate, walletAddress)
-		return c.JSON(all)
+	err = redeemedRepo.Iterate(ctx, filter, func(r repository.Redeemed) error {
+		redeemDate := ""
+		if r.RedeemDate > 0 {
+			redeemDate = time.Unix(int64(r.RedeemDate), 0).In(location).Format("2006-01-02 15:04:05")
+		}
+		price := r.Price
+		if r.Price != "" {
+			decimal, err := helperfunc.WeiToDecimal(r.Price, priceDecimals)
+			if err != nil {
+				log.Printf("redeem %d: price %v\n", r.RedeemId, err)
+			} else {
+				price = decimal
+			}
+		}
+		return rows.WriteRow([]export.Cell{
+			export.Number(strconv.Itoa(r.RedeemId)),
+			export.Text(redeemDate),
+			export.Text(r.ApproveStatus),
+			export.Text(r.Name),
+			export.Text(r.Company),
+			export.Text(r.TaxID),
+			export.Text(r.Email),
+			export.Text(r.Telephone),
+			export.Text(r.WalletAddress),
+			export.Number(strconv.Itoa(r.CertId)),
+			export.Number(strconv.Itoa(r.Amount)),
+			export.Number(price),
+			export.Text(r.TxHash),
+		})
 	})
+	if err != nil {
+		return err
+	}
+	return rows.Close()
+}
+
+// isRedeemedOwner reports whether the caller redeemed it. Only the wallet of
+// the token counts, it is set when the caller signed in with that wallet
+// (SIWE). The email is not proof, anyone with redeem:create can submit it.
+func isRedeemedOwner(principal *Principal, redeemed repository.Redeemed) bool {
+	if principal.ApiKey {
+		return false
+	}
+	return principal.MetamaskAddress != "" && strings.EqualFold(principal.MetamaskAddress, redeemed.WalletAddress)
 }
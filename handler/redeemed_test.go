@@ -0,0 +1,184 @@
+package handler_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/seenark/super-backend-temp/authen"
+	"github.com/seenark/super-backend-temp/cloudstorage"
+	"github.com/seenark/super-backend-temp/handler"
+	"github.com/seenark/super-backend-temp/repository"
+)
+
+func TestRedeemedHandler(t *testing.T) {
+	a := newTestApp(t)
+	admin := a.token(repository.ADMIN_ROLE, false)
+	user := a.token(repository.USER_ROLE, false)
+	wallet := "0x" + strings.Repeat("b", 40)
+	owner := a.walletToken(repository.USER_ROLE, wallet)
+	squatter := a.walletToken(repository.USER_ROLE, "0x"+strings.Repeat("c", 40))
+	txHash := "0x" + strings.Repeat("a", 64)
+	upperTxHash := "0x" + strings.Repeat("A", 64)
+	submit := map[string]interface{}{"tx_hash": txHash, "name": "Somchai", "email": "s@example.com", "tax_id": "1234567890121"}
+	squat := map[string]interface{}{"tx_hash": txHash, "name": "Mallory", "email": "m@example.com", "tax_id": "1234567890121"}
+	event := map[string]interface{}{"tx_hash": txHash, "redeem_id": 7, "redeem_date": 1650000000, "wallet_address": wallet, "amount": 2, "price": "15"}
+	status := func(approveStatus string, note string) map[string]interface{} {
+		return map[string]interface{}{"redeemed_id": 7, "approve_status": approveStatus, "note": note}
+	}
+
+	a.run([]request{
+		{"submit without a token", http.MethodPost, "/api/redeemed/", "", submit, http.StatusUnauthorized},
+		{"submit with a bad tax id", http.MethodPost, "/api/redeemed/", user, map[string]interface{}{"tx_hash": txHash, "name": "Somchai", "email": "s@example.com", "tax_id": "1234567890120"}, http.StatusBadRequest},
+		{"submit without a wallet", http.MethodPost, "/api/redeemed/", user, submit, http.StatusForbidden},
+		{"submit", http.MethodPost, "/api/redeemed/", owner, submit, http.StatusOK},
+		{"another wallet before the event", http.MethodPost, "/api/redeemed/", squatter, squat, http.StatusForbidden},
+		{"event without permission", http.MethodPost, "/api/redeemed/redeem-event", user, event, http.StatusForbidden},
+		{"event with a bad address", http.MethodPost, "/api/redeemed/redeem-event", admin, map[string]interface{}{"tx_hash": txHash, "wallet_address": "nope"}, http.StatusBadRequest},
+		{"event", http.MethodPost, "/api/redeemed/redeem-event", admin, event, http.StatusOK},
+		{"another wallet after the event", http.MethodPost, "/api/redeemed/", squatter, squat, http.StatusForbidden},
+		{"the wallet changes its details", http.MethodPost, "/api/redeemed/", owner, map[string]interface{}{"tx_hash": upperTxHash, "name": "Somchai", "company": "ACME", "email": "s@example.com", "tax_id": "1234567890121"}, http.StatusOK},
+		{"staff change the details", http.MethodPost, "/api/redeemed/", admin, map[string]interface{}{"tx_hash": txHash, "name": "Somchai Jaidee", "email": "s@example.com", "tax_id": "1234567890121"}, http.StatusOK},
+		{"list without permission", http.MethodGet, "/api/redeemed/", user, nil, http.StatusForbidden},
+		{"list", http.MethodGet, "/api/redeemed/?approveStatus=requested", admin, nil, http.StatusOK},
+		{"list with a bad date", http.MethodGet, "/api/redeemed/?redeemStartDate=today", admin, nil, http.StatusBadRequest},
+		{"list by redeem ids", http.MethodGet, "/api/redeemed/?redeemIds=7,%208", admin, nil, http.StatusOK},
+		{"list with a bad redeem id", http.MethodGet, "/api/redeemed/?redeemIds=7,eight", admin, nil, http.StatusBadRequest},
+		{"export", http.MethodGet, "/api/redeemed/export", admin, nil, http.StatusOK},
+		{"export with a bad format", http.MethodGet, "/api/redeemed/export?format=pdf", admin, nil, http.StatusBadRequest},
+		{"reject without a note", http.MethodPatch, "/api/redeemed/update-status", admin, status(repository.REJECTED_STATUS, ""), http.StatusBadRequest},
+		{"approve without permission", http.MethodPatch, "/api/redeemed/update-status", user, status(repository.APPROVED_STATUS, ""), http.StatusForbidden},
+		{"approve", http.MethodPatch, "/api/redeemed/update-status", admin, status(repository.APPROVED_STATUS, ""), http.StatusOK},
+		{"approve twice", http.MethodPatch, "/api/redeemed/update-status", admin, status(repository.APPROVED_STATUS, ""), http.StatusConflict},
+		{"history", http.MethodGet, "/api/redeemed/7/history", admin, nil, http.StatusOK},
+		{"history of an unknown redemption", http.MethodGet, "/api/redeemed/9/history", admin, nil, http.StatusNotFound},
+		{"certificate before delivery", http.MethodGet, "/api/redeemed/7/certificate", admin, nil, http.StatusNotFound},
+		{"certificate of an unknown redemption", http.MethodGet, "/api/redeemed/9/certificate", admin, nil, http.StatusNotFound},
+		{"verify by tx hash", http.MethodGet, "/api/verify/" + txHash, "", nil, http.StatusOK},
+		{"verify by an uppercase tx hash", http.MethodGet, "/api/verify/" + upperTxHash, "", nil, http.StatusOK},
+		{"verify an unknown code", http.MethodGet, "/api/verify/NOPE", "", nil, http.StatusNotFound},
+	})
+
+	redeemed, err := a.repos.Redeemed.GetByTxHash(ctx, txHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if redeemed.Name != "Somchai Jaidee" || redeemed.Company != "ACME" || redeemed.RedeemId != 7 || redeemed.ApproveStatus != repository.APPROVED_STATUS {
+		t.Fatalf("redeemed = %+v", redeemed)
+	}
+	_, body := a.do(request{method: http.MethodGet, path: "/api/redeemed/export", token: admin})
+	if lines := strings.Split(strings.TrimSpace(string(body)), "\n"); len(lines) != 2 {
+		t.Fatalf("export = %s", body)
+	}
+}
+
+func TestRedeemedCertificateOwner(t *testing.T) {
+	a := newTestApp(t)
+	wallet := "0x" + strings.Repeat("b", 40)
+	_, err := a.repos.Redeemed.Upsert(ctx, repository.Redeemed{TxHash: "0x1", RedeemId: 7, WalletAddress: wallet, Email: "s@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	signin, err := a.repos.Authen.WalletSignin(ctx, wallet, true, repository.ClientInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a token with the email of the redemption, it was not proven
+	email, err := authen.GenerateJWT(signin.User.Id.Hex(), "s@example.com", "", repository.USER_ROLE, false, a.session(signin.User.Id), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a.run([]request{
+		{"someone else", http.MethodGet, "/api/redeemed/7/certificate", a.token(repository.USER_ROLE, false), nil, http.StatusForbidden},
+		{"the email of the redemption", http.MethodGet, "/api/redeemed/7/certificate", email.Token, nil, http.StatusForbidden},
+		// the owner gets past the check, it is not delivered yet
+		{"the wallet of the redemption", http.MethodGet, "/api/redeemed/7/certificate", signin.Token.Token, nil, http.StatusNotFound},
+	})
+
+	for _, status := range []string{repository.APPROVED_STATUS, repository.DELIVERED_STATUS} {
+		_, err := a.repos.Redeemed.UpdateStatus(ctx, 7, status, "admin", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	a.run([]request{
+		{"download", http.MethodGet, "/api/redeemed/7/certificate", signin.Token.Token, nil, http.StatusOK},
+	})
+	redeemed, err := a.repos.Redeemed.GetByRedeemId(ctx, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cloudstorage.IsPrivate(redeemed.CertificateObject) {
+		t.Fatalf("certificate is stored as %q", redeemed.CertificateObject)
+	}
+	err = a.store.Upload(strings.NewReader("pdf"), "certificates/redeem-7.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.run([]request{
+		{"certificate on the images route", http.MethodGet, "/images/" + redeemed.CertificateObject, "", nil, http.StatusNotFound},
+		{"certificate of an old path", http.MethodGet, "/images/certificates/redeem-7.pdf", "", nil, http.StatusNotFound},
+	})
+}
+
+func TestVerifyStatement(t *testing.T) {
+	a := newTestApp(t)
+	user := a.token(repository.USER_ROLE, false)
+	txHash := "0x" + strings.Repeat("c", 64)
+	_, err := a.repos.Redeemed.Upsert(ctx, repository.Redeemed{TxHash: txHash, RedeemId: 7, WalletAddress: "0xabc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, body := a.do(request{method: http.MethodGet, path: "/api/verify/" + txHash})
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("verify = %d %s", res.StatusCode, body)
+	}
+	verified := handler.VerifyResponse{}
+	decode(t, body, &verified)
+
+	claims, err := authen.VerifyStatement(verified.Signature)
+	if err != nil {
+		t.Fatalf("VerifyStatement: %v", err)
+	}
+	if claims.Subject != txHash || claims.Audience != authen.STATEMENT_AUDIENCE {
+		t.Fatalf("claims = %+v", claims)
+	}
+	// neither token passes for the other
+	_, err = authen.ValidateJWT(verified.Signature)
+	if err == nil {
+		t.Fatal("a statement is accepted as an access token")
+	}
+	_, err = authen.VerifyStatement(user)
+	if err == nil {
+		t.Fatal("an access token is accepted as a statement")
+	}
+	a.run([]request{
+		{"statement as a bearer token", http.MethodGet, "/api/redeemed/", verified.Signature, nil, http.StatusUnauthorized},
+	})
+}
+
+func TestVerifyOffChain(t *testing.T) {
+	a := newTestApp(t)
+	submitted := "0x" + strings.Repeat("d", 64)
+	removed := "0x" + strings.Repeat("e", 64)
+	// the customer submitted, the redeem event never came
+	_, err := a.repos.Redeemed.Upsert(ctx, repository.Redeemed{TxHash: submitted, LogIndex: repository.UNKNOWN_LOG_INDEX, Name: "Somchai", Email: "s@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a reorg took the redeem event off the chain
+	_, err = a.repos.Redeemed.Upsert(ctx, repository.Redeemed{TxHash: removed, LogIndex: 0, RedeemId: 8, WalletAddress: "0xabc", BlockNumber: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = a.repos.Redeemed.MarkRemoved(ctx, removed, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a.run([]request{
+		{"only submitted by the customer", http.MethodGet, "/api/verify/" + submitted, "", nil, http.StatusNotFound},
+		{"removed by a reorg", http.MethodGet, "/api/verify/" + removed, "", nil, http.StatusNotFound},
+	})
+}
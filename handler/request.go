@@ -0,0 +1,188 @@
+package handler
+
+import (
+	"strings"
+
+	"github.com/seenark/super-backend-temp/repository"
+	"github.com/seenark/super-backend-temp/validation"
+)
+
+// request bodies, checked by bindBody with their validate tags
+
+// SubmitRedeemedRequest is what a customer fills in for a redemption
+type SubmitRedeemedRequest struct {
+	TxHash    string `json:"tx_hash" validate:"required,tx_hash"`
+	LogIndex  *int   `json:"log_index" validate:"omitempty,min=0"` // only needed when the transaction has more than one redemption
+	CertId    int    `json:"cert_id" validate:"min=0"`
+	Name      string `json:"name" validate:"required,max=200"`
+	Company   string `json:"company" validate:"max=200"`
+	Email     string `json:"email" validate:"required,email,max=254"`
+	Telephone string `json:"telephone" validate:"omitempty,e164"`
+	TaxID     string `json:"tax_id" validate:"required,thai_tax_id"`
+}
+
+// Normalize lowercases the tx hash as the indexer stores it
+func (r *SubmitRedeemedRequest) Normalize() {
+	r.TxHash = strings.ToLower(r.TxHash)
+	r.Email = strings.TrimSpace(r.Email)
+	r.Telephone = validation.NormalizePhone(r.Telephone)
+	r.TaxID = validation.NormalizeTaxID(r.TaxID)
+}
+
+func (r SubmitRedeemedRequest) toRedeemed() repository.Redeemed {
+	return repository.Redeemed{
+		TxHash:    r.TxHash,
+		LogIndex:  logIndex(r.LogIndex),
+		CertId:    r.CertId,
+		Name:      r.Name,
+		Company:   r.Company,
+		Email:     r.Email,
+		Telephone: r.Telephone,
+		TaxID:     r.TaxID,
+	}
+}
+
+// RedeemEventRequest is a redeem event read from the chain
+type RedeemEventRequest struct {
+	TxHash        string `json:"tx_hash" validate:"required,tx_hash"`
+	LogIndex      *int   `json:"log_index" validate:"omitempty,min=0"`
+	RedeemId      int    `json:"redeem_id" validate:"min=0"`
+	RedeemDate    int    `json:"redeem_date" validate:"min=0"`
+	WalletAddress string `json:"wallet_address" validate:"required,evm_address"`
+	Amount        int    `json:"amount" validate:"min=0"`
+	Price         string `json:"price" validate:"omitempty,number"`
+	CertId        int    `json:"cert_id" validate:"min=0"`
+}
+
+func (r *RedeemEventRequest) Normalize() {
+	r.TxHash = strings.ToLower(r.TxHash)
+}
+
+func (r RedeemEventRequest) toRedeemed() repository.Redeemed {
+	return repository.Redeemed{
+		TxHash:        r.TxHash,
+		LogIndex:      logIndex(r.LogIndex),
+		RedeemId:      r.RedeemId,
+		RedeemDate:    r.RedeemDate,
+		WalletAddress: r.WalletAddress,
+		Amount:        r.Amount,
+		Price:         r.Price,
+		CertId:        r.CertId,
+	}
+}
+
+// logIndex is the log index of a request, it may be left out
+func logIndex(value *int) int {
+	if value == nil {
+		return repository.UNKNOWN_LOG_INDEX
+	}
+	return *value
+}
+
+type UpdateRedeemedStatusRequest struct {
+	RedeemedId    int    `json:"redeemed_id" validate:"min=0"`
+	ApproveStatus string `json:"approve_status" validate:"required,oneof=requested pending approved delivered rejected"`
+	Note          string `json:"note" validate:"required_if=ApproveStatus rejected,max=1000"`
+}
+
+// CertTypeRequest is the form of creating a digital cert type, the logo is
+// sent as the logo_file file
+type CertTypeRequest struct {
+	TypeCode    string `form:"type_code" validate:"required,max=50"`
+	TypeName    string `form:"type_name" validate:"required,max=200"`
+	TypeOfUnit  string `form:"type_of_unit" validate:"required,max=100"`
+	Unit        string `form:"unit" validate:"required,max=50"`
+	VintageYear string `form:"vintage_year" validate:"omitempty,vintage_year"`
+}
+
+// UpdateCertTypeRequest is CertTypeRequest where empty fields are unchanged
+type UpdateCertTypeRequest struct {
+	TypeName    string `form:"type_name" validate:"max=200"`
+	TypeOfUnit  string `form:"type_of_unit" validate:"max=100"`
+	Unit        string `form:"unit" validate:"max=50"`
+	VintageYear string `form:"vintage_year" validate:"omitempty,vintage_year"`
+}
+
+// MetadataRequest is the form of a metadata, the image is sent as the image
+// file. CertId only comes from the form on create.
+type MetadataRequest struct {
+	TypeCode    string `form:"type_code" validate:"required,max=50"`
+	CertId      *int   `form:"cert_id" validate:"omitempty,min=0"`
+	ProjectName string `form:"project_name" validate:"max=200"`
+	ProjectType string `form:"project_type" validate:"max=100"`
+	Description string `form:"description" validate:"max=5000"`
+}
+
+// CreateUserRequest has no wallet, one is only bound by signing in with it
+type CreateUserRequest struct {
+	Name     string `json:"name" validate:"max=200"`
+	Email    string `json:"email" validate:"required,email,max=254"`
+	Password string `json:"password" validate:"required,min=8,max=72"`
+	Address  string `json:"address" validate:"max=500"`
+	Tel      string `json:"tel" validate:"omitempty,e164"`
+}
+
+func (r *CreateUserRequest) Normalize() {
+	r.Email = strings.TrimSpace(r.Email)
+	r.Tel = validation.NormalizePhone(r.Tel)
+}
+
+func (r CreateUserRequest) toUser(role string) repository.User {
+	return repository.User{
+		Name:     r.Name,
+		Email:    r.Email,
+		Password: r.Password,
+		Address:  r.Address,
+		Tel:      r.Tel,
+		Role:     role,
+	}
+}
+
+type ResetPasswordRequest struct {
+	OldPassword string `json:"old_password" validate:"required"`
+	NewPassword string `json:"new_password" validate:"required,min=8,max=72"`
+}
+
+type HashPasswordRequest struct {
+	Password string `json:"password" validate:"required,max=72"`
+}
+
+type SigninRequest struct {
+	Email    string `json:"email" form:"email" validate:"required"`
+	Password string `json:"password" form:"password" validate:"required"`
+}
+
+type RefreshRequest struct {
+	Refresh string `json:"refresh" validate:"required"`
+}
+
+type SiweVerifyRequest struct {
+	Message   string `json:"message" validate:"required"`
+	Signature string `json:"signature" validate:"required,hexadecimal"`
+}
+
+type CreateApiKeyRequest struct {
+	Name          string   `json:"name" validate:"required,max=100"`
+	Role          string   `json:"role" validate:"required"`
+	Routes        []string `json:"routes" validate:"dive,required"`
+	ExpiresInDays int      `json:"expires_in_days" validate:"min=0"`
+}
+
+type RoleRequest struct {
+	Permissions []string `json:"permissions" validate:"dive,permission"`
+}
+
+type CreateWebhookRequest struct {
+	Url         string   `json:"url" validate:"required,http_url"`
+	Description string   `json:"description" validate:"max=500"`
+	Events      []string `json:"events" validate:"required,min=1,dive,webhook_event"`
+}
+
+// UpdateWebhookRequest is CreateWebhookRequest where missing fields are
+// unchanged
+type UpdateWebhookRequest struct {
+	Url         *string  `json:"url" validate:"omitempty,http_url"`
+	Description *string  `json:"description" validate:"omitempty,max=500"`
+	Events      []string `json:"events" validate:"omitempty,min=1,dive,webhook_event"`
+	Active      *bool    `json:"active"`
+}
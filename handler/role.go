@@ -0,0 +1,54 @@
+package handler
+
+import (
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/seenark/super-backend-temp/repository"
+)
+
+func NewRoleHandler(router fiber.Router, roleDb repository.RoleRepository) {
+
+	router.Get("/", RequiredValidJWT, RequireSuperAdmin, func(c *fiber.Ctx) error {
+		return c.JSON(roleDb.GetAll(c.UserContext()))
+	})
+
+	router.Get("/permissions", RequiredValidJWT, RequireSuperAdmin, func(c *fiber.Ctx) error {
+		return c.JSON(repository.AllPermissions)
+	})
+
+	/*
+		func description require body: {
+			permissions: ["certtype:write", "redeem:approve"]
+		}
+		creates the role when it does not exist
+	*/
+	router.Put("/:name", RequiredValidJWT, RequireSuperAdmin, func(c *fiber.Ctx) error {
+		name := c.Params("name")
+		body := RoleRequest{}
+		err := bindBody(c, &body)
+		if err != nil {
+			return err
+		}
+		role, err := roleDb.Upsert(c.UserContext(), repository.Role{Name: name, Permissions: body.Permissions})
+		if err != nil {
+			log.Println(err)
+			return fiber.NewError(fiber.StatusInternalServerError, "error while saving role")
+		}
+		return c.JSON(role)
+	})
+
+	router.Delete("/:name", RequiredValidJWT, RequireSuperAdmin, func(c *fiber.Ctx) error {
+		name := c.Params("name")
+		for _, v := range repository.DefaultRoles {
+			if v.Name == name {
+				return fiber.NewError(fiber.StatusBadRequest, "default roles can not be deleted")
+			}
+		}
+		role, err := roleDb.Delete(c.UserContext(), name)
+		if err != nil {
+			return err
+		}
+		return c.JSON(role)
+	})
+}
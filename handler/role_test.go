@@ -0,0 +1,25 @@
+package handler_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/seenark/super-backend-temp/repository"
+)
+
+func TestRoleHandler(t *testing.T) {
+	a := newTestApp(t)
+	superAdmin := a.token(repository.ADMIN_ROLE, true)
+	admin := a.token(repository.ADMIN_ROLE, false)
+
+	a.run([]request{
+		{"list as admin", http.MethodGet, "/api/role/", admin, nil, http.StatusForbidden},
+		{"list", http.MethodGet, "/api/role/", superAdmin, nil, http.StatusOK},
+		{"permissions", http.MethodGet, "/api/role/permissions", superAdmin, nil, http.StatusOK},
+		{"unknown permission", http.MethodPut, "/api/role/auditor", superAdmin, map[string][]string{"permissions": {"nope"}}, http.StatusBadRequest},
+		{"create", http.MethodPut, "/api/role/auditor", superAdmin, map[string][]string{"permissions": {repository.REDEEM_READ_PERMISSION}}, http.StatusOK},
+		{"delete a default role", http.MethodDelete, "/api/role/" + repository.ADMIN_ROLE, superAdmin, nil, http.StatusBadRequest},
+		{"delete", http.MethodDelete, "/api/role/auditor", superAdmin, nil, http.StatusOK},
+		{"delete twice", http.MethodDelete, "/api/role/auditor", superAdmin, nil, http.StatusNotFound},
+	})
+}
@@ -0,0 +1,92 @@
+package handler
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/seenark/super-backend-temp/repository"
+)
+
+// SESSION_CACHE_TTL is how long RequiredValidJWT trusts a session it has
+// looked up. Revocations through this app take effect at once, others (e.g.
+// on another instance) within the ttl.
+const SESSION_CACHE_TTL = 30 * time.Second
+
+// liveSessions is the session store RequiredValidJWT checks access tokens
+// against, set by NewAuthHandler
+var liveSessions = &sessionCache{entries: map[string]sessionCacheEntry{}}
+
+type sessionCacheEntry struct {
+	userId string
+	until  time.Time
+}
+
+// sessionCache remembers the sessions found live for SESSION_CACHE_TTL so
+// not every request reads the session store
+type sessionCache struct {
+	mu       sync.Mutex
+	sessions repository.SessionRepository
+	entries  map[string]sessionCacheEntry
+}
+
+func (s *sessionCache) use(sessions repository.SessionRepository) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions = sessions
+	s.entries = map[string]sessionCacheEntry{}
+}
+
+// isLive tells whether sessionId is a session of userId that is neither
+// revoked nor expired
+func (s *sessionCache) isLive(ctx context.Context, userId string, sessionId string) (bool, error) {
+	now := time.Now()
+	s.mu.Lock()
+	sessions := s.sessions
+	entry, ok := s.entries[sessionId]
+	s.mu.Unlock()
+	if ok && entry.userId == userId && now.Before(entry.until) {
+		return true, nil
+	}
+	if sessions == nil || sessionId == "" {
+		return false, nil
+	}
+
+	session, err := sessions.GetById(ctx, sessionId)
+	if err == repository.ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if session.UserId.Hex() != userId || session.RevokedAt != nil || !now.Before(session.ExpiresAt) {
+		s.forget(sessionId)
+		return false, nil
+	}
+	until := now.Add(SESSION_CACHE_TTL)
+	if session.ExpiresAt.Before(until) {
+		until = session.ExpiresAt
+	}
+	s.mu.Lock()
+	s.entries[sessionId] = sessionCacheEntry{userId: userId, until: until}
+	s.mu.Unlock()
+	return true, nil
+}
+
+// forget drops a revoked session
+func (s *sessionCache) forget(sessionId string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, sessionId)
+}
+
+// forgetUser drops every session of userId
+func (s *sessionCache) forgetUser(userId string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, v := range s.entries {
+		if v.userId == userId {
+			delete(s.entries, id)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/seenark/super-backend-temp/config"
+)
+
+const DEFAULT_REQUEST_TIMEOUT = 10 * time.Second
+
+// Timeout gives every request a context that ends after the timeout of its
+// route, handlers pass c.UserContext() to the repositories so a slow query
+// is given up instead of holding the request. A route takes the timeout of
+// the longest path prefix in cfg.Routes, or cfg.Default.
+func Timeout(cfg config.TimeoutConfig) fiber.Handler {
+	defaultTimeout := time.Duration(cfg.Default) * time.Second
+	if defaultTimeout <= 0 {
+		defaultTimeout = DEFAULT_REQUEST_TIMEOUT
+	}
+	routes := map[string]time.Duration{}
+	for prefix, seconds := range cfg.Routes {
+		routes[prefix] = time.Duration(seconds) * time.Second
+	}
+
+	return func(c *fiber.Ctx) error {
+		timeout := defaultTimeout
+		matched := ""
+		for prefix, v := range routes {
+			if strings.HasPrefix(c.Path(), prefix) && len(prefix) > len(matched) {
+				timeout = v
+				matched = prefix
+			}
+		}
+		ctx, cancel := context.WithTimeout(c.UserContext(), timeout)
+		defer cancel()
+		c.SetUserContext(ctx)
+		return c.Next()
+	}
+}
+
+// streamContext is for work that runs after the handler returned, such as a
+// body stream writer, when the request context is canceled already. It
+// keeps the deadline of the request. c must not be used in the stream.
+func streamContext(c *fiber.Ctx) (context.Context, context.CancelFunc) {
+	deadline, ok := c.UserContext().Deadline()
+	if !ok {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithDeadline(context.Background(), deadline)
+}
@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/seenark/super-backend-temp/cloudstorage"
+	"github.com/seenark/super-backend-temp/config"
+	"github.com/seenark/super-backend-temp/repository"
+)
+
+// TokenMetadata is the metadata json of the OpenSea metadata standard, it is
+// what tokenURI (ERC-721) and uri (ERC-1155) point to.
+type TokenMetadata struct {
+	Name        string           `json:"name"`
+	Description string           `json:"description"`
+	Image       string           `json:"image"`
+	ExternalURL string           `json:"external_url,omitempty"`
+	Attributes  []TokenAttribute `json:"attributes"`
+}
+
+type TokenAttribute struct {
+	TraitType string `json:"trait_type"`
+	Value     string `json:"value"`
+}
+
+func NewTokenHandler(router fiber.Router, metadataRepo repository.IMetadataRepository, certTypeRepo repository.IDigitalCertTypeRepository) {
+	cfg := config.GetConfig()
+
+	// certId is decimal, or 64 hex digits when an ERC-1155 client substituted {id}
+	router.Get("/:certId.json", func(c *fiber.Ctx) error {
+		certId, err := parseTokenId(c.Params("certId"))
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "token id is invalid")
+		}
+		metadata, err := metadataRepo.GetByDigitalCertId(c.UserContext(), certId)
+		if err != nil {
+			log.Println(err)
+			return fiber.NewError(fiber.StatusNotFound, "token not found")
+		}
+		certType, err := certTypeRepo.GetByTypeCode(c.UserContext(), metadata.TypeCode)
+		if err != nil {
+			log.Println(err)
+			certType = &repository.DigitalCertType{}
+		}
+		c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+		return c.JSON(newTokenMetadata(cfg, *metadata, *certType))
+	})
+}
+
+func newTokenMetadata(cfg config.Configuration, metadata repository.Metadata, certType repository.DigitalCertType) TokenMetadata {
+	name := metadata.ProjectName
+	if name == "" {
+		name = fmt.Sprintf("%s #%d", certType.TypeName, metadata.DigitalCertID)
+	}
+	imageName := metadata.ImageName
+	if imageName == "" {
+		imageName = certType.LogoImageName
+	}
+	image := ""
+	if imageName != "" {
+		image = cloudstorage.PublicURL(cfg, imageName)
+	}
+
+	attributes := []TokenAttribute{}
+	traits := []TokenAttribute{
+		{TraitType: "Type of Unit", Value: certType.TypeOfUnit},
+		{TraitType: "Unit", Value: certType.Unit},
+		{TraitType: "Vintage Year", Value: certType.VintageYear},
+		{TraitType: "Project Type", Value: metadata.ProjectType},
+	}
+	for _, v := range traits {
+		if v.Value != "" {
+			attributes = append(attributes, v)
+		}
+	}
+
+	return TokenMetadata{
+		Name:        name,
+		Description: metadata.Description,
+		Image:       image,
+		ExternalURL: tokenURL(cfg.Token.ExternalURL, metadata.DigitalCertID),
+		Attributes:  attributes,
+	}
+}
+
+// tokenURL fills a url template, {id} follows the ERC-1155 id substitution:
+// lowercase hex without 0x, zero padded to 64 digits
+func tokenURL(template string, certId int) string {
+	url := strings.ReplaceAll(template, "{id}", fmt.Sprintf("%064x", certId))
+	return strings.ReplaceAll(url, "{cert_id}", strconv.Itoa(certId))
+}
+
+func parseTokenId(id string) (int, error) {
+	base := 10
+	if len(id) == 64 {
+		base = 16
+	} else if strings.HasPrefix(id, "0x") || strings.HasPrefix(id, "0X") {
+		id = id[2:]
+		base = 16
+	}
+	number, ok := new(big.Int).SetString(id, base)
+	if !ok || number.Sign() < 0 || !number.IsInt64() {
+		return 0, fmt.Errorf("token id %q is invalid", id)
+	}
+	return int(number.Int64()), nil
+}
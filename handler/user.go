@@ -1,9 +1,8 @@
 package handler
 
 import (
-	"fmt"
+	"errors"
 	"log"
-	"strings"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/seenark/super-backend-temp/authen"
@@ -32,47 +31,52 @@ func mapUserToUserResponse(user repository.User) *UserResponse {
 	}
 }
 
-func NewUserHandler(router fiber.Router, db repository.UserRepository) {
+func NewUserHandler(router fiber.Router, db repository.UserRepository, sessionDb repository.SessionRepository, roleDb repository.RoleRepository) {
+	canRead := RequirePermission(roleDb, repository.USER_READ_PERMISSION)
+	canWrite := RequirePermission(roleDb, repository.USER_WRITE_PERMISSION)
+
 	// get all
-	router.Get("/", func(c *fiber.Ctx) error {
+	router.Get("/", RequiredValidJWT, canRead, func(c *fiber.Ctx) error {
+		query, err := parsePageQuery(c)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+		users, page, err := db.GetPage(c.UserContext(), query)
+		if err != nil {
+			return err
+		}
 		usersRes := []UserResponse{}
-		users := db.GetAll()
 		for _, v := range users {
 			userRes := mapUserToUserResponse(v)
 			usersRes = append(usersRes, *userRes)
 		}
-		return c.Status(fiber.StatusOK).JSON(usersRes)
+		return c.Status(fiber.StatusOK).JSON(newPageResponse(usersRes, page))
 	})
 
 	// get by id
-	router.Get("/:id", func(c *fiber.Ctx) error {
+	router.Get("/:id", RequiredValidJWT, canRead, func(c *fiber.Ctx) error {
 		id := c.Params("id")
-		user, err := db.GetById(id)
+		user, err := db.GetById(c.UserContext(), id)
 		if err != nil {
-			return c.SendStatus(fiber.StatusNotFound)
+			return err
 		}
 		userRes := mapUserToUserResponse(*user)
 		return c.Status(fiber.StatusOK).JSON(userRes)
 	})
 
 	// create admin
-	router.Post("/create-admin", RequiredValidJWT, RequiredAdminRole, func(c *fiber.Ctx) error {
-		user := repository.User{}
-		err := c.BodyParser(&user)
+	router.Post("/create-admin", RequiredValidJWT, canWrite, func(c *fiber.Ctx) error {
+		body := CreateUserRequest{}
+		err := bindBody(c, &body)
 		if err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "invalid some field", "error": err})
+			return err
 		}
-		if user.Password == "" || user.Email == "" {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "email or password is empty"})
-		}
-		user.Role = "admin"
-		newUser, err := db.Create(user)
+		newUser, err := db.Create(c.UserContext(), body.toUser(repository.ADMIN_ROLE))
 		if err != nil {
-			// check if there is some error code in error text
-			if strings.Contains(err.Error(), "E11000") {
-				return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"message": "email already taken"})
+			if errors.Is(err, repository.ErrDuplicate) {
+				return fiber.NewError(fiber.StatusConflict, "email already taken")
 			}
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "error while creating new user"})
+			return err
 		}
 		userRes := mapUserToUserResponse(*newUser)
 		return c.Status(fiber.StatusOK).JSON(userRes)
@@ -80,22 +84,17 @@ func NewUserHandler(router fiber.Router, db repository.UserRepository) {
 
 	// create
 	router.Post("/", func(c *fiber.Ctx) error {
-		user := repository.User{}
-		err := c.BodyParser(&user)
+		body := CreateUserRequest{}
+		err := bindBody(c, &body)
 		if err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "invalid some field", "error": err})
-		}
-		if user.Password == "" || user.Email == "" {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "email or password is empty"})
+			return err
 		}
-		user.Role = "user"
-		newUser, err := db.Create(user)
+		newUser, err := db.Create(c.UserContext(), body.toUser(repository.USER_ROLE))
 		if err != nil {
-			// check if there is some error code in error text
-			if strings.Contains(err.Error(), "E11000") {
-				return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"message": "email already taken"})
+			if errors.Is(err, repository.ErrDuplicate) {
+				return fiber.NewError(fiber.StatusConflict, "email already taken")
 			}
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "error while creating new user"})
+			return err
 		}
 		userRes := mapUserToUserResponse(*newUser)
 		return c.Status(fiber.StatusOK).JSON(userRes)
@@ -103,55 +102,45 @@ func NewUserHandler(router fiber.Router, db repository.UserRepository) {
 
 	// reset-password
 	router.Post("/admin/reset-password", RequiredValidJWT, func(c *fiber.Ctx) error {
-		type ResetPassword struct {
-			OldPassword string `json:"old_password"`
-			NewPassword string `json:"new_password"`
-		}
-
-		userArray, ok := c.Locals("user").([]string)
+		principal, ok := GetPrincipal(c)
 		if !ok {
 			return c.SendStatus(fiber.StatusBadRequest)
 		}
-		userId := userArray[0]
-		resetPassword := ResetPassword{}
-		err := c.BodyParser(&resetPassword)
+		userId := principal.Id
+		resetPassword := ResetPasswordRequest{}
+		err := bindBody(c, &resetPassword)
 		if err != nil {
-			log.Println(err)
-			return c.SendStatus(fiber.StatusBadRequest)
-		}
-
-		if resetPassword.NewPassword == "" || resetPassword.OldPassword == "" {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "password could not be empty"})
+			return err
 		}
-		user, err := db.GetById(userId)
+		user, err := db.GetById(c.UserContext(), userId)
 		if err != nil {
-			log.Println(err)
-			return c.SendStatus(fiber.StatusNotFound)
+			return err
 		}
 		passwordOk := authen.VerifyPassword(user.Password, resetPassword.OldPassword)
 		if !passwordOk {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "password is incorrected"})
+			return fiber.NewError(fiber.StatusBadRequest, "password is incorrected")
 		}
 		user.Password = resetPassword.NewPassword
-		_, err = db.Update(*user)
+		_, err = db.Update(c.UserContext(), *user)
+		if err != nil {
+			return err
+		}
+		// a new password signs out every device
+		err = sessionDb.RevokeAllByUserId(c.UserContext(), userId, "password changed")
+		liveSessions.forgetUser(userId)
 		if err != nil {
 			log.Println(err)
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": err.Error()})
 		}
 		return c.SendStatus(fiber.StatusOK)
 	})
 
 	// hashPassword
-	router.Post("/hash-password", func(c *fiber.Ctx) error {
-		password := struct {
-			Password string `json:"password"`
-		}{}
-		err := c.BodyParser(&password)
+	router.Post("/hash-password", RequiredValidJWT, canWrite, func(c *fiber.Ctx) error {
+		password := HashPasswordRequest{}
+		err := bindBody(c, &password)
 		if err != nil {
-			fmt.Println(err)
-			return c.SendStatus(fiber.StatusBadRequest)
+			return err
 		}
-		fmt.Printf("password.password: %v\n", password.Password)
 		hash, err := authen.HashPassword(password.Password)
 		if err != nil {
 			return c.SendStatus(fiber.StatusBadRequest)
@@ -0,0 +1,70 @@
+package handler_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/seenark/super-backend-temp/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestUserHandler(t *testing.T) {
+	a := newTestApp(t)
+	admin := a.token(repository.ADMIN_ROLE, false)
+	user := a.token(repository.USER_ROLE, false)
+	newUser := map[string]string{"name": "Somchai", "email": "s@example.com", "password": "password1"}
+
+	a.run([]request{
+		{"sign up", http.MethodPost, "/api/user/", "", newUser, http.StatusOK},
+		{"sign up with a taken email", http.MethodPost, "/api/user/", "", newUser, http.StatusConflict},
+		{"sign up with a short password", http.MethodPost, "/api/user/", "", map[string]string{"email": "b@example.com", "password": "short"}, http.StatusBadRequest},
+		{"list without a token", http.MethodGet, "/api/user/", "", nil, http.StatusUnauthorized},
+		{"list without permission", http.MethodGet, "/api/user/", user, nil, http.StatusForbidden},
+		{"list", http.MethodGet, "/api/user/?limit=2", admin, nil, http.StatusOK},
+		{"list with a bad sort", http.MethodGet, "/api/user/?sort=password", admin, nil, http.StatusBadRequest},
+		{"unknown user", http.MethodGet, "/api/user/" + primitive.NewObjectID().Hex(), admin, nil, http.StatusNotFound},
+		{"malformed user id", http.MethodGet, "/api/user/nope", admin, nil, http.StatusNotFound},
+		{"create admin without permission", http.MethodPost, "/api/user/create-admin", user, map[string]string{"email": "c@example.com", "password": "password1"}, http.StatusForbidden},
+		{"create admin", http.MethodPost, "/api/user/create-admin", admin, map[string]string{"email": "c@example.com", "password": "password1"}, http.StatusOK},
+		{"hash password", http.MethodPost, "/api/user/hash-password", admin, map[string]string{"password": "password1"}, http.StatusOK},
+	})
+
+	_, body := a.do(request{method: http.MethodGet, path: "/api/user/?limit=2&sort=-name", token: admin})
+	page := struct {
+		Items      []map[string]interface{} `json:"items"`
+		Total      int64                    `json:"total"`
+		NextCursor string                   `json:"next_cursor"`
+	}{}
+	decode(t, body, &page)
+	if len(page.Items) != 2 || page.Total != 4 || page.NextCursor == "" {
+		t.Fatalf("page = %+v", page)
+	}
+	if _, ok := page.Items[0]["password"]; ok {
+		t.Fatal("password is in the response")
+	}
+}
+
+func TestUserResetPassword(t *testing.T) {
+	a := newTestApp(t)
+	created, err := a.repos.Users.Create(ctx, repository.User{Email: "s@example.com", Password: "password1", Role: repository.USER_ROLE})
+	if err != nil {
+		t.Fatal(err)
+	}
+	signin, err := a.repos.Authen.Signin(ctx, "s@example.com", "password1", repository.ClientInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := signin.Token.Token
+
+	a.run([]request{
+		{"wrong old password", http.MethodPost, "/api/user/admin/reset-password", token, map[string]string{"old_password": "nope", "new_password": "password2"}, http.StatusBadRequest},
+		{"reset", http.MethodPost, "/api/user/admin/reset-password", token, map[string]string{"old_password": "password1", "new_password": "password2"}, http.StatusOK},
+	})
+	if sessions := a.repos.Sessions.GetActiveByUserId(ctx, created.Id.Hex()); len(sessions) != 0 {
+		t.Fatal("sessions were not revoked")
+	}
+	_, err = a.repos.Authen.Signin(ctx, "s@example.com", "password2", repository.ClientInfo{})
+	if err != nil {
+		t.Fatalf("sign in with the new password: %v", err)
+	}
+}
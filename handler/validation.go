@@ -0,0 +1,39 @@
+package handler
+
+import (
+	"errors"
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/seenark/super-backend-temp/repository"
+	"github.com/seenark/super-backend-temp/validation"
+	"github.com/seenark/super-backend-temp/webhook"
+)
+
+var errInvalidBody = errors.New("body is invalid")
+
+func init() {
+	validation.Register("permission", "%s is not a known permission", repository.IsValidPermission)
+	validation.Register("webhook_event", "%s is not an event webhooks can subscribe to", webhook.IsWebhookEvent)
+}
+
+// bindBody parses the json or form body into body, a pointer to a request
+// struct, and checks its validate tags. Requests with a Normalize method are
+// normalized in between. The error is answered by ErrorHandler.
+func bindBody(c *fiber.Ctx, body interface{}) error {
+	err := c.BodyParser(body)
+	if err != nil {
+		log.Println(err)
+		return errInvalidBody
+	}
+	if normalizer, ok := body.(interface{ Normalize() }); ok {
+		normalizer.Normalize()
+	}
+	return validation.Struct(body)
+}
+
+// fieldError is the error of a check that is not a validate tag, such as a
+// required file
+func fieldError(field string, rule string, message string) error {
+	return validation.Errors{{Field: field, Rule: rule, Message: message}}
+}
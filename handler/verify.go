@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"log"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/seenark/super-backend-temp/authen"
+	"github.com/seenark/super-backend-temp/config"
+	"github.com/seenark/super-backend-temp/repository"
+)
+
+// VerifiedRedemption is the public view of a redemption, it has no email,
+// telephone or tax ID
+type VerifiedRedemption struct {
+	RedeemId         int    `json:"redeem_id"`
+	Company          string `json:"company"`
+	CertId           int    `json:"cert_id"`
+	CertType         string `json:"cert_type"`
+	TypeOfUnit       string `json:"type_of_unit"`
+	Unit             string `json:"unit"`
+	VintageYear      string `json:"vintage_year"`
+	ProjectName      string `json:"project_name"`
+	Amount           int    `json:"amount"`
+	RedeemDate       int    `json:"redeem_date"` // Unix timestamp
+	Status           string `json:"status"`
+	TxHash           string `json:"tx_hash"`
+	VerificationCode string `json:"verification_code,omitempty"`
+}
+
+type VerifyResponse struct {
+	Redemption VerifiedRedemption `json:"redemption"`
+	// compact JWS of the redemption with the audience super-backend-verify,
+	// check it against JwksURL
+	Signature string `json:"signature"`
+	JwksURL   string `json:"jwks_url"`
+}
+
+// NewVerifyHandler lets auditors and buyers check a redemption without an
+// account, by the tx hash or the code printed on the certificate
+func NewVerifyHandler(router fiber.Router, redeemedRepo repository.IRedeemedRepository, metadataRepo repository.IMetadataRepository, certTypeRepo repository.IDigitalCertTypeRepository) {
+	cfg := config.GetConfig()
+	jwksURL := strings.TrimRight(cfg.App.Domain, "/") + "/.well-known/jwks.json"
+
+	router.Get("/:code", func(c *fiber.Ctx) error {
+		code := strings.TrimSpace(c.Params("code"))
+		var redeemed *repository.Redeemed
+		var err error
+		if strings.HasPrefix(code, "0x") && len(code) == 66 {
+			redeemed, err = redeemedRepo.GetByTxHash(c.UserContext(), strings.ToLower(code))
+		} else {
+			redeemed, err = redeemedRepo.GetByVerificationCode(c.UserContext(), strings.ToUpper(code))
+		}
+		if err != nil {
+			return fiber.NewError(fiber.StatusNotFound, "no redemption with this code")
+		}
+		// only what is on the chain is signed, a customer submission has no
+		// wallet until its redeem event comes in and a reorg may take it off
+		if redeemed.WalletAddress == "" || redeemed.Removed {
+			return fiber.NewError(fiber.StatusNotFound, "no redemption with this code")
+		}
+
+		view := VerifiedRedemption{
+			RedeemId:         redeemed.RedeemId,
+			Company:          redeemed.Company,
+			CertId:           redeemed.CertId,
+			Amount:           redeemed.Amount,
+			RedeemDate:       redeemed.RedeemDate,
+			Status:           redeemed.ApproveStatus,
+			TxHash:           redeemed.TxHash,
+			VerificationCode: redeemed.VerificationCode,
+		}
+		metadata, err := metadataRepo.GetByDigitalCertId(c.UserContext(), redeemed.CertId)
+		if err == nil {
+			view.ProjectName = metadata.ProjectName
+			certType, err := certTypeRepo.GetByTypeCode(c.UserContext(), metadata.TypeCode)
+			if err == nil {
+				view.CertType = certType.TypeName
+				view.TypeOfUnit = certType.TypeOfUnit
+				view.Unit = certType.Unit
+				view.VintageYear = certType.VintageYear
+			}
+		}
+
+		signature, err := authen.SignStatement(redeemed.TxHash, view)
+		if err != nil {
+			log.Println(err)
+			return fiber.NewError(fiber.StatusInternalServerError, "could not sign the redemption")
+		}
+		return c.JSON(VerifyResponse{
+			Redemption: view,
+			Signature:  signature,
+			JwksURL:    jwksURL,
+		})
+	})
+}
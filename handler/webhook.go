@@ -0,0 +1,134 @@
+package handler
+
+import (
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/seenark/super-backend-temp/repository"
+	"github.com/seenark/super-backend-temp/webhook"
+)
+
+func NewWebhookHandler(router fiber.Router, webhookDb repository.WebhookRepository, deliveryDb repository.WebhookDeliveryRepository, dispatcher *webhook.Dispatcher, roleDb repository.RoleRepository) {
+	canManage := RequirePermission(roleDb, repository.WEBHOOK_MANAGE_PERMISSION)
+
+	/*
+		func description require body: {
+			url: <https://partner.example/webhook>,
+			description: <what it is for>,
+			events: ["redeemed.created", "redeemed.status_changed", "metadata.updated", "cert_type.created"]
+		}
+		the signing secret is only returned here
+	*/
+	router.Post("/", RequiredValidJWT, canManage, func(c *fiber.Ctx) error {
+		body := CreateWebhookRequest{}
+		err := bindBody(c, &body)
+		if err != nil {
+			return err
+		}
+		secret, err := webhook.NewSecret()
+		if err != nil {
+			log.Println(err)
+			return c.SendStatus(fiber.StatusInternalServerError)
+		}
+		principal, _ := GetPrincipal(c)
+		newWebhook, err := webhookDb.Create(c.UserContext(), repository.Webhook{
+			Url:         body.Url,
+			Description: body.Description,
+			Events:      body.Events,
+			Secret:      secret,
+			Active:      true,
+			CreatedBy:   principal.Id,
+		})
+		if err != nil {
+			log.Println(err)
+			return fiber.NewError(fiber.StatusInternalServerError, "error while creating webhook")
+		}
+		return c.JSON(fiber.Map{"secret": secret, "webhook": newWebhook})
+	})
+
+	router.Get("/", RequiredValidJWT, canManage, func(c *fiber.Ctx) error {
+		return c.JSON(webhookDb.GetAll(c.UserContext()))
+	})
+
+	router.Get("/:id", RequiredValidJWT, canManage, func(c *fiber.Ctx) error {
+		found, err := webhookDb.GetById(c.UserContext(), c.Params("id"))
+		if err != nil {
+			return fiber.NewError(fiber.StatusNotFound, "webhook not found")
+		}
+		return c.JSON(found)
+	})
+
+	/*
+		func description body: every field is optional {
+			url, description, events, active
+		}
+	*/
+	router.Patch("/:id", RequiredValidJWT, canManage, func(c *fiber.Ctx) error {
+		found, err := webhookDb.GetById(c.UserContext(), c.Params("id"))
+		if err != nil {
+			return fiber.NewError(fiber.StatusNotFound, "webhook not found")
+		}
+		body := UpdateWebhookRequest{}
+		err = bindBody(c, &body)
+		if err != nil {
+			return err
+		}
+		if body.Url != nil {
+			found.Url = *body.Url
+		}
+		if body.Description != nil {
+			found.Description = *body.Description
+		}
+		if body.Events != nil {
+			found.Events = body.Events
+		}
+		if body.Active != nil {
+			found.Active = *body.Active
+		}
+		updated, err := webhookDb.Update(c.UserContext(), c.Params("id"), *found)
+		if err != nil {
+			log.Println(err)
+			return fiber.NewError(fiber.StatusInternalServerError, "error while updating webhook")
+		}
+		return c.JSON(updated)
+	})
+
+	router.Delete("/:id", RequiredValidJWT, canManage, func(c *fiber.Ctx) error {
+		deleted, err := webhookDb.Delete(c.UserContext(), c.Params("id"))
+		if err != nil {
+			return fiber.NewError(fiber.StatusNotFound, "webhook not found")
+		}
+		return c.JSON(deleted)
+	})
+
+	// delivery log of a webhook, newest first
+	router.Get("/:id/deliveries", RequiredValidJWT, canManage, func(c *fiber.Ctx) error {
+		found, err := webhookDb.GetById(c.UserContext(), c.Params("id"))
+		if err != nil {
+			return fiber.NewError(fiber.StatusNotFound, "webhook not found")
+		}
+		query, err := parsePageQuery(c)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+		deliveries, page, err := deliveryDb.GetPage(c.UserContext(), found.Id, query)
+		if err != nil {
+			return err
+		}
+		return c.JSON(newPageResponse(deliveries, page))
+	})
+
+	// sends a logged delivery again, it is queued and logged as a new delivery
+	router.Post("/deliveries/:deliveryId/replay", RequiredValidJWT, canManage, func(c *fiber.Ctx) error {
+		delivery, err := deliveryDb.GetById(c.UserContext(), c.Params("deliveryId"))
+		if err != nil {
+			return fiber.NewError(fiber.StatusNotFound, "delivery not found")
+		}
+		event, err := dispatcher.Replay(c.UserContext(), *delivery)
+		if err != nil {
+			log.Println(err)
+			return fiber.NewError(fiber.StatusInternalServerError, "error while replaying delivery")
+		}
+		return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"message": "delivery queued", "outbox_event_id": event.Id})
+	})
+}
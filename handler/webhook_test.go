@@ -0,0 +1,61 @@
+package handler_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/seenark/super-backend-temp/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestWebhookHandler(t *testing.T) {
+	a := newTestApp(t)
+	admin := a.token(repository.ADMIN_ROLE, false)
+	user := a.token(repository.USER_ROLE, false)
+	newWebhook := map[string]interface{}{"url": "https://example.com/hook", "events": []string{repository.REDEEMED_CREATED_EVENT}}
+
+	a.run([]request{
+		{"create without permission", http.MethodPost, "/api/webhook/", user, newWebhook, http.StatusForbidden},
+		{"create with an unknown event", http.MethodPost, "/api/webhook/", admin, map[string]interface{}{"url": "https://example.com/hook", "events": []string{"nope"}}, http.StatusBadRequest},
+		{"create with a bad url", http.MethodPost, "/api/webhook/", admin, map[string]interface{}{"url": "ftp://example.com", "events": []string{repository.REDEEMED_CREATED_EVENT}}, http.StatusBadRequest},
+	})
+
+	res, body := a.do(request{method: http.MethodPost, path: "/api/webhook/", token: admin, body: newWebhook})
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("create = %d: %s", res.StatusCode, body)
+	}
+	created := struct {
+		Secret  string             `json:"secret"`
+		Webhook repository.Webhook `json:"webhook"`
+	}{}
+	decode(t, body, &created)
+	if created.Secret == "" {
+		t.Fatal("no secret")
+	}
+	path := "/api/webhook/" + created.Webhook.Id.Hex()
+	unknown := "/api/webhook/" + primitive.NewObjectID().Hex()
+
+	a.run([]request{
+		{"list", http.MethodGet, "/api/webhook/", admin, nil, http.StatusOK},
+		{"get", http.MethodGet, path, admin, nil, http.StatusOK},
+		{"get unknown", http.MethodGet, unknown, admin, nil, http.StatusNotFound},
+		{"update", http.MethodPatch, path, admin, map[string]interface{}{"active": false}, http.StatusOK},
+		{"update unknown", http.MethodPatch, unknown, admin, map[string]interface{}{"active": false}, http.StatusNotFound},
+		{"deliveries", http.MethodGet, path + "/deliveries", admin, nil, http.StatusOK},
+		{"deliveries of unknown", http.MethodGet, unknown + "/deliveries", admin, nil, http.StatusNotFound},
+		{"replay unknown delivery", http.MethodPost, "/api/webhook/deliveries/" + primitive.NewObjectID().Hex() + "/replay", admin, nil, http.StatusNotFound},
+		{"delete", http.MethodDelete, path, admin, nil, http.StatusOK},
+		{"delete twice", http.MethodDelete, path, admin, nil, http.StatusNotFound},
+	})
+
+	if found := a.repos.Webhooks.GetAll(ctx); len(found) != 0 {
+		t.Fatalf("webhooks = %v", found)
+	}
+}
+
+func TestJwksHandler(t *testing.T) {
+	a := newTestApp(t)
+	a.run([]request{
+		{"keys", http.MethodGet, "/.well-known/jwks.json", "", nil, http.StatusOK},
+	})
+}
@@ -3,7 +3,9 @@ package helperfunc
 import (
 	"encoding/json"
 	"fmt"
+	"math/big"
 	"runtime"
+	"strings"
 	"time"
 )
 
@@ -27,3 +29,26 @@ func Trace() {
 	frame, _ := frames.Next()
 	fmt.Printf("%s:%d %s\n", frame.File, frame.Line, frame.Function)
 }
+
+// WeiToDecimal formats an integer amount of the smallest unit, such as wei,
+// as a decimal string with decimals digits after the point. Trailing zeros
+// are dropped, "1500000000000000000" with 18 decimals is "1.5".
+func WeiToDecimal(wei string, decimals int) (string, error) {
+	value, ok := new(big.Int).SetString(wei, 10)
+	if !ok {
+		return "", fmt.Errorf("%q is not an integer", wei)
+	}
+	sign := ""
+	if value.Sign() < 0 {
+		sign = "-"
+		value.Neg(value)
+	}
+	unit := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil)
+	whole, frac := new(big.Int).QuoRem(value, unit, new(big.Int))
+	if frac.Sign() == 0 {
+		return sign + whole.String(), nil
+	}
+	fracStr := frac.String()
+	fracStr = strings.Repeat("0", decimals-len(fracStr)) + fracStr
+	return sign + whole.String() + "." + strings.TrimRight(fracStr, "0"), nil
+}
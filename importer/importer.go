@@ -0,0 +1,232 @@
+package importer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/seenark/super-backend-temp/cloudstorage"
+	"github.com/seenark/super-backend-temp/config"
+	"github.com/seenark/super-backend-temp/repository"
+	"github.com/seenark/super-backend-temp/validation"
+)
+
+var (
+	// ErrInvalidFile is a manifest or zip that can not be read
+	ErrInvalidFile = errors.New("import file is invalid")
+	// ErrInvalidRows is returned with a report when a row fails its checks,
+	// nothing is imported then
+	ErrInvalidRows = errors.New("manifest has invalid rows")
+)
+
+const (
+	DEFAULT_MAX_ROWS       = 1000
+	DEFAULT_MAX_IMAGE_SIZE = 10 // MB
+)
+
+// RowReport is what was found for a row of the manifest
+type RowReport struct {
+	Row       int      `json:"row"` // 1 is the first row, after the header of a csv
+	TypeCode  string   `json:"type_code"`
+	CertId    int      `json:"cert_id"`
+	Image     string   `json:"image"`
+	ImageName string   `json:"image_name,omitempty"` // object the image was stored as
+	Errors    []string `json:"errors"`
+}
+
+// Report is the result of a check or an import
+type Report struct {
+	DryRun   bool        `json:"dry_run"`
+	Imported int         `json:"imported"`
+	Invalid  int         `json:"invalid"`
+	Rows     []RowReport `json:"rows"`
+}
+
+// Importer creates metadata in bulk from a manifest and a zip of images.
+// Every row is checked before anything is written, and an import either
+// creates all its metadata with their images or leaves nothing behind.
+type Importer struct {
+	metadataRepo repository.IMetadataRepository
+	certTypeRepo repository.IDigitalCertTypeRepository
+	store        cloudstorage.Storage
+	maxRows      int
+	maxImageSize int64
+}
+
+func NewImporter(cfg config.ImportConfig, metadataRepo repository.IMetadataRepository, certTypeRepo repository.IDigitalCertTypeRepository, store cloudstorage.Storage) *Importer {
+	importer := &Importer{
+		metadataRepo: metadataRepo,
+		certTypeRepo: certTypeRepo,
+		store:        store,
+		maxRows:      cfg.MaxRows,
+		maxImageSize: int64(cfg.MaxImageSize) << 20,
+	}
+	if importer.maxRows <= 0 {
+		importer.maxRows = DEFAULT_MAX_ROWS
+	}
+	if importer.maxImageSize <= 0 {
+		importer.maxImageSize = DEFAULT_MAX_IMAGE_SIZE << 20
+	}
+	return importer
+}
+
+// Check reports the problems of every row without writing anything, the
+// error is for a failing repository only
+func (i *Importer) Check(ctx context.Context, rows []Row, images *Images) (*Report, error) {
+	report := &Report{DryRun: true, Rows: make([]RowReport, len(rows))}
+	certTypes := map[string]bool{}
+	for _, v := range i.certTypeRepo.GetAll(ctx) {
+		certTypes[v.TypeCode] = true
+	}
+
+	firstRow := map[int]int{}
+	certIds := []int{}
+	for n, row := range rows {
+		result := RowReport{Row: n + 1, TypeCode: row.TypeCode, Image: row.Image, Errors: []string{}}
+		err := validation.Struct(row)
+		fields := validation.Errors{}
+		certIdValid := true
+		if errors.As(err, &fields) {
+			for _, v := range fields {
+				result.Errors = append(result.Errors, v.Message)
+				certIdValid = certIdValid && v.Field != "cert_id"
+			}
+		} else if err != nil {
+			return nil, err
+		}
+		if row.TypeCode != "" && !certTypes[row.TypeCode] {
+			result.Errors = append(result.Errors, fmt.Sprintf("type_code %s does not exist, create it first", row.TypeCode))
+		}
+		if row.Image != "" {
+			_, err := images.find(row.Image)
+			if err != nil {
+				result.Errors = append(result.Errors, err.Error())
+			}
+		}
+		certId, err := strconv.Atoi(string(row.CertId))
+		if err == nil {
+			result.CertId = certId
+			if first, ok := firstRow[certId]; ok {
+				result.Errors = append(result.Errors, fmt.Sprintf("cert_id %d is in row %d too", certId, first))
+			} else {
+				firstRow[certId] = n + 1
+				certIds = append(certIds, certId)
+			}
+		} else if certIdValid {
+			result.Errors = append(result.Errors, "cert_id is too large")
+		}
+		report.Rows[n] = result
+	}
+
+	existing, err := i.existingCertIds(ctx, certIds)
+	if err != nil {
+		return nil, err
+	}
+	for n, v := range report.Rows {
+		if existing[v.CertId] && firstRow[v.CertId] == v.Row {
+			report.Rows[n].Errors = append(v.Errors, fmt.Sprintf("cert_id %d already has metadata", v.CertId))
+		}
+	}
+	for _, v := range report.Rows {
+		if len(v.Errors) > 0 {
+			report.Invalid++
+		}
+	}
+	return report, nil
+}
+
+// existingCertIds returns which of certIds have metadata, a page at a time
+func (i *Importer) existingCertIds(ctx context.Context, certIds []int) (map[int]bool, error) {
+	existing := map[int]bool{}
+	for start := 0; start < len(certIds); start += repository.MAX_PAGE_LIMIT {
+		end := start + repository.MAX_PAGE_LIMIT
+		if end > len(certIds) {
+			end = len(certIds)
+		}
+		filter := repository.MetadataFilter{Ids: certIds[start:end]}
+		metadatas, _, err := i.metadataRepo.GetPage(ctx, filter, repository.PageQuery{Limit: repository.MAX_PAGE_LIMIT})
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range metadatas {
+			existing[v.DigitalCertID] = true
+		}
+	}
+	return existing, nil
+}
+
+// Import checks the rows, then uploads their images and creates their
+// metadata. When a row is invalid it returns the report and ErrInvalidRows,
+// when an upload or the insert fails the uploaded images are deleted and
+// no metadata is created.
+func (i *Importer) Import(ctx context.Context, rows []Row, images *Images) (*Report, error) {
+	report, err := i.Check(ctx, rows, images)
+	if err != nil {
+		return nil, err
+	}
+	if report.Invalid > 0 {
+		return report, ErrInvalidRows
+	}
+	report.DryRun = false
+
+	uploaded := []string{}
+	rollback := func() {
+		for _, v := range uploaded {
+			err := i.store.Delete(v)
+			if err != nil {
+				log.Printf("delete %s of a failed import: %v\n", v, err)
+			}
+		}
+	}
+	listedDate := time.Now().Format(time.RFC3339)
+	metadatas := []repository.Metadata{}
+	for n, row := range rows {
+		imageName, err := i.upload(images, row.Image)
+		if err != nil {
+			rollback()
+			return nil, fmt.Errorf("row %d: %w", n+1, err)
+		}
+		uploaded = append(uploaded, imageName)
+		report.Rows[n].ImageName = imageName
+		metadatas = append(metadatas, repository.Metadata{
+			TypeCode:      row.TypeCode,
+			DigitalCertID: report.Rows[n].CertId,
+			ProjectName:   row.ProjectName,
+			ProjectType:   row.ProjectType,
+			ImageName:     imageName,
+			Description:   row.Description,
+			ListedDate:    listedDate,
+		})
+	}
+	err = i.metadataRepo.CreateMany(ctx, metadatas)
+	if err != nil {
+		rollback()
+		return nil, err
+	}
+	report.Imported = len(metadatas)
+	return report, nil
+}
+
+// upload stores an image of the zip under a new name like the api does
+func (i *Importer) upload(images *Images, name string) (string, error) {
+	f, err := images.find(name)
+	if err != nil {
+		return "", err
+	}
+	rc, err := images.open(f)
+	if err != nil {
+		return "", fmt.Errorf("open image %s: %w", name, err)
+	}
+	defer rc.Close()
+	imageName := fmt.Sprintf("%s%s", uuid.New(), path.Ext(f.Name))
+	err = i.store.Upload(rc, imageName)
+	if err != nil {
+		return "", fmt.Errorf("upload image %s: %w", name, err)
+	}
+	return imageName, nil
+}
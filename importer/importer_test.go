@@ -0,0 +1,287 @@
+package importer_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/seenark/super-backend-temp/cloudstorage"
+	"github.com/seenark/super-backend-temp/config"
+	"github.com/seenark/super-backend-temp/importer"
+	"github.com/seenark/super-backend-temp/repository"
+)
+
+var ctx = context.Background()
+
+// recordingStorage is a memory storage that keeps what is stored and may
+// fail an upload
+type recordingStorage struct {
+	*cloudstorage.MemoryStorage
+	stored   map[string]bool
+	failFrom int // uploads from this one on fail, 0 never
+	uploads  int
+}
+
+func newRecordingStorage() *recordingStorage {
+	return &recordingStorage{MemoryStorage: cloudstorage.NewMemoryStorage(""), stored: map[string]bool{}}
+}
+
+func (s *recordingStorage) Upload(file io.Reader, object string) error {
+	s.uploads++
+	if s.failFrom > 0 && s.uploads >= s.failFrom {
+		return errors.New("bucket is down")
+	}
+	err := s.MemoryStorage.Upload(file, object)
+	if err == nil {
+		s.stored[object] = true
+	}
+	return err
+}
+
+func (s *recordingStorage) Delete(object string) error {
+	delete(s.stored, object)
+	return s.MemoryStorage.Delete(object)
+}
+
+// failingMetadataDb fails every insert
+type failingMetadataDb struct {
+	repository.IMetadataRepository
+}
+
+func (failingMetadataDb) CreateMany(ctx context.Context, metadatas []repository.Metadata) error {
+	return repository.ErrTimeout
+}
+
+func newZip(t *testing.T, files map[string]string) *bytes.Reader {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	w := zip.NewWriter(buf)
+	for name, content := range files {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(content))
+	}
+	err := w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.NewReader(buf.Bytes())
+}
+
+type fixture struct {
+	repos    repository.Repositories
+	store    *recordingStorage
+	importer *importer.Importer
+	images   *importer.Images
+}
+
+func newFixture(t *testing.T) *fixture {
+	t.Helper()
+	repos := repository.NewMemoryRepositories(repository.NewMemoryStore())
+	for _, code := range []string{"REC", "CARBON"} {
+		_, err := repos.CertTypes.Create(ctx, code, code, "", "MWh", "1", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := repos.Metadata.Create(ctx, "REC", 9, "", "", "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := newRecordingStorage()
+	f := &fixture{repos: repos, store: store}
+	f.importer = importer.NewImporter(config.ImportConfig{MaxRows: 5, MaxImageSize: 1}, repos.Metadata, repos.CertTypes, store)
+	zipFile := newZip(t, map[string]string{
+		"certs/1.png":   "png",
+		"certs/2.jpg":   "jpg",
+		"certs/3.png":   "png",
+		"other/3.png":   "png",
+		"notes.txt":     "text",
+		"certs/big.png": strings.Repeat("x", 1<<20+1),
+	})
+	f.images, err = f.importer.OpenImages(zipFile, zipFile.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestReadManifest(t *testing.T) {
+	f := newFixture(t)
+	want := []importer.Row{
+		{TypeCode: "REC", CertId: "1", ProjectName: "Solar farm", Image: "certs/1.png"},
+		{TypeCode: "CARBON", CertId: "2", Description: "Mangrove, Trat", Image: "2.jpg"},
+	}
+	manifests := map[string]string{
+		"m.json": `[
+			{"type_code": "REC", "cert_id": 1, "project_name": "Solar farm", "image": "certs/1.png"},
+			{"type_code": "CARBON", "cert_id": "2", "description": "Mangrove, Trat", "image": "2.jpg"}
+		]`,
+		"m.csv": "\xEF\xBB\xBFcert_id,type_code,project_name,description,image\n" +
+			"1,REC,Solar farm,,certs/1.png\n" +
+			"2,CARBON,,\"Mangrove, Trat\",2.jpg\n",
+	}
+	for name, content := range manifests {
+		t.Run(name, func(t *testing.T) {
+			rows, err := f.importer.ReadManifest(name, strings.NewReader(content))
+			if err != nil {
+				t.Fatalf("ReadManifest: %v", err)
+			}
+			if len(rows) != len(want) {
+				t.Fatalf("rows = %+v", rows)
+			}
+			for i := range want {
+				if rows[i] != want[i] {
+					t.Fatalf("row %d = %+v, want %+v", i+1, rows[i], want[i])
+				}
+			}
+		})
+	}
+
+	invalid := []struct {
+		name    string
+		content string
+	}{
+		{"m.txt", "cert_id\n1\n"},
+		{"m.csv", "cert_id,colour\n1,green\n"},
+		{"m.csv", "cert_id,image\n"},
+		{"m.json", `[{"cert_id": 1, "colour": "green"}]`},
+		{"m.csv", "cert_id\n1\n2\n3\n4\n5\n6\n"},
+	}
+	for _, tt := range invalid {
+		t.Run("invalid "+tt.name, func(t *testing.T) {
+			_, err := f.importer.ReadManifest(tt.name, strings.NewReader(tt.content))
+			if !errors.Is(err, importer.ErrInvalidFile) {
+				t.Fatalf("error = %v, want ErrInvalidFile", err)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	f := newFixture(t)
+	rows := []importer.Row{
+		{TypeCode: "REC", CertId: "1", Image: "certs/1.png"},
+		{TypeCode: "NOPE", CertId: "2", Image: "2.jpg"},
+		{TypeCode: "REC", CertId: "1", Image: "certs/1.png"},
+		{TypeCode: "REC", CertId: "9", Image: "certs/1.png"},
+		{TypeCode: "REC", CertId: "-4", Image: "missing.png"},
+		{TypeCode: "REC", CertId: "5", Image: "3.png"},
+		{TypeCode: "REC", CertId: "6", Image: "notes.txt"},
+		{TypeCode: "REC", CertId: "7", Image: "certs/big.png"},
+		{CertId: "99999999999999999999", Image: "./certs/3.png"},
+	}
+	report, err := f.importer.Check(ctx, rows, f.images)
+	if err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	wantErrors := [][]string{
+		{},
+		{"type_code NOPE does not exist, create it first"},
+		{"cert_id 1 is in row 1 too"},
+		{"cert_id 9 already has metadata"},
+		{"cert_id must contain only digits", "image missing.png is not in the zip"},
+		{"image 3.png is in the zip 2 times, give its folder"},
+		{"image notes.txt is not an image file"},
+		{"image certs/big.png is larger than 1 MB"},
+		{"type_code is required", "cert_id is too large"},
+	}
+	for i, want := range wantErrors {
+		got := report.Rows[i].Errors
+		if strings.Join(got, "|") != strings.Join(want, "|") {
+			t.Errorf("row %d errors = %q, want %q", i+1, got, want)
+		}
+	}
+	if !report.DryRun || report.Invalid != len(rows)-1 || report.Imported != 0 {
+		t.Fatalf("report = %+v", report)
+	}
+	if len(f.store.stored) != 0 {
+		t.Fatalf("a check stored %v", f.store.stored)
+	}
+}
+
+func TestImport(t *testing.T) {
+	rows := []importer.Row{
+		{TypeCode: "REC", CertId: "1", ProjectName: "Solar farm", Image: "certs/1.png"},
+		{TypeCode: "CARBON", CertId: "2", Image: "2.jpg"},
+		{TypeCode: "REC", CertId: "3", Image: "other/3.png"},
+	}
+
+	t.Run("imports every row", func(t *testing.T) {
+		f := newFixture(t)
+		report, err := f.importer.Import(ctx, rows, f.images)
+		if err != nil {
+			t.Fatalf("Import: %v", err)
+		}
+		if report.DryRun || report.Imported != 3 || report.Invalid != 0 {
+			t.Fatalf("report = %+v", report)
+		}
+		for _, v := range report.Rows {
+			metadata, err := f.repos.Metadata.GetByDigitalCertId(ctx, v.CertId)
+			if err != nil {
+				t.Fatalf("cert %d: %v", v.CertId, err)
+			}
+			if metadata.ImageName != v.ImageName || !f.store.stored[v.ImageName] {
+				t.Fatalf("cert %d has image %q, report %q, stored %v", v.CertId, metadata.ImageName, v.ImageName, f.store.stored)
+			}
+		}
+		metadata, _ := f.repos.Metadata.GetByDigitalCertId(ctx, 1)
+		if metadata.ProjectName != "Solar farm" || metadata.ListedDate == "" {
+			t.Fatalf("metadata = %+v", metadata)
+		}
+	})
+
+	t.Run("invalid rows import nothing", func(t *testing.T) {
+		f := newFixture(t)
+		invalid := append([]importer.Row{}, rows...)
+		invalid[2].TypeCode = "NOPE"
+		report, err := f.importer.Import(ctx, invalid, f.images)
+		if !errors.Is(err, importer.ErrInvalidRows) || report.Invalid != 1 {
+			t.Fatalf("Import = %+v, %v", report, err)
+		}
+		assertNothingImported(t, f)
+	})
+
+	t.Run("failed upload rolls back", func(t *testing.T) {
+		f := newFixture(t)
+		f.store.failFrom = 3
+		_, err := f.importer.Import(ctx, rows, f.images)
+		if err == nil || !strings.Contains(err.Error(), "row 3") {
+			t.Fatalf("error = %v", err)
+		}
+		assertNothingImported(t, f)
+	})
+
+	t.Run("failed insert rolls back", func(t *testing.T) {
+		f := newFixture(t)
+		failing := importer.NewImporter(config.ImportConfig{}, failingMetadataDb{f.repos.Metadata}, f.repos.CertTypes, f.store)
+		_, err := failing.Import(ctx, rows, f.images)
+		if !errors.Is(err, repository.ErrTimeout) {
+			t.Fatalf("error = %v", err)
+		}
+		if f.store.uploads != 3 {
+			t.Fatalf("%d uploads, want 3", f.store.uploads)
+		}
+		assertNothingImported(t, f)
+	})
+}
+
+func assertNothingImported(t *testing.T, f *fixture) {
+	t.Helper()
+	if len(f.store.stored) != 0 {
+		t.Fatalf("images left behind: %v", f.store.stored)
+	}
+	metadatas, _, err := f.repos.Metadata.GetPage(ctx, repository.MetadataFilter{}, repository.PageQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metadatas) != 1 {
+		t.Fatalf("%d metadata, want only the existing one", len(metadatas))
+	}
+}
@@ -0,0 +1,190 @@
+package importer
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Row is a metadata of a manifest, with the rules of handler.MetadataRequest.
+// Image is the path of its image in the zip.
+type Row struct {
+	TypeCode    string      `json:"type_code" validate:"required,max=50"`
+	CertId      json.Number `json:"cert_id" validate:"required,number"`
+	ProjectName string      `json:"project_name" validate:"max=200"`
+	ProjectType string      `json:"project_type" validate:"max=100"`
+	Description string      `json:"description" validate:"max=5000"`
+	Image       string      `json:"image" validate:"required"`
+}
+
+var columns = []string{"type_code", "cert_id", "project_name", "project_type", "description", "image"}
+
+// ReadManifest reads the rows of a manifest, a JSON array or a CSV file with
+// a header row, by the extension of name
+func (i *Importer) ReadManifest(name string, r io.Reader) ([]Row, error) {
+	rows := []Row{}
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json":
+		decoder := json.NewDecoder(r)
+		decoder.DisallowUnknownFields()
+		err := decoder.Decode(&rows)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidFile, name, err)
+		}
+	case ".csv":
+		var err error
+		rows, err = readCSV(r)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidFile, name, err)
+		}
+	default:
+		return nil, fmt.Errorf("%w: manifest %s must be a .json or .csv file", ErrInvalidFile, name)
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("%w: manifest %s has no rows", ErrInvalidFile, name)
+	}
+	if len(rows) > i.maxRows {
+		return nil, fmt.Errorf("%w: manifest %s has %d rows, at most %d can be imported at once", ErrInvalidFile, name, len(rows), i.maxRows)
+	}
+	return rows, nil
+}
+
+func readCSV(r io.Reader) ([]Row, error) {
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("header: %v", err)
+	}
+	index := map[string]int{}
+	for i, v := range header {
+		// excel writes a byte order mark first
+		name := strings.TrimSpace(strings.TrimPrefix(v, "\xEF\xBB\xBF"))
+		if !contains(columns, name) {
+			return nil, fmt.Errorf("unknown column %q, the columns are %s", name, strings.Join(columns, ", "))
+		}
+		index[name] = i
+	}
+	rows := []Row{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		cell := func(column string) string {
+			i, ok := index[column]
+			if !ok || i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+		rows = append(rows, Row{
+			TypeCode:    cell("type_code"),
+			CertId:      json.Number(cell("cert_id")),
+			ProjectName: cell("project_name"),
+			ProjectType: cell("project_type"),
+			Description: cell("description"),
+			Image:       cell("image"),
+		})
+	}
+}
+
+// Images are the images of a zip by their path in it
+type Images struct {
+	files   map[string]*zip.File
+	byBase  map[string][]*zip.File
+	maxSize int64
+}
+
+// OpenImages reads the directory of a zip, the images are read on import
+func (i *Importer) OpenImages(r io.ReaderAt, size int64) (*Images, error) {
+	reader, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("%w: images must be a zip file: %v", ErrInvalidFile, err)
+	}
+	images := &Images{
+		files:   map[string]*zip.File{},
+		byBase:  map[string][]*zip.File{},
+		maxSize: i.maxImageSize,
+	}
+	for _, f := range reader.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		name := path.Clean(f.Name)
+		images.files[name] = f
+		images.byBase[path.Base(name)] = append(images.byBase[path.Base(name)], f)
+	}
+	return images, nil
+}
+
+// find returns the image at name, or the only image with its file name so
+// a zip of a folder matches a manifest without the folder
+func (m *Images) find(name string) (*zip.File, error) {
+	if m == nil {
+		return nil, fmt.Errorf("image %s is not in the zip", name)
+	}
+	name = path.Clean(strings.TrimPrefix(filepath.ToSlash(name), "./"))
+	f, ok := m.files[name]
+	if !ok {
+		same := m.byBase[path.Base(name)]
+		if len(same) > 1 {
+			return nil, fmt.Errorf("image %s is in the zip %d times, give its folder", name, len(same))
+		}
+		if len(same) == 0 {
+			return nil, fmt.Errorf("image %s is not in the zip", name)
+		}
+		f = same[0]
+	}
+	if !strings.HasPrefix(mime.TypeByExtension(path.Ext(f.Name)), "image/") {
+		return nil, fmt.Errorf("image %s is not an image file", name)
+	}
+	if f.UncompressedSize64 > uint64(m.maxSize) {
+		return nil, fmt.Errorf("image %s is larger than %d MB", name, m.maxSize>>20)
+	}
+	return f, nil
+}
+
+// open opens the image f, reading past the size limit fails even when the
+// zip states a smaller size
+func (m *Images) open(f *zip.File) (io.ReadCloser, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	return &limitedReader{ReadCloser: rc, left: m.maxSize}, nil
+}
+
+var errTooLarge = errors.New("image is larger than stated in the zip")
+
+type limitedReader struct {
+	io.ReadCloser
+	left int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	n, err := l.ReadCloser.Read(p)
+	l.left -= int64(n)
+	if l.left < 0 {
+		return n, errTooLarge
+	}
+	return n, err
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,442 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/seenark/super-backend-temp/config"
+	"github.com/seenark/super-backend-temp/repository"
+)
+
+// redeemed fields an event argument can be mapped to
+const (
+	REDEEM_ID_FIELD = "redeem_id"
+	CUSTOMER_FIELD  = "customer"
+	CERT_ID_FIELD   = "cert_id"
+	AMOUNT_FIELD    = "amount"
+	PRICE_FIELD     = "price"
+)
+
+var defaultFields = map[string]string{
+	REDEEM_ID_FIELD: "redeemId",
+	CUSTOMER_FIELD:  "customer",
+	CERT_ID_FIELD:   "futureTokenId",
+	AMOUNT_FIELD:    "amount",
+	PRICE_FIELD:     "price",
+}
+
+// ChainReader is the part of an EVM node the indexer needs. Both
+// *ethclient.Client and the simulated backend of go-ethereum satisfy it.
+type ChainReader interface {
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+	FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error)
+}
+
+// headSubscriber is implemented by clients that can push new blocks, such
+// as ethclient over websocket. Without it the indexer only polls.
+type headSubscriber interface {
+	SubscribeNewHead(ctx context.Context, ch chan<- *types.Header) (ethereum.Subscription, error)
+}
+
+// Indexer reads redeem events of one contract and upserts them as Redeemed.
+// Events are only ingested once they are confirmations blocks deep and the
+// last processed block is checkpointed so a restart continues from there.
+type Indexer struct {
+	name          string
+	client        ChainReader
+	contract      common.Address
+	event         abi.Event
+	fields        map[string]string
+	startBlock    uint64
+	confirmations uint64
+	batchSize     uint64
+	pollInterval  time.Duration
+	redeemedDb    repository.IRedeemedRepository
+	checkpointDb  repository.CheckpointRepository
+}
+
+func NewIndexer(cfg config.IndexerConfig, client ChainReader, redeemedDb repository.IRedeemedRepository, checkpointDb repository.CheckpointRepository) (*Indexer, error) {
+	if !common.IsHexAddress(cfg.ContractAddress) {
+		return nil, fmt.Errorf("contract address %q is invalid", cfg.ContractAddress)
+	}
+	file, err := os.Open(cfg.AbiFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	contractAbi, err := abi.JSON(file)
+	if err != nil {
+		return nil, fmt.Errorf("parse abi %s: %v", cfg.AbiFile, err)
+	}
+	eventName := cfg.EventName
+	if eventName == "" {
+		eventName = "Redeemed"
+	}
+	event, ok := contractAbi.Events[eventName]
+	if !ok {
+		return nil, fmt.Errorf("event %s not found in %s", eventName, cfg.AbiFile)
+	}
+
+	fields := map[string]string{}
+	for k, v := range defaultFields {
+		fields[k] = v
+	}
+	for k, v := range cfg.Fields {
+		if _, ok := defaultFields[k]; !ok {
+			return nil, fmt.Errorf("unknown redeemed field %q", k)
+		}
+		fields[k] = v
+	}
+	// the default mapping may name arguments this event does not have
+	for k, v := range fields {
+		if !hasInput(event, v) {
+			if _, ok := cfg.Fields[k]; ok || k == REDEEM_ID_FIELD {
+				return nil, fmt.Errorf("event %s has no argument %q for %s", eventName, v, k)
+			}
+			delete(fields, k)
+		}
+	}
+
+	contract := common.HexToAddress(cfg.ContractAddress)
+	indexer := &Indexer{
+		name:          "redeemed:" + strings.ToLower(contract.Hex()),
+		client:        client,
+		contract:      contract,
+		event:         event,
+		fields:        fields,
+		startBlock:    cfg.StartBlock,
+		confirmations: cfg.Confirmations,
+		batchSize:     cfg.BatchSize,
+		pollInterval:  time.Duration(cfg.PollInterval) * time.Second,
+		redeemedDb:    redeemedDb,
+		checkpointDb:  checkpointDb,
+	}
+	if indexer.batchSize == 0 {
+		indexer.batchSize = 2000
+	}
+	if indexer.pollInterval <= 0 {
+		indexer.pollInterval = 15 * time.Second
+	}
+	return indexer, nil
+}
+
+func hasInput(event abi.Event, name string) bool {
+	for _, input := range event.Inputs {
+		if input.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Run polls until ctx is done. When the client can push new heads it also
+// polls on every new block.
+func (i *Indexer) Run(ctx context.Context) {
+	ticker := time.NewTicker(i.pollInterval)
+	defer ticker.Stop()
+
+	var heads chan *types.Header
+	if subscriber, ok := i.client.(headSubscriber); ok {
+		ch := make(chan *types.Header, 16)
+		sub, err := subscriber.SubscribeNewHead(ctx, ch)
+		if err != nil {
+			fmt.Printf("indexer: subscribe new head: %v, polling every %v\n", err, i.pollInterval)
+		} else {
+			defer sub.Unsubscribe()
+			heads = ch
+		}
+	}
+
+	for {
+		err := i.Poll(ctx)
+		if err != nil {
+			fmt.Printf("indexer: %v\n", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-heads:
+		}
+	}
+}
+
+// Poll ingests every confirmed block after the checkpoint
+func (i *Indexer) Poll(ctx context.Context) error {
+	head, err := i.client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("get head: %v", err)
+	}
+	if head.Number.Uint64() < i.confirmations {
+		return nil
+	}
+	safe := head.Number.Uint64() - i.confirmations
+
+	from, err := i.resumeBlock(ctx)
+	if err != nil {
+		return err
+	}
+
+	for from <= safe {
+		to := from + i.batchSize - 1
+		if to > safe {
+			to = safe
+		}
+		err := i.ingestRange(ctx, from, to)
+		if err != nil {
+			return err
+		}
+		header, err := i.client.HeaderByNumber(ctx, new(big.Int).SetUint64(to))
+		if err != nil {
+			return fmt.Errorf("get block %d: %v", to, err)
+		}
+		_, err = i.checkpointDb.Save(ctx, repository.Checkpoint{
+			Name:        i.name,
+			BlockNumber: to,
+			BlockHash:   header.Hash().Hex(),
+		})
+		if err != nil {
+			return fmt.Errorf("save checkpoint: %v", err)
+		}
+		from = to + 1
+	}
+	return nil
+}
+
+// Reindex ingests the blocks from to to again, to 0 is the last confirmed
+// block. The checkpoint is left as is, redemptions already ingested with the
+// same chain data are skipped and the ones no longer on the chain are
+// flagged removed. It returns the last block ingested.
+func (i *Indexer) Reindex(ctx context.Context, from uint64, to uint64) (uint64, error) {
+	head, err := i.client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("get head: %v", err)
+	}
+	if head.Number.Uint64() < i.confirmations {
+		return 0, errors.New("no confirmed blocks yet")
+	}
+	safe := head.Number.Uint64() - i.confirmations
+	if to == 0 || to > safe {
+		to = safe
+	}
+	if from > to {
+		return 0, fmt.Errorf("from block %d is after block %d", from, to)
+	}
+	for start := from; start <= to; start += i.batchSize {
+		end := start + i.batchSize - 1
+		if end > to {
+			end = to
+		}
+		err := i.ingestRange(ctx, start, end)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return to, nil
+}
+
+// StartBlock is the block the indexer starts from without a checkpoint
+func (i *Indexer) StartBlock() uint64 {
+	return i.startBlock
+}
+
+// resumeBlock returns the first block still to ingest. When the checkpointed
+// block is no longer on the canonical chain the reorg went deeper than the
+// confirmation depth, so the indexer steps back that far and ingests again.
+func (i *Indexer) resumeBlock(ctx context.Context) (uint64, error) {
+	checkpoint, err := i.checkpointDb.Get(ctx, i.name)
+	if errors.Is(err, repository.ErrNotFound) {
+		return i.startBlock, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("get checkpoint: %v", err)
+	}
+
+	header, err := i.client.HeaderByNumber(ctx, new(big.Int).SetUint64(checkpoint.BlockNumber))
+	if err != nil {
+		return 0, fmt.Errorf("get block %d: %v", checkpoint.BlockNumber, err)
+	}
+	if header.Hash().Hex() == checkpoint.BlockHash {
+		return checkpoint.BlockNumber + 1, nil
+	}
+
+	rewind := i.startBlock
+	if checkpoint.BlockNumber > i.startBlock+i.confirmations {
+		rewind = checkpoint.BlockNumber - i.confirmations
+	}
+	fmt.Printf("indexer: block %d %s was reorged, ingesting again from %d\n", checkpoint.BlockNumber, checkpoint.BlockHash, rewind)
+	return rewind, nil
+}
+
+func (i *Indexer) ingestRange(ctx context.Context, from uint64, to uint64) error {
+	logs, err := i.client.FilterLogs(ctx, ethereum.FilterQuery{
+		FromBlock: new(big.Int).SetUint64(from),
+		ToBlock:   new(big.Int).SetUint64(to),
+		Addresses: []common.Address{i.contract},
+		Topics:    [][]common.Hash{{i.event.ID}},
+	})
+	if err != nil {
+		return fmt.Errorf("filter logs %d-%d: %v", from, to, err)
+	}
+
+	blockTimes := map[uint64]uint64{}
+	onChain := map[logKey]bool{}
+	for _, log := range logs {
+		if log.Removed {
+			// only subscriptions send removed logs, removeMissing finds them
+			continue
+		}
+		redeemed, err := i.decode(log)
+		if err != nil {
+			return fmt.Errorf("decode log %s #%d: %v", log.TxHash.Hex(), log.Index, err)
+		}
+		blockTime, ok := blockTimes[log.BlockNumber]
+		if !ok {
+			header, err := i.client.HeaderByNumber(ctx, new(big.Int).SetUint64(log.BlockNumber))
+			if err != nil {
+				return fmt.Errorf("get block %d: %v", log.BlockNumber, err)
+			}
+			blockTime = header.Time
+			blockTimes[log.BlockNumber] = blockTime
+		}
+		redeemed.RedeemDate = int(blockTime)
+		onChain[logKey{redeemed.TxHash, redeemed.LogIndex}] = true
+
+		// blocks can be ingested twice after a restart or a reorg
+		_, err = i.redeemedDb.Upsert(ctx, *redeemed)
+		if errors.Is(err, repository.ErrNoChange) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("upsert redeemed %s #%d: %v", redeemed.TxHash, redeemed.LogIndex, err)
+		}
+	}
+	return i.removeMissing(ctx, from, to, onChain)
+}
+
+// logKey is the key of a redemption, its tx hash and log index
+type logKey struct {
+	txHash   string
+	logIndex int
+}
+
+// removeMissing flags the redemptions ingested from the blocks from to to
+// whose event is not in onChain any more, a reorg took them off the chain
+func (i *Indexer) removeMissing(ctx context.Context, from uint64, to uint64, onChain map[logKey]bool) error {
+	if to == 0 {
+		return nil
+	}
+	gone := []repository.Redeemed{}
+	err := i.redeemedDb.Iterate(ctx, repository.RedeemedFilter{FromBlock: from, ToBlock: to}, func(redeemed repository.Redeemed) error {
+		if !redeemed.Removed && !onChain[logKey{redeemed.TxHash, redeemed.LogIndex}] {
+			gone = append(gone, redeemed)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("find redeemed of blocks %d-%d: %v", from, to, err)
+	}
+	for _, v := range gone {
+		fmt.Printf("indexer: redeem %d of %s #%d in block %d is no longer on the chain\n", v.RedeemId, v.TxHash, v.LogIndex, v.BlockNumber)
+		_, err := i.redeemedDb.MarkRemoved(ctx, v.TxHash, v.LogIndex)
+		if err != nil && !errors.Is(err, repository.ErrNotFound) {
+			return fmt.Errorf("remove redeemed %s #%d: %v", v.TxHash, v.LogIndex, err)
+		}
+	}
+	return nil
+}
+
+// decode maps the event arguments to a Redeemed using the configured fields
+func (i *Indexer) decode(log types.Log) (*repository.Redeemed, error) {
+	if len(log.Topics) == 0 || log.Topics[0] != i.event.ID {
+		return nil, fmt.Errorf("not a %s event", i.event.Name)
+	}
+	values := map[string]interface{}{}
+	err := i.event.Inputs.NonIndexed().UnpackIntoMap(values, log.Data)
+	if err != nil {
+		return nil, err
+	}
+	indexed := abi.Arguments{}
+	for _, input := range i.event.Inputs {
+		if input.Indexed {
+			indexed = append(indexed, input)
+		}
+	}
+	err = abi.ParseTopicsIntoMap(values, indexed, log.Topics[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	redeemed := &repository.Redeemed{
+		TxHash:      log.TxHash.Hex(),
+		LogIndex:    int(log.Index),
+		BlockNumber: log.BlockNumber,
+	}
+	for field, arg := range i.fields {
+		value := values[arg]
+		switch field {
+		case CUSTOMER_FIELD:
+			address, ok := value.(common.Address)
+			if !ok {
+				return nil, fmt.Errorf("argument %s is not an address", arg)
+			}
+			redeemed.WalletAddress = address.Hex()
+		case PRICE_FIELD:
+			number, err := toBigInt(value)
+			if err != nil {
+				return nil, fmt.Errorf("argument %s: %v", arg, err)
+			}
+			redeemed.Price = number.String()
+		default:
+			number, err := toBigInt(value)
+			if err != nil {
+				return nil, fmt.Errorf("argument %s: %v", arg, err)
+			}
+			if !number.IsInt64() {
+				return nil, fmt.Errorf("argument %s overflows int", arg)
+			}
+			switch field {
+			case REDEEM_ID_FIELD:
+				redeemed.RedeemId = int(number.Int64())
+			case CERT_ID_FIELD:
+				redeemed.CertId = int(number.Int64())
+			case AMOUNT_FIELD:
+				redeemed.Amount = int(number.Int64())
+			}
+		}
+	}
+	return redeemed, nil
+}
+
+func toBigInt(value interface{}) (*big.Int, error) {
+	switch v := value.(type) {
+	case *big.Int:
+		return v, nil
+	case uint8:
+		return new(big.Int).SetUint64(uint64(v)), nil
+	case uint16:
+		return new(big.Int).SetUint64(uint64(v)), nil
+	case uint32:
+		return new(big.Int).SetUint64(uint64(v)), nil
+	case uint64:
+		return new(big.Int).SetUint64(v), nil
+	case int8:
+		return big.NewInt(int64(v)), nil
+	case int16:
+		return big.NewInt(int64(v)), nil
+	case int32:
+		return big.NewInt(int64(v)), nil
+	case int64:
+		return big.NewInt(v), nil
+	}
+	return nil, fmt.Errorf("%T is not a number", value)
+}
@@ -0,0 +1,397 @@
+package indexer_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/seenark/super-backend-temp/config"
+	"github.com/seenark/super-backend-temp/indexer"
+	"github.com/seenark/super-backend-temp/repository"
+	"github.com/seenark/super-backend-temp/repository/repotest"
+)
+
+const ABI_FILE = "../config/redeem.abi.json"
+
+var ctx = context.Background()
+
+// emitterCode deploys a contract that emits LOG3 with the first three words
+// of the call data as topics and the rest as data, so a test sends the
+// events it wants without a compiler
+var emitterCode = common.FromHex(
+	// init: return the runtime code
+	"601a80600b6000396000f3" +
+		// runtime: copy calldata[96:] to memory, LOG3 with calldata[0:96]
+		"606036036060600037" + "604035" + "602035" + "600035" + "60603603" + "6000" + "a3" + "00")
+
+// chain is a simulated chain with the emitter deployed
+type chain struct {
+	t        *testing.T
+	backend  *backends.SimulatedBackend
+	signer   types.Signer
+	keys     []*ecdsa.PrivateKey
+	contract common.Address
+	event    abi.Event
+}
+
+func newChain(t *testing.T) *chain {
+	t.Helper()
+	alloc := core.GenesisAlloc{}
+	keys := []*ecdsa.PrivateKey{}
+	for i := 0; i < 2; i++ {
+		key, err := crypto.GenerateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key)
+		alloc[crypto.PubkeyToAddress(key.PublicKey)] = core.GenesisAccount{Balance: big.NewInt(1e18)}
+	}
+	backend := backends.NewSimulatedBackend(alloc, 8000000)
+	t.Cleanup(func() { backend.Close() })
+
+	file, err := os.Open(ABI_FILE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	contractAbi, err := abi.JSON(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &chain{
+		t:       t,
+		backend: backend,
+		signer:  types.LatestSigner(backend.Blockchain().Config()),
+		keys:    keys,
+		event:   contractAbi.Events["Redeemed"],
+	}
+	c.contract = crypto.CreateAddress(crypto.PubkeyToAddress(keys[0].PublicKey), 0)
+	c.send(0, nil, emitterCode)
+	c.backend.Commit()
+	return c
+}
+
+// send signs a transaction of the sender keys[sender], to nil deploys data
+func (c *chain) send(sender int, to *common.Address, data []byte) common.Hash {
+	c.t.Helper()
+	from := crypto.PubkeyToAddress(c.keys[sender].PublicKey)
+	nonce, err := c.backend.PendingNonceAt(ctx, from)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	gasPrice, err := c.backend.SuggestGasPrice(ctx)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	tx, err := types.SignNewTx(c.keys[sender], c.signer, &types.LegacyTx{
+		Nonce:    nonce,
+		To:       to,
+		Gas:      200000,
+		GasPrice: gasPrice,
+		Data:     data,
+	})
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	err = c.backend.SendTransaction(ctx, tx)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return tx.Hash()
+}
+
+// redeem emits a Redeemed event in the pending block
+func (c *chain) redeem(sender int, redeemId int64, customer common.Address) common.Hash {
+	c.t.Helper()
+	data, err := c.event.Inputs.NonIndexed().Pack(big.NewInt(redeemId+100), big.NewInt(2), big.NewInt(15))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	topics := append(c.event.ID.Bytes(), common.BigToHash(big.NewInt(redeemId)).Bytes()...)
+	topics = append(topics, common.BytesToHash(customer.Bytes()).Bytes()...)
+	return c.send(sender, &c.contract, append(topics, data...))
+}
+
+func (c *chain) mine(blocks int) {
+	for i := 0; i < blocks; i++ {
+		c.backend.Commit()
+	}
+}
+
+func (c *chain) hash(number uint64) common.Hash {
+	c.t.Helper()
+	header, err := c.backend.HeaderByNumber(ctx, new(big.Int).SetUint64(number))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return header.Hash()
+}
+
+// countingReader records the block ranges of the logs asked for
+type countingReader struct {
+	indexer.ChainReader
+	from []uint64
+}
+
+func (r *countingReader) FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error) {
+	r.from = append(r.from, q.FromBlock.Uint64())
+	return r.ChainReader.FilterLogs(ctx, q)
+}
+
+func newIndexer(t *testing.T, client indexer.ChainReader, c *chain, repos repository.Repositories) *indexer.Indexer {
+	t.Helper()
+	cfg := config.IndexerConfig{
+		ContractAddress: c.contract.Hex(),
+		AbiFile:         ABI_FILE,
+		Confirmations:   1,
+		BatchSize:       3,
+		PollInterval:    1,
+	}
+	idx, err := indexer.NewIndexer(cfg, client, repos.Redeemed, repos.Checkpoints)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return idx
+}
+
+// stored returns every redemption by redeem id
+func stored(t *testing.T, repos repository.Repositories) map[int]repository.Redeemed {
+	t.Helper()
+	found := map[int]repository.Redeemed{}
+	err := repos.Redeemed.Iterate(ctx, repository.RedeemedFilter{}, func(r repository.Redeemed) error {
+		found[r.RedeemId] = r
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return found
+}
+
+func outboxTypes(t *testing.T, repos repository.Repositories) []string {
+	t.Helper()
+	types := []string{}
+	for {
+		event, err := repos.Outbox.Claim(ctx, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if event == nil {
+			sort.Strings(types)
+			return types
+		}
+		types = append(types, event.Type)
+	}
+}
+
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestIngest(t *testing.T) {
+	c := newChain(t)
+	repos := repotest.Memory().New(t)
+	customer := common.HexToAddress("0x00000000000000000000000000000000000000c1")
+
+	a := c.redeem(0, 1, customer)
+	c.mine(1)
+	// two events in one block
+	b := c.redeem(0, 2, customer)
+	d := c.redeem(1, 3, customer)
+	c.mine(1)
+	// a customer submits before the event is ingested
+	_, err := repos.Redeemed.Upsert(ctx, repository.Redeemed{TxHash: d.Hex(), LogIndex: repository.UNKNOWN_LOG_INDEX, Name: "Somchai", Email: "s@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// not confirmed yet
+	c.redeem(0, 4, customer)
+	c.mine(1)
+
+	idx := newIndexer(t, c.backend, c, repos)
+	err = idx.Poll(ctx)
+	if err != nil {
+		t.Fatalf("Poll: %v", err)
+	}
+	found := stored(t, repos)
+	if len(found) != 3 {
+		t.Fatalf("stored %+v, want redeem 1 to 3", found)
+	}
+	header, err := c.backend.HeaderByNumber(ctx, big.NewInt(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[int]struct {
+		txHash   common.Hash
+		logIndex int
+		block    uint64
+	}{
+		1: {a, 0, 2},
+		2: {b, 0, 3},
+		3: {d, 1, 3},
+	}
+	for id, w := range want {
+		r := found[id]
+		if r.TxHash != w.txHash.Hex() || r.LogIndex != w.logIndex || r.BlockNumber != w.block {
+			t.Fatalf("redeem %d = %+v, want %s #%d in block %d", id, r, w.txHash.Hex(), w.logIndex, w.block)
+		}
+		if r.WalletAddress != customer.Hex() || r.CertId != id+100 || r.Amount != 2 || r.Price != "15" {
+			t.Fatalf("redeem %d = %+v", id, r)
+		}
+	}
+	if found[3].RedeemDate != int(header.Time) || found[3].Email != "s@example.com" {
+		t.Fatalf("redeem 3 = %+v", found[3])
+	}
+
+	checkpoint, err := repos.Checkpoints.Get(ctx, "redeemed:"+strings.ToLower(c.contract.Hex()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checkpoint.BlockNumber != 3 || checkpoint.BlockHash != c.hash(3).Hex() {
+		t.Fatalf("checkpoint = %+v", checkpoint)
+	}
+
+	// reindexing changes nothing
+	last, err := idx.Reindex(ctx, 0, 0)
+	if err != nil || last != 3 {
+		t.Fatalf("Reindex = %d, %v", last, err)
+	}
+	wantEvents := []string{
+		repository.REDEEMED_CREATED_EVENT, repository.REDEEMED_CREATED_EVENT, repository.REDEEMED_CREATED_EVENT,
+		repository.REDEEMED_DETAILS_SUBMITTED_EVENT, repository.REDEEMED_RECORDED_EVENT,
+	}
+	if events := outboxTypes(t, repos); !equalStrings(events, wantEvents) {
+		t.Fatalf("outbox = %v, want %v", events, wantEvents)
+	}
+}
+
+func TestRestartFromCheckpoint(t *testing.T) {
+	c := newChain(t)
+	repos := repotest.Memory().New(t)
+	customer := common.HexToAddress("0x00000000000000000000000000000000000000c1")
+	c.redeem(0, 1, customer)
+	c.mine(2)
+
+	err := newIndexer(t, c.backend, c, repos).Poll(ctx)
+	if err != nil {
+		t.Fatalf("Poll: %v", err)
+	}
+	c.redeem(0, 2, customer)
+	c.mine(2)
+
+	// a new indexer, as after a restart
+	reader := &countingReader{ChainReader: c.backend}
+	err = newIndexer(t, reader, c, repos).Poll(ctx)
+	if err != nil {
+		t.Fatalf("Poll after restart: %v", err)
+	}
+	if len(reader.from) == 0 || reader.from[0] != 3 {
+		t.Fatalf("logs read from blocks %v, want from 3 on", reader.from)
+	}
+	if found := stored(t, repos); len(found) != 2 {
+		t.Fatalf("stored %+v", found)
+	}
+	wantEvents := []string{repository.REDEEMED_CREATED_EVENT, repository.REDEEMED_CREATED_EVENT}
+	if events := outboxTypes(t, repos); !equalStrings(events, wantEvents) {
+		t.Fatalf("outbox = %v, want %v", events, wantEvents)
+	}
+}
+
+func TestReorg(t *testing.T) {
+	c := newChain(t)
+	repos := repotest.Memory().New(t)
+	customer := common.HexToAddress("0x00000000000000000000000000000000000000c1")
+
+	c.redeem(0, 1, customer)
+	c.mine(1)
+	forkPoint := c.hash(2)
+	// b is the second log of block 3
+	d := c.redeem(0, 4, customer)
+	b := c.redeem(1, 2, customer)
+	c.mine(3)
+	rawB, _, err := c.backend.TransactionByHash(ctx, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idx := newIndexer(t, c.backend, c, repos)
+	err = idx.Poll(ctx)
+	if err != nil {
+		t.Fatalf("Poll: %v", err)
+	}
+	_, err = repos.Redeemed.Upsert(ctx, repository.Redeemed{TxHash: b.Hex(), LogIndex: repository.UNKNOWN_LOG_INDEX, Name: "Somchai", Email: "s@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found := stored(t, repos); len(found) != 3 || found[2].LogIndex != 1 || found[4].Removed {
+		t.Fatalf("before the reorg %+v", found)
+	}
+
+	// a longer side chain from block 2: a redemption of the same nonce
+	// replaces d and b lands alone in block 4
+	err = c.backend.Fork(ctx, forkPoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.redeem(0, 3, customer)
+	c.mine(1)
+	err = c.backend.SendTransaction(ctx, rawB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.mine(3)
+	head, err := c.backend.HeaderByNumber(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head.Number.Uint64() != 6 || c.hash(2) != forkPoint {
+		t.Fatalf("the side chain is not canonical, head %d", head.Number)
+	}
+
+	err = idx.Poll(ctx)
+	if err != nil {
+		t.Fatalf("Poll after the reorg: %v", err)
+	}
+	found := stored(t, repos)
+	if len(found) != 4 {
+		t.Fatalf("stored %+v", found)
+	}
+	if !found[4].Removed || found[4].TxHash != d.Hex() {
+		t.Fatalf("redeem 4 = %+v, want it removed", found[4])
+	}
+	if found[1].Removed || found[3].Removed || found[3].BlockNumber != 3 {
+		t.Fatalf("redeem 1 = %+v, 3 = %+v", found[1], found[3])
+	}
+	// the same redemption in another block keeps its details
+	if found[2].Removed || found[2].BlockNumber != 4 || found[2].LogIndex != 0 || found[2].Email != "s@example.com" {
+		t.Fatalf("redeem 2 = %+v", found[2])
+	}
+	wantEvents := []string{
+		repository.REDEEMED_CREATED_EVENT, repository.REDEEMED_CREATED_EVENT, repository.REDEEMED_CREATED_EVENT, repository.REDEEMED_CREATED_EVENT,
+		repository.REDEEMED_DETAILS_SUBMITTED_EVENT,
+		repository.REDEEMED_REMOVED_EVENT,
+	}
+	if events := outboxTypes(t, repos); !equalStrings(events, wantEvents) {
+		t.Fatalf("outbox = %v, want %v", events, wantEvents)
+	}
+}
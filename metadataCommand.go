@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/seenark/super-backend-temp/cloudstorage"
+	"github.com/seenark/super-backend-temp/config"
+	"github.com/seenark/super-backend-temp/importer"
+)
+
+const metadataUsage = `usage: metadata import -manifest rows.csv|rows.json -images images.zip [-dry-run] [flags]
+
+the manifest has type_code, cert_id, project_name, project_type, description
+and image, the path of the image in the zip. Every row is checked first, and
+nothing is imported when one is invalid or an upload fails.`
+
+func metadataCommand(cfg config.Configuration, args []string) error {
+	name, args, err := subcommand(args, metadataUsage)
+	if err != nil {
+		return err
+	}
+	if name != "import" {
+		return fmt.Errorf("unknown metadata command %q\n%s", name, metadataUsage)
+	}
+	flags := flag.NewFlagSet("metadata import", flag.ExitOnError)
+	storageFlags(flags, &cfg)
+	manifestPath := flags.String("manifest", "", "CSV or JSON file of the metadata")
+	imagesPath := flags.String("images", "", "zip of the images")
+	dryRun := flags.Bool("dry-run", false, "only check the rows")
+	flags.Parse(args)
+
+	if *manifestPath == "" || *imagesPath == "" {
+		return errors.New("-manifest and -images are required")
+	}
+	memoryCloudStorage(&cfg)
+	uploader, err := cloudstorage.NewStorage(cfg)
+	if err != nil {
+		return fmt.Errorf("create cloud storage: %w", err)
+	}
+	repos := newRepositories(cfg)
+	metadataImporter := importer.NewImporter(cfg.Import, repos.Metadata, repos.CertTypes, uploader)
+
+	manifest, err := os.Open(*manifestPath)
+	if err != nil {
+		return err
+	}
+	defer manifest.Close()
+	rows, err := metadataImporter.ReadManifest(*manifestPath, manifest)
+	if err != nil {
+		return err
+	}
+	zipFile, err := os.Open(*imagesPath)
+	if err != nil {
+		return err
+	}
+	defer zipFile.Close()
+	info, err := zipFile.Stat()
+	if err != nil {
+		return err
+	}
+	images, err := metadataImporter.OpenImages(zipFile, info.Size())
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	var report *importer.Report
+	if *dryRun {
+		report, err = metadataImporter.Check(ctx, rows, images)
+	} else {
+		report, err = metadataImporter.Import(ctx, rows, images)
+	}
+	if report != nil {
+		printImportReport(report)
+	}
+	if err != nil {
+		return err
+	}
+	if report.Invalid > 0 {
+		return fmt.Errorf("%d of %d rows are invalid", report.Invalid, len(report.Rows))
+	}
+	return nil
+}
+
+func printImportReport(report *importer.Report) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ROW\tCERT ID\tTYPE\tIMAGE\tRESULT")
+	for _, v := range report.Rows {
+		result := "ok"
+		if len(v.Errors) > 0 {
+			result = strings.Join(v.Errors, "; ")
+		} else if v.ImageName != "" {
+			result = "imported as " + v.ImageName
+		}
+		fmt.Fprintf(w, "%d\t%d\t%s\t%s\t%s\n", v.Row, v.CertId, v.TypeCode, v.Image, result)
+	}
+	w.Flush()
+	if report.DryRun {
+		fmt.Printf("dry run, %d rows are valid and %d invalid\n", len(report.Rows)-report.Invalid, report.Invalid)
+		return
+	}
+	fmt.Printf("%d imported\n", report.Imported)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/seenark/super-backend-temp/config"
+	"github.com/seenark/super-backend-temp/migration"
+)
+
+const migrateUsage = `usage: migrate <command>
+
+commands:
+  up [-to version]   apply pending migrations, up to version when given
+  down [-steps n]    revert the last n applied migrations, 1 by default
+  status             list migrations and when they were applied`
+
+// migrateCommand runs the schema migrations of the mongo databases, they are
+// not run when the api starts
+func migrateCommand(cfg config.Configuration, args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+	if args[0] != "up" && args[0] != "down" && args[0] != "status" {
+		return fmt.Errorf("unknown migrate command %q\n%s", args[0], migrateUsage)
+	}
+	client := connectMongo(cfg.Mongo)
+	defer client.Disconnect(context.Background())
+	dbs := migration.Databases{
+		Accounts:     client.Database(ACCOUNTS_DATABASE),
+		Certificates: client.Database(CERTIFICATES_DATABASE),
+	}
+	migrator, err := migration.NewMigrator(dbs, migration.All)
+	if err != nil {
+		return err
+	}
+	// backfills may take a while on a big collection
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+
+	switch args[0] {
+	case "up":
+		flags := flag.NewFlagSet("migrate up", flag.ExitOnError)
+		to := flags.Int("to", 0, "last version to apply, 0 is the latest")
+		flags.Parse(args[1:])
+		done, err := migrator.Up(ctx, *to)
+		for _, v := range done {
+			fmt.Printf("applied %d %s\n", v.Version, v.Description)
+		}
+		if err == nil && len(done) == 0 {
+			fmt.Println("no pending migrations")
+		}
+		return err
+	case "down":
+		flags := flag.NewFlagSet("migrate down", flag.ExitOnError)
+		steps := flags.Int("steps", 1, "number of migrations to revert")
+		flags.Parse(args[1:])
+		if *steps < 1 {
+			return errors.New("steps must be at least 1")
+		}
+		done, err := migrator.Down(ctx, *steps)
+		for _, v := range done {
+			fmt.Printf("reverted %d %s\n", v.Version, v.Description)
+		}
+		return err
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tAPPLIED\tDESCRIPTION")
+		for _, v := range statuses {
+			applied := "pending"
+			if v.AppliedAt != nil {
+				applied = v.AppliedAt.Local().Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", v.Version, applied, v.Description)
+		}
+		return w.Flush()
+	}
+	return nil
+}
+
+// warnPendingMigrations tells that the databases are behind the code, the
+// unique indexes the repositories rely on may be missing
+func warnPendingMigrations(dbs migration.Databases) {
+	migrator, err := migration.NewMigrator(dbs, migration.All)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	pending, err := migrator.Pending(ctx)
+	if err != nil {
+		log.Printf("check migrations: %v\n", err)
+		return
+	}
+	if len(pending) > 0 {
+		log.Printf("WARNING: %d migrations are pending from version %d, run `migrate up`\n", len(pending), pending[0].Version)
+	}
+}
@@ -0,0 +1,63 @@
+package migration
+
+import (
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// error codes of the mongo server
+const (
+	namespaceNotFoundCode     = 26
+	indexNotFoundCode         = 27
+	indexOptionsConflictCode  = 85
+	indexKeySpecsConflictCode = 86
+)
+
+// createIndexes creates the indexes of col, every model must be named. An
+// index that exists with the same spec is left as is, one with the same name
+// and another spec is dropped and created again.
+func createIndexes(ctx context.Context, col *mongo.Collection, models ...mongo.IndexModel) error {
+	for _, model := range models {
+		if model.Options == nil || model.Options.Name == nil {
+			return errors.New("index of " + col.Name() + " has no name")
+		}
+		_, err := col.Indexes().CreateOne(ctx, model)
+		if hasCode(err, indexOptionsConflictCode, indexKeySpecsConflictCode) {
+			_, err = col.Indexes().DropOne(ctx, *model.Options.Name)
+			if err != nil {
+				return err
+			}
+			_, err = col.Indexes().CreateOne(ctx, model)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dropIndexes drops the named indexes of col, missing ones are skipped
+func dropIndexes(ctx context.Context, col *mongo.Collection, names ...string) error {
+	for _, name := range names {
+		_, err := col.Indexes().DropOne(ctx, name)
+		if err != nil && !hasCode(err, namespaceNotFoundCode, indexNotFoundCode) {
+			return err
+		}
+	}
+	return nil
+}
+
+func hasCode(err error, codes ...int32) bool {
+	cmdErr := mongo.CommandError{}
+	if !errors.As(err, &cmdErr) {
+		return false
+	}
+	for _, code := range codes {
+		if cmdErr.Code == code {
+			return true
+		}
+	}
+	return false
+}
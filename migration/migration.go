@@ -0,0 +1,182 @@
+package migration
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// COLLECTION_NAME records the applied migrations, in the accounts database
+const COLLECTION_NAME = "schema_migrations"
+
+// Databases are the databases migrations change
+type Databases struct {
+	Accounts     *mongo.Database // super_energy
+	Certificates *mongo.Database // digital_certificate
+}
+
+// Migration is one versioned change of the databases. Up and Down must be
+// safe to run again, a migration that failed halfway is run from the start
+// on the next up.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(ctx context.Context, dbs Databases) error
+	Down        func(ctx context.Context, dbs Databases) error
+}
+
+// Record is the document of an applied migration
+type Record struct {
+	Version     int       `bson:"_id" json:"version"`
+	Description string    `bson:"description" json:"description"`
+	AppliedAt   time.Time `bson:"applied_at" json:"applied_at"`
+}
+
+// Status is a migration and whether it is applied
+type Status struct {
+	Version     int        `json:"version"`
+	Description string     `json:"description"`
+	AppliedAt   *time.Time `json:"applied_at"` // nil when pending
+}
+
+// Migrator runs migrations in version order. Two migrators running up at
+// the same time may both run a migration, which is harmless as migrations
+// are idempotent.
+type Migrator struct {
+	dbs        Databases
+	col        *mongo.Collection
+	migrations []Migration
+}
+
+// NewMigrator checks that the versions of migrations are positive and
+// unique, they are run in version order whatever the order of the slice
+func NewMigrator(dbs Databases, migrations []Migration) (*Migrator, error) {
+	sorted := append([]Migration{}, migrations...)
+	sort.Slice(sorted, func(i int, j int) bool {
+		return sorted[i].Version < sorted[j].Version
+	})
+	for i, v := range sorted {
+		if v.Version <= 0 {
+			return nil, fmt.Errorf("migration %q: version must be positive", v.Description)
+		}
+		if i > 0 && sorted[i-1].Version == v.Version {
+			return nil, fmt.Errorf("migration version %d is used twice", v.Version)
+		}
+		if v.Up == nil || v.Down == nil {
+			return nil, fmt.Errorf("migration %d: up and down are required", v.Version)
+		}
+	}
+	return &Migrator{
+		dbs:        dbs,
+		col:        dbs.Accounts.Collection(COLLECTION_NAME),
+		migrations: sorted,
+	}, nil
+}
+
+// applied returns the records by version
+func (m *Migrator) applied(ctx context.Context) (map[int]Record, error) {
+	cur, err := m.col.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	records := []Record{}
+	err = cur.All(ctx, &records)
+	if err != nil {
+		return nil, err
+	}
+	applied := map[int]Record{}
+	for _, v := range records {
+		applied[v.Version] = v
+	}
+	return applied, nil
+}
+
+// Status lists every migration, applied ones with the time they were
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	statuses := []Status{}
+	for _, v := range m.migrations {
+		status := Status{Version: v.Version, Description: v.Description}
+		if record, ok := applied[v.Version]; ok {
+			appliedAt := record.AppliedAt
+			status.AppliedAt = &appliedAt
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// Pending returns the migrations that are not applied yet
+func (m *Migrator) Pending(ctx context.Context) ([]Migration, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pending := []Migration{}
+	for _, v := range m.migrations {
+		if _, ok := applied[v.Version]; !ok {
+			pending = append(pending, v)
+		}
+	}
+	return pending, nil
+}
+
+// Up applies the pending migrations up to and including version target, 0
+// is the latest. It stops at the first error, what was applied stays.
+func (m *Migrator) Up(ctx context.Context, target int) ([]Migration, error) {
+	pending, err := m.Pending(ctx)
+	if err != nil {
+		return nil, err
+	}
+	done := []Migration{}
+	for _, v := range pending {
+		if target > 0 && v.Version > target {
+			break
+		}
+		err := v.Up(ctx, m.dbs)
+		if err != nil {
+			return done, fmt.Errorf("migration %d up: %w", v.Version, err)
+		}
+		record := Record{Version: v.Version, Description: v.Description, AppliedAt: time.Now()}
+		opts := options.Replace().SetUpsert(true)
+		_, err = m.col.ReplaceOne(ctx, bson.M{"_id": v.Version}, record, opts)
+		if err != nil {
+			return done, fmt.Errorf("record migration %d: %w", v.Version, err)
+		}
+		done = append(done, v)
+	}
+	return done, nil
+}
+
+// Down reverts the last steps applied migrations, newest first
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	done := []Migration{}
+	for i := len(m.migrations) - 1; i >= 0 && len(done) < steps; i-- {
+		v := m.migrations[i]
+		if _, ok := applied[v.Version]; !ok {
+			continue
+		}
+		err := v.Down(ctx, m.dbs)
+		if err != nil {
+			return done, fmt.Errorf("migration %d down: %w", v.Version, err)
+		}
+		_, err = m.col.DeleteOne(ctx, bson.M{"_id": v.Version})
+		if err != nil {
+			return done, fmt.Errorf("unrecord migration %d: %w", v.Version, err)
+		}
+		done = append(done, v)
+	}
+	return done, nil
+}
@@ -0,0 +1,147 @@
+package migration_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/seenark/super-backend-temp/migration"
+	"github.com/seenark/super-backend-temp/repository"
+	"github.com/seenark/super-backend-temp/repository/repotest"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(repotest.Run(m))
+}
+
+var ctx = context.Background()
+
+func noop(ctx context.Context, dbs migration.Databases) error {
+	return nil
+}
+
+func TestNewMigrator(t *testing.T) {
+	tests := []struct {
+		name       string
+		migrations []migration.Migration
+		ok         bool
+	}{
+		{"every migration of the app", migration.All, true},
+		{"zero version", []migration.Migration{{Version: 0, Up: noop, Down: noop}}, false},
+		{"version used twice", []migration.Migration{{Version: 1, Up: noop, Down: noop}, {Version: 1, Up: noop, Down: noop}}, false},
+		{"no down", []migration.Migration{{Version: 1, Up: noop}}, false},
+	}
+	// not connected, the migrator only needs to name its collection
+	client, err := mongo.NewClient(options.Client().ApplyURI("mongodb://localhost"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbs := migration.Databases{Accounts: client.Database("accounts"), Certificates: client.Database("certificates")}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := migration.NewMigrator(dbs, tt.migrations)
+			if (err == nil) != tt.ok {
+				t.Fatalf("err = %v", err)
+			}
+		})
+	}
+}
+
+func TestMigrator(t *testing.T) {
+	dbs := repotest.MongoDatabases(t)
+	failing := errors.New("failing")
+	ran := []int{}
+	step := func(version int, err error) func(context.Context, migration.Databases) error {
+		return func(ctx context.Context, dbs migration.Databases) error {
+			ran = append(ran, version)
+			return err
+		}
+	}
+	// given out of order, run in version order
+	migrator, err := migration.NewMigrator(dbs, []migration.Migration{
+		{Version: 3, Description: "three", Up: step(3, failing), Down: step(-3, nil)},
+		{Version: 1, Description: "one", Up: step(1, nil), Down: step(-1, nil)},
+		{Version: 2, Description: "two", Up: step(2, nil), Down: step(-2, nil)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done, err := migrator.Up(ctx, 1)
+	if err != nil || len(done) != 1 {
+		t.Fatalf("up to 1 = %d, %v", len(done), err)
+	}
+	done, err = migrator.Up(ctx, 0)
+	if !errors.Is(err, failing) || len(done) != 1 {
+		t.Fatalf("up = %d, %v", len(done), err)
+	}
+	statuses, err := migrator.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statuses[0].AppliedAt == nil || statuses[1].AppliedAt == nil || statuses[2].AppliedAt != nil {
+		t.Fatalf("statuses = %+v", statuses)
+	}
+	done, err = migrator.Down(ctx, 5)
+	if err != nil || len(done) != 2 {
+		t.Fatalf("down = %d, %v", len(done), err)
+	}
+	want := []int{1, 2, 3, -2, -1}
+	if len(ran) != len(want) {
+		t.Fatalf("ran %v, want %v", ran, want)
+	}
+	for i := range want {
+		if ran[i] != want[i] {
+			t.Fatalf("ran %v, want %v", ran, want)
+		}
+	}
+}
+
+func TestMigrationsUpAndDown(t *testing.T) {
+	dbs := repotest.MongoDatabases(t)
+	redeemeds := dbs.Certificates.Collection(repository.REDEEM_COLLECTION_NAME)
+	_, err := redeemeds.InsertMany(ctx, []interface{}{
+		bson.M{"tx_hash": "0x01", "price": "1000000000000000000"},
+		bson.M{"tx_hash": "0x02", "price": "not a number"},
+		bson.M{"tx_hash": "0x03", "price": ""},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	migrator, err := migration.NewMigrator(dbs, migration.All)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// up twice is the same as once, down and up again works too
+	for i := 0; i < 2; i++ {
+		_, err = migrator.Up(ctx, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pending, err := migrator.Pending(ctx)
+		if err != nil || len(pending) != 0 {
+			t.Fatalf("pending = %v, %v", pending, err)
+		}
+		count, err := redeemeds.CountDocuments(ctx, bson.M{"price_decimal": bson.M{"$exists": true}})
+		if err != nil || count != 1 {
+			t.Fatalf("%d backfilled, %v", count, err)
+		}
+		_, err = redeemeds.InsertOne(ctx, bson.M{"tx_hash": "0x01"})
+		if !mongo.IsDuplicateKeyError(err) {
+			t.Fatalf("insert a taken tx hash: %v", err)
+		}
+		_, err = migrator.Down(ctx, len(migration.All))
+		if err != nil {
+			t.Fatal(err)
+		}
+		count, err = redeemeds.CountDocuments(ctx, bson.M{"price_decimal": bson.M{"$exists": true}})
+		if err != nil || count != 0 {
+			t.Fatalf("%d still have price_decimal, %v", count, err)
+		}
+	}
+}
@@ -0,0 +1,308 @@
+package migration
+
+import (
+	"context"
+	"log"
+
+	"github.com/seenark/super-backend-temp/repository"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// All is every migration of the app. Never change one that was released,
+// add a new version instead. The first ones create the indexes that were
+// created on startup before, existing databases apply them as no-ops.
+var All = []Migration{
+	{
+		Version:     1,
+		Description: "unique non-empty email of users",
+		Up: func(ctx context.Context, dbs Databases) error {
+			// wallet users have no email so only non-empty emails must be
+			// unique, an old index without the partial filter is replaced
+			return createIndexes(ctx, dbs.Accounts.Collection(repository.USER_COLLECTION_NAME), mongo.IndexModel{
+				Keys: bson.D{{Key: "email", Value: 1}},
+				Options: options.Index().
+					SetName("email_1").
+					SetUnique(true).
+					SetPartialFilterExpression(bson.M{"email": bson.M{"$gt": ""}}),
+			})
+		},
+		Down: func(ctx context.Context, dbs Databases) error {
+			return dropIndexes(ctx, dbs.Accounts.Collection(repository.USER_COLLECTION_NAME), "email_1")
+		},
+	},
+	{
+		Version:     2,
+		Description: "unique type_code of digital cert types",
+		Up: func(ctx context.Context, dbs Databases) error {
+			return createIndexes(ctx, dbs.Certificates.Collection(repository.DIGITAL_CERT_TYPE_COLLECTION_NAME), mongo.IndexModel{
+				Keys:    bson.D{{Key: "type_code", Value: 1}},
+				Options: options.Index().SetName("type_code_1").SetUnique(true),
+			})
+		},
+		Down: func(ctx context.Context, dbs Databases) error {
+			return dropIndexes(ctx, dbs.Certificates.Collection(repository.DIGITAL_CERT_TYPE_COLLECTION_NAME), "type_code_1")
+		},
+	},
+	{
+		Version:     3,
+		Description: "unique digital_cert_id of metadata",
+		Up: func(ctx context.Context, dbs Databases) error {
+			return createIndexes(ctx, dbs.Certificates.Collection(repository.METADATA_COLLECTION_NAME), mongo.IndexModel{
+				Keys:    bson.D{{Key: "digital_cert_id", Value: 1}},
+				Options: options.Index().SetName("digital_cert_id_1").SetUnique(true),
+			})
+		},
+		Down: func(ctx context.Context, dbs Databases) error {
+			return dropIndexes(ctx, dbs.Certificates.Collection(repository.METADATA_COLLECTION_NAME), "digital_cert_id_1")
+		},
+	},
+	{
+		Version:     4,
+		Description: "unique tx_hash and log_index and verification_code of redeemeds",
+		Up: func(ctx context.Context, dbs Databases) error {
+			col := dbs.Certificates.Collection(repository.REDEEM_COLLECTION_NAME)
+			// the log index of what is stored is not known, the indexer
+			// sets it when it ingests the event again
+			_, err := col.UpdateMany(ctx, bson.M{"log_index": bson.M{"$exists": false}}, bson.M{"$set": bson.M{"log_index": repository.UNKNOWN_LOG_INDEX}})
+			if err != nil {
+				return err
+			}
+			// a transaction may have more than one redemption, the index
+			// made before migrations refuses them
+			err = dropIndexes(ctx, col, "tx_hash_1")
+			if err != nil {
+				return err
+			}
+			return createIndexes(ctx, col,
+				mongo.IndexModel{
+					Keys:    bson.D{{Key: "tx_hash", Value: 1}, {Key: "log_index", Value: 1}},
+					Options: options.Index().SetName("tx_hash_1_log_index_1").SetUnique(true),
+				},
+				mongo.IndexModel{
+					// the indexer looks up the redemptions of a block range
+					Keys:    bson.D{{Key: "block_number", Value: 1}},
+					Options: options.Index().SetName("block_number_1"),
+				},
+				mongo.IndexModel{
+					// only delivered redemptions have a code
+					Keys: bson.D{{Key: "verification_code", Value: 1}},
+					Options: options.Index().
+						SetName("verification_code_1").
+						SetUnique(true).
+						SetPartialFilterExpression(bson.M{"verification_code": bson.M{"$gt": ""}}),
+				},
+			)
+		},
+		Down: func(ctx context.Context, dbs Databases) error {
+			return dropIndexes(ctx, dbs.Certificates.Collection(repository.REDEEM_COLLECTION_NAME), "tx_hash_1_log_index_1", "block_number_1", "verification_code_1")
+		},
+	},
+	{
+		Version:     5,
+		Description: "session and siwe nonce indexes",
+		Up: func(ctx context.Context, dbs Databases) error {
+			err := createIndexes(ctx, dbs.Accounts.Collection(repository.SESSION_COLLECTION_NAME),
+				mongo.IndexModel{
+					Keys:    bson.D{{Key: "user_id", Value: 1}},
+					Options: options.Index().SetName("user_id_1"),
+				},
+				mongo.IndexModel{
+					// keep revoked sessions until they would have expired anyway
+					Keys:    bson.D{{Key: "expires_at", Value: 1}},
+					Options: options.Index().SetName("expires_at_1").SetExpireAfterSeconds(0),
+				},
+			)
+			if err != nil {
+				return err
+			}
+			return createIndexes(ctx, dbs.Accounts.Collection(repository.NONCE_COLLECTION_NAME),
+				mongo.IndexModel{
+					Keys:    bson.D{{Key: "nonce", Value: 1}},
+					Options: options.Index().SetName("nonce_1").SetUnique(true),
+				},
+				mongo.IndexModel{
+					// mongo removes expired nonces by itself
+					Keys:    bson.D{{Key: "expires_at", Value: 1}},
+					Options: options.Index().SetName("expires_at_1").SetExpireAfterSeconds(0),
+				},
+			)
+		},
+		Down: func(ctx context.Context, dbs Databases) error {
+			err := dropIndexes(ctx, dbs.Accounts.Collection(repository.SESSION_COLLECTION_NAME), "user_id_1", "expires_at_1")
+			if err != nil {
+				return err
+			}
+			return dropIndexes(ctx, dbs.Accounts.Collection(repository.NONCE_COLLECTION_NAME), "nonce_1", "expires_at_1")
+		},
+	},
+	{
+		Version:     6,
+		Description: "unique hash of api keys and name of roles",
+		Up: func(ctx context.Context, dbs Databases) error {
+			err := createIndexes(ctx, dbs.Accounts.Collection(repository.API_KEY_COLLECTION_NAME), mongo.IndexModel{
+				Keys:    bson.D{{Key: "hash", Value: 1}},
+				Options: options.Index().SetName("hash_1").SetUnique(true),
+			})
+			if err != nil {
+				return err
+			}
+			return createIndexes(ctx, dbs.Accounts.Collection(repository.ROLE_COLLECTION_NAME), mongo.IndexModel{
+				Keys:    bson.D{{Key: "name", Value: 1}},
+				Options: options.Index().SetName("name_1").SetUnique(true),
+			})
+		},
+		Down: func(ctx context.Context, dbs Databases) error {
+			err := dropIndexes(ctx, dbs.Accounts.Collection(repository.API_KEY_COLLECTION_NAME), "hash_1")
+			if err != nil {
+				return err
+			}
+			return dropIndexes(ctx, dbs.Accounts.Collection(repository.ROLE_COLLECTION_NAME), "name_1")
+		},
+	},
+	{
+		Version:     7,
+		Description: "outbox and webhook indexes",
+		Up: func(ctx context.Context, dbs Databases) error {
+			err := createIndexes(ctx, dbs.Certificates.Collection(repository.OUTBOX_COLLECTION_NAME),
+				mongo.IndexModel{
+					Keys:    bson.D{{Key: "status", Value: 1}, {Key: "next_attempt_at", Value: 1}},
+					Options: options.Index().SetName("status_1_next_attempt_at_1"),
+				},
+				mongo.IndexModel{
+					// delivered events are kept for a month
+					Keys: bson.D{{Key: "processed_at", Value: 1}},
+					Options: options.Index().
+						SetName("processed_at_1").
+						SetExpireAfterSeconds(30 * 24 * 60 * 60).
+						SetPartialFilterExpression(bson.M{"status": repository.OUTBOX_DONE}),
+				},
+			)
+			if err != nil {
+				return err
+			}
+			err = createIndexes(ctx, dbs.Certificates.Collection(repository.WEBHOOK_COLLECTION_NAME), mongo.IndexModel{
+				Keys:    bson.D{{Key: "events", Value: 1}, {Key: "active", Value: 1}},
+				Options: options.Index().SetName("events_1_active_1"),
+			})
+			if err != nil {
+				return err
+			}
+			return createIndexes(ctx, dbs.Certificates.Collection(repository.WEBHOOK_DELIVERY_COLLECTION_NAME),
+				mongo.IndexModel{
+					Keys:    bson.D{{Key: "webhook_id", Value: 1}, {Key: "created_at", Value: -1}},
+					Options: options.Index().SetName("webhook_id_1_created_at_-1"),
+				},
+				mongo.IndexModel{
+					// the delivery log is kept for a month
+					Keys:    bson.D{{Key: "created_at", Value: 1}},
+					Options: options.Index().SetName("created_at_1").SetExpireAfterSeconds(30 * 24 * 60 * 60),
+				},
+			)
+		},
+		Down: func(ctx context.Context, dbs Databases) error {
+			err := dropIndexes(ctx, dbs.Certificates.Collection(repository.OUTBOX_COLLECTION_NAME), "status_1_next_attempt_at_1", "processed_at_1")
+			if err != nil {
+				return err
+			}
+			err = dropIndexes(ctx, dbs.Certificates.Collection(repository.WEBHOOK_COLLECTION_NAME), "events_1_active_1")
+			if err != nil {
+				return err
+			}
+			return dropIndexes(ctx, dbs.Certificates.Collection(repository.WEBHOOK_DELIVERY_COLLECTION_NAME), "webhook_id_1_created_at_-1", "created_at_1")
+		},
+	},
+	{
+		Version:     8,
+		Description: "backfill price_decimal of redeemeds from the price string",
+		Up:          backfillPriceDecimal,
+		Down: func(ctx context.Context, dbs Databases) error {
+			col := dbs.Certificates.Collection(repository.REDEEM_COLLECTION_NAME)
+			_, err := col.UpdateMany(ctx, bson.M{"price_decimal": bson.M{"$exists": true}}, bson.M{"$unset": bson.M{"price_decimal": ""}})
+			return err
+		},
+	},
+	{
+		Version:     9,
+		Description: "unique metamask_address of users proven with siwe",
+		Up: func(ctx context.Context, dbs Databases) error {
+			return createIndexes(ctx, dbs.Accounts.Collection(repository.USER_COLLECTION_NAME), mongo.IndexModel{
+				// an address set at registration is not proven and may be
+				// anyone's
+				Keys: bson.D{{Key: "metamask_address", Value: 1}},
+				Options: options.Index().
+					SetName("metamask_address_1").
+					SetUnique(true).
+					SetPartialFilterExpression(bson.M{"wallet_verified": true}),
+			})
+		},
+		Down: func(ctx context.Context, dbs Databases) error {
+			return dropIndexes(ctx, dbs.Accounts.Collection(repository.USER_COLLECTION_NAME), "metamask_address_1")
+		},
+	},
+}
+
+// backfillPriceDecimal sets price_decimal of the redeemeds written before
+// it was, in batches so a big collection is not held in memory
+func backfillPriceDecimal(ctx context.Context, dbs Databases) error {
+	const batchSize = 500
+	col := dbs.Certificates.Collection(repository.REDEEM_COLLECTION_NAME)
+	filter := bson.M{
+		"price":         bson.M{"$type": "string", "$ne": ""},
+		"price_decimal": bson.M{"$exists": false},
+	}
+	cur, err := col.Find(ctx, filter, options.Find().SetProjection(bson.M{"price": 1}))
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	updated, skipped := 0, 0
+	writes := []mongo.WriteModel{}
+	flush := func() error {
+		if len(writes) == 0 {
+			return nil
+		}
+		_, err := col.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+		writes = writes[:0]
+		return err
+	}
+	for cur.Next(ctx) {
+		doc := struct {
+			Id    primitive.ObjectID `bson:"_id"`
+			Price string             `bson:"price"`
+		}{}
+		err := cur.Decode(&doc)
+		if err != nil {
+			return err
+		}
+		price, ok := repository.PriceDecimal(doc.Price)
+		if !ok {
+			log.Printf("redeemed %s: price %q is not a number, skipped\n", doc.Id.Hex(), doc.Price)
+			skipped++
+			continue
+		}
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": doc.Id}).
+			SetUpdate(bson.M{"$set": bson.M{"price_decimal": price}}))
+		updated++
+		if len(writes) == batchSize {
+			err := flush()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	err = cur.Err()
+	if err != nil {
+		return err
+	}
+	err = flush()
+	if err != nil {
+		return err
+	}
+	log.Printf("price_decimal set on %d redeemeds, %d skipped\n", updated, skipped)
+	return nil
+}
@@ -0,0 +1,131 @@
+package notification
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/seenark/super-backend-temp/config"
+	"github.com/seenark/super-backend-temp/repository"
+)
+
+const (
+	CUSTOMER_AUDIENCE = "customer"
+	ADMIN_AUDIENCE    = "admin"
+)
+
+//go:embed templates/*.tmpl
+var templateFiles embed.FS
+
+// templateData is what the templates of redeemed.* events see
+type templateData struct {
+	Redeemed repository.Redeemed
+	Change   *repository.StatusChange
+}
+
+// EmailHandler emails one audience about redeemed.* outbox events. Each
+// audience is its own outbox handler, so a failed admin email is retried
+// without sending the customer email twice.
+type EmailHandler struct {
+	audience    string
+	sender      Sender
+	adminEmails []string
+	languages   []string
+	templates   map[string]*template.Template
+}
+
+// NewEmailHandlers returns the handler of the customer and the admin emails
+func NewEmailHandlers(cfg config.NotificationConfig, sender Sender) ([]*EmailHandler, error) {
+	languages := cfg.Languages
+	if len(languages) == 0 {
+		languages = []string{"th", "en"}
+	}
+	templates := map[string]*template.Template{}
+	for _, language := range languages {
+		tmpl, err := template.ParseFS(templateFiles, fmt.Sprintf("templates/%s.tmpl", language))
+		if err != nil {
+			return nil, fmt.Errorf("email templates of language %q: %v", language, err)
+		}
+		templates[language] = tmpl
+	}
+
+	handlers := []*EmailHandler{}
+	for _, audience := range []string{CUSTOMER_AUDIENCE, ADMIN_AUDIENCE} {
+		handlers = append(handlers, &EmailHandler{
+			audience:    audience,
+			sender:      sender,
+			adminEmails: cfg.AdminEmails,
+			languages:   languages,
+			templates:   templates,
+		})
+	}
+	return handlers, nil
+}
+
+// Name implements outbox.Handler
+func (h *EmailHandler) Name() string {
+	return "email." + h.audience
+}
+
+// Handle implements outbox.Handler
+func (h *EmailHandler) Handle(ctx context.Context, event repository.OutboxEvent) error {
+	if !strings.HasPrefix(event.Type, "redeemed.") {
+		return nil
+	}
+	data := repository.RedeemedEventData{}
+	err := event.Decode(&data)
+	if err != nil {
+		return err
+	}
+
+	to := h.adminEmails
+	if h.audience == CUSTOMER_AUDIENCE {
+		to = []string{}
+		if data.Redeemed.Email != "" {
+			to = []string{data.Redeemed.Email}
+		}
+	}
+	if len(to) == 0 {
+		return nil
+	}
+
+	message, ok, err := h.render(event.Type, templateData{Redeemed: data.Redeemed, Change: data.Change})
+	if err != nil || !ok {
+		return err
+	}
+	message.To = to
+	return h.sender.Send(message)
+}
+
+// render joins the message of every language, ok is false when the event
+// has no template for the audience
+func (h *EmailHandler) render(eventType string, data templateData) (Message, bool, error) {
+	name := eventType + "." + h.audience
+	subjects := []string{}
+	bodies := []string{}
+	for _, language := range h.languages {
+		tmpl := h.templates[language]
+		if tmpl.Lookup(name+".subject") == nil {
+			return Message{}, false, nil
+		}
+		subject := bytes.Buffer{}
+		err := tmpl.ExecuteTemplate(&subject, name+".subject", data)
+		if err != nil {
+			return Message{}, false, err
+		}
+		body := bytes.Buffer{}
+		err = tmpl.ExecuteTemplate(&body, name+".body", data)
+		if err != nil {
+			return Message{}, false, err
+		}
+		subjects = append(subjects, strings.TrimSpace(subject.String()))
+		bodies = append(bodies, strings.TrimSpace(body.String()))
+	}
+	return Message{
+		Subject: strings.Join(subjects, " / "),
+		Body:    strings.Join(bodies, "\n\n--------\n\n") + "\n",
+	}, true, nil
+}
@@ -0,0 +1,172 @@
+package notification
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/seenark/super-backend-temp/config"
+)
+
+const (
+	SMTP_DRIVER = "smtp"
+	FILE_DRIVER = "file"
+	LOG_DRIVER  = "log"
+)
+
+// Message is a plain text email
+type Message struct {
+	To      []string
+	Subject string
+	Body    string
+}
+
+type Sender interface {
+	Send(message Message) error
+}
+
+// NewSender returns the sender of cfg.Driver, nil when notifications are off
+func NewSender(cfg config.NotificationConfig) (Sender, error) {
+	switch cfg.Driver {
+	case "":
+		return nil, nil
+	case SMTP_DRIVER:
+		return NewSMTPSender(cfg), nil
+	case FILE_DRIVER:
+		return NewFileSender(cfg.From, cfg.FileDir)
+	case LOG_DRIVER:
+		return NewLogSender(cfg.From), nil
+	}
+	return nil, fmt.Errorf("notification driver %q is unknown, use %s, %s or %s", cfg.Driver, SMTP_DRIVER, FILE_DRIVER, LOG_DRIVER)
+}
+
+type SMTPSender struct {
+	addr string
+	auth smtp.Auth
+	from string
+}
+
+func NewSMTPSender(cfg config.NotificationConfig) SMTPSender {
+	var auth smtp.Auth
+	if cfg.Smtp.Username != "" {
+		auth = smtp.PlainAuth("", cfg.Smtp.Username, cfg.Smtp.Password, cfg.Smtp.Host)
+	}
+	return SMTPSender{
+		addr: fmt.Sprintf("%s:%d", cfg.Smtp.Host, cfg.Smtp.Port),
+		auth: auth,
+		from: cfg.From,
+	}
+}
+
+// Send implements Sender
+func (s SMTPSender) Send(message Message) error {
+	from, err := envelopeAddress(s.from)
+	if err != nil {
+		return err
+	}
+	to := []string{}
+	for _, v := range message.To {
+		address, err := envelopeAddress(v)
+		if err != nil {
+			return err
+		}
+		to = append(to, address)
+	}
+	return smtp.SendMail(s.addr, s.auth, from, to, encodeMessage(s.from, message))
+}
+
+// FileSender writes every message as an .eml file, for local testing
+type FileSender struct {
+	from string
+	dir  string
+}
+
+func NewFileSender(from string, dir string) (FileSender, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return FileSender{}, err
+	}
+	return FileSender{from: from, dir: dir}, nil
+}
+
+// Send implements Sender
+func (s FileSender) Send(message Message) error {
+	name := fmt.Sprintf("%s-%s.eml", time.Now().Format("20060102-150405"), uuid.New().String())
+	return ioutil.WriteFile(filepath.Join(s.dir, name), encodeMessage(s.from, message), 0644)
+}
+
+// LogSender prints every message to stdout, for local testing
+type LogSender struct {
+	from string
+}
+
+func NewLogSender(from string) LogSender {
+	return LogSender{from: from}
+}
+
+// Send implements Sender
+func (s LogSender) Send(message Message) error {
+	fmt.Printf("mail from %s to %s\nsubject: %s\n%s\n", s.from, strings.Join(message.To, ", "), message.Subject, message.Body)
+	return nil
+}
+
+// envelopeAddress is the bare address of "Name <address>"
+func envelopeAddress(address string) (string, error) {
+	parsed, err := mail.ParseAddress(address)
+	if err != nil {
+		return "", fmt.Errorf("email address %q: %v", address, err)
+	}
+	return parsed.Address, nil
+}
+
+// encodeMessage builds the MIME message, the subject and body are UTF-8 so
+// Thai text survives every mail server
+func encodeMessage(from string, message Message) []byte {
+	buf := bytes.Buffer{}
+	headers := [][2]string{
+		{"From", encodeAddress(from)},
+		{"To", encodeAddressList(message.To)},
+		{"Subject", mime.BEncoding.Encode("UTF-8", message.Subject)},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"Message-ID", fmt.Sprintf("<%s@super-energy>", uuid.New().String())},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "text/plain; charset=UTF-8"},
+		{"Content-Transfer-Encoding", "base64"},
+	}
+	for _, v := range headers {
+		buf.WriteString(v[0] + ": " + v[1] + "\r\n")
+	}
+	buf.WriteString("\r\n")
+	body := base64.StdEncoding.EncodeToString([]byte(message.Body))
+	for len(body) > 76 {
+		buf.WriteString(body[:76] + "\r\n")
+		body = body[76:]
+	}
+	buf.WriteString(body + "\r\n")
+	return buf.Bytes()
+}
+
+func encodeAddress(address string) string {
+	parsed, err := mail.ParseAddress(address)
+	if err != nil {
+		return address
+	}
+	return parsed.String()
+}
+
+func encodeAddressList(addresses []string) string {
+	encoded := []string{}
+	for _, v := range addresses {
+		encoded = append(encoded, encodeAddress(v))
+	}
+	return strings.Join(encoded, ", ")
+}
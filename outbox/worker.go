@@ -0,0 +1,126 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/seenark/super-backend-temp/config"
+	"github.com/seenark/super-backend-temp/repository"
+)
+
+// Handler delivers outbox events somewhere, such as email. Name must not
+// change because it is stored on events the handler already handled.
+type Handler interface {
+	Name() string
+	Handle(ctx context.Context, event repository.OutboxEvent) error
+}
+
+// Worker delivers outbox events to every handler. A failed handler is
+// retried with exponential backoff until MaxAttempts.
+type Worker struct {
+	repo         repository.OutboxRepository
+	handlers     []Handler
+	pollInterval time.Duration
+	maxAttempts  int
+	retryDelay   time.Duration
+	maxDelay     time.Duration
+	lockFor      time.Duration
+}
+
+func NewWorker(repo repository.OutboxRepository, cfg config.OutboxConfig, handlers ...Handler) *Worker {
+	worker := &Worker{
+		repo:         repo,
+		handlers:     handlers,
+		pollInterval: time.Duration(cfg.PollInterval) * time.Second,
+		maxAttempts:  cfg.MaxAttempts,
+		retryDelay:   time.Duration(cfg.RetryDelay) * time.Second,
+		maxDelay:     time.Hour,
+		lockFor:      5 * time.Minute,
+	}
+	if worker.pollInterval <= 0 {
+		worker.pollInterval = 5 * time.Second
+	}
+	if worker.maxAttempts <= 0 {
+		worker.maxAttempts = 10
+	}
+	if worker.retryDelay <= 0 {
+		worker.retryDelay = 30 * time.Second
+	}
+	return worker
+}
+
+// Run delivers due events until ctx is done
+func (w *Worker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.pollInterval)
+	defer ticker.Stop()
+	for {
+		err := w.ProcessDue(ctx)
+		if err != nil {
+			fmt.Printf("outbox: %v\n", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ProcessDue delivers every event that is due now
+func (w *Worker) ProcessDue(ctx context.Context) error {
+	for {
+		event, err := w.repo.Claim(ctx, w.lockFor)
+		if err != nil {
+			return err
+		}
+		if event == nil {
+			return nil
+		}
+		err = w.process(ctx, *event)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (w *Worker) process(ctx context.Context, event repository.OutboxEvent) error {
+	var lastErr error
+	for _, handler := range w.handlers {
+		if event.IsDone(handler.Name()) {
+			continue
+		}
+		err := handler.Handle(ctx, event)
+		if err != nil {
+			lastErr = fmt.Errorf("%s: %v", handler.Name(), err)
+			fmt.Printf("outbox: event %s %s attempt %d: %v\n", event.Id.Hex(), event.Type, event.Attempts, lastErr)
+			continue
+		}
+		err = w.repo.MarkHandled(ctx, event.Id, handler.Name())
+		if err != nil {
+			return err
+		}
+	}
+
+	if lastErr == nil {
+		return w.repo.Complete(ctx, event.Id)
+	}
+	if event.Attempts >= w.maxAttempts {
+		return w.repo.Fail(ctx, event.Id, lastErr.Error())
+	}
+	next := time.Now().Add(Backoff(event.Attempts, w.retryDelay, w.maxDelay))
+	return w.repo.Retry(ctx, event.Id, next, lastErr.Error())
+}
+
+// Backoff is the wait before retry attempt, base doubled every attempt up
+// to max
+func Backoff(attempt int, base time.Duration, max time.Duration) time.Duration {
+	delay := base
+	for i := 1; i < attempt; i++ {
+		delay *= 2
+		if delay >= max {
+			return max
+		}
+	}
+	return delay
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/seenark/super-backend-temp/config"
+	"github.com/seenark/super-backend-temp/indexer"
+)
+
+const redeemedUsage = `usage: redeemed reindex [-from block] [-to block] [flags]
+
+ingests the redeem events of the blocks again with the indexer config, such
+as after a bug in the indexer or a redemption deleted by mistake. The
+checkpoint of the running indexer is not changed.`
+
+func redeemedCommand(cfg config.Configuration, args []string) error {
+	name, args, err := subcommand(args, redeemedUsage)
+	if err != nil {
+		return err
+	}
+	if name != "reindex" {
+		return fmt.Errorf("unknown redeemed command %q\n%s", name, redeemedUsage)
+	}
+	flags := flag.NewFlagSet("redeemed reindex", flag.ExitOnError)
+	storageFlags(flags, &cfg)
+	from := flags.Uint64("from", 0, "first block, the start block of the indexer config by default")
+	to := flags.Uint64("to", 0, "last block, 0 is the last confirmed block")
+	flags.Parse(args)
+
+	if cfg.Indexer.RpcURL == "" {
+		return errors.New("indexer rpc url is not set")
+	}
+	client, err := ethclient.Dial(cfg.Indexer.RpcURL)
+	if err != nil {
+		return fmt.Errorf("connect to rpc %s: %w", cfg.Indexer.RpcURL, err)
+	}
+	defer client.Close()
+	repos := newRepositories(cfg)
+	redeemIndexer, err := indexer.NewIndexer(cfg.Indexer, client, repos.Redeemed, repos.Checkpoints)
+	if err != nil {
+		return fmt.Errorf("create indexer: %w", err)
+	}
+	if *from == 0 {
+		*from = redeemIndexer.StartBlock()
+	}
+	last, err := redeemIndexer.Reindex(context.Background(), *from, *to)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("reindexed blocks %d to %d\n", *from, last)
+	return nil
+}
@@ -0,0 +1,142 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	API_KEY_COLLECTION_NAME = "api_keys"
+)
+
+// ApiKey lets a machine client such as the chain indexer call the API
+// without a user password.
+type ApiKey struct {
+	Id         primitive.ObjectID `bson:"_id" json:"id"`
+	Name       string             `bson:"name" json:"name"`
+	Prefix     string             `bson:"prefix" json:"prefix"`
+	Hash       string             `bson:"hash" json:"-"`
+	Role       string             `bson:"role" json:"role"`
+	Routes     []string           `bson:"routes" json:"routes"` // "POST /api/redeemed/redeem-event" or "/api/redeemed/redeem-event", empty allows every route
+	CreatedBy  string             `bson:"created_by" json:"created_by"`
+	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
+	ExpiresAt  *time.Time         `bson:"expires_at" json:"expires_at"`
+	LastUsedAt *time.Time         `bson:"last_used_at" json:"last_used_at"`
+	RevokedAt  *time.Time         `bson:"revoked_at" json:"revoked_at"`
+}
+
+// IsActive reports whether the key is neither revoked nor expired
+func (k ApiKey) IsActive(now time.Time) bool {
+	if k.RevokedAt != nil {
+		return false
+	}
+	return k.ExpiresAt == nil || now.Before(*k.ExpiresAt)
+}
+
+// AllowsRoute reports whether the key may call route path with method
+func (k ApiKey) AllowsRoute(method string, path string) bool {
+	if len(k.Routes) == 0 {
+		return true
+	}
+	for _, v := range k.Routes {
+		if v == path || v == method+" "+path {
+			return true
+		}
+	}
+	return false
+}
+
+type ApiKeyRepository interface {
+	Create(ctx context.Context, apiKey ApiKey) (*ApiKey, error)
+	GetAll(ctx context.Context) []ApiKey
+	GetByHash(ctx context.Context, hash string) (*ApiKey, error)
+	Revoke(ctx context.Context, id string) (*ApiKey, error)
+	Touch(ctx context.Context, id primitive.ObjectID) error
+}
+
+type ApiKeyDb struct {
+	col *mongo.Collection
+}
+
+func NewApiKeyDb(db *mongo.Database) ApiKeyRepository {
+	col := db.Collection(API_KEY_COLLECTION_NAME)
+	return ApiKeyDb{
+		col: col,
+	}
+}
+
+// Create implements ApiKeyRepository
+func (a ApiKeyDb) Create(ctx context.Context, apiKey ApiKey) (*ApiKey, error) {
+	apiKey.Id = primitive.NewObjectID()
+	apiKey.CreatedAt = time.Now()
+	if apiKey.Routes == nil {
+		apiKey.Routes = []string{}
+	}
+	_, err := a.col.InsertOne(ctx, apiKey)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	return &apiKey, nil
+}
+
+// GetAll implements ApiKeyRepository
+func (a ApiKeyDb) GetAll(ctx context.Context) []ApiKey {
+	apiKeys := []ApiKey{}
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	cur, err := a.col.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		fmt.Printf("err: %v\n", err)
+		return apiKeys
+	}
+	for cur.Next(ctx) {
+		apiKey := ApiKey{}
+		err := cur.Decode(&apiKey)
+		if err != nil {
+			continue
+		}
+		apiKeys = append(apiKeys, apiKey)
+	}
+	return apiKeys
+}
+
+// GetByHash implements ApiKeyRepository
+func (a ApiKeyDb) GetByHash(ctx context.Context, hash string) (*ApiKey, error) {
+	res := a.col.FindOne(ctx, genfilter("hash", hash))
+	apiKey := ApiKey{}
+	err := res.Decode(&apiKey)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	return &apiKey, nil
+}
+
+// Revoke implements ApiKeyRepository
+func (a ApiKeyDb) Revoke(ctx context.Context, id string) (*ApiKey, error) {
+	objectId, err := parseObjectId(id)
+	if err != nil {
+		return nil, err
+	}
+	filter := bson.M{"_id": objectId, "revoked_at": nil}
+	update := bson.M{"$set": bson.M{"revoked_at": time.Now()}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	res := a.col.FindOneAndUpdate(ctx, filter, update, opts)
+	apiKey := ApiKey{}
+	err = res.Decode(&apiKey)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	return &apiKey, nil
+}
+
+// Touch implements ApiKeyRepository, it records the last time a key was used
+func (a ApiKeyDb) Touch(ctx context.Context, id primitive.ObjectID) error {
+	update := bson.M{"$set": bson.M{"last_used_at": time.Now()}}
+	_, err := a.col.UpdateByID(ctx, id, update)
+	return err
+}
@@ -0,0 +1,141 @@
+package repository_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/seenark/super-backend-temp/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestApiKeyCreate(t *testing.T) {
+	eachBackend(t, func(t *testing.T, repos repository.Repositories) {
+		tests := []struct {
+			name   string
+			apiKey repository.ApiKey
+			err    error
+		}{
+			{"new", repository.ApiKey{Name: "indexer", Prefix: "abc", Hash: "hash-1", Role: repository.EVENT_LOGGER_ROLE}, nil},
+			{"hash taken", repository.ApiKey{Name: "copy", Hash: "hash-1"}, repository.ErrDuplicate},
+			{"with routes", repository.ApiKey{Name: "partner", Hash: "hash-2", Routes: []string{"GET /api/verify"}}, nil},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				created, err := repos.ApiKeys.Create(ctx, tt.apiKey)
+				checkError(t, err, tt.err)
+				if err != nil {
+					return
+				}
+				if created.Id.IsZero() || created.CreatedAt.IsZero() || created.Routes == nil {
+					t.Fatalf("created %+v, want an id, a creation time and routes that are not nil", created)
+				}
+				found, err := repos.ApiKeys.GetByHash(ctx, tt.apiKey.Hash)
+				checkError(t, err, nil)
+				if found.Id != created.Id || found.Name != tt.apiKey.Name || len(found.Routes) != len(tt.apiKey.Routes) {
+					t.Fatalf("found %+v", found)
+				}
+			})
+		}
+
+		all := repos.ApiKeys.GetAll(ctx)
+		if len(all) != 2 || all[0].Name != "partner" {
+			t.Fatalf("keys = %v, want newest first", all)
+		}
+		_, err := repos.ApiKeys.GetByHash(ctx, "hash-3")
+		checkError(t, err, repository.ErrNotFound)
+	})
+}
+
+func TestApiKeyTouch(t *testing.T) {
+	eachBackend(t, func(t *testing.T, repos repository.Repositories) {
+		touched, err := repos.ApiKeys.Create(ctx, repository.ApiKey{Name: "indexer", Hash: "hash-1"})
+		checkError(t, err, nil)
+		untouched, err := repos.ApiKeys.Create(ctx, repository.ApiKey{Name: "partner", Hash: "hash-2"})
+		checkError(t, err, nil)
+
+		tests := []struct {
+			name string
+			id   primitive.ObjectID
+		}{
+			{"known key", touched.Id},
+			// a key deleted while it was in use is not an error
+			{"unknown key", primitive.NewObjectID()},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				checkError(t, repos.ApiKeys.Touch(ctx, tt.id), nil)
+			})
+		}
+
+		found, err := repos.ApiKeys.GetByHash(ctx, "hash-1")
+		checkError(t, err, nil)
+		if found.LastUsedAt == nil || time.Since(*found.LastUsedAt) > time.Minute {
+			t.Fatalf("last used at = %v", found.LastUsedAt)
+		}
+		found, err = repos.ApiKeys.GetByHash(ctx, "hash-2")
+		checkError(t, err, nil)
+		if found.Id != untouched.Id || found.LastUsedAt != nil {
+			t.Fatalf("another key was touched: %+v", found)
+		}
+	})
+}
+
+func TestApiKeyRevoke(t *testing.T) {
+	eachBackend(t, func(t *testing.T, repos repository.Repositories) {
+		key, err := repos.ApiKeys.Create(ctx, repository.ApiKey{Name: "indexer", Hash: "hash-1"})
+		checkError(t, err, nil)
+		if !key.IsActive(time.Now()) {
+			t.Fatal("a new key is not active")
+		}
+
+		tests := []struct {
+			name string
+			id   string
+			err  error
+		}{
+			{"active key", key.Id.Hex(), nil},
+			{"revoked key", key.Id.Hex(), repository.ErrNotFound},
+			{"unknown key", primitive.NewObjectID().Hex(), repository.ErrNotFound},
+			{"malformed id", "nope", repository.ErrNotFound},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				revoked, err := repos.ApiKeys.Revoke(ctx, tt.id)
+				checkError(t, err, tt.err)
+				if err == nil && (revoked.RevokedAt == nil || revoked.IsActive(time.Now())) {
+					t.Fatalf("revoked %+v is still active", revoked)
+				}
+			})
+		}
+
+		// the key is still found so the caller can tell it was revoked
+		found, err := repos.ApiKeys.GetByHash(ctx, "hash-1")
+		checkError(t, err, nil)
+		if found.IsActive(time.Now()) {
+			t.Fatal("a revoked key is active")
+		}
+	})
+}
+
+func TestApiKeyIsActive(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Minute)
+	future := now.Add(time.Minute)
+	tests := []struct {
+		name   string
+		apiKey repository.ApiKey
+		want   bool
+	}{
+		{"no expiry", repository.ApiKey{}, true},
+		{"expires later", repository.ApiKey{ExpiresAt: &future}, true},
+		{"expired", repository.ApiKey{ExpiresAt: &past}, false},
+		{"revoked", repository.ApiKey{ExpiresAt: &future, RevokedAt: &past}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.apiKey.IsActive(now); got != tt.want {
+				t.Fatalf("IsActive = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,8 +2,12 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"regexp"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/seenark/super-backend-temp/authen"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -17,30 +21,93 @@ type SignInData struct {
 }
 
 type AuthenticationRepository interface {
-	Signin(email string, password string) (*SignInData, error)
-	AdminSignin(email string, password string) (*SignInData, error)
-	EventLoggerSigin(email string, password string) (*SignInData, error)
-	NewAccessTokenAndRefreshToken(userId string, refreshToken string) (*SignInData, error)
-	Signout(userId string) error
+	Signin(ctx context.Context, email string, password string, client ClientInfo) (*SignInData, error)
+	AdminSignin(ctx context.Context, email string, password string, client ClientInfo) (*SignInData, error)
+	EventLoggerSigin(ctx context.Context, email string, password string, client ClientInfo) (*SignInData, error)
+	WalletSignin(ctx context.Context, address string, autoRegister bool, client ClientInfo) (*SignInData, error)
+	NewAccessTokenAndRefreshToken(ctx context.Context, userId string, refreshToken string, client ClientInfo) (*SignInData, error)
+	Signout(ctx context.Context, userId string, sessionId string) error
+}
+
+var ErrRefreshTokenReused = errors.New("refresh token was already used, session revoked")
+
+// authUsers is where AuthenDb looks up the users signing in, so the sign-in
+// rules are the same on every storage
+type authUsers interface {
+	// roles empty matches any role
+	findByEmail(ctx context.Context, email string, roles []string) (*User, error)
+	findById(ctx context.Context, id primitive.ObjectID) (*User, error)
+	// address is matched case-insensitively, only wallets proven with SIWE
+	// are found
+	findByWallet(ctx context.Context, address string) (*User, error)
+	insertUser(ctx context.Context, user User) error
 }
 
 type AuthenDb struct {
-	col *mongo.Collection
-	ctx context.Context
+	users    authUsers
+	sessions SessionRepository
 }
 
-func NewAuthDB(col *mongo.Collection) AuthenticationRepository {
+func NewAuthDB(col *mongo.Collection, sessions SessionRepository) AuthenticationRepository {
 	return &AuthenDb{
-		col: col,
-		ctx: context.Background(),
+		users:    mongoAuthUsers{col: col},
+		sessions: sessions,
+	}
+}
+
+type mongoAuthUsers struct {
+	col *mongo.Collection
+}
+
+func (m mongoAuthUsers) findByEmail(ctx context.Context, email string, roles []string) (*User, error) {
+	filter := bson.M{"email": email}
+	if len(roles) > 0 {
+		filter["role"] = bson.M{"$in": roles}
 	}
+	return m.findOne(ctx, filter)
 }
 
-func (a *AuthenDb) Signin(email string, password string) (*SignInData, error) {
-	filer := genfilter("email", email)
+func (m mongoAuthUsers) findById(ctx context.Context, id primitive.ObjectID) (*User, error) {
+	return m.findOne(ctx, bson.M{"_id": id})
+}
+
+func (m mongoAuthUsers) findByWallet(ctx context.Context, address string) (*User, error) {
+	return m.findOne(ctx, bson.M{
+		"metamask_address": primitive.Regex{Pattern: "^" + regexp.QuoteMeta(address) + "$", Options: "i"},
+		"wallet_verified":  true,
+	})
+}
+
+func (m mongoAuthUsers) findOne(ctx context.Context, filter bson.M) (*User, error) {
 	user := User{}
-	res := a.col.FindOne(a.ctx, filer)
-	err := res.Decode(&user)
+	err := m.col.FindOne(ctx, filter).Decode(&user)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	return &user, nil
+}
+
+func (m mongoAuthUsers) insertUser(ctx context.Context, user User) error {
+	_, err := m.col.InsertOne(ctx, user)
+	return wrapError(err)
+}
+
+func (a *AuthenDb) Signin(ctx context.Context, email string, password string, client ClientInfo) (*SignInData, error) {
+	return a.signinWithRoles(ctx, email, password, nil, client)
+}
+
+func (a *AuthenDb) AdminSignin(ctx context.Context, email string, password string, client ClientInfo) (*SignInData, error) {
+	return a.signinWithRoles(ctx, email, password, []string{ADMIN_ROLE}, client)
+}
+
+func (a *AuthenDb) EventLoggerSigin(ctx context.Context, email string, password string, client ClientInfo) (*SignInData, error) {
+	return a.signinWithRoles(ctx, email, password, []string{EVENT_LOGGER_ROLE}, client)
+}
+
+// signinWithRoles is the only password sign-in path. When roles is not empty
+// the user must have one of them, otherwise any role can sign in.
+func (a *AuthenDb) signinWithRoles(ctx context.Context, email string, password string, roles []string, client ClientInfo) (*SignInData, error) {
+	user, err := a.users.findByEmail(ctx, email, roles)
 	if err != nil {
 		return nil, err
 	}
@@ -48,139 +115,129 @@ func (a *AuthenDb) Signin(email string, password string) (*SignInData, error) {
 	if !passwordOk {
 		return nil, fmt.Errorf("password incorected")
 	}
-	token, err := authen.GenerateJWT(user.Id.Hex(), user.Email, user.MetamaskAddress, user.Role, 0)
+	return a.newSession(ctx, user, client)
+}
+
+// NewAccessTokenAndRefreshToken rotates the refresh token of a session. If
+// an already rotated refresh token comes back it has been stolen or replayed,
+// so the whole session (token family) is revoked.
+func (a *AuthenDb) NewAccessTokenAndRefreshToken(ctx context.Context, userId string, refreshToken string, client ClientInfo) (*SignInData, error) {
+	claims, err := authen.ValidateRefreshJWT(refreshToken)
 	if err != nil {
 		return nil, err
 	}
-	refresh, err := authen.GenerateRefreshJWT(user.Id.Hex(), user.Email, token.Token)
+	sessionId := claims.SessionId
+	tokenId := claims.Id
+
+	session, err := a.sessions.GetById(ctx, sessionId)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("refresh token is no longer valid")
 	}
-
-	// update refresh token in DB
-	updateRefreshTokenInDB(user.Id, refresh.Token, a)
-	signinData := SignInData{
-		Token:   token,
-		Refresh: refresh,
-		User:    &user,
+	if session.UserId.Hex() != userId || session.RevokedAt != nil || time.Now().After(session.ExpiresAt) {
+		return nil, fmt.Errorf("refresh token is no longer valid")
 	}
-	return &signinData, nil
-}
-func (a *AuthenDb) AdminSignin(email string, password string) (*SignInData, error) {
-	// { $and: [{email: "super@super.energy2"}, {role: "admin"}] }
-	filerFor := []bson.E{
-		{Key: "email", Value: email},
-		{Key: "role", Value: "admin"},
+	if session.TokenId != tokenId {
+		err := a.sessions.RevokeFamily(ctx, sessionId, "refresh token reused")
+		if err != nil {
+			fmt.Printf("err: %v\n", err)
+		}
+		return nil, ErrRefreshTokenReused
 	}
 
-	filter := bson.D{bson.E{Key: "$and", Value: filerFor}}
-	user := User{}
-	res := a.col.FindOne(a.ctx, filter)
-	err := res.Decode(&user)
+	user, err := a.users.findById(ctx, session.UserId)
 	if err != nil {
 		return nil, err
 	}
-	token, err := authen.GenerateJWT(user.Id.Hex(), user.Email, user.MetamaskAddress, user.Role, 0)
+
+	newTokenId := uuid.NewString()
+	signinData, err := a.generateTokens(user, sessionId, newTokenId)
 	if err != nil {
 		return nil, err
 	}
-	return &SignInData{
-		Token: token,
-		User:  &user,
-	}, nil
-}
-
-func (a *AuthenDb) EventLoggerSigin(email string, password string) (*SignInData, error) {
-	filerFor := []bson.E{
-		{Key: "email", Value: email},
-		{Key: "role", Value: "eventLogger"},
+	_, err = a.sessions.Rotate(ctx, sessionId, tokenId, newTokenId, client, time.Unix(signinData.Refresh.Exp, 0))
+	if errors.Is(err, ErrNotFound) {
+		// another request rotated the same token first
+		a.sessions.RevokeFamily(ctx, sessionId, "refresh token reused")
+		return nil, ErrRefreshTokenReused
 	}
-
-	filter := bson.D{bson.E{Key: "$and", Value: filerFor}}
-	user := User{}
-	res := a.col.FindOne(a.ctx, filter)
-	err := res.Decode(&user)
 	if err != nil {
 		return nil, err
 	}
-	token, err := authen.GenerateJWT(user.Id.Hex(), user.Email, user.MetamaskAddress, user.Role, 0)
+	return signinData, nil
+}
+
+// WalletSignin signs in the user owning the metamask address. When
+// autoRegister is true a first-time wallet gets a new "user" account. An
+// address only binds to an account here, once proven with SIWE, so one set
+// on a profile by whoever registered it never signs anyone in.
+func (a *AuthenDb) WalletSignin(ctx context.Context, address string, autoRegister bool, client ClientInfo) (*SignInData, error) {
+	user, err := a.users.findByWallet(ctx, address)
+	if errors.Is(err, ErrNotFound) && autoRegister {
+		user = &User{
+			Id:              primitive.NewObjectID(),
+			Role:            USER_ROLE,
+			MetamaskAddress: address,
+			WalletVerified:  true,
+		}
+		err = a.users.insertUser(ctx, *user)
+	}
 	if err != nil {
 		return nil, err
 	}
-	return &SignInData{
-		Token: token,
-		User:  &user,
-	}, nil
+	return a.newSession(ctx, user, client)
+}
+
+// Signout ends the session the access token belongs to
+func (a *AuthenDb) Signout(ctx context.Context, userId string, sessionId string) error {
+	return a.sessions.Revoke(ctx, userId, sessionId, "signout")
 }
 
-func (a *AuthenDb) NewAccessTokenAndRefreshToken(userId string, refreshToken string) (*SignInData, error) {
-	id, err := primitive.ObjectIDFromHex(userId)
+// helper
+func (a *AuthenDb) newSession(ctx context.Context, user *User, client ClientInfo) (*SignInData, error) {
+	sessionId := uuid.NewString()
+	tokenId := uuid.NewString()
+	signinData, err := a.generateTokens(user, sessionId, tokenId)
 	if err != nil {
 		return nil, err
 	}
-	filter := genfilter("_id", id)
-	user := User{}
-	res := a.col.FindOne(a.ctx, filter)
-	err = res.Decode(&user)
+	_, err = a.sessions.Create(ctx, Session{
+		Id:        sessionId,
+		UserId:    user.Id,
+		TokenId:   tokenId,
+		Device:    client.Device,
+		IP:        client.IP,
+		ExpiresAt: time.Unix(signinData.Refresh.Exp, 0),
+	})
 	if err != nil {
 		return nil, err
 	}
-	if user.Refreshtoken != refreshToken {
-		return nil, fmt.Errorf("refresh token is no longer valid")
+	return signinData, nil
+}
+
+// generateTokens signs the tokens of a session. The metamask claim is the
+// wallet of the user once proven with SIWE, whichever way this login signed
+// in.
+func (a *AuthenDb) generateTokens(user *User, sessionId string, tokenId string) (*SignInData, error) {
+	wallet := ""
+	if user.WalletVerified {
+		wallet = user.MetamaskAddress
 	}
-	token, err := authen.GenerateJWT(user.Id.Hex(), user.Email, user.MetamaskAddress, user.Role, 0)
+	token, err := authen.GenerateJWT(user.Id.Hex(), user.Email, wallet, user.Role, user.SuperAdmin, sessionId, 0)
 	if err != nil {
 		return nil, err
 	}
-	refresh, err := authen.GenerateRefreshJWT(user.Id.Hex(), user.Email, token.Token)
+	refresh, err := authen.GenerateRefreshJWT(user.Id.Hex(), user.Email, token.Token, sessionId, tokenId)
 	if err != nil {
 		return nil, err
 	}
-
-	// update refresh token in DB
-	updateRefreshTokenInDB(user.Id, refresh.Token, a)
 	signinData := SignInData{
 		Token:   token,
 		Refresh: refresh,
-		User:    &user,
+		User:    user,
 	}
 	return &signinData, nil
 }
 
-func (a *AuthenDb) Signout(userId string) error {
-	id, err := primitive.ObjectIDFromHex(userId)
-	if err != nil {
-		return err
-	}
-	res := a.col.FindOne(a.ctx, genfilter("_id", id))
-	user := User{}
-	err = res.Decode(&user)
-	if err != nil {
-		return err
-	}
-	user.Refreshtoken = ""
-	update := bson.D{bson.E{Key: "$set", Value: user}}
-	updateRes, err := a.col.UpdateByID(a.ctx, id, update)
-	if err != nil {
-		return err
-	}
-	if updateRes.ModifiedCount <= 0 {
-		return fmt.Errorf("signout -> update user error")
-	}
-	return nil
-}
-
-// helper
-func updateRefreshTokenInDB(userId primitive.ObjectID, refreshToken string, authDb *AuthenDb) error {
-	filter := genfilter("_id", userId)
-	update := bson.D{bson.E{Key: "$set", Value: bson.M{"refresh_token": refreshToken}}}
-	res := authDb.col.FindOneAndUpdate(authDb.ctx, filter, update)
-	if res.Err() != nil {
-		fmt.Printf("res: %v\n", res.Err().Error())
-	}
-	return res.Err()
-}
-
 func genfilter(key string, value interface{}) primitive.D {
 	return bson.D{bson.E{Key: key, Value: value}}
 }
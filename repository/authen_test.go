@@ -0,0 +1,178 @@
+package repository_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/seenark/super-backend-temp/authen"
+	"github.com/seenark/super-backend-temp/repository"
+)
+
+func TestAuthenSignin(t *testing.T) {
+	eachBackend(t, func(t *testing.T, repos repository.Repositories) {
+		for _, user := range []repository.User{
+			{Email: "user@example.com", Password: "secret", Role: repository.USER_ROLE},
+			{Email: "admin@example.com", Password: "secret", Role: repository.ADMIN_ROLE},
+			{Email: "logger@example.com", Password: "secret", Role: repository.EVENT_LOGGER_ROLE},
+		} {
+			_, err := repos.Users.Create(ctx, user)
+			checkError(t, err, nil)
+		}
+		client := repository.ClientInfo{Device: "test", IP: "127.0.0.1"}
+
+		tests := []struct {
+			name     string
+			signin   func() (*repository.SignInData, error)
+			wantFail bool
+		}{
+			{"user", func() (*repository.SignInData, error) {
+				return repos.Authen.Signin(ctx, "user@example.com", "secret", client)
+			}, false},
+			{"wrong password", func() (*repository.SignInData, error) {
+				return repos.Authen.Signin(ctx, "user@example.com", "wrong", client)
+			}, true},
+			{"unknown email", func() (*repository.SignInData, error) {
+				return repos.Authen.Signin(ctx, "nobody@example.com", "secret", client)
+			}, true},
+			{"admin", func() (*repository.SignInData, error) {
+				return repos.Authen.AdminSignin(ctx, "admin@example.com", "secret", client)
+			}, false},
+			{"admin sign-in of a user", func() (*repository.SignInData, error) {
+				return repos.Authen.AdminSignin(ctx, "user@example.com", "secret", client)
+			}, true},
+			{"event logger", func() (*repository.SignInData, error) {
+				return repos.Authen.EventLoggerSigin(ctx, "logger@example.com", "secret", client)
+			}, false},
+			{"event logger sign-in of an admin", func() (*repository.SignInData, error) {
+				return repos.Authen.EventLoggerSigin(ctx, "admin@example.com", "secret", client)
+			}, true},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				data, err := tt.signin()
+				if tt.wantFail {
+					if err == nil {
+						t.Fatal("signed in")
+					}
+					return
+				}
+				checkError(t, err, nil)
+				if data.Token == nil || data.Refresh == nil {
+					t.Fatal("tokens are missing")
+				}
+				sessions := repos.Sessions.GetActiveByUserId(ctx, data.User.Id.Hex())
+				if len(sessions) != 1 || sessions[0].Device != client.Device {
+					t.Fatalf("sessions = %v", sessions)
+				}
+			})
+		}
+	})
+}
+
+func TestAuthenWalletSignin(t *testing.T) {
+	eachBackend(t, func(t *testing.T, repos repository.Repositories) {
+		address := "0xAbCdEf0000000000000000000000000000000001"
+		client := repository.ClientInfo{}
+		// an address set at registration is not proven, it signs no one in
+		squatter, err := repos.Users.Create(ctx, repository.User{Email: "squatter@example.com", Password: "secret", Role: repository.USER_ROLE, MetamaskAddress: address})
+		checkError(t, err, nil)
+
+		_, err = repos.Authen.WalletSignin(ctx, address, false, client)
+		checkError(t, err, repository.ErrNotFound)
+
+		first, err := repos.Authen.WalletSignin(ctx, address, true, client)
+		checkError(t, err, nil)
+		if first.User.Role != repository.USER_ROLE || !first.User.WalletVerified {
+			t.Fatalf("user = %+v", first.User)
+		}
+		if first.User.Id == squatter.Id {
+			t.Fatal("signed in as the account that registered the address")
+		}
+		// the address matches whatever its case
+		again, err := repos.Authen.WalletSignin(ctx, strings.ToLower(address), true, client)
+		checkError(t, err, nil)
+		if again.User.Id != first.User.Id {
+			t.Fatal("a second account was registered")
+		}
+		// wallet users have no email, that must not collide
+		_, err = repos.Authen.WalletSignin(ctx, "0x0000000000000000000000000000000000000002", true, client)
+		checkError(t, err, nil)
+	})
+}
+
+func TestAuthenWalletClaim(t *testing.T) {
+	eachBackend(t, func(t *testing.T, repos repository.Repositories) {
+		address := "0xAbCdEf0000000000000000000000000000000001"
+		proven := "0xAbCdEf0000000000000000000000000000000002"
+		// anyone can register with the address of someone else
+		_, err := repos.Users.Create(ctx, repository.User{Email: "user@example.com", Password: "secret", Role: repository.USER_ROLE, MetamaskAddress: address})
+		checkError(t, err, nil)
+		_, err = repos.Users.Create(ctx, repository.User{Email: "owner@example.com", Password: "secret", Role: repository.USER_ROLE, MetamaskAddress: proven, WalletVerified: true})
+		checkError(t, err, nil)
+		client := repository.ClientInfo{}
+
+		tests := []struct {
+			name   string
+			signin func() (*repository.SignInData, error)
+			want   string
+		}{
+			{"password sign-in with an unproven address", func() (*repository.SignInData, error) {
+				return repos.Authen.Signin(ctx, "user@example.com", "secret", client)
+			}, ""},
+			{"password sign-in with a proven address", func() (*repository.SignInData, error) {
+				return repos.Authen.Signin(ctx, "owner@example.com", "secret", client)
+			}, proven},
+			{"wallet sign-in", func() (*repository.SignInData, error) {
+				return repos.Authen.WalletSignin(ctx, address, true, client)
+			}, address},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				data, err := tt.signin()
+				checkError(t, err, nil)
+				refreshed, err := repos.Authen.NewAccessTokenAndRefreshToken(ctx, data.User.Id.Hex(), data.Refresh.Token, client)
+				checkError(t, err, nil)
+				for _, token := range []*authen.TokenData{data.Token, refreshed.Token} {
+					claims, err := authen.ValidateJWT(token.Token)
+					checkError(t, err, nil)
+					if claims.MetamaskAddress != tt.want {
+						t.Fatalf("metamask claim = %q, want %q", claims.MetamaskAddress, tt.want)
+					}
+				}
+			})
+		}
+	})
+}
+
+func TestAuthenRefreshAndSignout(t *testing.T) {
+	eachBackend(t, func(t *testing.T, repos repository.Repositories) {
+		_, err := repos.Users.Create(ctx, repository.User{Email: "user@example.com", Password: "secret", Role: repository.USER_ROLE})
+		checkError(t, err, nil)
+		client := repository.ClientInfo{Device: "test"}
+		signin, err := repos.Authen.Signin(ctx, "user@example.com", "secret", client)
+		checkError(t, err, nil)
+		userId := signin.User.Id.Hex()
+
+		refreshed, err := repos.Authen.NewAccessTokenAndRefreshToken(ctx, userId, signin.Refresh.Token, client)
+		checkError(t, err, nil)
+
+		// the old refresh token was used already, the session is revoked
+		_, err = repos.Authen.NewAccessTokenAndRefreshToken(ctx, userId, signin.Refresh.Token, client)
+		checkError(t, err, repository.ErrRefreshTokenReused)
+		_, err = repos.Authen.NewAccessTokenAndRefreshToken(ctx, userId, refreshed.Refresh.Token, client)
+		if err == nil {
+			t.Fatal("refreshed a revoked session")
+		}
+
+		_, err = repos.Authen.Signin(ctx, "user@example.com", "secret", client)
+		checkError(t, err, nil)
+		sessions := repos.Sessions.GetActiveByUserId(ctx, userId)
+		if len(sessions) != 1 {
+			t.Fatalf("%d active sessions, want 1", len(sessions))
+		}
+		err = repos.Authen.Signout(ctx, userId, sessions[0].Id)
+		checkError(t, err, nil)
+		err = repos.Authen.Signout(ctx, userId, sessions[0].Id)
+		checkError(t, err, repository.ErrNotFound)
+	})
+}
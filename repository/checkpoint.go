@@ -0,0 +1,64 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	CHECKPOINT_COLLECTION_NAME = "indexer_checkpoints"
+)
+
+// Checkpoint is the last block a chain indexer has fully processed. The hash
+// is kept to notice when that block was replaced by a reorg.
+type Checkpoint struct {
+	Name        string    `bson:"_id" json:"name"`
+	BlockNumber uint64    `bson:"block_number" json:"block_number"`
+	BlockHash   string    `bson:"block_hash" json:"block_hash"`
+	UpdatedAt   time.Time `bson:"updated_at" json:"updated_at"`
+}
+
+type CheckpointRepository interface {
+	Get(ctx context.Context, name string) (*Checkpoint, error)
+	Save(ctx context.Context, checkpoint Checkpoint) (*Checkpoint, error)
+}
+
+type CheckpointDb struct {
+	col *mongo.Collection
+}
+
+func NewCheckpointDb(db *mongo.Database) CheckpointRepository {
+	return CheckpointDb{
+		col: db.Collection(CHECKPOINT_COLLECTION_NAME),
+	}
+}
+
+// Get implements CheckpointRepository
+func (c CheckpointDb) Get(ctx context.Context, name string) (*Checkpoint, error) {
+	res := c.col.FindOne(ctx, genfilter("_id", name))
+	checkpoint := Checkpoint{}
+	err := res.Decode(&checkpoint)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	return &checkpoint, nil
+}
+
+// Save implements CheckpointRepository
+func (c CheckpointDb) Save(ctx context.Context, checkpoint Checkpoint) (*Checkpoint, error) {
+	checkpoint.UpdatedAt = time.Now()
+	update := bson.M{"$set": bson.M{
+		"block_number": checkpoint.BlockNumber,
+		"block_hash":   checkpoint.BlockHash,
+		"updated_at":   checkpoint.UpdatedAt,
+	}}
+	_, err := c.col.UpdateOne(ctx, genfilter("_id", checkpoint.Name), update, options.Update().SetUpsert(true))
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	return &checkpoint, nil
+}
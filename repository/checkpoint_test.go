@@ -0,0 +1,45 @@
+package repository_test
+
+import (
+	"testing"
+
+	"github.com/seenark/super-backend-temp/repository"
+)
+
+func TestCheckpoint(t *testing.T) {
+	eachBackend(t, func(t *testing.T, repos repository.Repositories) {
+		_, err := repos.Checkpoints.Get(ctx, "redeem")
+		checkError(t, err, repository.ErrNotFound)
+
+		tests := []struct {
+			name       string
+			checkpoint repository.Checkpoint
+		}{
+			{"first save", repository.Checkpoint{Name: "redeem", BlockNumber: 10, BlockHash: "0x10"}},
+			{"later block", repository.Checkpoint{Name: "redeem", BlockNumber: 20, BlockHash: "0x20"}},
+			// after a reorg the indexer steps back
+			{"earlier block", repository.Checkpoint{Name: "redeem", BlockNumber: 18, BlockHash: "0x18"}},
+			{"another indexer", repository.Checkpoint{Name: "transfer", BlockNumber: 5, BlockHash: "0x5"}},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				saved, err := repos.Checkpoints.Save(ctx, tt.checkpoint)
+				checkError(t, err, nil)
+				if saved.UpdatedAt.IsZero() {
+					t.Fatal("updated at was not set")
+				}
+				found, err := repos.Checkpoints.Get(ctx, tt.checkpoint.Name)
+				checkError(t, err, nil)
+				if found.BlockNumber != tt.checkpoint.BlockNumber || found.BlockHash != tt.checkpoint.BlockHash {
+					t.Fatalf("found %+v, want %+v", found, tt.checkpoint)
+				}
+			})
+		}
+
+		found, err := repos.Checkpoints.Get(ctx, "redeem")
+		checkError(t, err, nil)
+		if found.BlockNumber != 18 {
+			t.Fatalf("another indexer moved this one to %d", found.BlockNumber)
+		}
+	})
+}
@@ -7,7 +7,6 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type DigitalCertType struct {
@@ -20,17 +19,17 @@ type DigitalCertType struct {
 }
 
 type IDigitalCertTypeRepository interface {
-	GetAll() []DigitalCertType
-	GetByTypeCode(string) (*DigitalCertType, error)
-	Create(typeCode string, typeName string, logoImageName string, typeOfUnit string, unit string, vintageYear string) (*DigitalCertType, error)
-	Update(string, DigitalCertType) (*DigitalCertType, error)
-	Delete(string) (*DigitalCertType, error)
+	GetAll(ctx context.Context) []DigitalCertType
+	GetPage(ctx context.Context, query PageQuery) ([]DigitalCertType, *Page, error)
+	GetByTypeCode(context.Context, string) (*DigitalCertType, error)
+	Create(ctx context.Context, typeCode string, typeName string, logoImageName string, typeOfUnit string, unit string, vintageYear string) (*DigitalCertType, error)
+	Update(context.Context, string, DigitalCertType) (*DigitalCertType, error)
+	Delete(context.Context, string) (*DigitalCertType, error)
 	FindInArrayByTypeCode(string, []DigitalCertType) (int, *DigitalCertType)
 }
 
 type DigitalCertTypeDb struct {
 	col *mongo.Collection
-	ctx context.Context
 }
 
 const (
@@ -38,7 +37,7 @@ const (
 )
 
 // Create implements IDigitalCertTypeRepository
-func (d DigitalCertTypeDb) Create(typeCode string, typeName string, logoImageName string, typeOfUnit string, unit string, vintageYear string) (*DigitalCertType, error) {
+func (d DigitalCertTypeDb) Create(ctx context.Context, typeCode string, typeName string, logoImageName string, typeOfUnit string, unit string, vintageYear string) (*DigitalCertType, error) {
 	certType := DigitalCertType{
 		TypeCode:      typeCode,
 		TypeName:      typeName,
@@ -47,34 +46,44 @@ func (d DigitalCertTypeDb) Create(typeCode string, typeName string, logoImageNam
 		Unit:          unit,
 		VintageYear:   vintageYear,
 	}
-	_, err := d.col.InsertOne(d.ctx, certType)
+	err := withTransaction(ctx, d.col.Database().Client(), func(ctx context.Context) error {
+		_, err := d.col.InsertOne(ctx, certType)
+		if err != nil {
+			return wrapError(err)
+		}
+		event, err := NewOutboxEvent(CERT_TYPE_CREATED_EVENT, typeCode, certType)
+		if err != nil {
+			return err
+		}
+		return insertOutbox(ctx, d.col.Database(), event)
+	})
 	if err != nil {
-		return nil, err
+		return nil, wrapError(err)
 	}
 	return &certType, nil
 }
 
 // Delte implements IDigitalCertTypeRepository
-func (d DigitalCertTypeDb) Delete(typeCode string) (*DigitalCertType, error) {
+func (d DigitalCertTypeDb) Delete(ctx context.Context, typeCode string) (*DigitalCertType, error) {
 	filter := genfilter("type_code", typeCode)
 
-	res := d.col.FindOneAndDelete(d.ctx, filter)
+	res := d.col.FindOneAndDelete(ctx, filter)
 	certType := DigitalCertType{}
 	err := res.Decode(&certType)
 	if err != nil {
-		return nil, err
+		return nil, wrapError(err)
 	}
 	return &certType, nil
 }
 
 // GetAll implements IDigitalCertTypeRepository
-func (d DigitalCertTypeDb) GetAll() []DigitalCertType {
+func (d DigitalCertTypeDb) GetAll(ctx context.Context) []DigitalCertType {
 	certTypes := []DigitalCertType{}
-	cur, err := d.col.Find(d.ctx, bson.M{})
+	cur, err := d.col.Find(ctx, bson.M{})
 	if err != nil {
 		return certTypes
 	}
-	for cur.Next(d.ctx) {
+	for cur.Next(ctx) {
 		certType := DigitalCertType{}
 		err := cur.Decode(&certType)
 		if err != nil {
@@ -86,31 +95,41 @@ func (d DigitalCertTypeDb) GetAll() []DigitalCertType {
 	return certTypes
 }
 
+// GetPage implements IDigitalCertTypeRepository
+func (d DigitalCertTypeDb) GetPage(ctx context.Context, query PageQuery) ([]DigitalCertType, *Page, error) {
+	certTypes := []DigitalCertType{}
+	page, err := findPage(ctx, d.col, bson.M{}, query, DigitalCertTypeSortFields, "type_code", &certTypes)
+	if err != nil {
+		return nil, nil, err
+	}
+	return certTypes, page, nil
+}
+
 // GetByTypeCode implements IDigitalCertTypeRepository
-func (d DigitalCertTypeDb) GetByTypeCode(typeCode string) (*DigitalCertType, error) {
+func (d DigitalCertTypeDb) GetByTypeCode(ctx context.Context, typeCode string) (*DigitalCertType, error) {
 	filter := genfilter("type_code", typeCode)
 
-	res := d.col.FindOne(d.ctx, filter)
+	res := d.col.FindOne(ctx, filter)
 	certType := DigitalCertType{}
 	err := res.Decode(&certType)
 	if err != nil {
-		return nil, err
+		return nil, wrapError(err)
 	}
 	return &certType, nil
 }
 
 // Update implements IDigitalCertTypeRepository
-func (d DigitalCertTypeDb) Update(typeCode string, newCertType DigitalCertType) (*DigitalCertType, error) {
+func (d DigitalCertTypeDb) Update(ctx context.Context, typeCode string, newCertType DigitalCertType) (*DigitalCertType, error) {
 	filter := genfilter("type_code", typeCode)
 
 	updateObj := bson.D{primitive.E{Key: "$set", Value: newCertType}}
 
-	upRes, err := d.col.UpdateOne(d.ctx, filter, updateObj)
+	upRes, err := d.col.UpdateOne(ctx, filter, updateObj)
 	if err != nil {
-		return nil, err
+		return nil, wrapError(err)
 	}
 	if upRes.ModifiedCount == 0 {
-		return nil, fmt.Errorf("no update any")
+		return nil, ErrNoChange
 	}
 	return &newCertType, nil
 }
@@ -126,23 +145,7 @@ func (d DigitalCertTypeDb) FindInArrayByTypeCode(typeCode string, certTypes []Di
 
 func NewDigitalCertTypeDb(db *mongo.Database) IDigitalCertTypeRepository {
 	col := db.Collection(DIGITAL_CERT_TYPE_COLLECTION_NAME)
-	makeTypeCodeAsIndexes(col)
 	return DigitalCertTypeDb{
 		col: col,
-		ctx: context.Background(),
-	}
-}
-
-func makeTypeCodeAsIndexes(collection *mongo.Collection) {
-	indexName, err := collection.Indexes().CreateOne(
-		context.Background(),
-		mongo.IndexModel{
-			Keys:    bson.D{{Key: "type_code", Value: 1}},
-			Options: options.Index().SetUnique(true),
-		},
-	)
-	if err != nil {
-		panic(err)
 	}
-	fmt.Println("index name:", indexName)
 }
@@ -0,0 +1,99 @@
+package repository_test
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/seenark/super-backend-temp/repository"
+)
+
+func TestDigitalCertType(t *testing.T) {
+	eachBackend(t, func(t *testing.T, repos repository.Repositories) {
+		for _, code := range []string{"REC", "CARBON"} {
+			_, err := repos.CertTypes.Create(ctx, code, code+" certificate", "", "MWh", "1", "2022")
+			checkError(t, err, nil)
+		}
+		_, err := repos.CertTypes.Create(ctx, "REC", "again", "", "", "", "")
+		checkError(t, err, repository.ErrDuplicate)
+		if types := outboxTypes(t, repos); !equalStrings(types, []string{repository.CERT_TYPE_CREATED_EVENT, repository.CERT_TYPE_CREATED_EVENT}) {
+			t.Fatalf("outbox = %v", types)
+		}
+
+		all := repos.CertTypes.GetAll(ctx)
+		if len(all) != 2 {
+			t.Fatalf("%d types, want 2", len(all))
+		}
+		i, found := repos.CertTypes.FindInArrayByTypeCode("CARBON", all)
+		if found.TypeCode != "CARBON" || all[i].TypeCode != "CARBON" {
+			t.Fatalf("found %d %v", i, found)
+		}
+		page, _, err := repos.CertTypes.GetPage(ctx, repository.PageQuery{})
+		checkError(t, err, nil)
+		if page[0].TypeCode != "CARBON" {
+			t.Fatalf("page = %v, want sorted by type code", page)
+		}
+
+		rec, err := repos.CertTypes.GetByTypeCode(ctx, "REC")
+		checkError(t, err, nil)
+		changed := *rec
+		changed.Unit = "kWh"
+
+		tests := []struct {
+			name     string
+			typeCode string
+			update   repository.DigitalCertType
+			err      error
+		}{
+			{"changed", "REC", changed, nil},
+			{"unchanged", "REC", changed, repository.ErrNoChange},
+			{"unknown type", "NOPE", changed, repository.ErrNoChange},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				_, err := repos.CertTypes.Update(ctx, tt.typeCode, tt.update)
+				checkError(t, err, tt.err)
+			})
+		}
+		rec, err = repos.CertTypes.GetByTypeCode(ctx, "REC")
+		checkError(t, err, nil)
+		if rec.Unit != "kWh" {
+			t.Fatalf("unit = %q", rec.Unit)
+		}
+
+		_, err = repos.CertTypes.Delete(ctx, "REC")
+		checkError(t, err, nil)
+		_, err = repos.CertTypes.Delete(ctx, "REC")
+		checkError(t, err, repository.ErrNotFound)
+		_, err = repos.CertTypes.GetByTypeCode(ctx, "REC")
+		checkError(t, err, repository.ErrNotFound)
+	})
+}
+
+// outboxTypes claims every due outbox event and returns their types sorted,
+// events written together are due at the same time and claimed in any order
+func outboxTypes(t *testing.T, repos repository.Repositories) []string {
+	t.Helper()
+	types := []string{}
+	for {
+		event, err := repos.Outbox.Claim(ctx, time.Hour)
+		checkError(t, err, nil)
+		if event == nil {
+			sort.Strings(types)
+			return types
+		}
+		types = append(types, event.Type)
+	}
+}
+
+func equalInts(a []int, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
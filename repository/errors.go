@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// errors every repository returns instead of driver errors, handlers map
+// them to status codes
+var (
+	ErrNotFound  = errors.New("not found")
+	ErrDuplicate = errors.New("already exists")
+	ErrNoChange  = errors.New("nothing was changed")
+	ErrConflict  = errors.New("changed by someone else")
+	// the context of the query ended before mongo answered
+	ErrTimeout = errors.New("query timed out")
+)
+
+// kindError is an error with its own message that is one of the errors
+// above for errors.Is
+type kindError struct {
+	kind    error
+	message string
+}
+
+func newKindError(kind error, message string) error {
+	return kindError{kind: kind, message: message}
+}
+
+func (e kindError) Error() string {
+	return e.message
+}
+
+func (e kindError) Is(target error) bool {
+	return target == e.kind
+}
+
+// wrapError turns a driver error into one of the errors above, the driver
+// error is kept in the message of duplicates and timeouts for the logs
+func wrapError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrNotFound
+	}
+	if mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("%w: %v", ErrDuplicate, err)
+	}
+	if mongo.IsTimeout(err) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return fmt.Errorf("%w: %v", ErrTimeout, err)
+	}
+	return err
+}
+
+// parseObjectId is primitive.ObjectIDFromHex for ids from a request, an id
+// that is not an ObjectID can not be found
+func parseObjectId(id string) (primitive.ObjectID, error) {
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return primitive.NilObjectID, ErrNotFound
+	}
+	return objectId, nil
+}
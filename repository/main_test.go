@@ -0,0 +1,42 @@
+package repository_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/seenark/super-backend-temp/repository"
+	"github.com/seenark/super-backend-temp/repository/repotest"
+)
+
+func TestMain(m *testing.M) {
+	// the config, and the jwt keys in it, are read relative to the repo root
+	err := os.Chdir("..")
+	if err != nil {
+		panic(err)
+	}
+	os.Exit(repotest.Run(m))
+}
+
+// eachBackend runs fn on empty repositories of every backend
+func eachBackend(t *testing.T, fn func(t *testing.T, repos repository.Repositories)) {
+	for _, backend := range repotest.Backends(t) {
+		backend := backend
+		t.Run(backend.Name, func(t *testing.T) {
+			fn(t, backend.New(t))
+		})
+	}
+}
+
+func checkError(t *testing.T, err error, want error) {
+	t.Helper()
+	if want == nil && err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want != nil && !errors.Is(err, want) {
+		t.Fatalf("error = %v, want %v", err, want)
+	}
+}
+
+var ctx = context.Background()
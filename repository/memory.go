@@ -0,0 +1,273 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryStore keeps every collection in memory, for tests and for running
+// without mongo. One lock guards all of them so a change and its outbox
+// events are written together, as in a transaction. The unique indexes of
+// the mongo collections are checked too.
+type MemoryStore struct {
+	mu          sync.Mutex
+	users       []User
+	sessions    []Session
+	nonces      []Nonce
+	apiKeys     []ApiKey
+	roles       []Role
+	certTypes   []DigitalCertType
+	metadata    []Metadata
+	redeemed    []Redeemed
+	outbox      []OutboxEvent
+	webhooks    []Webhook
+	deliveries  []WebhookDelivery
+	checkpoints []Checkpoint
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+// NewMemoryRepositories builds the repositories on store
+func NewMemoryRepositories(store *MemoryStore) Repositories {
+	sessions := MemorySessionDb{store: store}
+	return Repositories{
+		Users:             MemoryUserDb{store: store},
+		Authen:            &AuthenDb{users: memoryAuthUsers{store: store}, sessions: sessions},
+		Sessions:          sessions,
+		Nonces:            MemoryNonceDb{store: store},
+		ApiKeys:           MemoryApiKeyDb{store: store},
+		Roles:             MemoryRoleDb{store: store},
+		CertTypes:         MemoryDigitalCertTypeDb{store: store},
+		Metadata:          MemoryMetadataDb{store: store},
+		Redeemed:          MemoryRedeemedDb{store: store},
+		Outbox:            MemoryOutboxDb{store: store},
+		Webhooks:          MemoryWebhookDb{store: store},
+		WebhookDeliveries: MemoryWebhookDeliveryDb{store: store},
+		Checkpoints:       MemoryCheckpointDb{store: store},
+	}
+}
+
+// lock takes the store lock, a done ctx fails like a query that timed out
+func (s *MemoryStore) lock(ctx context.Context) error {
+	err := ctx.Err()
+	if err != nil {
+		return wrapError(err)
+	}
+	s.mu.Lock()
+	return nil
+}
+
+func (s *MemoryStore) unlock() {
+	s.mu.Unlock()
+}
+
+// addOutbox is insertOutbox of the memory store, the lock must be held
+func (s *MemoryStore) addOutbox(events ...OutboxEvent) {
+	for _, v := range events {
+		v.Done = append([]string{}, v.Done...)
+		s.outbox = append(s.outbox, v)
+	}
+}
+
+func duplicateError(field string, value interface{}) error {
+	return fmt.Errorf("%w: %s %v", ErrDuplicate, field, value)
+}
+
+// memoryPage is findPage on a slice: items is a []T that is sorted like mongo
+// would, out is a *[]T the page is copied into
+func memoryPage(items interface{}, query PageQuery, sortFields []string, defaultSort string, out interface{}) (*Page, error) {
+	bounds, err := newPageBounds(query, sortFields, defaultSort)
+	if err != nil {
+		return nil, err
+	}
+
+	all := reflect.ValueOf(items)
+	docs := make([]bson.M, all.Len())
+	order := make([]int, all.Len())
+	for i := range docs {
+		docs[i], err = toBsonM(all.Index(i).Interface())
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := docs[i]["_id"]; !ok {
+			// mongo made up the _id, it grows with insertion order
+			docs[i]["_id"] = int64(i)
+		}
+		order[i] = i
+	}
+	// compare orders a doc before a cursor by the sort keys
+	compare := func(doc bson.M, value interface{}, id interface{}) int {
+		for _, key := range bounds.sort {
+			other := value
+			if key.Key == "_id" {
+				other = id
+			}
+			c := compareBsonValues(doc[key.Key], other)
+			if c != 0 {
+				return c * key.Value.(int)
+			}
+		}
+		return 0
+	}
+	sort.SliceStable(order, func(a int, b int) bool {
+		other := docs[order[b]]
+		return compare(docs[order[a]], other[bounds.sort[0].Key], other["_id"]) < 0
+	})
+
+	start := bounds.skip
+	total := int64(len(order))
+	if bounds.after != nil {
+		start = len(order)
+		for i, v := range order {
+			if compare(docs[v], bounds.after.Value, bounds.after.Id) > 0 {
+				start = i
+				break
+			}
+		}
+	}
+	page := reflect.MakeSlice(all.Type(), 0, bounds.limit)
+	for i := start; i < len(order) && i < start+bounds.limit; i++ {
+		page = reflect.Append(page, all.Index(order[i]))
+	}
+	reflect.ValueOf(out).Elem().Set(page)
+
+	result := &Page{Total: total, Limit: bounds.limit}
+	if end := start + bounds.limit; end < len(order) {
+		last := docs[order[end-1]]
+		next := cursor{Sort: bounds.sortName, Value: last[bounds.sort[0].Key], Id: last["_id"]}
+		result.NextCursor, err = next.encode()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// compareBsonValues orders two decoded bson values the way mongo sorts them:
+// null, numbers, strings, object ids, booleans and then dates
+func compareBsonValues(a interface{}, b interface{}) int {
+	rankA, rankB := bsonSortRank(a), bsonSortRank(b)
+	if rankA != rankB {
+		return rankA - rankB
+	}
+	switch v := a.(type) {
+	case string:
+		return strings.Compare(v, b.(string))
+	case primitive.ObjectID:
+		id := b.(primitive.ObjectID)
+		return bytes.Compare(v[:], id[:])
+	case bool:
+		if v == b.(bool) {
+			return 0
+		}
+		if !v {
+			return -1
+		}
+		return 1
+	case primitive.DateTime:
+		return compareFloats(float64(v), float64(b.(primitive.DateTime)))
+	}
+	if rankA == 1 {
+		return compareFloats(toFloat(a), toFloat(b))
+	}
+	return 0
+}
+
+func bsonSortRank(v interface{}) int {
+	switch v.(type) {
+	case nil:
+		return 0
+	case int32, int64, float64:
+		return 1
+	case string:
+		return 2
+	case primitive.ObjectID:
+		return 3
+	case bool:
+		return 4
+	case primitive.DateTime:
+		return 5
+	}
+	return 6
+}
+
+func toFloat(v interface{}) float64 {
+	switch n := v.(type) {
+	case int32:
+		return float64(n)
+	case int64:
+		return float64(n)
+	case float64:
+		return n
+	}
+	return 0
+}
+
+func compareFloats(a float64, b float64) int {
+	if a < b {
+		return -1
+	}
+	if a > b {
+		return 1
+	}
+	return 0
+}
+
+// matches is toFilter for the memory store
+func (f RedeemedFilter) matches(r Redeemed) bool {
+	if len(f.ApproveStatus) > 0 && !contains(f.ApproveStatus, r.ApproveStatus) {
+		return false
+	}
+	if f.Name != "" && r.Name != f.Name {
+		return false
+	}
+	if f.Email != "" && r.Email != f.Email {
+		return false
+	}
+	if len(f.RedeemId) > 0 && !containsInt(f.RedeemId, r.RedeemId) {
+		return false
+	}
+	if f.StartDate > 0 && f.EndDate > 0 {
+		if r.RedeemDate < f.StartDate || r.RedeemDate >= f.EndDate {
+			return false
+		}
+	} else if f.StartDate > 0 && r.RedeemDate < f.StartDate {
+		return false
+	} else if f.EndDate > 0 && f.StartDate == 0 && r.RedeemDate > f.EndDate {
+		return false
+	}
+	if f.WalletAddress != "" && r.WalletAddress != f.WalletAddress {
+		return false
+	}
+	if f.ToBlock > 0 && (r.BlockNumber == 0 || r.BlockNumber < f.FromBlock || r.BlockNumber > f.ToBlock) {
+		return false
+	}
+	return true
+}
+
+// matches is the mongo filter of MetadataDb.GetPage for the memory store
+func (f MetadataFilter) matches(m Metadata) bool {
+	if len(f.Ids) > 0 && !containsInt(f.Ids, m.DigitalCertID) {
+		return false
+	}
+	return f.TypeCode == "" || m.TypeCode == f.TypeCode
+}
+
+func containsInt(list []int, value int) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
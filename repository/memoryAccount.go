@@ -0,0 +1,644 @@
+package repository
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/seenark/super-backend-temp/authen"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type MemoryUserDb struct {
+	store *MemoryStore
+}
+
+// GetPage implements UserRepository
+func (u MemoryUserDb) GetPage(ctx context.Context, query PageQuery) ([]User, *Page, error) {
+	err := u.store.lock(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer u.store.unlock()
+	users := []User{}
+	page, err := memoryPage(u.store.users, query, UserSortFields, "_id", &users)
+	if err != nil {
+		return nil, nil, err
+	}
+	return users, page, nil
+}
+
+// GetById implements UserRepository
+func (u MemoryUserDb) GetById(ctx context.Context, userId string) (*User, error) {
+	id, err := parseObjectId(userId)
+	if err != nil {
+		return nil, err
+	}
+	err = u.store.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer u.store.unlock()
+	i := u.store.userIndex(id)
+	if i < 0 {
+		return nil, ErrNotFound
+	}
+	user := u.store.users[i]
+	return &user, nil
+}
+
+// GetByEmail implements UserRepository
+func (u MemoryUserDb) GetByEmail(ctx context.Context, email string) (*User, error) {
+	if email == "" {
+		return nil, ErrNotFound
+	}
+	err := u.store.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer u.store.unlock()
+	for _, v := range u.store.users {
+		if v.Email == email {
+			user := v
+			return &user, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// Create implements UserRepository
+func (u MemoryUserDb) Create(ctx context.Context, newUser User) (*User, error) {
+	var err error
+	newUser.Id = primitive.NewObjectID()
+	newUser.Password, err = authen.HashPassword(newUser.Password)
+	if err != nil {
+		return nil, err
+	}
+	err = u.store.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer u.store.unlock()
+	err = u.store.insertUser(newUser)
+	if err != nil {
+		return nil, err
+	}
+	return &newUser, nil
+}
+
+// Update implements UserRepository
+func (u MemoryUserDb) Update(ctx context.Context, newUser User) (*User, error) {
+	user, err := u.GetById(ctx, newUser.Id.Hex())
+	if err != nil {
+		return nil, err
+	}
+	if newUser.Name != "" {
+		user.Name = newUser.Name
+	}
+	if newUser.Email != "" {
+		user.Email = newUser.Email
+	}
+	if newUser.MetamaskAddress != "" {
+		user.MetamaskAddress = newUser.MetamaskAddress
+	}
+	if newUser.Role != "" {
+		user.Role = newUser.Role
+	}
+	if newUser.Tel != "" {
+		user.Tel = newUser.Tel
+	}
+	if newUser.Address != "" {
+		user.Address = newUser.Address
+	}
+	if newUser.Password != "" {
+		user.Password, err = authen.HashPassword(newUser.Password)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = u.store.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer u.store.unlock()
+	i := u.store.userIndex(user.Id)
+	if i < 0 || reflect.DeepEqual(u.store.users[i], *user) {
+		return nil, ErrNoChange
+	}
+	if user.Email != "" && user.Email != u.store.users[i].Email && u.store.emailTaken(user.Email) {
+		return nil, duplicateError("email", user.Email)
+	}
+	u.store.users[i] = *user
+	return user, nil
+}
+
+// Delete implements UserRepository
+func (u MemoryUserDb) Delete(ctx context.Context, userId string) (*User, error) {
+	id, err := parseObjectId(userId)
+	if err != nil {
+		return nil, err
+	}
+	err = u.store.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer u.store.unlock()
+	i := u.store.userIndex(id)
+	if i < 0 {
+		return nil, ErrNotFound
+	}
+	user := u.store.users[i]
+	u.store.users = append(u.store.users[:i], u.store.users[i+1:]...)
+	return &user, nil
+}
+
+func (s *MemoryStore) userIndex(id primitive.ObjectID) int {
+	for i, v := range s.users {
+		if v.Id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// only non-empty emails are unique, wallet users have none
+func (s *MemoryStore) emailTaken(email string) bool {
+	for _, v := range s.users {
+		if email != "" && v.Email == email {
+			return true
+		}
+	}
+	return false
+}
+
+// a wallet proven with SIWE belongs to one user
+func (s *MemoryStore) walletTaken(address string) bool {
+	for _, v := range s.users {
+		if v.WalletVerified && strings.EqualFold(v.MetamaskAddress, address) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *MemoryStore) insertUser(user User) error {
+	if s.emailTaken(user.Email) {
+		return duplicateError("email", user.Email)
+	}
+	if user.WalletVerified && s.walletTaken(user.MetamaskAddress) {
+		return duplicateError("metamask_address", user.MetamaskAddress)
+	}
+	s.users = append(s.users, user)
+	return nil
+}
+
+// memoryAuthUsers implements authUsers on the memory store
+type memoryAuthUsers struct {
+	store *MemoryStore
+}
+
+func (m memoryAuthUsers) findByEmail(ctx context.Context, email string, roles []string) (*User, error) {
+	return m.find(ctx, func(user User) bool {
+		return user.Email == email && (len(roles) == 0 || contains(roles, user.Role))
+	})
+}
+
+func (m memoryAuthUsers) findById(ctx context.Context, id primitive.ObjectID) (*User, error) {
+	return m.find(ctx, func(user User) bool {
+		return user.Id == id
+	})
+}
+
+func (m memoryAuthUsers) findByWallet(ctx context.Context, address string) (*User, error) {
+	return m.find(ctx, func(user User) bool {
+		return user.WalletVerified && strings.EqualFold(user.MetamaskAddress, address)
+	})
+}
+
+func (m memoryAuthUsers) find(ctx context.Context, match func(User) bool) (*User, error) {
+	err := m.store.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer m.store.unlock()
+	for _, v := range m.store.users {
+		if match(v) {
+			user := v
+			return &user, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m memoryAuthUsers) insertUser(ctx context.Context, user User) error {
+	err := m.store.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer m.store.unlock()
+	return m.store.insertUser(user)
+}
+
+type MemorySessionDb struct {
+	store *MemoryStore
+}
+
+// Create implements SessionRepository
+func (s MemorySessionDb) Create(ctx context.Context, session Session) (*Session, error) {
+	now := time.Now()
+	session.CreatedAt = now
+	session.LastUsedAt = now
+	err := s.store.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer s.store.unlock()
+	if s.store.sessionIndex(session.Id) >= 0 {
+		return nil, duplicateError("_id", session.Id)
+	}
+	s.store.sessions = append(s.store.sessions, session)
+	return &session, nil
+}
+
+// GetById implements SessionRepository
+func (s MemorySessionDb) GetById(ctx context.Context, sessionId string) (*Session, error) {
+	err := s.store.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer s.store.unlock()
+	i := s.store.sessionIndex(sessionId)
+	if i < 0 {
+		return nil, ErrNotFound
+	}
+	session := s.store.sessions[i]
+	return &session, nil
+}
+
+// GetActiveByUserId implements SessionRepository
+func (s MemorySessionDb) GetActiveByUserId(ctx context.Context, userId string) []Session {
+	sessions := []Session{}
+	id, err := parseObjectId(userId)
+	if err != nil {
+		return sessions
+	}
+	err = s.store.lock(ctx)
+	if err != nil {
+		return sessions
+	}
+	defer s.store.unlock()
+	now := time.Now()
+	for _, v := range s.store.sessions {
+		if v.UserId == id && v.RevokedAt == nil && v.ExpiresAt.After(now) {
+			sessions = append(sessions, v)
+		}
+	}
+	sort.SliceStable(sessions, func(i int, j int) bool {
+		return sessions[i].LastUsedAt.After(sessions[j].LastUsedAt)
+	})
+	return sessions
+}
+
+// Rotate implements SessionRepository
+func (s MemorySessionDb) Rotate(ctx context.Context, sessionId string, oldTokenId string, newTokenId string, client ClientInfo, expiresAt time.Time) (*Session, error) {
+	err := s.store.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer s.store.unlock()
+	i := s.store.sessionIndex(sessionId)
+	if i < 0 || s.store.sessions[i].TokenId != oldTokenId || s.store.sessions[i].RevokedAt != nil {
+		return nil, ErrNotFound
+	}
+	session := &s.store.sessions[i]
+	session.TokenId = newTokenId
+	session.Device = client.Device
+	session.IP = client.IP
+	session.LastUsedAt = time.Now()
+	session.ExpiresAt = expiresAt
+	rotated := *session
+	return &rotated, nil
+}
+
+// Revoke implements SessionRepository
+func (s MemorySessionDb) Revoke(ctx context.Context, userId string, sessionId string, reason string) error {
+	id, err := parseObjectId(userId)
+	if err != nil {
+		return err
+	}
+	err = s.store.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer s.store.unlock()
+	i := s.store.sessionIndex(sessionId)
+	if i < 0 || s.store.sessions[i].UserId != id || s.store.sessions[i].RevokedAt != nil {
+		return ErrNotFound
+	}
+	revokeSession(&s.store.sessions[i], reason)
+	return nil
+}
+
+// RevokeFamily implements SessionRepository
+func (s MemorySessionDb) RevokeFamily(ctx context.Context, sessionId string, reason string) error {
+	err := s.store.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer s.store.unlock()
+	i := s.store.sessionIndex(sessionId)
+	if i >= 0 && s.store.sessions[i].RevokedAt == nil {
+		revokeSession(&s.store.sessions[i], reason)
+	}
+	return nil
+}
+
+// RevokeAllByUserId implements SessionRepository
+func (s MemorySessionDb) RevokeAllByUserId(ctx context.Context, userId string, reason string) error {
+	id, err := parseObjectId(userId)
+	if err != nil {
+		return err
+	}
+	err = s.store.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer s.store.unlock()
+	for i, v := range s.store.sessions {
+		if v.UserId == id && v.RevokedAt == nil {
+			revokeSession(&s.store.sessions[i], reason)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) sessionIndex(id string) int {
+	for i, v := range s.sessions {
+		if v.Id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func revokeSession(session *Session, reason string) {
+	now := time.Now()
+	session.RevokedAt = &now
+	session.RevokeReason = reason
+}
+
+type MemoryNonceDb struct {
+	store *MemoryStore
+}
+
+// Create implements NonceRepository
+func (n MemoryNonceDb) Create(ctx context.Context, nonce string, expiresAt time.Time) (*Nonce, error) {
+	err := n.store.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer n.store.unlock()
+	// expired nonces go away like with the ttl index
+	now := time.Now()
+	nonces := []Nonce{}
+	for _, v := range n.store.nonces {
+		if v.Nonce == nonce && v.ExpiresAt.After(now) {
+			return nil, duplicateError("nonce", nonce)
+		}
+		if v.ExpiresAt.After(now) {
+			nonces = append(nonces, v)
+		}
+	}
+	newNonce := Nonce{Nonce: nonce, ExpiresAt: expiresAt}
+	n.store.nonces = append(nonces, newNonce)
+	return &newNonce, nil
+}
+
+// Consume implements NonceRepository, a nonce can only be consumed once
+func (n MemoryNonceDb) Consume(ctx context.Context, nonce string) error {
+	err := n.store.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer n.store.unlock()
+	for i, v := range n.store.nonces {
+		if v.Nonce == nonce && v.ExpiresAt.After(time.Now()) {
+			n.store.nonces = append(n.store.nonces[:i], n.store.nonces[i+1:]...)
+			return nil
+		}
+	}
+	return newKindError(ErrNotFound, "nonce is invalid or expired")
+}
+
+type MemoryApiKeyDb struct {
+	store *MemoryStore
+}
+
+// Create implements ApiKeyRepository
+func (a MemoryApiKeyDb) Create(ctx context.Context, apiKey ApiKey) (*ApiKey, error) {
+	apiKey.Id = primitive.NewObjectID()
+	apiKey.CreatedAt = time.Now()
+	if apiKey.Routes == nil {
+		apiKey.Routes = []string{}
+	}
+	err := a.store.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer a.store.unlock()
+	for _, v := range a.store.apiKeys {
+		if v.Hash == apiKey.Hash {
+			return nil, duplicateError("hash", apiKey.Prefix)
+		}
+	}
+	a.store.apiKeys = append(a.store.apiKeys, apiKey)
+	return &apiKey, nil
+}
+
+// GetAll implements ApiKeyRepository
+func (a MemoryApiKeyDb) GetAll(ctx context.Context) []ApiKey {
+	apiKeys := []ApiKey{}
+	err := a.store.lock(ctx)
+	if err != nil {
+		return apiKeys
+	}
+	defer a.store.unlock()
+	apiKeys = append(apiKeys, a.store.apiKeys...)
+	sort.SliceStable(apiKeys, func(i int, j int) bool {
+		return apiKeys[i].CreatedAt.After(apiKeys[j].CreatedAt)
+	})
+	return apiKeys
+}
+
+// GetByHash implements ApiKeyRepository
+func (a MemoryApiKeyDb) GetByHash(ctx context.Context, hash string) (*ApiKey, error) {
+	err := a.store.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer a.store.unlock()
+	for _, v := range a.store.apiKeys {
+		if v.Hash == hash {
+			apiKey := v
+			return &apiKey, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// Revoke implements ApiKeyRepository
+func (a MemoryApiKeyDb) Revoke(ctx context.Context, id string) (*ApiKey, error) {
+	objectId, err := parseObjectId(id)
+	if err != nil {
+		return nil, err
+	}
+	err = a.store.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer a.store.unlock()
+	for i, v := range a.store.apiKeys {
+		if v.Id == objectId && v.RevokedAt == nil {
+			now := time.Now()
+			a.store.apiKeys[i].RevokedAt = &now
+			apiKey := a.store.apiKeys[i]
+			return &apiKey, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// Touch implements ApiKeyRepository, it records the last time a key was used
+func (a MemoryApiKeyDb) Touch(ctx context.Context, id primitive.ObjectID) error {
+	err := a.store.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer a.store.unlock()
+	for i, v := range a.store.apiKeys {
+		if v.Id == id {
+			now := time.Now()
+			a.store.apiKeys[i].LastUsedAt = &now
+		}
+	}
+	return nil
+}
+
+type MemoryRoleDb struct {
+	store *MemoryStore
+}
+
+// GetAll implements RoleRepository
+func (r MemoryRoleDb) GetAll(ctx context.Context) []Role {
+	roles := []Role{}
+	err := r.store.lock(ctx)
+	if err != nil {
+		return roles
+	}
+	defer r.store.unlock()
+	roles = append(roles, r.store.roles...)
+	sort.SliceStable(roles, func(i int, j int) bool {
+		return roles[i].Name < roles[j].Name
+	})
+	return roles
+}
+
+// GetByName implements RoleRepository
+func (r MemoryRoleDb) GetByName(ctx context.Context, name string) (*Role, error) {
+	err := r.store.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer r.store.unlock()
+	i := r.store.roleIndex(name)
+	if i < 0 {
+		return nil, ErrNotFound
+	}
+	role := r.store.roles[i]
+	return &role, nil
+}
+
+// Upsert implements RoleRepository
+func (r MemoryRoleDb) Upsert(ctx context.Context, role Role) (*Role, error) {
+	role.UpdatedAt = time.Now()
+	if role.Permissions == nil {
+		role.Permissions = []string{}
+	}
+	err := r.store.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer r.store.unlock()
+	stored := Role{
+		Name:        role.Name,
+		Permissions: append([]string{}, role.Permissions...),
+		UpdatedAt:   role.UpdatedAt,
+	}
+	i := r.store.roleIndex(role.Name)
+	if i < 0 {
+		r.store.roles = append(r.store.roles, stored)
+	} else {
+		stored.KnownPermissions = r.store.roles[i].KnownPermissions
+		r.store.roles[i] = stored
+	}
+	return &role, nil
+}
+
+// Delete implements RoleRepository
+func (r MemoryRoleDb) Delete(ctx context.Context, name string) (*Role, error) {
+	err := r.store.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer r.store.unlock()
+	i := r.store.roleIndex(name)
+	if i < 0 {
+		return nil, ErrNotFound
+	}
+	role := r.store.roles[i]
+	r.store.roles = append(r.store.roles[:i], r.store.roles[i+1:]...)
+	return &role, nil
+}
+
+// SeedDefaults implements RoleRepository, see RoleDb.SeedDefaults
+func (r MemoryRoleDb) SeedDefaults(ctx context.Context) error {
+	err := r.store.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer r.store.unlock()
+	for _, role := range DefaultRoles {
+		i := r.store.roleIndex(role.Name)
+		if i < 0 {
+			r.store.roles = append(r.store.roles, Role{
+				Name:             role.Name,
+				Permissions:      append([]string{}, role.Permissions...),
+				UpdatedAt:        time.Now(),
+				KnownPermissions: append([]string{}, AllPermissions...),
+			})
+			continue
+		}
+		found := &r.store.roles[i]
+		permissions := append([]string{}, found.Permissions...)
+		for _, v := range role.Permissions {
+			if !found.HasPermission(v) && !contains(found.KnownPermissions, v) {
+				permissions = append(permissions, v)
+			}
+		}
+		found.Permissions = permissions
+		found.KnownPermissions = append([]string{}, AllPermissions...)
+	}
+	return nil
+}
+
+func (s *MemoryStore) roleIndex(name string) int {
+	for i, v := range s.roles {
+		if v.Name == name {
+			return i
+		}
+	}
+	return -1
+}
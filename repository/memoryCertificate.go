@@ -0,0 +1,591 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+type MemoryDigitalCertTypeDb struct {
+	store *MemoryStore
+}
+
+// Create implements IDigitalCertTypeRepository
+func (d MemoryDigitalCertTypeDb) Create(ctx context.Context, typeCode string, typeName string, logoImageName string, typeOfUnit string, unit string, vintageYear string) (*DigitalCertType, error) {
+	certType := DigitalCertType{
+		TypeCode:      typeCode,
+		TypeName:      typeName,
+		LogoImageName: logoImageName,
+		TypeOfUnit:    typeOfUnit,
+		Unit:          unit,
+		VintageYear:   vintageYear,
+	}
+	event, err := NewOutboxEvent(CERT_TYPE_CREATED_EVENT, typeCode, certType)
+	if err != nil {
+		return nil, err
+	}
+	err = d.store.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer d.store.unlock()
+	if d.store.certTypeIndex(typeCode) >= 0 {
+		return nil, duplicateError("type_code", typeCode)
+	}
+	d.store.certTypes = append(d.store.certTypes, certType)
+	d.store.addOutbox(event)
+	return &certType, nil
+}
+
+// Delete implements IDigitalCertTypeRepository
+func (d MemoryDigitalCertTypeDb) Delete(ctx context.Context, typeCode string) (*DigitalCertType, error) {
+	err := d.store.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer d.store.unlock()
+	i := d.store.certTypeIndex(typeCode)
+	if i < 0 {
+		return nil, ErrNotFound
+	}
+	certType := d.store.certTypes[i]
+	d.store.certTypes = append(d.store.certTypes[:i], d.store.certTypes[i+1:]...)
+	return &certType, nil
+}
+
+// GetAll implements IDigitalCertTypeRepository
+func (d MemoryDigitalCertTypeDb) GetAll(ctx context.Context) []DigitalCertType {
+	certTypes := []DigitalCertType{}
+	err := d.store.lock(ctx)
+	if err != nil {
+		return certTypes
+	}
+	defer d.store.unlock()
+	return append(certTypes, d.store.certTypes...)
+}
+
+// GetPage implements IDigitalCertTypeRepository
+func (d MemoryDigitalCertTypeDb) GetPage(ctx context.Context, query PageQuery) ([]DigitalCertType, *Page, error) {
+	err := d.store.lock(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer d.store.unlock()
+	certTypes := []DigitalCertType{}
+	page, err := memoryPage(d.store.certTypes, query, DigitalCertTypeSortFields, "type_code", &certTypes)
+	if err != nil {
+		return nil, nil, err
+	}
+	return certTypes, page, nil
+}
+
+// GetByTypeCode implements IDigitalCertTypeRepository
+func (d MemoryDigitalCertTypeDb) GetByTypeCode(ctx context.Context, typeCode string) (*DigitalCertType, error) {
+	err := d.store.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer d.store.unlock()
+	i := d.store.certTypeIndex(typeCode)
+	if i < 0 {
+		return nil, ErrNotFound
+	}
+	certType := d.store.certTypes[i]
+	return &certType, nil
+}
+
+// Update implements IDigitalCertTypeRepository
+func (d MemoryDigitalCertTypeDb) Update(ctx context.Context, typeCode string, newCertType DigitalCertType) (*DigitalCertType, error) {
+	err := d.store.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer d.store.unlock()
+	i := d.store.certTypeIndex(typeCode)
+	if i < 0 || d.store.certTypes[i] == newCertType {
+		return nil, ErrNoChange
+	}
+	if newCertType.TypeCode != typeCode && d.store.certTypeIndex(newCertType.TypeCode) >= 0 {
+		return nil, duplicateError("type_code", newCertType.TypeCode)
+	}
+	d.store.certTypes[i] = newCertType
+	return &newCertType, nil
+}
+
+// FindInArrayByTypeCode implements IDigitalCertTypeRepository
+func (d MemoryDigitalCertTypeDb) FindInArrayByTypeCode(typeCode string, certTypes []DigitalCertType) (int, *DigitalCertType) {
+	return DigitalCertTypeDb{}.FindInArrayByTypeCode(typeCode, certTypes)
+}
+
+func (s *MemoryStore) certTypeIndex(typeCode string) int {
+	for i, v := range s.certTypes {
+		if v.TypeCode == typeCode {
+			return i
+		}
+	}
+	return -1
+}
+
+type MemoryMetadataDb struct {
+	store *MemoryStore
+}
+
+// Create implements IMetadataRepository
+func (m MemoryMetadataDb) Create(ctx context.Context, typeCode string, certId int, projectName string, projectType string, imageName string, description string, listedDate string) (*Metadata, error) {
+	metadata := Metadata{
+		TypeCode:      typeCode,
+		DigitalCertID: certId,
+		ProjectName:   projectName,
+		ProjectType:   projectType,
+		ImageName:     imageName,
+		Description:   description,
+		ListedDate:    listedDate,
+	}
+	err := m.store.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer m.store.unlock()
+	if m.store.metadataIndex(certId) >= 0 {
+		return nil, duplicateError("digital_cert_id", certId)
+	}
+	m.store.metadata = append(m.store.metadata, metadata)
+	return &metadata, nil
+}
+
+// CreateMany implements IMetadataRepository, the unique index is checked
+// for all of metadatas before any is added
+func (m MemoryMetadataDb) CreateMany(ctx context.Context, metadatas []Metadata) error {
+	err := m.store.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer m.store.unlock()
+	certIds := map[int]bool{}
+	for _, v := range metadatas {
+		if certIds[v.DigitalCertID] || m.store.metadataIndex(v.DigitalCertID) >= 0 {
+			return duplicateError("digital_cert_id", v.DigitalCertID)
+		}
+		certIds[v.DigitalCertID] = true
+	}
+	m.store.metadata = append(m.store.metadata, metadatas...)
+	return nil
+}
+
+// DeleteByDigitalCertId implements IMetadataRepository
+func (m MemoryMetadataDb) DeleteByDigitalCertId(ctx context.Context, certId int) (*Metadata, error) {
+	err := m.store.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer m.store.unlock()
+	i := m.store.metadataIndex(certId)
+	if i < 0 {
+		return nil, ErrNotFound
+	}
+	metadata := m.store.metadata[i]
+	m.store.metadata = append(m.store.metadata[:i], m.store.metadata[i+1:]...)
+	return &metadata, nil
+}
+
+// GetPage implements IMetadataRepository
+func (m MemoryMetadataDb) GetPage(ctx context.Context, filter MetadataFilter, query PageQuery) ([]Metadata, *Page, error) {
+	err := m.store.lock(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer m.store.unlock()
+	found := []Metadata{}
+	for _, v := range m.store.metadata {
+		if filter.matches(v) {
+			found = append(found, v)
+		}
+	}
+	metadatas := []Metadata{}
+	page, err := memoryPage(found, query, MetadataSortFields, "digital_cert_id", &metadatas)
+	if err != nil {
+		return nil, nil, err
+	}
+	return metadatas, page, nil
+}
+
+// GetByDigitalCertId implements IMetadataRepository
+func (m MemoryMetadataDb) GetByDigitalCertId(ctx context.Context, certId int) (*Metadata, error) {
+	err := m.store.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer m.store.unlock()
+	i := m.store.metadataIndex(certId)
+	if i < 0 {
+		return nil, ErrNotFound
+	}
+	metadata := m.store.metadata[i]
+	return &metadata, nil
+}
+
+// GetByTypeCode implements IMetadataRepository
+func (m MemoryMetadataDb) GetByTypeCode(ctx context.Context, typeCode string) []Metadata {
+	metadatas := []Metadata{}
+	err := m.store.lock(ctx)
+	if err != nil {
+		return metadatas
+	}
+	defer m.store.unlock()
+	for _, v := range m.store.metadata {
+		if v.TypeCode == typeCode {
+			metadatas = append(metadatas, v)
+		}
+	}
+	return metadatas
+}
+
+// UpdateByDigitalCertId implements IMetadataRepository
+func (m MemoryMetadataDb) UpdateByDigitalCertId(ctx context.Context, certId int, metadata Metadata) (*Metadata, error) {
+	event, err := NewOutboxEvent(METADATA_UPDATED_EVENT, strconv.Itoa(certId), metadata)
+	if err != nil {
+		return nil, err
+	}
+	err = m.store.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer m.store.unlock()
+	i := m.store.metadataIndex(certId)
+	if i < 0 || m.store.metadata[i] == metadata {
+		return nil, ErrNoChange
+	}
+	if metadata.DigitalCertID != certId && m.store.metadataIndex(metadata.DigitalCertID) >= 0 {
+		return nil, duplicateError("digital_cert_id", metadata.DigitalCertID)
+	}
+	m.store.metadata[i] = metadata
+	m.store.addOutbox(event)
+	return &metadata, nil
+}
+
+func (s *MemoryStore) metadataIndex(certId int) int {
+	for i, v := range s.metadata {
+		if v.DigitalCertID == certId {
+			return i
+		}
+	}
+	return -1
+}
+
+type MemoryRedeemedDb struct {
+	store *MemoryStore
+}
+
+// create implements IRedeemedRepository
+func (r MemoryRedeemedDb) create(ctx context.Context, redeemed Redeemed) (*Redeemed, error) {
+	err := r.store.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer r.store.unlock()
+	return r.store.createRedeemed(redeemed)
+}
+
+// update implements IRedeemedRepository
+func (r MemoryRedeemedDb) update(ctx context.Context, logIndex int, redeemed Redeemed) (*Redeemed, error) {
+	err := r.store.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer r.store.unlock()
+	return r.store.updateRedeemed(logIndex, redeemed)
+}
+
+// Upsert implements IRedeemedRepository, see RedeemedDb.Upsert
+func (r MemoryRedeemedDb) Upsert(ctx context.Context, redeemed Redeemed) (*Redeemed, error) {
+	err := r.store.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer r.store.unlock()
+	sameTx := []Redeemed{}
+	for _, v := range r.store.redeemed {
+		if v.TxHash == redeemed.TxHash {
+			sameTx = append(sameTx, copyRedeemed(v))
+		}
+	}
+	found, err := matchRedeemed(redeemed, sameTx)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	if err != nil {
+		created, err := r.store.createRedeemed(redeemed)
+		if err != nil {
+			return nil, err
+		}
+		event, err := newRedeemedEvent(REDEEMED_CREATED_EVENT, *created)
+		if err != nil {
+			return nil, err
+		}
+		events := []OutboxEvent{event}
+		if created.Email != "" {
+			event, err := newRedeemedEvent(REDEEMED_DETAILS_SUBMITTED_EVENT, *created)
+			if err != nil {
+				return nil, err
+			}
+			events = append(events, event)
+		}
+		r.store.addOutbox(events...)
+		return created, nil
+	}
+
+	logIndex := found.LogIndex
+	recorded := !hasChainData(*found) && hasChainData(redeemed)
+	detailsSubmitted, err := mergeRedeemed(found, redeemed)
+	if err != nil {
+		return nil, err
+	}
+	updated, err := r.store.updateRedeemed(logIndex, *found)
+	if err != nil {
+		return nil, err
+	}
+	events, err := mergedEvents(*updated, recorded, detailsSubmitted)
+	if err != nil {
+		return nil, err
+	}
+	r.store.addOutbox(events...)
+	return updated, nil
+}
+
+// MarkRemoved implements IRedeemedRepository, see RedeemedDb.MarkRemoved
+func (r MemoryRedeemedDb) MarkRemoved(ctx context.Context, txHash string, logIndex int) (*Redeemed, error) {
+	err := r.store.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer r.store.unlock()
+	i := r.store.redeemedIndex(func(v Redeemed) bool {
+		return v.TxHash == txHash && v.LogIndex == logIndex && !v.Removed
+	})
+	if i < 0 {
+		return nil, ErrNotFound
+	}
+	redeemed := copyRedeemed(r.store.redeemed[i])
+	redeemed.Removed = true
+	event, err := newRedeemedEvent(REDEEMED_REMOVED_EVENT, redeemed)
+	if err != nil {
+		return nil, err
+	}
+	r.store.redeemed[i] = copyRedeemed(redeemed)
+	r.store.addOutbox(event)
+	return &redeemed, nil
+}
+
+// GetPage implements IRedeemedRepository
+func (r MemoryRedeemedDb) GetPage(ctx context.Context, filter RedeemedFilter, query PageQuery) ([]Redeemed, *Page, error) {
+	err := r.store.lock(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer r.store.unlock()
+	allRedeemed := []Redeemed{}
+	page, err := memoryPage(r.store.findRedeemed(filter), query, RedeemedSortFields, "-redeem_date", &allRedeemed)
+	if err != nil {
+		return nil, nil, err
+	}
+	return allRedeemed, page, nil
+}
+
+// Iterate implements IRedeemedRepository, fn is called without the lock so
+// it may use the store too
+func (r MemoryRedeemedDb) Iterate(ctx context.Context, filter RedeemedFilter, fn func(Redeemed) error) error {
+	err := r.store.lock(ctx)
+	if err != nil {
+		return err
+	}
+	found := r.store.findRedeemed(filter)
+	r.store.unlock()
+	sort.SliceStable(found, func(i int, j int) bool {
+		return found[i].RedeemDate < found[j].RedeemDate
+	})
+	for _, v := range found {
+		err := ctx.Err()
+		if err != nil {
+			return wrapError(err)
+		}
+		err = fn(v)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetByTxHash implements IRedeemedRepository
+func (r MemoryRedeemedDb) GetByTxHash(ctx context.Context, txHash string) (*Redeemed, error) {
+	return r.findOne(ctx, func(v Redeemed) bool {
+		return v.TxHash == txHash
+	})
+}
+
+// GetByRedeemId implements IRedeemedRepository
+func (r MemoryRedeemedDb) GetByRedeemId(ctx context.Context, redeemId int) (*Redeemed, error) {
+	return r.findOne(ctx, func(v Redeemed) bool {
+		return v.RedeemId == redeemId
+	})
+}
+
+// GetByVerificationCode implements IRedeemedRepository
+func (r MemoryRedeemedDb) GetByVerificationCode(ctx context.Context, code string) (*Redeemed, error) {
+	return r.findOne(ctx, func(v Redeemed) bool {
+		return v.VerificationCode == code
+	})
+}
+
+func (r MemoryRedeemedDb) findOne(ctx context.Context, match func(Redeemed) bool) (*Redeemed, error) {
+	err := r.store.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer r.store.unlock()
+	i := r.store.redeemedIndex(match)
+	if i < 0 {
+		return nil, ErrNotFound
+	}
+	redeemed := copyRedeemed(r.store.redeemed[i])
+	return &redeemed, nil
+}
+
+// UpdateStatus implements IRedeemedRepository, see RedeemedDb.UpdateStatus
+func (r MemoryRedeemedDb) UpdateStatus(ctx context.Context, redeemId int, status string, actor string, note string) (*Redeemed, error) {
+	err := checkStatusRequest(status, note)
+	if err != nil {
+		return nil, err
+	}
+	err = r.store.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer r.store.unlock()
+	i := r.store.redeemedIndex(func(v Redeemed) bool {
+		return v.RedeemId == redeemId
+	})
+	if i < 0 {
+		return nil, ErrNotFound
+	}
+	redeemed := copyRedeemed(r.store.redeemed[i])
+	change, err := changeStatus(&redeemed, status, actor, note)
+	if err != nil {
+		return nil, err
+	}
+	data := RedeemedEventData{Redeemed: redeemed, Change: &change}
+	event, err := NewOutboxEvent(REDEEMED_STATUS_CHANGED_EVENT, strconv.Itoa(redeemId), data)
+	if err != nil {
+		return nil, err
+	}
+	r.store.redeemed[i] = copyRedeemed(redeemed)
+	r.store.addOutbox(event)
+	return &redeemed, nil
+}
+
+// GetHistory implements IRedeemedRepository
+func (r MemoryRedeemedDb) GetHistory(ctx context.Context, redeemId int) ([]StatusChange, error) {
+	redeemed, err := r.GetByRedeemId(ctx, redeemId)
+	if err != nil {
+		return nil, err
+	}
+	if redeemed.StatusHistory == nil {
+		return []StatusChange{}, nil
+	}
+	return redeemed.StatusHistory, nil
+}
+
+// SetCertificate implements IRedeemedRepository
+func (r MemoryRedeemedDb) SetCertificate(ctx context.Context, redeemId int, object string, verificationCode string) error {
+	err := r.store.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer r.store.unlock()
+	i := r.store.redeemedIndex(func(v Redeemed) bool {
+		return v.RedeemId == redeemId
+	})
+	if i < 0 {
+		return ErrNotFound
+	}
+	if verificationCode != "" {
+		taken := r.store.redeemedIndex(func(v Redeemed) bool {
+			return v.VerificationCode == verificationCode
+		})
+		if taken >= 0 && taken != i {
+			return duplicateError("verification_code", verificationCode)
+		}
+	}
+	r.store.redeemed[i].CertificateObject = object
+	r.store.redeemed[i].VerificationCode = verificationCode
+	return nil
+}
+
+func (s *MemoryStore) redeemedIndex(match func(Redeemed) bool) int {
+	for i, v := range s.redeemed {
+		if match(v) {
+			return i
+		}
+	}
+	return -1
+}
+
+// findRedeemed returns copies of the redemptions that match filter
+func (s *MemoryStore) findRedeemed(filter RedeemedFilter) []Redeemed {
+	found := []Redeemed{}
+	for _, v := range s.redeemed {
+		if filter.matches(v) {
+			found = append(found, copyRedeemed(v))
+		}
+	}
+	return found
+}
+
+// createRedeemed is RedeemedDb.create, the lock must be held
+func (s *MemoryStore) createRedeemed(redeemed Redeemed) (*Redeemed, error) {
+	redeemed.PriceDecimal, _ = PriceDecimal(redeemed.Price)
+	redeemed.ApproveStatus = REQUEST_STATUS
+	redeemed.RejectReason = ""
+	redeemed.StatusHistory = []StatusChange{}
+	taken := s.redeemedIndex(func(v Redeemed) bool {
+		return v.TxHash == redeemed.TxHash && v.LogIndex == redeemed.LogIndex
+	})
+	if taken >= 0 {
+		return nil, duplicateError("tx_hash", redeemed.TxHash)
+	}
+	s.redeemed = append(s.redeemed, copyRedeemed(redeemed))
+	return &redeemed, nil
+}
+
+// updateRedeemed is RedeemedDb.update, the lock must be held
+func (s *MemoryStore) updateRedeemed(logIndex int, redeemed Redeemed) (*Redeemed, error) {
+	i := s.redeemedIndex(func(v Redeemed) bool {
+		return v.TxHash == redeemed.TxHash && v.LogIndex == logIndex
+	})
+	if i < 0 {
+		return nil, ErrNoChange
+	}
+	found := s.redeemed[i]
+	// status fields only change through UpdateStatus
+	updated := copyRedeemed(redeemed)
+	updated.ApproveStatus = found.ApproveStatus
+	updated.RejectReason = found.RejectReason
+	updated.StatusHistory = found.StatusHistory
+	updated.CertificateObject = found.CertificateObject
+	updated.VerificationCode = found.VerificationCode
+	if reflect.DeepEqual(updated, found) {
+		return nil, ErrNoChange
+	}
+	s.redeemed[i] = updated
+	return &redeemed, nil
+}
+
+// copyRedeemed copies the history too, so callers can not change the store
+func copyRedeemed(redeemed Redeemed) Redeemed {
+	if redeemed.StatusHistory != nil {
+		redeemed.StatusHistory = append([]StatusChange{}, redeemed.StatusHistory...)
+	}
+	return redeemed
+}
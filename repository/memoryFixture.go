@@ -0,0 +1,128 @@
+package repository
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/seenark/super-backend-temp/authen"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Fixture is what a memory store is seeded with, so the api runs with data
+// and without mongo. Passwords are plain text unless they are bcrypt hashes
+// already, redemptions without approved_status are requested.
+type Fixture struct {
+	Users     []User            `json:"users"`
+	Roles     []Role            `json:"roles"`
+	CertTypes []DigitalCertType `json:"cert_types"`
+	Metadata  []Metadata        `json:"metadata"`
+	Redeemed  []Redeemed        `json:"redeemed"`
+}
+
+// ReadFixture reads a fixture from a JSON file, unknown fields are an error
+// so a typo does not leave data out silently
+func ReadFixture(path string) (*Fixture, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	fixture := Fixture{}
+	err = decoder.Decode(&fixture)
+	if err != nil {
+		return nil, fmt.Errorf("fixture %s: %w", path, err)
+	}
+	return &fixture, nil
+}
+
+// Seed adds the fixture to the store. It fails on the first document that
+// breaks a unique index, what was added before it stays.
+func (s *MemoryStore) Seed(fixture Fixture) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, user := range fixture.Users {
+		if user.Id.IsZero() {
+			user.Id = primitive.NewObjectID()
+		}
+		if s.userIndex(user.Id) >= 0 {
+			return duplicateError("user id", user.Id.Hex())
+		}
+		if !strings.HasPrefix(user.Password, "$2") {
+			hashed, err := authen.HashPassword(user.Password)
+			if err != nil {
+				return err
+			}
+			user.Password = hashed
+		}
+		err := s.insertUser(user)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, role := range fixture.Roles {
+		if role.Name == "" {
+			return fmt.Errorf("role without a name")
+		}
+		for _, v := range role.Permissions {
+			if !contains(AllPermissions, v) {
+				return fmt.Errorf("role %s: unknown permission %s", role.Name, v)
+			}
+		}
+		// the fixture wins over the defaults seeded on startup
+		role.KnownPermissions = AllPermissions
+		role.UpdatedAt = time.Now()
+		i := s.roleIndex(role.Name)
+		if i >= 0 {
+			s.roles[i] = role
+		} else {
+			s.roles = append(s.roles, role)
+		}
+	}
+
+	for _, certType := range fixture.CertTypes {
+		if s.certTypeIndex(certType.TypeCode) >= 0 {
+			return duplicateError("type_code", certType.TypeCode)
+		}
+		s.certTypes = append(s.certTypes, certType)
+	}
+
+	for _, metadata := range fixture.Metadata {
+		if s.metadataIndex(metadata.DigitalCertID) >= 0 {
+			return duplicateError("digital_cert_id", metadata.DigitalCertID)
+		}
+		s.metadata = append(s.metadata, metadata)
+	}
+
+	for _, redeemed := range fixture.Redeemed {
+		if redeemed.ApproveStatus == "" {
+			redeemed.ApproveStatus = REQUEST_STATUS
+		}
+		redeemed.PriceDecimal, _ = PriceDecimal(redeemed.Price)
+		if _, ok := redeemStatusTransitions[redeemed.ApproveStatus]; !ok {
+			return fmt.Errorf("%w: %s of tx %s", ErrInvalidStatus, redeemed.ApproveStatus, redeemed.TxHash)
+		}
+		taken := s.redeemedIndex(func(v Redeemed) bool {
+			return v.TxHash == redeemed.TxHash && v.LogIndex == redeemed.LogIndex
+		})
+		if taken >= 0 {
+			return duplicateError("tx_hash", redeemed.TxHash)
+		}
+		if redeemed.VerificationCode != "" {
+			taken = s.redeemedIndex(func(v Redeemed) bool {
+				return v.VerificationCode == redeemed.VerificationCode
+			})
+			if taken >= 0 {
+				return duplicateError("verification_code", redeemed.VerificationCode)
+			}
+		}
+		s.redeemed = append(s.redeemed, copyRedeemed(redeemed))
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package repository_test
+
+import (
+	"testing"
+
+	"github.com/seenark/super-backend-temp/repository"
+)
+
+func TestSeedFixture(t *testing.T) {
+	// the fixture of the dev mode loads and its passwords work
+	fixture, err := repository.ReadFixture("fixtures/dev.json")
+	checkError(t, err, nil)
+	store := repository.NewMemoryStore()
+	checkError(t, store.Seed(*fixture), nil)
+	repos := repository.NewMemoryRepositories(store)
+	_, err = repos.Authen.Signin(ctx, fixture.Users[0].Email, fixture.Users[0].Password, repository.ClientInfo{})
+	checkError(t, err, nil)
+	_, err = repos.Users.Create(ctx, repository.User{Email: fixture.Users[0].Email, Password: "password1"})
+	checkError(t, err, repository.ErrDuplicate)
+
+	tests := []struct {
+		name    string
+		fixture repository.Fixture
+		err     error
+	}{
+		{"new documents", repository.Fixture{
+			Users:    []repository.User{{Email: "new@example.com", Password: "password1"}},
+			Redeemed: []repository.Redeemed{{TxHash: "0x01", ApproveStatus: repository.DELIVERED_STATUS}},
+		}, nil},
+		{"taken email", repository.Fixture{Users: []repository.User{{Email: "new@example.com"}}}, repository.ErrDuplicate},
+		{"taken type code", repository.Fixture{CertTypes: []repository.DigitalCertType{fixture.CertTypes[0]}}, repository.ErrDuplicate},
+		{"taken cert id", repository.Fixture{Metadata: []repository.Metadata{fixture.Metadata[0]}}, repository.ErrDuplicate},
+		{"taken tx hash", repository.Fixture{Redeemed: []repository.Redeemed{{TxHash: "0x01"}}}, repository.ErrDuplicate},
+		{"unknown status", repository.Fixture{Redeemed: []repository.Redeemed{{TxHash: "0x02", ApproveStatus: "lost"}}}, repository.ErrInvalidStatus},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkError(t, store.Seed(tt.fixture), tt.err)
+		})
+	}
+	redeemed, err := repos.Redeemed.GetByTxHash(ctx, "0x01")
+	checkError(t, err, nil)
+	if redeemed.ApproveStatus != repository.DELIVERED_STATUS {
+		t.Fatalf("status = %s", redeemed.ApproveStatus)
+	}
+}
@@ -0,0 +1,336 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type MemoryOutboxDb struct {
+	store *MemoryStore
+}
+
+// Add implements OutboxRepository
+func (o MemoryOutboxDb) Add(ctx context.Context, events ...OutboxEvent) error {
+	err := o.store.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer o.store.unlock()
+	o.store.addOutbox(events...)
+	return nil
+}
+
+// Claim implements OutboxRepository, see OutboxDb.Claim
+func (o MemoryOutboxDb) Claim(ctx context.Context, lockFor time.Duration) (*OutboxEvent, error) {
+	err := o.store.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer o.store.unlock()
+	now := time.Now()
+	due := -1
+	for i, v := range o.store.outbox {
+		if v.Status != OUTBOX_PENDING || v.NextAttemptAt.After(now) || v.LockedUntil.After(now) {
+			continue
+		}
+		if due < 0 || v.NextAttemptAt.Before(o.store.outbox[due].NextAttemptAt) {
+			due = i
+		}
+	}
+	if due < 0 {
+		return nil, nil
+	}
+	event := &o.store.outbox[due]
+	event.LockedUntil = now.Add(lockFor)
+	event.Attempts++
+	claimed := *event
+	claimed.Done = append([]string{}, event.Done...)
+	return &claimed, nil
+}
+
+// MarkHandled implements OutboxRepository
+func (o MemoryOutboxDb) MarkHandled(ctx context.Context, id primitive.ObjectID, handler string) error {
+	return o.change(ctx, id, func(event *OutboxEvent) {
+		if !contains(event.Done, handler) {
+			event.Done = append(event.Done, handler)
+		}
+	})
+}
+
+// Complete implements OutboxRepository
+func (o MemoryOutboxDb) Complete(ctx context.Context, id primitive.ObjectID) error {
+	return o.change(ctx, id, func(event *OutboxEvent) {
+		now := time.Now()
+		event.Status = OUTBOX_DONE
+		event.ProcessedAt = &now
+		event.LastError = ""
+	})
+}
+
+// Retry implements OutboxRepository
+func (o MemoryOutboxDb) Retry(ctx context.Context, id primitive.ObjectID, nextAttemptAt time.Time, lastError string) error {
+	return o.change(ctx, id, func(event *OutboxEvent) {
+		event.NextAttemptAt = nextAttemptAt
+		event.LockedUntil = time.Time{}
+		event.LastError = lastError
+	})
+}
+
+// Fail implements OutboxRepository, the event is not tried again
+func (o MemoryOutboxDb) Fail(ctx context.Context, id primitive.ObjectID, lastError string) error {
+	return o.change(ctx, id, func(event *OutboxEvent) {
+		now := time.Now()
+		event.Status = OUTBOX_FAILED
+		event.LastError = lastError
+		event.ProcessedAt = &now
+	})
+}
+
+// change applies fn to the event with id, like UpdateByID an unknown id is
+// not an error
+func (o MemoryOutboxDb) change(ctx context.Context, id primitive.ObjectID, fn func(*OutboxEvent)) error {
+	err := o.store.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer o.store.unlock()
+	for i, v := range o.store.outbox {
+		if v.Id == id {
+			fn(&o.store.outbox[i])
+		}
+	}
+	return nil
+}
+
+type MemoryWebhookDb struct {
+	store *MemoryStore
+}
+
+// Create implements WebhookRepository
+func (w MemoryWebhookDb) Create(ctx context.Context, webhook Webhook) (*Webhook, error) {
+	webhook.Id = primitive.NewObjectID()
+	webhook.CreatedAt = time.Now()
+	webhook.UpdatedAt = webhook.CreatedAt
+	if webhook.Events == nil {
+		webhook.Events = []string{}
+	}
+	err := w.store.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer w.store.unlock()
+	w.store.webhooks = append(w.store.webhooks, copyWebhook(webhook))
+	return &webhook, nil
+}
+
+// GetAll implements WebhookRepository
+func (w MemoryWebhookDb) GetAll(ctx context.Context) []Webhook {
+	webhooks := []Webhook{}
+	err := w.store.lock(ctx)
+	if err != nil {
+		return webhooks
+	}
+	defer w.store.unlock()
+	for _, v := range w.store.webhooks {
+		webhooks = append(webhooks, copyWebhook(v))
+	}
+	sort.SliceStable(webhooks, func(i int, j int) bool {
+		return webhooks[i].CreatedAt.After(webhooks[j].CreatedAt)
+	})
+	return webhooks
+}
+
+// GetById implements WebhookRepository
+func (w MemoryWebhookDb) GetById(ctx context.Context, id string) (*Webhook, error) {
+	objectId, err := parseObjectId(id)
+	if err != nil {
+		return nil, err
+	}
+	err = w.store.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer w.store.unlock()
+	i := w.store.webhookIndex(objectId)
+	if i < 0 {
+		return nil, ErrNotFound
+	}
+	webhook := copyWebhook(w.store.webhooks[i])
+	return &webhook, nil
+}
+
+// GetByEvent implements WebhookRepository, only active webhooks are returned
+func (w MemoryWebhookDb) GetByEvent(ctx context.Context, eventType string) ([]Webhook, error) {
+	err := w.store.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer w.store.unlock()
+	webhooks := []Webhook{}
+	for _, v := range w.store.webhooks {
+		if v.Active && contains(v.Events, eventType) {
+			webhooks = append(webhooks, copyWebhook(v))
+		}
+	}
+	return webhooks, nil
+}
+
+// Update implements WebhookRepository, the secret can not be changed
+func (w MemoryWebhookDb) Update(ctx context.Context, id string, webhook Webhook) (*Webhook, error) {
+	objectId, err := parseObjectId(id)
+	if err != nil {
+		return nil, err
+	}
+	err = w.store.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer w.store.unlock()
+	i := w.store.webhookIndex(objectId)
+	if i < 0 {
+		return nil, ErrNotFound
+	}
+	found := &w.store.webhooks[i]
+	found.Url = webhook.Url
+	found.Description = webhook.Description
+	found.Events = append([]string(nil), webhook.Events...)
+	found.Active = webhook.Active
+	found.UpdatedAt = time.Now()
+	updated := copyWebhook(*found)
+	return &updated, nil
+}
+
+// Delete implements WebhookRepository
+func (w MemoryWebhookDb) Delete(ctx context.Context, id string) (*Webhook, error) {
+	objectId, err := parseObjectId(id)
+	if err != nil {
+		return nil, err
+	}
+	err = w.store.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer w.store.unlock()
+	i := w.store.webhookIndex(objectId)
+	if i < 0 {
+		return nil, ErrNotFound
+	}
+	webhook := w.store.webhooks[i]
+	w.store.webhooks = append(w.store.webhooks[:i], w.store.webhooks[i+1:]...)
+	return &webhook, nil
+}
+
+func (s *MemoryStore) webhookIndex(id primitive.ObjectID) int {
+	for i, v := range s.webhooks {
+		if v.Id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func copyWebhook(webhook Webhook) Webhook {
+	if webhook.Events != nil {
+		webhook.Events = append([]string{}, webhook.Events...)
+	}
+	return webhook
+}
+
+type MemoryWebhookDeliveryDb struct {
+	store *MemoryStore
+}
+
+// Create implements WebhookDeliveryRepository
+func (w MemoryWebhookDeliveryDb) Create(ctx context.Context, delivery WebhookDelivery) (*WebhookDelivery, error) {
+	delivery.Id = primitive.NewObjectID()
+	delivery.CreatedAt = time.Now()
+	err := w.store.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer w.store.unlock()
+	w.store.deliveries = append(w.store.deliveries, delivery)
+	return &delivery, nil
+}
+
+// GetById implements WebhookDeliveryRepository
+func (w MemoryWebhookDeliveryDb) GetById(ctx context.Context, id string) (*WebhookDelivery, error) {
+	objectId, err := parseObjectId(id)
+	if err != nil {
+		return nil, err
+	}
+	err = w.store.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer w.store.unlock()
+	for _, v := range w.store.deliveries {
+		if v.Id == objectId {
+			delivery := v
+			return &delivery, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// GetPage implements WebhookDeliveryRepository
+func (w MemoryWebhookDeliveryDb) GetPage(ctx context.Context, webhookId primitive.ObjectID, query PageQuery) ([]WebhookDelivery, *Page, error) {
+	err := w.store.lock(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer w.store.unlock()
+	found := []WebhookDelivery{}
+	for _, v := range w.store.deliveries {
+		if v.WebhookId == webhookId {
+			found = append(found, v)
+		}
+	}
+	deliveries := []WebhookDelivery{}
+	page, err := memoryPage(found, query, WebhookDeliverySortFields, "-created_at", &deliveries)
+	if err != nil {
+		return nil, nil, err
+	}
+	return deliveries, page, nil
+}
+
+type MemoryCheckpointDb struct {
+	store *MemoryStore
+}
+
+// Get implements CheckpointRepository
+func (c MemoryCheckpointDb) Get(ctx context.Context, name string) (*Checkpoint, error) {
+	err := c.store.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer c.store.unlock()
+	for _, v := range c.store.checkpoints {
+		if v.Name == name {
+			checkpoint := v
+			return &checkpoint, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// Save implements CheckpointRepository
+func (c MemoryCheckpointDb) Save(ctx context.Context, checkpoint Checkpoint) (*Checkpoint, error) {
+	checkpoint.UpdatedAt = time.Now()
+	err := c.store.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer c.store.unlock()
+	for i, v := range c.store.checkpoints {
+		if v.Name == checkpoint.Name {
+			c.store.checkpoints[i] = checkpoint
+			return &checkpoint, nil
+		}
+	}
+	c.store.checkpoints = append(c.store.checkpoints, checkpoint)
+	return &checkpoint, nil
+}
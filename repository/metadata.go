@@ -2,12 +2,13 @@ package repository
 
 import (
 	"context"
-	"fmt"
+	"errors"
+	"log"
+	"strconv"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type Metadata struct {
@@ -20,18 +21,24 @@ type Metadata struct {
 	ListedDate    string `bson:"listed_date" json:"listed_date"`
 }
 
+// MetadataFilter narrows a metadata list, empty fields match everything
+type MetadataFilter struct {
+	Ids      []int
+	TypeCode string
+}
+
 type IMetadataRepository interface {
-	GetAll(ids []int) []Metadata
-	GetByDigitalCertId(certId int) (*Metadata, error)
-	GetByTypeCode(typeCode string) []Metadata
-	Create(typeCode string, certId int, projectName string, projectType string, imageName string, description string, listedDate string) (*Metadata, error)
-	UpdateByDigitalCertId(certId int, metadata Metadata) (*Metadata, error)
-	DeleteByDigitalCertId(certId int) (*Metadata, error)
+	GetPage(ctx context.Context, filter MetadataFilter, query PageQuery) ([]Metadata, *Page, error)
+	GetByDigitalCertId(ctx context.Context, certId int) (*Metadata, error)
+	GetByTypeCode(ctx context.Context, typeCode string) []Metadata
+	Create(ctx context.Context, typeCode string, certId int, projectName string, projectType string, imageName string, description string, listedDate string) (*Metadata, error)
+	CreateMany(ctx context.Context, metadatas []Metadata) error
+	UpdateByDigitalCertId(ctx context.Context, certId int, metadata Metadata) (*Metadata, error)
+	DeleteByDigitalCertId(ctx context.Context, certId int) (*Metadata, error)
 }
 
 type MetadataDb struct {
 	col *mongo.Collection
-	ctx context.Context
 }
 
 const (
@@ -39,7 +46,7 @@ const (
 )
 
 // Create implements IMetadataRepository
-func (m MetadataDb) Create(typeCode string, certId int, projectName string, projectType string, imageName string, description string, listedDate string) (*Metadata, error) {
+func (m MetadataDb) Create(ctx context.Context, typeCode string, certId int, projectName string, projectType string, imageName string, description string, listedDate string) (*Metadata, error) {
 
 	metadata := Metadata{
 		TypeCode:      typeCode,
@@ -50,80 +57,111 @@ func (m MetadataDb) Create(typeCode string, certId int, projectName string, proj
 		Description:   description,
 		ListedDate:    listedDate,
 	}
-	_, err := m.col.InsertOne(m.ctx, metadata)
+	_, err := m.col.InsertOne(ctx, metadata)
 	if err != nil {
-		return nil, err
+		return nil, wrapError(err)
 	}
 	return &metadata, nil
 }
 
+// CreateMany implements IMetadataRepository. All of metadatas are inserted
+// or none: in a transaction when mongo has them, otherwise the ones inserted
+// before a failure are deleted again.
+func (m MetadataDb) CreateMany(ctx context.Context, metadatas []Metadata) error {
+	if len(metadatas) == 0 {
+		return nil
+	}
+	docs := make([]interface{}, len(metadatas))
+	for i, v := range metadatas {
+		docs[i] = v
+	}
+	return withTransaction(ctx, m.col.Database().Client(), func(ctx context.Context) error {
+		_, err := m.col.InsertMany(ctx, docs)
+		if err != nil && mongo.SessionFromContext(ctx) == nil {
+			m.deleteInserted(ctx, metadatas, err)
+		}
+		return wrapError(err)
+	})
+}
+
+// deleteInserted deletes the metadatas an ordered insert wrote before it
+// failed with err, a transaction rolls them back instead
+func (m MetadataDb) deleteInserted(ctx context.Context, metadatas []Metadata, err error) {
+	inserted := len(metadatas)
+	bulkErr := mongo.BulkWriteException{}
+	if errors.As(err, &bulkErr) && len(bulkErr.WriteErrors) > 0 {
+		inserted = bulkErr.WriteErrors[0].Index
+	}
+	if inserted == 0 {
+		return
+	}
+	ids := make([]int, inserted)
+	for i, v := range metadatas[:inserted] {
+		ids[i] = v.DigitalCertID
+	}
+	_, deleteErr := m.col.DeleteMany(ctx, bson.M{"digital_cert_id": bson.M{"$in": ids}})
+	if deleteErr != nil {
+		log.Printf("delete %d metadata of a failed insert: %v\n", inserted, deleteErr)
+	}
+}
+
 // DeleteByDigitalCertId implements IMetadataRepository
-func (m MetadataDb) DeleteByDigitalCertId(certId int) (*Metadata, error) {
+func (m MetadataDb) DeleteByDigitalCertId(ctx context.Context, certId int) (*Metadata, error) {
 	filter := genfilter("digital_cert_id", certId)
-	res := m.col.FindOne(m.ctx, filter)
+	res := m.col.FindOne(ctx, filter)
 	metadata := Metadata{}
 	err := res.Decode(&metadata)
 	if err != nil {
-		return nil, err
+		return nil, wrapError(err)
 	}
-	delRes, err := m.col.DeleteOne(m.ctx, filter)
+	delRes, err := m.col.DeleteOne(ctx, filter)
 	if err != nil {
-		return nil, err
+		return nil, wrapError(err)
 	}
 	if delRes.DeletedCount <= 0 {
-		return nil, fmt.Errorf("delete count == 0")
+		return nil, ErrNotFound
 	}
 	return &metadata, nil
 }
 
-// GetAll implements IMetadataRepository
-func (m MetadataDb) GetAll(ids []int) []Metadata {
-	metadatas := []Metadata{}
-	filter := bson.M{}
-	if len(ids) > 0 {
-		filter = bson.M{
-			"digital_cert_id": bson.M{
-				"$in": ids,
-			},
-		}
+// GetPage implements IMetadataRepository
+func (m MetadataDb) GetPage(ctx context.Context, filter MetadataFilter, query PageQuery) ([]Metadata, *Page, error) {
+	find := bson.M{}
+	if len(filter.Ids) > 0 {
+		find["digital_cert_id"] = bson.M{"$in": filter.Ids}
 	}
-
-	cur, err := m.col.Find(m.ctx, filter)
-	if err != nil {
-		return metadatas
+	if filter.TypeCode != "" {
+		find["type_code"] = filter.TypeCode
 	}
-	for cur.Next(m.ctx) {
-		metadata := Metadata{}
-		err = cur.Decode(&metadata)
-		if err != nil {
-			continue
-		}
-		metadatas = append(metadatas, metadata)
+	metadatas := []Metadata{}
+	page, err := findPage(ctx, m.col, find, query, MetadataSortFields, "digital_cert_id", &metadatas)
+	if err != nil {
+		return nil, nil, err
 	}
-	return metadatas
+	return metadatas, page, nil
 }
 
 // GetByDigitalCertId implements IMetadataRepository
-func (m MetadataDb) GetByDigitalCertId(certId int) (*Metadata, error) {
+func (m MetadataDb) GetByDigitalCertId(ctx context.Context, certId int) (*Metadata, error) {
 	filter := genfilter("digital_cert_id", certId)
-	res := m.col.FindOne(m.ctx, filter)
+	res := m.col.FindOne(ctx, filter)
 	medadata := Metadata{}
 	err := res.Decode(&medadata)
 	if err != nil {
-		return nil, err
+		return nil, wrapError(err)
 	}
 	return &medadata, nil
 }
 
 // GetByTypeId implements IMetadataRepository
-func (m MetadataDb) GetByTypeCode(typeCode string) []Metadata {
+func (m MetadataDb) GetByTypeCode(ctx context.Context, typeCode string) []Metadata {
 	filter := genfilter("type_code", typeCode)
 	metadatas := []Metadata{}
-	cur, err := m.col.Find(m.ctx, filter)
+	cur, err := m.col.Find(ctx, filter)
 	if err != nil {
 		return metadatas
 	}
-	for cur.Next(m.ctx) {
+	for cur.Next(ctx) {
 		metadata := Metadata{}
 		err := cur.Decode(&metadata)
 		if err != nil {
@@ -135,39 +173,33 @@ func (m MetadataDb) GetByTypeCode(typeCode string) []Metadata {
 }
 
 // UpdateByDigitalCertId implements IMetadataRepository
-func (m MetadataDb) UpdateByDigitalCertId(certId int, metadata Metadata) (*Metadata, error) {
+func (m MetadataDb) UpdateByDigitalCertId(ctx context.Context, certId int, metadata Metadata) (*Metadata, error) {
 	filter := genfilter("digital_cert_id", certId)
 	update := bson.D{primitive.E{Key: "$set", Value: metadata}}
 
-	updateRes, err := m.col.UpdateOne(m.ctx, filter, update)
+	err := withTransaction(ctx, m.col.Database().Client(), func(ctx context.Context) error {
+		updateRes, err := m.col.UpdateOne(ctx, filter, update)
+		if err != nil {
+			return wrapError(err)
+		}
+		if updateRes.ModifiedCount <= 0 {
+			return ErrNoChange
+		}
+		event, err := NewOutboxEvent(METADATA_UPDATED_EVENT, strconv.Itoa(certId), metadata)
+		if err != nil {
+			return err
+		}
+		return insertOutbox(ctx, m.col.Database(), event)
+	})
 	if err != nil {
-		return nil, err
-	}
-	if updateRes.ModifiedCount <= 0 {
-		return nil, fmt.Errorf("no update any metadata")
+		return nil, wrapError(err)
 	}
 	return &metadata, nil
 }
 
 func NewMetadataRepository(db *mongo.Database) IMetadataRepository {
 	col := db.Collection(METADATA_COLLECTION_NAME)
-	makeDigitalCertIDAsIndexes(col)
 	return MetadataDb{
 		col: col,
-		ctx: context.Background(),
-	}
-}
-
-func makeDigitalCertIDAsIndexes(collection *mongo.Collection) {
-	indexName, err := collection.Indexes().CreateOne(
-		context.Background(),
-		mongo.IndexModel{
-			Keys:    bson.D{{Key: "digital_cert_id", Value: 1}},
-			Options: options.Index().SetUnique(true),
-		},
-	)
-	if err != nil {
-		panic(err)
 	}
-	fmt.Println("index name:", indexName)
 }
@@ -0,0 +1,141 @@
+package repository_test
+
+import (
+	"testing"
+
+	"github.com/seenark/super-backend-temp/repository"
+)
+
+func TestMetadata(t *testing.T) {
+	eachBackend(t, func(t *testing.T, repos repository.Repositories) {
+		createTests := []struct {
+			name     string
+			typeCode string
+			certId   int
+			err      error
+		}{
+			{"first", "REC", 1, nil},
+			{"second", "REC", 2, nil},
+			{"other type", "CARBON", 3, nil},
+			{"cert id taken", "CARBON", 1, repository.ErrDuplicate},
+		}
+		for _, tt := range createTests {
+			t.Run(tt.name, func(t *testing.T) {
+				created, err := repos.Metadata.Create(ctx, tt.typeCode, tt.certId, "project", "solar", "", "", "2022-01-01")
+				checkError(t, err, tt.err)
+				if err == nil && (created.DigitalCertID != tt.certId || created.TypeCode != tt.typeCode) {
+					t.Fatalf("created %+v", created)
+				}
+			})
+		}
+
+		pageTests := []struct {
+			name    string
+			filter  repository.MetadataFilter
+			query   repository.PageQuery
+			wantIds []int
+		}{
+			{"everything", repository.MetadataFilter{}, repository.PageQuery{}, []int{1, 2, 3}},
+			{"by ids", repository.MetadataFilter{Ids: []int{1, 3}}, repository.PageQuery{}, []int{1, 3}},
+			{"by type code", repository.MetadataFilter{TypeCode: "REC"}, repository.PageQuery{Sort: "-digital_cert_id"}, []int{2, 1}},
+		}
+		for _, tt := range pageTests {
+			t.Run(tt.name, func(t *testing.T) {
+				metadatas, page, err := repos.Metadata.GetPage(ctx, tt.filter, tt.query)
+				checkError(t, err, nil)
+				ids := []int{}
+				for _, v := range metadatas {
+					ids = append(ids, v.DigitalCertID)
+				}
+				if !equalInts(ids, tt.wantIds) || page.Total != int64(len(tt.wantIds)) {
+					t.Fatalf("ids = %v total %d, want %v", ids, page.Total, tt.wantIds)
+				}
+			})
+		}
+		typeCodeTests := []struct {
+			typeCode string
+			want     int
+		}{
+			{"REC", 2},
+			{"CARBON", 1},
+			{"NOPE", 0},
+		}
+		for _, tt := range typeCodeTests {
+			t.Run("type code "+tt.typeCode, func(t *testing.T) {
+				if got := repos.Metadata.GetByTypeCode(ctx, tt.typeCode); len(got) != tt.want {
+					t.Fatalf("%d %s metadata, want %d", len(got), tt.typeCode, tt.want)
+				}
+			})
+		}
+
+		found, err := repos.Metadata.GetByDigitalCertId(ctx, 2)
+		checkError(t, err, nil)
+		changed := *found
+		changed.Description = "updated"
+		updateTests := []struct {
+			name   string
+			certId int
+			err    error
+		}{
+			{"changed", 2, nil},
+			{"unchanged", 2, repository.ErrNoChange},
+			{"unknown", 9, repository.ErrNoChange},
+		}
+		for _, tt := range updateTests {
+			t.Run(tt.name, func(t *testing.T) {
+				_, err := repos.Metadata.UpdateByDigitalCertId(ctx, tt.certId, changed)
+				checkError(t, err, tt.err)
+			})
+		}
+		if types := outboxTypes(t, repos); !equalStrings(types, []string{repository.METADATA_UPDATED_EVENT}) {
+			t.Fatalf("outbox = %v", types)
+		}
+
+		_, err = repos.Metadata.DeleteByDigitalCertId(ctx, 2)
+		checkError(t, err, nil)
+		_, err = repos.Metadata.DeleteByDigitalCertId(ctx, 2)
+		checkError(t, err, repository.ErrNotFound)
+		_, err = repos.Metadata.GetByDigitalCertId(ctx, 2)
+		checkError(t, err, repository.ErrNotFound)
+	})
+}
+
+func TestMetadataCreateMany(t *testing.T) {
+	eachBackend(t, func(t *testing.T, repos repository.Repositories) {
+		_, err := repos.Metadata.Create(ctx, "REC", 3, "", "", "", "", "")
+		checkError(t, err, nil)
+		batch := func(ids ...int) []repository.Metadata {
+			metadatas := []repository.Metadata{}
+			for _, id := range ids {
+				metadatas = append(metadatas, repository.Metadata{TypeCode: "REC", DigitalCertID: id, ImageName: "image.png"})
+			}
+			return metadatas
+		}
+		tests := []struct {
+			name string
+			ids  []int
+			err  error
+		}{
+			{"existing cert id", []int{1, 2, 3, 4}, repository.ErrDuplicate},
+			{"cert id twice", []int{1, 2, 1}, repository.ErrDuplicate},
+			{"new cert ids", []int{1, 2}, nil},
+			{"empty", []int{}, nil},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				err := repos.Metadata.CreateMany(ctx, batch(tt.ids...))
+				checkError(t, err, tt.err)
+			})
+		}
+		// the failed batches left nothing behind
+		metadatas, _, err := repos.Metadata.GetPage(ctx, repository.MetadataFilter{}, repository.PageQuery{})
+		checkError(t, err, nil)
+		ids := []int{}
+		for _, v := range metadatas {
+			ids = append(ids, v.DigitalCertID)
+		}
+		if !equalInts(ids, []int{1, 2, 3}) {
+			t.Fatalf("ids = %v, want [1 2 3]", ids)
+		}
+	})
+}
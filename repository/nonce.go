@@ -0,0 +1,61 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	NONCE_COLLECTION_NAME = "siwe_nonces"
+)
+
+type Nonce struct {
+	Nonce     string    `bson:"nonce" json:"nonce"`
+	ExpiresAt time.Time `bson:"expires_at" json:"expires_at"`
+}
+
+// NonceRepository stores one-time nonces for Sign-In with Ethereum.
+type NonceRepository interface {
+	Create(ctx context.Context, nonce string, expiresAt time.Time) (*Nonce, error)
+	Consume(ctx context.Context, nonce string) error
+}
+
+type NonceDb struct {
+	col *mongo.Collection
+}
+
+func NewNonceDb(db *mongo.Database) NonceRepository {
+	col := db.Collection(NONCE_COLLECTION_NAME)
+	return NonceDb{
+		col: col,
+	}
+}
+
+// Create implements NonceRepository
+func (n NonceDb) Create(ctx context.Context, nonce string, expiresAt time.Time) (*Nonce, error) {
+	newNonce := Nonce{
+		Nonce:     nonce,
+		ExpiresAt: expiresAt,
+	}
+	_, err := n.col.InsertOne(ctx, newNonce)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	return &newNonce, nil
+}
+
+// Consume implements NonceRepository, a nonce can only be consumed once
+func (n NonceDb) Consume(ctx context.Context, nonce string) error {
+	filter := bson.M{
+		"nonce":      nonce,
+		"expires_at": bson.M{"$gt": time.Now()},
+	}
+	res := n.col.FindOneAndDelete(ctx, filter)
+	if res.Err() == mongo.ErrNoDocuments {
+		return newKindError(ErrNotFound, "nonce is invalid or expired")
+	}
+	return res.Err()
+}
@@ -0,0 +1,90 @@
+package repository_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/seenark/super-backend-temp/repository"
+)
+
+func TestNonceCreate(t *testing.T) {
+	eachBackend(t, func(t *testing.T, repos repository.Repositories) {
+		tests := []struct {
+			name      string
+			nonce     string
+			expiresAt time.Time
+			err       error
+		}{
+			{"new", "fresh", time.Now().Add(time.Minute), nil},
+			{"taken", "fresh", time.Now().Add(time.Minute), repository.ErrDuplicate},
+			{"already expired", "stale", time.Now().Add(-time.Minute), nil},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				created, err := repos.Nonces.Create(ctx, tt.nonce, tt.expiresAt)
+				checkError(t, err, tt.err)
+				if err == nil && created.Nonce != tt.nonce {
+					t.Fatalf("created %+v", created)
+				}
+			})
+		}
+	})
+}
+
+func TestNonceConsume(t *testing.T) {
+	eachBackend(t, func(t *testing.T, repos repository.Repositories) {
+		_, err := repos.Nonces.Create(ctx, "fresh", time.Now().Add(time.Minute))
+		checkError(t, err, nil)
+		_, err = repos.Nonces.Create(ctx, "stale", time.Now().Add(-time.Minute))
+		checkError(t, err, nil)
+
+		tests := []struct {
+			name  string
+			nonce string
+			err   error
+		}{
+			{"fresh", "fresh", nil},
+			{"used twice", "fresh", repository.ErrNotFound},
+			{"expired", "stale", repository.ErrNotFound},
+			{"unknown", "unknown", repository.ErrNotFound},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				checkError(t, repos.Nonces.Consume(ctx, tt.nonce), tt.err)
+			})
+		}
+	})
+}
+
+func TestNonceConsumeOnce(t *testing.T) {
+	eachBackend(t, func(t *testing.T, repos repository.Repositories) {
+		_, err := repos.Nonces.Create(ctx, "contested", time.Now().Add(time.Minute))
+		checkError(t, err, nil)
+
+		// signins racing with the same nonce, only one may use it
+		const tries = 8
+		errs := make(chan error, tries)
+		wg := sync.WaitGroup{}
+		for i := 0; i < tries; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs <- repos.Nonces.Consume(ctx, "contested")
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		consumed := 0
+		for err := range errs {
+			if err == nil {
+				consumed++
+				continue
+			}
+			checkError(t, err, repository.ErrNotFound)
+		}
+		if consumed != 1 {
+			t.Fatalf("consumed %d times, want once", consumed)
+		}
+	})
+}
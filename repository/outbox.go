@@ -0,0 +1,226 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	OUTBOX_COLLECTION_NAME = "outbox"
+)
+
+// outbox event types
+const (
+	REDEEMED_CREATED_EVENT           = "redeemed.created"
+	REDEEMED_RECORDED_EVENT          = "redeemed.recorded" // the redeem event of a redemption the customer created first
+	REDEEMED_DETAILS_SUBMITTED_EVENT = "redeemed.details_submitted"
+	REDEEMED_STATUS_CHANGED_EVENT    = "redeemed.status_changed"
+	REDEEMED_REMOVED_EVENT           = "redeemed.removed"
+	METADATA_UPDATED_EVENT           = "metadata.updated"
+	CERT_TYPE_CREATED_EVENT          = "cert_type.created"
+	// one delivery of an event to one webhook
+	WEBHOOK_DELIVERY_EVENT = "webhook.delivery"
+)
+
+const (
+	OUTBOX_PENDING = "pending"
+	OUTBOX_DONE    = "done"
+	OUTBOX_FAILED  = "failed"
+)
+
+// OutboxEvent is written together with the change it announces and is
+// delivered later by the outbox worker. Handlers that already succeeded are
+// listed in Done so a retry only runs the ones that failed.
+type OutboxEvent struct {
+	Id            primitive.ObjectID `bson:"_id" json:"id"`
+	Type          string             `bson:"type" json:"type"`
+	Subject       string             `bson:"subject" json:"subject"` // id of what the event is about
+	Data          bson.Raw           `bson:"data" json:"-"`
+	Status        string             `bson:"status" json:"status"`
+	Done          []string           `bson:"done" json:"done"`
+	Attempts      int                `bson:"attempts" json:"attempts"`
+	NextAttemptAt time.Time          `bson:"next_attempt_at" json:"next_attempt_at"`
+	LockedUntil   time.Time          `bson:"locked_until" json:"-"`
+	LastError     string             `bson:"last_error,omitempty" json:"last_error,omitempty"`
+	CreatedAt     time.Time          `bson:"created_at" json:"created_at"`
+	ProcessedAt   *time.Time         `bson:"processed_at,omitempty" json:"processed_at,omitempty"`
+}
+
+// RedeemedEventData is the data of every redeemed.* event
+type RedeemedEventData struct {
+	Redeemed Redeemed      `bson:"redeemed" json:"redeemed"`
+	Change   *StatusChange `bson:"change,omitempty" json:"change,omitempty"`
+}
+
+// WebhookDeliveryData is the data of a webhook.delivery event. Payload is
+// the signed JSON body, kept as is so retries and replays send the same bytes.
+type WebhookDeliveryData struct {
+	WebhookId primitive.ObjectID  `bson:"webhook_id"`
+	EventId   string              `bson:"event_id"`
+	EventType string              `bson:"event_type"`
+	Payload   string              `bson:"payload"`
+	ReplayOf  *primitive.ObjectID `bson:"replay_of,omitempty"`
+}
+
+func NewOutboxEvent(eventType string, subject string, data interface{}) (OutboxEvent, error) {
+	raw, err := bson.Marshal(data)
+	if err != nil {
+		return OutboxEvent{}, err
+	}
+	now := time.Now()
+	return OutboxEvent{
+		Id:            primitive.NewObjectID(),
+		Type:          eventType,
+		Subject:       subject,
+		Data:          raw,
+		Status:        OUTBOX_PENDING,
+		Done:          []string{},
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	}, nil
+}
+
+// Decode unmarshals the event data into v
+func (e OutboxEvent) Decode(v interface{}) error {
+	return bson.Unmarshal(e.Data, v)
+}
+
+// IsDone reports whether handler already handled the event
+func (e OutboxEvent) IsDone(handler string) bool {
+	return contains(e.Done, handler)
+}
+
+type OutboxRepository interface {
+	Add(ctx context.Context, events ...OutboxEvent) error
+	Claim(ctx context.Context, lockFor time.Duration) (*OutboxEvent, error)
+	MarkHandled(ctx context.Context, id primitive.ObjectID, handler string) error
+	Complete(ctx context.Context, id primitive.ObjectID) error
+	Retry(ctx context.Context, id primitive.ObjectID, nextAttemptAt time.Time, lastError string) error
+	Fail(ctx context.Context, id primitive.ObjectID, lastError string) error
+}
+
+type OutboxDb struct {
+	col *mongo.Collection
+}
+
+func NewOutboxDb(db *mongo.Database) OutboxRepository {
+	col := db.Collection(OUTBOX_COLLECTION_NAME)
+	return OutboxDb{
+		col: col,
+	}
+}
+
+// Add implements OutboxRepository, for events that are not written together
+// with a change
+func (o OutboxDb) Add(ctx context.Context, events ...OutboxEvent) error {
+	return insertOutbox(ctx, o.col.Database(), events...)
+}
+
+// Claim implements OutboxRepository. It locks the oldest due event for
+// lockFor so other workers skip it, nil means nothing is due.
+func (o OutboxDb) Claim(ctx context.Context, lockFor time.Duration) (*OutboxEvent, error) {
+	now := time.Now()
+	filter := bson.M{
+		"status":          OUTBOX_PENDING,
+		"next_attempt_at": bson.M{"$lte": now},
+		"locked_until":    bson.M{"$lte": now},
+	}
+	update := bson.M{
+		"$set": bson.M{"locked_until": now.Add(lockFor)},
+		"$inc": bson.M{"attempts": 1},
+	}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "next_attempt_at", Value: 1}}).
+		SetReturnDocument(options.After)
+	res := o.col.FindOneAndUpdate(ctx, filter, update, opts)
+	event := OutboxEvent{}
+	err := res.Decode(&event)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	return &event, nil
+}
+
+// MarkHandled implements OutboxRepository
+func (o OutboxDb) MarkHandled(ctx context.Context, id primitive.ObjectID, handler string) error {
+	_, err := o.col.UpdateByID(ctx, id, bson.M{"$addToSet": bson.M{"done": handler}})
+	return err
+}
+
+// Complete implements OutboxRepository
+func (o OutboxDb) Complete(ctx context.Context, id primitive.ObjectID) error {
+	update := bson.M{
+		"$set":   bson.M{"status": OUTBOX_DONE, "processed_at": time.Now()},
+		"$unset": bson.M{"last_error": ""},
+	}
+	_, err := o.col.UpdateByID(ctx, id, update)
+	return err
+}
+
+// Retry implements OutboxRepository
+func (o OutboxDb) Retry(ctx context.Context, id primitive.ObjectID, nextAttemptAt time.Time, lastError string) error {
+	update := bson.M{"$set": bson.M{
+		"next_attempt_at": nextAttemptAt,
+		"locked_until":    time.Time{},
+		"last_error":      lastError,
+	}}
+	_, err := o.col.UpdateByID(ctx, id, update)
+	return err
+}
+
+// Fail implements OutboxRepository, the event is not tried again
+func (o OutboxDb) Fail(ctx context.Context, id primitive.ObjectID, lastError string) error {
+	update := bson.M{"$set": bson.M{
+		"status":       OUTBOX_FAILED,
+		"last_error":   lastError,
+		"processed_at": time.Now(),
+	}}
+	_, err := o.col.UpdateByID(ctx, id, update)
+	return err
+}
+
+// insertOutbox writes events with ctx, which is the session of the
+// transaction of the change when there is one
+func insertOutbox(ctx context.Context, db *mongo.Database, events ...OutboxEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	docs := []interface{}{}
+	for _, v := range events {
+		docs = append(docs, v)
+	}
+	_, err := db.Collection(OUTBOX_COLLECTION_NAME).InsertMany(ctx, docs)
+	return err
+}
+
+// withTransaction runs fn in a transaction. A standalone mongod has no
+// transactions, there fn runs without one.
+func withTransaction(ctx context.Context, client *mongo.Client, fn func(ctx context.Context) error) error {
+	session, err := client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+	_, err = session.WithTransaction(ctx, func(sessionCtx mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sessionCtx)
+	})
+	if isTransactionUnsupported(err) {
+		return fn(ctx)
+	}
+	return err
+}
+
+func isTransactionUnsupported(err error) bool {
+	cmdErr := mongo.CommandError{}
+	// IllegalOperation: Transaction numbers are only allowed on a replica set member or mongos
+	return errors.As(err, &cmdErr) && cmdErr.Code == 20
+}
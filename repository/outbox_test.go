@@ -0,0 +1,72 @@
+package repository_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/seenark/super-backend-temp/repository"
+)
+
+func TestOutbox(t *testing.T) {
+	eachBackend(t, func(t *testing.T, repos repository.Repositories) {
+		event, err := repository.NewOutboxEvent(repository.METADATA_UPDATED_EVENT, "1", repository.Metadata{DigitalCertID: 1})
+		checkError(t, err, nil)
+		checkError(t, repos.Outbox.Add(ctx, event), nil)
+
+		claimed, err := repos.Outbox.Claim(ctx, time.Hour)
+		checkError(t, err, nil)
+		if claimed == nil || claimed.Id != event.Id || claimed.Attempts != 1 {
+			t.Fatalf("claimed %+v", claimed)
+		}
+		data := repository.Metadata{}
+		checkError(t, claimed.Decode(&data), nil)
+		if data.DigitalCertID != 1 {
+			t.Fatalf("data = %+v", data)
+		}
+		// locked while a worker handles it
+		again, err := repos.Outbox.Claim(ctx, time.Hour)
+		checkError(t, err, nil)
+		if again != nil {
+			t.Fatal("claimed a locked event")
+		}
+
+		checkError(t, repos.Outbox.MarkHandled(ctx, event.Id, "email"), nil)
+		checkError(t, repos.Outbox.MarkHandled(ctx, event.Id, "email"), nil)
+		checkError(t, repos.Outbox.Retry(ctx, event.Id, time.Now().Add(-time.Second), "timeout"), nil)
+		retried, err := repos.Outbox.Claim(ctx, time.Hour)
+		checkError(t, err, nil)
+		if retried == nil || retried.Attempts != 2 || retried.LastError != "timeout" || len(retried.Done) != 1 || !retried.IsDone("email") {
+			t.Fatalf("retried %+v", retried)
+		}
+
+		checkError(t, repos.Outbox.Complete(ctx, event.Id), nil)
+		checkError(t, repos.Outbox.Retry(ctx, event.Id, time.Now().Add(-time.Second), ""), nil)
+		done, err := repos.Outbox.Claim(ctx, time.Hour)
+		checkError(t, err, nil)
+		if done != nil {
+			t.Fatal("claimed a completed event")
+		}
+
+		failing, err := repository.NewOutboxEvent(repository.CERT_TYPE_CREATED_EVENT, "REC", repository.DigitalCertType{})
+		checkError(t, err, nil)
+		checkError(t, repos.Outbox.Add(ctx, failing), nil)
+		checkError(t, repos.Outbox.Fail(ctx, failing.Id, "bad data"), nil)
+		failed, err := repos.Outbox.Claim(ctx, time.Hour)
+		checkError(t, err, nil)
+		if failed != nil {
+			t.Fatal("claimed a failed event")
+		}
+	})
+}
+
+func TestCanceledContext(t *testing.T) {
+	eachBackend(t, func(t *testing.T, repos repository.Repositories) {
+		canceled, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := repos.Redeemed.GetByRedeemId(canceled, 1)
+		checkError(t, err, repository.ErrTimeout)
+		_, _, err = repos.Users.GetPage(canceled, repository.PageQuery{})
+		checkError(t, err, repository.ErrTimeout)
+	})
+}
@@ -0,0 +1,231 @@
+package repository
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	DEFAULT_PAGE_LIMIT = 50
+	MAX_PAGE_LIMIT     = 200
+)
+
+var (
+	ErrInvalidSort   = errors.New("sort field is not allowed")
+	ErrInvalidCursor = errors.New("cursor is invalid")
+)
+
+// sort fields allowed per resource, a "-" prefix sorts descending
+var (
+	UserSortFields            = []string{"_id", "name", "email", "role"}
+	DigitalCertTypeSortFields = []string{"type_code", "type_name", "vintage_year"}
+	MetadataSortFields        = []string{"digital_cert_id", "type_code", "project_name", "listed_date"}
+	RedeemedSortFields        = []string{"redeem_id", "redeem_date", "amount", "approved_status", "name", "company"}
+)
+
+// PageQuery asks for one page of a list. Cursor wins over Page when both are
+// given.
+type PageQuery struct {
+	Limit  int
+	Page   int    // starts at 1
+	Cursor string // NextCursor of the previous page
+	Sort   string // e.g. "-redeem_date"
+}
+
+// Page describes the page that was returned
+type Page struct {
+	Total      int64  `json:"total"`
+	Limit      int    `json:"limit"`
+	NextCursor string `json:"next_cursor"`
+}
+
+// pageBounds is what a page query asks for. After is the position of the
+// last item of the previous page when the query has a cursor.
+type pageBounds struct {
+	sortName string
+	sort     bson.D
+	skip     int
+	limit    int
+	after    *cursor
+}
+
+// cursor is the sort value and _id of the last item of a page, bound to
+// the sort it was made for. The next page starts after it, so items added
+// or removed meanwhile do not shift it.
+type cursor struct {
+	Sort  string      `bson:"s"`
+	Value interface{} `bson:"v"`
+	Id    interface{} `bson:"i"`
+}
+
+// findPage finds one page of filter into items, a pointer to a slice. A
+// cursor page is found with a range on the sort field and _id, a page by
+// number is skipped to. _id breaks ties so pages do not overlap.
+func findPage(ctx context.Context, col *mongo.Collection, filter interface{}, query PageQuery, sortFields []string, defaultSort string, items interface{}) (*Page, error) {
+	bounds, err := newPageBounds(query, sortFields, defaultSort)
+	if err != nil {
+		return nil, err
+	}
+
+	// the total is of the whole filter, a cursor only moves the page
+	total, err := col.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	find := filter
+	if bounds.after != nil {
+		find = bson.M{"$and": bson.A{filter, bounds.after.filter(bounds.sort)}}
+	}
+	// one more than the limit tells whether there is a next page
+	opts := options.Find().SetSort(bounds.sort).SetSkip(int64(bounds.skip)).SetLimit(int64(bounds.limit + 1))
+	cur, err := col.Find(ctx, find, opts)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	docs := []bson.Raw{}
+	err = cur.All(ctx, &docs)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	page := &Page{Total: total, Limit: bounds.limit}
+	if len(docs) > bounds.limit {
+		docs = docs[:bounds.limit]
+		last := docs[len(docs)-1]
+		next := cursor{Sort: bounds.sortName, Value: rawField(last, bounds.sort[0].Key), Id: rawField(last, "_id")}
+		page.NextCursor, err = next.encode()
+		if err != nil {
+			return nil, err
+		}
+	}
+	list := reflect.ValueOf(items).Elem()
+	list.Set(reflect.MakeSlice(list.Type(), len(docs), len(docs)))
+	for i, v := range docs {
+		err := bson.Unmarshal(v, list.Index(i).Addr().Interface())
+		if err != nil {
+			return nil, wrapError(err)
+		}
+	}
+	return page, nil
+}
+
+// rawField is the value of key in doc, nil when it is missing
+func rawField(doc bson.Raw, key string) interface{} {
+	value, err := doc.LookupErr(key)
+	if err != nil {
+		return nil
+	}
+	return value
+}
+
+// newPageBounds reads the sort, start and size of the page query asks for
+func newPageBounds(query PageQuery, sortFields []string, defaultSort string) (*pageBounds, error) {
+	bounds := &pageBounds{sortName: query.Sort}
+	if bounds.sortName == "" {
+		bounds.sortName = defaultSort
+	}
+	sort, err := parseSort(bounds.sortName, sortFields)
+	if err != nil {
+		return nil, err
+	}
+	bounds.sort = sort
+
+	bounds.limit = query.Limit
+	if bounds.limit <= 0 {
+		bounds.limit = DEFAULT_PAGE_LIMIT
+	}
+	if bounds.limit > MAX_PAGE_LIMIT {
+		bounds.limit = MAX_PAGE_LIMIT
+	}
+	if query.Cursor != "" {
+		bounds.after, err = decodeCursor(query.Cursor, bounds.sortName)
+		if err != nil {
+			return nil, err
+		}
+	} else if query.Page > 1 {
+		bounds.skip = (query.Page - 1) * bounds.limit
+	}
+	return bounds, nil
+}
+
+func parseSort(sortName string, sortFields []string) (bson.D, error) {
+	field := strings.TrimPrefix(sortName, "-")
+	direction := 1
+	if field != sortName {
+		direction = -1
+	}
+	for _, v := range sortFields {
+		if v == field {
+			sort := bson.D{{Key: field, Value: direction}}
+			if field != "_id" {
+				sort = append(sort, bson.E{Key: "_id", Value: direction})
+			}
+			return sort, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %q, use one of %s", ErrInvalidSort, field, strings.Join(sortFields, ", "))
+}
+
+// filter matches the items sorted by sort after the cursor. Null sorts
+// first, so ascending it is only followed by values and descending every
+// value is followed by null.
+func (c cursor) filter(sort bson.D) bson.M {
+	field := sort[0].Key
+	op := "$gt"
+	if sort[0].Value.(int) < 0 {
+		op = "$lt"
+	}
+	if field == "_id" {
+		return bson.M{"_id": bson.M{op: c.Id}}
+	}
+	sameValue := bson.M{field: bson.M{"$eq": c.Value}, "_id": bson.M{op: c.Id}}
+	switch {
+	case c.Value == nil && op == "$gt":
+		return bson.M{"$or": bson.A{bson.M{field: bson.M{"$ne": nil}}, sameValue}}
+	case c.Value == nil:
+		return sameValue
+	case op == "$gt":
+		return bson.M{"$or": bson.A{bson.M{field: bson.M{op: c.Value}}, sameValue}}
+	}
+	return bson.M{"$or": bson.A{bson.M{field: bson.M{op: c.Value}}, sameValue, bson.M{field: bson.M{"$eq": nil}}}}
+}
+
+func (c cursor) encode() (string, error) {
+	data, err := bson.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeCursor(encoded string, sortName string) (*cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	c := &cursor{}
+	err = bson.Unmarshal(data, c)
+	if err != nil || c.Sort != sortName || !isCursorValue(c.Value) || !isCursorValue(c.Id) {
+		return nil, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// isCursorValue reports whether v is a value a cursor can hold, documents
+// and arrays are refused so a cursor can not smuggle in query operators
+func isCursorValue(v interface{}) bool {
+	switch v.(type) {
+	case nil, int32, int64, float64, string, bool, primitive.ObjectID, primitive.DateTime:
+		return true
+	}
+	return false
+}
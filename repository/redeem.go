@@ -28,7 +28,7 @@ package repository
 // }
 
 // // GetRedeemByFutureContractId implements IFutureContractEventRepository
-// func (fe RedeemEventDb) GetRedeemByFutureContractId(id int) []RedeemEvent {
+// func (fe RedeemEventDb) GetRedeemByFutureContractId(ctx context.Context, id int) []RedeemEvent {
 // 	pipeline := []bson.D{
 // 		{
 // 			{Key: "$match", Value: bson.M{"futureTokenId": id}},
@@ -80,7 +80,7 @@ package repository
 // }
 
 // // GetRedeemByCustomerAddress implements IRedeemEventRepository
-// func (fe RedeemEventDb) GetRedeemByCustomerAddress(address string) []RedeemEvent {
+// func (fe RedeemEventDb) GetRedeemByCustomerAddress(ctx context.Context, address string) []RedeemEvent {
 // 	filter := genfilter("customer", bson.D{{Key: "$regex", Value: primitive.Regex{Pattern: address, Options: "i"}}})
 // 	redeems := []RedeemEvent{}
 
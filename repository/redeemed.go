@@ -2,9 +2,14 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -14,239 +19,659 @@ const (
 	APPROVED_STATUS        = "approved"
 	PENDING_STATUS         = "pending"
 	DELIVERED_STATUS       = "delivered"
+	REJECTED_STATUS        = "rejected"
 	REDEEM_COLLECTION_NAME = "redeemeds"
+	// log index of a redemption whose chain event is not ingested yet
+	UNKNOWN_LOG_INDEX = -1
 )
 
-type Redeemed struct {
-	TxHash        string `bson:"tx_hash" json:"tx_hash"` // indexed
-	Name          string `bson:"name" json:"name"`
-	Company       string `bson:"company" json:"company"`
-	Email         string `bson:"email" json:"email"`
-	Telephone     string `bson:"telephone" json:"telephone"`
-	TaxID         string `bson:"tax_id" json:"tax_id"`
-	Price         string `bson:"price" json:"price"` // BigNumber in string format
-	RedeemId      int    `bson:"redeem_id" json:"redeem_id"`
-	RedeemDate    int    `bson:"redeem_date" json:"redeem_date"` // Unix timestamp
-	WalletAddress string `bson:"wallet_address" json:"wallet_address"`
-	ApproveStatus string `bson:"approved_status" json:"approved_status"`
-	Amount        int    `bson:"amount" json:"amount"`
-	CertId        int    `bson:"cert_id" json:"cert_id"`
+// redeemStatusTransitions lists where a redemption may go from each status.
+// delivered and rejected are final.
+var redeemStatusTransitions = map[string][]string{
+	REQUEST_STATUS:   {PENDING_STATUS, APPROVED_STATUS, REJECTED_STATUS},
+	PENDING_STATUS:   {APPROVED_STATUS, REJECTED_STATUS},
+	APPROVED_STATUS:  {DELIVERED_STATUS, REJECTED_STATUS},
+	DELIVERED_STATUS: {},
+	REJECTED_STATUS:  {},
 }
 
-type IRedeemedRepository interface {
-	create(Redeemed) (*Redeemed, error)
-	update(Redeemed) (*Redeemed, error)
-	Upsert(Redeemed) (*Redeemed, error)
-	GetAll(approveStatus []string, name string, email string, redeemId []int, startDate int, endDate int, walletAddress string) []Redeemed
-	GetByTxHash(txHash string) (*Redeemed, error)
-	GetByRedeemId(redeemId int) (*Redeemed, error)
-	UpdateStatus(redeemId int, status string) (*Redeemed, error)
-}
+var (
+	ErrInvalidStatus     = errors.New("approved status is invalid")
+	ErrInvalidTransition = newKindError(ErrConflict, "approved status can not change this way")
+	ErrReasonRequired    = errors.New("a reason is required to reject")
+	ErrStatusChanged     = newKindError(ErrConflict, "approved status was changed by someone else")
+	ErrLogIndexRequired  = newKindError(ErrConflict, "the transaction has more than one redemption, log_index is required")
+	ErrNotOwner          = errors.New("only the wallet of the redemption can submit its details")
+)
 
-type RedeemedDb struct {
-	col *mongo.Collection
-	ctx context.Context
+func IsValidRedeemStatus(status string) bool {
+	_, ok := redeemStatusTransitions[status]
+	return ok
 }
 
-// create implements IRedeemedRepository
-func (r RedeemedDb) create(redeemed Redeemed) (*Redeemed, error) {
-	_, err := r.col.InsertOne(r.ctx, redeemed)
-	if err != nil {
-		return nil, err
+// CanChangeRedeemStatus reports whether a redemption in status from may move
+// to status to. Old documents without a status count as requested.
+func CanChangeRedeemStatus(from string, to string) bool {
+	if from == "" {
+		from = REQUEST_STATUS
 	}
-	return &redeemed, nil
+	for _, v := range redeemStatusTransitions[from] {
+		if v == to {
+			return true
+		}
+	}
+	return false
 }
 
-// update implements IRedeemedRepository
-func (r RedeemedDb) update(redeemed Redeemed) (*Redeemed, error) {
-	filter := genfilter("tx_hash", redeemed.TxHash)
-	update := bson.M{"$set": redeemed}
+// StatusChange is one entry of the status history of a redemption
+type StatusChange struct {
+	From      string    `bson:"from" json:"from"`
+	To        string    `bson:"to" json:"to"`
+	Actor     string    `bson:"actor" json:"actor"` // user or api key id
+	Note      string    `bson:"note" json:"note"`
+	ChangedAt time.Time `bson:"changed_at" json:"changed_at"`
+}
 
-	updateRes, err := r.col.UpdateOne(r.ctx, filter, update)
-	if err != nil {
-		return nil, err
-	}
-	if updateRes.ModifiedCount <= 0 {
-		return nil, fmt.Errorf("no update any item")
-	}
-	return &redeemed, nil
+type Redeemed struct {
+	TxHash string `bson:"tx_hash" json:"tx_hash"` // indexed
+	// index of the redeem event log in its block, with TxHash the key of a
+	// redemption. UNKNOWN_LOG_INDEX until the chain event is ingested.
+	LogIndex  int    `bson:"log_index" json:"log_index"`
+	Name      string `bson:"name" json:"name"`
+	Company   string `bson:"company" json:"company"`
+	Email     string `bson:"email" json:"email"`
+	Telephone string `bson:"telephone" json:"telephone"`
+	TaxID     string `bson:"tax_id" json:"tax_id"`
+	Price     string `bson:"price" json:"price"` // BigNumber in string format
+	// Price as a number for queries and sums, kept in step with Price
+	PriceDecimal  primitive.Decimal128 `bson:"price_decimal,omitempty" json:"-"`
+	RedeemId      int                  `bson:"redeem_id" json:"redeem_id"`
+	RedeemDate    int                  `bson:"redeem_date" json:"redeem_date"` // Unix timestamp
+	WalletAddress string               `bson:"wallet_address" json:"wallet_address"`
+	ApproveStatus string               `bson:"approved_status" json:"approved_status"`
+	Amount        int                  `bson:"amount" json:"amount"`
+	CertId        int                  `bson:"cert_id" json:"cert_id"`
+	RejectReason  string               `bson:"reject_reason,omitempty" json:"reject_reason,omitempty"`
+	BlockNumber   uint64               `bson:"block_number,omitempty" json:"block_number,omitempty"`
+	// the event is no longer on the chain after a reorg, a redemption is
+	// never deleted
+	Removed bool `bson:"removed,omitempty" json:"removed,omitempty"`
+	// wallet of the customer that submitted the details, proven with SIWE.
+	// Empty when staff did.
+	SubmittedBy string `bson:"submitted_by,omitempty" json:"submitted_by,omitempty"`
+	// object name of the PDF certificate in cloud storage, set once delivered
+	CertificateObject string `bson:"certificate_object,omitempty" json:"-"`
+	// printed on the certificate, looks the redemption up on the public verify route
+	VerificationCode string `bson:"verification_code,omitempty" json:"verification_code,omitempty"`
+	// only written by UpdateStatus, served by GetHistory
+	StatusHistory []StatusChange `bson:"status_history,omitempty" json:"-"`
+}
+
+// RedeemedFilter narrows a redemption list, empty fields match everything
+type RedeemedFilter struct {
+	ApproveStatus []string
+	Name          string
+	Email         string
+	RedeemId      []int
+	StartDate     int // unix timestamp
+	EndDate       int
+	WalletAddress string
+	// blocks of the redeem events, both included, when ToBlock is set
+	FromBlock uint64
+	ToBlock   uint64
 }
 
-// GetAll implements IRedeemedRepository
-func (r RedeemedDb) GetAll(approveStatus []string, name string, email string, redeemId []int, startDate int, endDate int, walletAddress string) []Redeemed {
+func (f RedeemedFilter) toFilter() bson.M {
 	filter := bson.M{}
-	if len(approveStatus) > 0 {
-		filter["approved_status"] = bson.M{"$in": approveStatus}
+	if len(f.ApproveStatus) > 0 {
+		filter["approved_status"] = bson.M{"$in": f.ApproveStatus}
 	}
 
-	if name != "" {
-		filter["name"] = name
+	if f.Name != "" {
+		filter["name"] = f.Name
 	}
 
-	if email != "" {
-		filter["email"] = email
+	if f.Email != "" {
+		filter["email"] = f.Email
 	}
 
-	if len(redeemId) > 0 {
-		filter["redeem_id"] = bson.M{"$in": redeemId}
+	if len(f.RedeemId) > 0 {
+		filter["redeem_id"] = bson.M{"$in": f.RedeemId}
 	}
 
-	if startDate > 0 && endDate > 0 {
+	if f.StartDate > 0 && f.EndDate > 0 {
 		filter["$and"] = bson.A{
-			bson.M{"redeem_date": bson.M{"$gte": startDate}},
-			bson.M{"redeem_date": bson.M{"$lt": endDate}},
+			bson.M{"redeem_date": bson.M{"$gte": f.StartDate}},
+			bson.M{"redeem_date": bson.M{"$lt": f.EndDate}},
 		}
-	} else if startDate > 0 && endDate == 0 {
-		filter["redeem_date"] = bson.M{"$gte": startDate}
-	} else if endDate > 0 && startDate == 0 {
-		filter["redeem_date"] = bson.M{"$lte": endDate}
+	} else if f.StartDate > 0 && f.EndDate == 0 {
+		filter["redeem_date"] = bson.M{"$gte": f.StartDate}
+	} else if f.EndDate > 0 && f.StartDate == 0 {
+		filter["redeem_date"] = bson.M{"$lte": f.EndDate}
 	}
 
-	if walletAddress != "" {
-		filter["wallet_address"] = walletAddress
+	if f.WalletAddress != "" {
+		filter["wallet_address"] = f.WalletAddress
 	}
 
-	fmt.Printf("filter: %v\n", filter)
+	if f.ToBlock > 0 {
+		filter["block_number"] = bson.M{"$gte": f.FromBlock, "$lte": f.ToBlock}
+	}
+	return filter
+}
+
+type IRedeemedRepository interface {
+	create(context.Context, Redeemed) (*Redeemed, error)
+	update(ctx context.Context, logIndex int, redeemed Redeemed) (*Redeemed, error)
+	Upsert(context.Context, Redeemed) (*Redeemed, error)
+	MarkRemoved(ctx context.Context, txHash string, logIndex int) (*Redeemed, error)
+	GetPage(ctx context.Context, filter RedeemedFilter, query PageQuery) ([]Redeemed, *Page, error)
+	Iterate(ctx context.Context, filter RedeemedFilter, fn func(Redeemed) error) error
+	GetByTxHash(ctx context.Context, txHash string) (*Redeemed, error)
+	GetByRedeemId(ctx context.Context, redeemId int) (*Redeemed, error)
+	GetByVerificationCode(ctx context.Context, code string) (*Redeemed, error)
+	UpdateStatus(ctx context.Context, redeemId int, status string, actor string, note string) (*Redeemed, error)
+	GetHistory(ctx context.Context, redeemId int) ([]StatusChange, error)
+	SetCertificate(ctx context.Context, redeemId int, object string, verificationCode string) error
+}
+
+type RedeemedDb struct {
+	col *mongo.Collection
+}
+
+// create implements IRedeemedRepository
+func (r RedeemedDb) create(ctx context.Context, redeemed Redeemed) (*Redeemed, error) {
+	redeemed.PriceDecimal, _ = PriceDecimal(redeemed.Price)
+	redeemed.ApproveStatus = REQUEST_STATUS
+	redeemed.RejectReason = ""
+	redeemed.StatusHistory = []StatusChange{}
+	_, err := r.col.InsertOne(ctx, redeemed)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	return &redeemed, nil
+}
+
+// update implements IRedeemedRepository, logIndex is the stored one which
+// redeemed may change
+func (r RedeemedDb) update(ctx context.Context, logIndex int, redeemed Redeemed) (*Redeemed, error) {
+	filter := bson.M{"tx_hash": redeemed.TxHash, "log_index": logIndex}
+	set, err := toBsonM(redeemed)
+	if err != nil {
+		return nil, err
+	}
+	// omitempty fields that mergeRedeemed may clear
+	set["removed"] = redeemed.Removed
+	set["submitted_by"] = redeemed.SubmittedBy
+	// status fields only change through UpdateStatus
+	delete(set, "approved_status")
+	delete(set, "reject_reason")
+	delete(set, "status_history")
+	delete(set, "certificate_object")
+	delete(set, "verification_code")
+	update := bson.M{"$set": set}
+
+	updateRes, err := r.col.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	if updateRes.ModifiedCount <= 0 {
+		return nil, ErrNoChange
+	}
+	return &redeemed, nil
+}
+
+// GetPage implements IRedeemedRepository
+func (r RedeemedDb) GetPage(ctx context.Context, filter RedeemedFilter, query PageQuery) ([]Redeemed, *Page, error) {
 	allRedeemed := []Redeemed{}
-	cur, err := r.col.Find(r.ctx, filter)
+	page, err := findPage(ctx, r.col, filter.toFilter(), query, RedeemedSortFields, "-redeem_date", &allRedeemed)
 	if err != nil {
-		fmt.Printf("err: %v\n", err)
-		return allRedeemed
+		return nil, nil, err
 	}
+	return allRedeemed, page, nil
+}
 
-	for cur.Next(r.ctx) {
+// Iterate implements IRedeemedRepository, it calls fn for every match
+// oldest first without loading them all into memory
+func (r RedeemedDb) Iterate(ctx context.Context, filter RedeemedFilter, fn func(Redeemed) error) error {
+	opts := options.Find().SetSort(bson.D{{Key: "redeem_date", Value: 1}, {Key: "_id", Value: 1}})
+	cur, err := r.col.Find(ctx, filter.toFilter(), opts)
+	if err != nil {
+		return wrapError(err)
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
 		redeemed := Redeemed{}
 		err := cur.Decode(&redeemed)
 		if err != nil {
-			fmt.Printf("err: %v\n", err)
-			continue
+			return wrapError(err)
+		}
+		err = fn(redeemed)
+		if err != nil {
+			return err
 		}
-		allRedeemed = append(allRedeemed, redeemed)
 	}
-
-	return allRedeemed
+	return cur.Err()
 }
 
-// GetByTxHash implements IRedeemedRepository
-func (r RedeemedDb) GetByTxHash(txHash string) (*Redeemed, error) {
+// GetByTxHash implements IRedeemedRepository, it returns the first
+// redemption of a transaction with more than one
+func (r RedeemedDb) GetByTxHash(ctx context.Context, txHash string) (*Redeemed, error) {
 	filter := genfilter("tx_hash", txHash)
-	res := r.col.FindOne(r.ctx, filter)
+	res := r.col.FindOne(ctx, filter)
 	redeemed := Redeemed{}
 	err := res.Decode(&redeemed)
 	if err != nil {
-		return nil, err
+		return nil, wrapError(err)
 	}
 	return &redeemed, nil
 }
 
-func (r RedeemedDb) GetByRedeemId(redeemId int) (*Redeemed, error) {
+func (r RedeemedDb) GetByRedeemId(ctx context.Context, redeemId int) (*Redeemed, error) {
 	filter := genfilter("redeem_id", redeemId)
-	res := r.col.FindOne(r.ctx, filter)
+	res := r.col.FindOne(ctx, filter)
 	redeemed := Redeemed{}
 	err := res.Decode(&redeemed)
 	if err != nil {
-		return nil, err
+		return nil, wrapError(err)
 	}
 	return &redeemed, nil
 }
 
-// Upsert implements IRedeemedRepository
-func (r RedeemedDb) Upsert(redeemed Redeemed) (*Redeemed, error) {
-	findRedeemed, err := r.GetByTxHash(redeemed.TxHash)
+// GetByVerificationCode implements IRedeemedRepository
+func (r RedeemedDb) GetByVerificationCode(ctx context.Context, code string) (*Redeemed, error) {
+	res := r.col.FindOne(ctx, genfilter("verification_code", code))
+	redeemed := Redeemed{}
+	err := res.Decode(&redeemed)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	return &redeemed, nil
+}
+
+// Upsert implements IRedeemedRepository. The outbox events of the change are
+// written in the same transaction. matchRedeemed picks the redemption that
+// is updated.
+func (r RedeemedDb) Upsert(ctx context.Context, redeemed Redeemed) (*Redeemed, error) {
+	cur, err := r.col.Find(ctx, genfilter("tx_hash", redeemed.TxHash))
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	sameTx := []Redeemed{}
+	err = cur.All(ctx, &sameTx)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	findRedeemed, err := matchRedeemed(redeemed, sameTx)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
 	if err != nil {
 		// not found
 		// should create new redeemed
-		newRedeemed, err := r.create(redeemed)
+		var newRedeemed *Redeemed
+		err := withTransaction(ctx, r.col.Database().Client(), func(ctx context.Context) error {
+			created, err := r.create(ctx, redeemed)
+			if err != nil {
+				return err
+			}
+			newRedeemed = created
+			event, err := newRedeemedEvent(REDEEMED_CREATED_EVENT, *created)
+			if err != nil {
+				return err
+			}
+			events := []OutboxEvent{event}
+			if created.Email != "" {
+				event, err := newRedeemedEvent(REDEEMED_DETAILS_SUBMITTED_EVENT, *created)
+				if err != nil {
+					return err
+				}
+				events = append(events, event)
+			}
+			return insertOutbox(ctx, r.col.Database(), events...)
+		})
 		if err != nil {
-			return nil, err
+			return nil, wrapError(err)
 		}
 		return newRedeemed, nil
 	} else {
 		// found old one
 		// update data
-		if redeemed.Amount > 0 {
-			findRedeemed.Amount = redeemed.Amount
-		}
-		if redeemed.ApproveStatus != findRedeemed.ApproveStatus {
-			findRedeemed.ApproveStatus = redeemed.ApproveStatus
+		logIndex := findRedeemed.LogIndex
+		recorded := !hasChainData(*findRedeemed) && hasChainData(redeemed)
+		detailsSubmitted, err := mergeRedeemed(findRedeemed, redeemed)
+		if err != nil {
+			return nil, err
 		}
-		if redeemed.Company != "" {
-			findRedeemed.Company = redeemed.Company
+
+		var newRedeemed *Redeemed
+		err = withTransaction(ctx, r.col.Database().Client(), func(ctx context.Context) error {
+			updated, err := r.update(ctx, logIndex, *findRedeemed)
+			if err != nil {
+				return err
+			}
+			newRedeemed = updated
+			events, err := mergedEvents(*updated, recorded, detailsSubmitted)
+			if err != nil || len(events) == 0 {
+				return err
+			}
+			return insertOutbox(ctx, r.col.Database(), events...)
+		})
+		if err != nil {
+			return nil, wrapError(err)
 		}
-		if redeemed.Email != "" {
-			findRedeemed.Email = redeemed.Email
+		return newRedeemed, nil
+	}
+}
+
+// MarkRemoved implements IRedeemedRepository. It flags the redemption of a
+// redeem event a reorg took off the chain, ErrNotFound when there is none
+// or it is flagged already.
+func (r RedeemedDb) MarkRemoved(ctx context.Context, txHash string, logIndex int) (*Redeemed, error) {
+	var removed *Redeemed
+	err := withTransaction(ctx, r.col.Database().Client(), func(ctx context.Context) error {
+		filter := bson.M{"tx_hash": txHash, "log_index": logIndex, "removed": bson.M{"$ne": true}}
+		update := bson.M{"$set": bson.M{"removed": true}}
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		redeemed := Redeemed{}
+		err := r.col.FindOneAndUpdate(ctx, filter, update, opts).Decode(&redeemed)
+		if err != nil {
+			return err
 		}
-		if redeemed.Name != "" {
-			findRedeemed.Name = redeemed.Name
+		removed = &redeemed
+		event, err := newRedeemedEvent(REDEEMED_REMOVED_EVENT, redeemed)
+		if err != nil {
+			return err
 		}
-		if redeemed.Price != "" {
-			findRedeemed.Price = redeemed.Price
+		return insertOutbox(ctx, r.col.Database(), event)
+	})
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	return removed, nil
+}
+
+// UpdateStatus implements IRedeemedRepository. It refuses transitions that
+// are not in redeemStatusTransitions and appends the change to the history.
+func (r RedeemedDb) UpdateStatus(ctx context.Context, redeemId int, status string, actor string, note string) (*Redeemed, error) {
+	err := checkStatusRequest(status, note)
+	if err != nil {
+		return nil, err
+	}
+	findRedeem, err := r.GetByRedeemId(ctx, redeemId)
+	if err != nil {
+		return nil, err
+	}
+	oldStatus := findRedeem.ApproveStatus
+	change, err := changeStatus(findRedeem, status, actor, note)
+	if err != nil {
+		return nil, err
+	}
+	set := bson.M{"approved_status": status}
+	if status == REJECTED_STATUS {
+		set["reject_reason"] = note
+	}
+	// only apply when nobody changed the status since we read it
+	filter := bson.M{"redeem_id": redeemId, "approved_status": oldStatus}
+	if oldStatus == "" {
+		filter["approved_status"] = bson.M{"$in": bson.A{"", nil}}
+	}
+	update := bson.M{
+		"$set":  set,
+		"$push": bson.M{"status_history": change},
+	}
+
+	err = withTransaction(ctx, r.col.Database().Client(), func(ctx context.Context) error {
+		updateRes, err := r.col.UpdateOne(ctx, filter, update)
+		if err != nil {
+			return wrapError(err)
 		}
-		if redeemed.RedeemDate != 0 {
-			findRedeemed.RedeemDate = redeemed.RedeemDate
+		if updateRes.ModifiedCount <= 0 {
+			return ErrStatusChanged
 		}
-		if redeemed.RedeemId > 0 {
-			findRedeemed.RedeemId = redeemed.RedeemId
+		data := RedeemedEventData{Redeemed: *findRedeem, Change: &change}
+		event, err := NewOutboxEvent(REDEEMED_STATUS_CHANGED_EVENT, strconv.Itoa(redeemId), data)
+		if err != nil {
+			return err
 		}
-		if redeemed.TaxID != "" {
-			findRedeemed.TaxID = redeemed.TaxID
+		return insertOutbox(ctx, r.col.Database(), event)
+	})
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	return findRedeem, nil
+}
+
+// GetHistory implements IRedeemedRepository
+func (r RedeemedDb) GetHistory(ctx context.Context, redeemId int) ([]StatusChange, error) {
+	redeemed, err := r.GetByRedeemId(ctx, redeemId)
+	if err != nil {
+		return nil, err
+	}
+	if redeemed.StatusHistory == nil {
+		return []StatusChange{}, nil
+	}
+	return redeemed.StatusHistory, nil
+}
+
+// SetCertificate implements IRedeemedRepository
+func (r RedeemedDb) SetCertificate(ctx context.Context, redeemId int, object string, verificationCode string) error {
+	update := bson.M{"$set": bson.M{
+		"certificate_object": object,
+		"verification_code":  verificationCode,
+	}}
+	updateRes, err := r.col.UpdateOne(ctx, genfilter("redeem_id", redeemId), update)
+	if err != nil {
+		return wrapError(err)
+	}
+	if updateRes.MatchedCount <= 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// matchRedeemed picks which of sameTx, the redemptions with the tx hash of
+// redeemed, redeemed is merged into. A chain event goes to the one with its
+// log index, or else takes over one whose log index is not known yet, which
+// the customer submitted first or was stored before log indexes were, or
+// one with its redeem id, the same event moved to another block by a reorg.
+// Without a log index redeemed goes to the only redemption of the
+// transaction still on the chain.
+func matchRedeemed(redeemed Redeemed, sameTx []Redeemed) (*Redeemed, error) {
+	if redeemed.LogIndex == UNKNOWN_LOG_INDEX {
+		var found *Redeemed
+		for i, v := range sameTx {
+			if v.Removed {
+				continue
+			}
+			if found != nil {
+				return nil, ErrLogIndexRequired
+			}
+			found = &sameTx[i]
 		}
-		if redeemed.Telephone != "" {
-			findRedeemed.Telephone = redeemed.Telephone
+		if found == nil {
+			return nil, ErrNotFound
 		}
-		if redeemed.WalletAddress != "" {
-			findRedeemed.WalletAddress = redeemed.WalletAddress
+		return found, nil
+	}
+	for i, v := range sameTx {
+		if v.LogIndex == redeemed.LogIndex {
+			return &sameTx[i], nil
 		}
-		if redeemed.CertId != 0 {
-			findRedeemed.CertId = redeemed.CertId
+	}
+	if !hasChainData(redeemed) {
+		return nil, ErrNotFound
+	}
+	for i, v := range sameTx {
+		sameRedeemId := v.RedeemId == redeemed.RedeemId
+		if (v.LogIndex == UNKNOWN_LOG_INDEX && (v.RedeemId == 0 || sameRedeemId)) || (hasChainData(v) && sameRedeemId) {
+			return &sameTx[i], nil
 		}
+	}
+	return nil, ErrNotFound
+}
 
-		newRedeemed, err := r.update(*findRedeemed)
+// hasChainData reports whether redeemed has fields of its redeem event
+func hasChainData(redeemed Redeemed) bool {
+	return redeemed.RedeemId > 0 || redeemed.WalletAddress != "" || redeemed.RedeemDate > 0 || redeemed.BlockNumber > 0
+}
+
+// mergedEvents are the events of a redemption updated by Upsert, recorded
+// when its redeem event came after the customer details
+func mergedEvents(updated Redeemed, recorded bool, detailsSubmitted bool) ([]OutboxEvent, error) {
+	events := []OutboxEvent{}
+	if recorded {
+		event, err := newRedeemedEvent(REDEEMED_RECORDED_EVENT, updated)
 		if err != nil {
 			return nil, err
 		}
-		return newRedeemed, nil
+		events = append(events, event)
 	}
+	if detailsSubmitted {
+		event, err := newRedeemedEvent(REDEEMED_DETAILS_SUBMITTED_EVENT, updated)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, nil
 }
 
-// UpdateStatus implements IRedeemedRepository
-func (r RedeemedDb) UpdateStatus(redeemId int, status string) (*Redeemed, error) {
-	filter := genfilter("redeem_id", redeemId)
-	findRedeem, err := r.GetByRedeemId(redeemId)
+// mergeRedeemed copies the fields set in redeemed onto found, the rest of
+// found is kept. Customer details with a SubmittedBy wallet are only taken
+// from the wallet of the redemption, or from the first wallet while the
+// redeem event is not ingested yet, others fail with ErrNotOwner. Details
+// without one come from staff and may overwrite. It reports whether the
+// customer details came in just now.
+func mergeRedeemed(found *Redeemed, redeemed Redeemed) (bool, error) {
+	if redeemed.SubmittedBy != "" {
+		owner := found.WalletAddress
+		if owner == "" {
+			owner = found.SubmittedBy
+		}
+		if owner != "" && !strings.EqualFold(owner, redeemed.SubmittedBy) {
+			return false, ErrNotOwner
+		}
+	}
+	if redeemed.WalletAddress != "" && found.SubmittedBy != "" && !strings.EqualFold(found.SubmittedBy, redeemed.WalletAddress) {
+		// submitted before the redeem event by another wallet
+		found.Company, found.Email, found.Name, found.TaxID, found.Telephone, found.SubmittedBy = "", "", "", "", "", ""
+	}
+	detailsSubmitted := found.Email == "" && redeemed.Email != ""
+	if redeemed.Company != "" {
+		found.Company = redeemed.Company
+	}
+	if redeemed.Email != "" {
+		found.Email = redeemed.Email
+	}
+	if redeemed.Name != "" {
+		found.Name = redeemed.Name
+	}
+	if redeemed.TaxID != "" {
+		found.TaxID = redeemed.TaxID
+	}
+	if redeemed.Telephone != "" {
+		found.Telephone = redeemed.Telephone
+	}
+	if redeemed.SubmittedBy != "" {
+		found.SubmittedBy = redeemed.SubmittedBy
+	}
+	if redeemed.Amount > 0 {
+		found.Amount = redeemed.Amount
+	}
+	if redeemed.Price != "" {
+		found.Price = redeemed.Price
+	}
+	found.PriceDecimal, _ = PriceDecimal(found.Price)
+	if redeemed.RedeemDate != 0 {
+		found.RedeemDate = redeemed.RedeemDate
+	}
+	if redeemed.RedeemId > 0 {
+		found.RedeemId = redeemed.RedeemId
+	}
+	if redeemed.WalletAddress != "" {
+		found.WalletAddress = redeemed.WalletAddress
+	}
+	if redeemed.CertId != 0 {
+		found.CertId = redeemed.CertId
+	}
+	if redeemed.LogIndex != UNKNOWN_LOG_INDEX {
+		found.LogIndex = redeemed.LogIndex
+	}
+	if redeemed.BlockNumber != 0 {
+		found.BlockNumber = redeemed.BlockNumber
+	}
+	if hasChainData(redeemed) {
+		// back on the chain after a reorg
+		found.Removed = false
+	}
+	return detailsSubmitted, nil
+}
+
+// PriceDecimal is the decimal of a price string, false when price is empty
+// or not a number
+func PriceDecimal(price string) (primitive.Decimal128, bool) {
+	if price == "" {
+		return primitive.Decimal128{}, false
+	}
+	d, err := primitive.ParseDecimal128(price)
 	if err != nil {
-		return nil, err
+		return primitive.Decimal128{}, false
+	}
+	return d, true
+}
+
+// checkStatusRequest refuses status changes that are invalid whatever the
+// current status is
+func checkStatusRequest(status string, note string) error {
+	if !IsValidRedeemStatus(status) {
+		return ErrInvalidStatus
+	}
+	if status == REJECTED_STATUS && note == "" {
+		return ErrReasonRequired
 	}
-	findRedeem.ApproveStatus = status
-	update := bson.M{"$set": findRedeem}
-	updateRes, err := r.col.UpdateOne(r.ctx, filter, update)
+	return nil
+}
+
+// changeStatus moves redeemed to status and appends the change to its
+// history, transitions that are not in redeemStatusTransitions are refused
+func changeStatus(redeemed *Redeemed, status string, actor string, note string) (StatusChange, error) {
+	if !CanChangeRedeemStatus(redeemed.ApproveStatus, status) {
+		return StatusChange{}, fmt.Errorf("%w: %s -> %s", ErrInvalidTransition, redeemed.ApproveStatus, status)
+	}
+	change := StatusChange{
+		From:      redeemed.ApproveStatus,
+		To:        status,
+		Actor:     actor,
+		Note:      note,
+		ChangedAt: time.Now(),
+	}
+	redeemed.ApproveStatus = status
+	if status == REJECTED_STATUS {
+		redeemed.RejectReason = note
+	}
+	history := make([]StatusChange, 0, len(redeemed.StatusHistory)+1)
+	redeemed.StatusHistory = append(append(history, redeemed.StatusHistory...), change)
+	return change, nil
+}
+
+func newRedeemedEvent(eventType string, redeemed Redeemed) (OutboxEvent, error) {
+	return NewOutboxEvent(eventType, strconv.Itoa(redeemed.RedeemId), RedeemedEventData{Redeemed: redeemed})
+}
+
+func toBsonM(v interface{}) (bson.M, error) {
+	data, err := bson.Marshal(v)
 	if err != nil {
 		return nil, err
 	}
-	if updateRes.ModifiedCount <= 0 {
-		return nil, fmt.Errorf("no update any items")
-	}
-	return findRedeem, nil
+	m := bson.M{}
+	err = bson.Unmarshal(data, &m)
+	return m, err
 }
 
 func NewRedeemedDb(db *mongo.Database) IRedeemedRepository {
 	col := db.Collection(REDEEM_COLLECTION_NAME)
-	makeTransactionHashAsIndexes(col)
 	return RedeemedDb{
 		col: col,
-		ctx: context.Background(),
-	}
-}
-
-func makeTransactionHashAsIndexes(collection *mongo.Collection) {
-	indexName, err := collection.Indexes().CreateOne(
-		context.Background(),
-		mongo.IndexModel{
-			Keys:    bson.D{{Key: "tx_hash", Value: 1}},
-			Options: options.Index().SetUnique(true),
-		},
-	)
-	if err != nil {
-		panic(err)
 	}
-	fmt.Println("index name:", indexName)
 }
@@ -0,0 +1,356 @@
+package repository_test
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/seenark/super-backend-temp/repository"
+)
+
+func TestRedeemedUpsert(t *testing.T) {
+	eachBackend(t, func(t *testing.T, repos repository.Repositories) {
+		tests := []struct {
+			name       string
+			redeemed   repository.Redeemed
+			err        error
+			want       repository.Redeemed
+			wantEvents []string
+		}{
+			{
+				name:       "chain event creates it",
+				redeemed:   repository.Redeemed{TxHash: "0x1", RedeemId: 1, Amount: 5, RedeemDate: 100, WalletAddress: "0xabc"},
+				want:       repository.Redeemed{TxHash: "0x1", RedeemId: 1, Amount: 5, RedeemDate: 100, WalletAddress: "0xabc", ApproveStatus: repository.REQUEST_STATUS},
+				wantEvents: []string{repository.REDEEMED_CREATED_EVENT},
+			},
+			{
+				name:       "details of the wallet are merged in",
+				redeemed:   repository.Redeemed{TxHash: "0x1", Name: "Somchai", Email: "s@example.com", SubmittedBy: "0xABC"},
+				want:       repository.Redeemed{TxHash: "0x1", RedeemId: 1, Amount: 5, RedeemDate: 100, WalletAddress: "0xabc", Name: "Somchai", Email: "s@example.com", SubmittedBy: "0xABC", ApproveStatus: repository.REQUEST_STATUS},
+				wantEvents: []string{repository.REDEEMED_DETAILS_SUBMITTED_EVENT},
+			},
+			{
+				name:       "chain fields keep the details",
+				redeemed:   repository.Redeemed{TxHash: "0x1", Amount: 7, Email: "s@example.com"},
+				want:       repository.Redeemed{TxHash: "0x1", RedeemId: 1, Amount: 7, RedeemDate: 100, WalletAddress: "0xabc", Name: "Somchai", Email: "s@example.com", SubmittedBy: "0xABC", ApproveStatus: repository.REQUEST_STATUS},
+				wantEvents: []string{},
+			},
+			{
+				name:       "details of another wallet are refused",
+				redeemed:   repository.Redeemed{TxHash: "0x1", Email: "other@example.com", TaxID: "1234567890121", SubmittedBy: "0xdef"},
+				err:        repository.ErrNotOwner,
+				wantEvents: []string{},
+			},
+			{
+				name:       "the wallet changes its details",
+				redeemed:   repository.Redeemed{TxHash: "0x1", Company: "ACME", SubmittedBy: "0xabc"},
+				want:       repository.Redeemed{TxHash: "0x1", RedeemId: 1, Amount: 7, RedeemDate: 100, WalletAddress: "0xabc", Name: "Somchai", Company: "ACME", Email: "s@example.com", SubmittedBy: "0xabc", ApproveStatus: repository.REQUEST_STATUS},
+				wantEvents: []string{},
+			},
+			{
+				name:       "staff change the details",
+				redeemed:   repository.Redeemed{TxHash: "0x1", Name: "Somchai Jaidee"},
+				want:       repository.Redeemed{TxHash: "0x1", RedeemId: 1, Amount: 7, RedeemDate: 100, WalletAddress: "0xabc", Name: "Somchai Jaidee", Company: "ACME", Email: "s@example.com", SubmittedBy: "0xabc", ApproveStatus: repository.REQUEST_STATUS},
+				wantEvents: []string{},
+			},
+			{
+				name:       "nothing new",
+				redeemed:   repository.Redeemed{TxHash: "0x1", Name: "Somchai Jaidee"},
+				err:        repository.ErrNoChange,
+				wantEvents: []string{},
+			},
+			{
+				name:       "details and chain event together",
+				redeemed:   repository.Redeemed{TxHash: "0x2", RedeemId: 2, Email: "b@example.com"},
+				want:       repository.Redeemed{TxHash: "0x2", RedeemId: 2, Email: "b@example.com", ApproveStatus: repository.REQUEST_STATUS},
+				wantEvents: []string{repository.REDEEMED_CREATED_EVENT, repository.REDEEMED_DETAILS_SUBMITTED_EVENT},
+			},
+			{
+				name:       "customer first",
+				redeemed:   repository.Redeemed{TxHash: "0x3", Name: "Somchai", Email: "s@example.com", SubmittedBy: "0xabc"},
+				want:       repository.Redeemed{TxHash: "0x3", Name: "Somchai", Email: "s@example.com", SubmittedBy: "0xabc", ApproveStatus: repository.REQUEST_STATUS},
+				wantEvents: []string{repository.REDEEMED_CREATED_EVENT, repository.REDEEMED_DETAILS_SUBMITTED_EVENT},
+			},
+			{
+				name:       "another wallet before the chain event",
+				redeemed:   repository.Redeemed{TxHash: "0x3", Name: "Mallory", Email: "m@example.com", SubmittedBy: "0xdef"},
+				err:        repository.ErrNotOwner,
+				wantEvents: []string{},
+			},
+			{
+				name:       "chain event after the customer",
+				redeemed:   repository.Redeemed{TxHash: "0x3", RedeemId: 3, RedeemDate: 300, WalletAddress: "0xabc"},
+				want:       repository.Redeemed{TxHash: "0x3", RedeemId: 3, RedeemDate: 300, WalletAddress: "0xabc", Name: "Somchai", Email: "s@example.com", SubmittedBy: "0xabc", ApproveStatus: repository.REQUEST_STATUS},
+				wantEvents: []string{repository.REDEEMED_RECORDED_EVENT},
+			},
+			{
+				name:       "chain event again",
+				redeemed:   repository.Redeemed{TxHash: "0x3", RedeemId: 3, RedeemDate: 300, WalletAddress: "0xabc"},
+				err:        repository.ErrNoChange,
+				wantEvents: []string{},
+			},
+			{
+				name:       "another wallet first",
+				redeemed:   repository.Redeemed{TxHash: "0x4", Name: "Mallory", Email: "m@example.com", SubmittedBy: "0xdef"},
+				want:       repository.Redeemed{TxHash: "0x4", Name: "Mallory", Email: "m@example.com", SubmittedBy: "0xdef", ApproveStatus: repository.REQUEST_STATUS},
+				wantEvents: []string{repository.REDEEMED_CREATED_EVENT, repository.REDEEMED_DETAILS_SUBMITTED_EVENT},
+			},
+			{
+				name:       "the chain event drops details of another wallet",
+				redeemed:   repository.Redeemed{TxHash: "0x4", RedeemId: 4, RedeemDate: 400, WalletAddress: "0xabc"},
+				want:       repository.Redeemed{TxHash: "0x4", RedeemId: 4, RedeemDate: 400, WalletAddress: "0xabc", ApproveStatus: repository.REQUEST_STATUS},
+				wantEvents: []string{repository.REDEEMED_RECORDED_EVENT},
+			},
+			{
+				name:       "the wallet submits after all",
+				redeemed:   repository.Redeemed{TxHash: "0x4", Name: "Somchai", Email: "s@example.com", SubmittedBy: "0xabc"},
+				want:       repository.Redeemed{TxHash: "0x4", RedeemId: 4, RedeemDate: 400, WalletAddress: "0xabc", Name: "Somchai", Email: "s@example.com", SubmittedBy: "0xabc", ApproveStatus: repository.REQUEST_STATUS},
+				wantEvents: []string{repository.REDEEMED_DETAILS_SUBMITTED_EVENT},
+			},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				_, err := repos.Redeemed.Upsert(ctx, tt.redeemed)
+				checkError(t, err, tt.err)
+				if events := outboxTypes(t, repos); !equalStrings(events, tt.wantEvents) {
+					t.Fatalf("outbox = %v, want %v", events, tt.wantEvents)
+				}
+				if err != nil {
+					return
+				}
+				found, err := repos.Redeemed.GetByTxHash(ctx, tt.redeemed.TxHash)
+				checkError(t, err, nil)
+				found.StatusHistory = nil
+				if !reflect.DeepEqual(*found, tt.want) {
+					t.Fatalf("stored %+v, want %+v", *found, tt.want)
+				}
+			})
+		}
+	})
+}
+
+func TestRedeemedGetPage(t *testing.T) {
+	eachBackend(t, func(t *testing.T, repos repository.Repositories) {
+		for id := 1; id <= 4; id++ {
+			_, err := repos.Redeemed.Upsert(ctx, repository.Redeemed{
+				TxHash:        fmt.Sprintf("0x%d", id),
+				RedeemId:      id,
+				RedeemDate:    id * 100,
+				Name:          fmt.Sprintf("name %d", id%2),
+				WalletAddress: "0xabc",
+			})
+			checkError(t, err, nil)
+		}
+		_, err := repos.Redeemed.UpdateStatus(ctx, 4, repository.APPROVED_STATUS, "admin", "")
+		checkError(t, err, nil)
+
+		tests := []struct {
+			name    string
+			filter  repository.RedeemedFilter
+			query   repository.PageQuery
+			wantIds []int
+		}{
+			{"newest first by default", repository.RedeemedFilter{}, repository.PageQuery{}, []int{4, 3, 2, 1}},
+			{"sorted by id", repository.RedeemedFilter{}, repository.PageQuery{Sort: "redeem_id"}, []int{1, 2, 3, 4}},
+			{"status", repository.RedeemedFilter{ApproveStatus: []string{repository.APPROVED_STATUS}}, repository.PageQuery{}, []int{4}},
+			{"name", repository.RedeemedFilter{Name: "name 1"}, repository.PageQuery{}, []int{3, 1}},
+			{"ids", repository.RedeemedFilter{RedeemId: []int{2, 3}}, repository.PageQuery{}, []int{3, 2}},
+			// the end of a range is exclusive when there is a start
+			{"start and end", repository.RedeemedFilter{StartDate: 200, EndDate: 400}, repository.PageQuery{}, []int{3, 2}},
+			{"start only", repository.RedeemedFilter{StartDate: 300}, repository.PageQuery{}, []int{4, 3}},
+			// and inclusive without one
+			{"end only", repository.RedeemedFilter{EndDate: 200}, repository.PageQuery{}, []int{2, 1}},
+			{"wallet", repository.RedeemedFilter{WalletAddress: "0xdef"}, repository.PageQuery{}, []int{}},
+			{"second page", repository.RedeemedFilter{}, repository.PageQuery{Limit: 3, Page: 2}, []int{1}},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				found, page, err := repos.Redeemed.GetPage(ctx, tt.filter, tt.query)
+				checkError(t, err, nil)
+				ids := redeemIds(found)
+				if !equalInts(ids, tt.wantIds) {
+					t.Fatalf("ids = %v, want %v", ids, tt.wantIds)
+				}
+				if tt.query.Page == 0 && page.Total != int64(len(tt.wantIds)) {
+					t.Fatalf("total = %d", page.Total)
+				}
+			})
+		}
+
+		iterated := []repository.Redeemed{}
+		err = repos.Redeemed.Iterate(ctx, repository.RedeemedFilter{StartDate: 200}, func(r repository.Redeemed) error {
+			iterated = append(iterated, r)
+			return nil
+		})
+		checkError(t, err, nil)
+		if ids := redeemIds(iterated); !equalInts(ids, []int{2, 3, 4}) {
+			t.Fatalf("iterated %v, want oldest first", ids)
+		}
+	})
+}
+
+func TestRedeemedLogIndex(t *testing.T) {
+	eachBackend(t, func(t *testing.T, repos repository.Repositories) {
+		tests := []struct {
+			name       string
+			redeemed   repository.Redeemed
+			err        error
+			wantEvents []string
+		}{
+			{"first event of the transaction", repository.Redeemed{TxHash: "0x5", LogIndex: 0, RedeemId: 1, BlockNumber: 10}, nil, []string{repository.REDEEMED_CREATED_EVENT}},
+			{"second event of the transaction", repository.Redeemed{TxHash: "0x5", LogIndex: 1, RedeemId: 2, BlockNumber: 10}, nil, []string{repository.REDEEMED_CREATED_EVENT}},
+			{"details without a log index", repository.Redeemed{TxHash: "0x5", LogIndex: repository.UNKNOWN_LOG_INDEX, Email: "s@example.com"}, repository.ErrLogIndexRequired, []string{}},
+			{"details of the second event", repository.Redeemed{TxHash: "0x5", LogIndex: 1, Email: "s@example.com"}, nil, []string{repository.REDEEMED_DETAILS_SUBMITTED_EVENT}},
+			{"event ingested again", repository.Redeemed{TxHash: "0x5", LogIndex: 0, RedeemId: 1, BlockNumber: 10}, repository.ErrNoChange, []string{}},
+			{"event in another block", repository.Redeemed{TxHash: "0x6", LogIndex: 0, RedeemId: 3, BlockNumber: 12}, nil, []string{repository.REDEEMED_CREATED_EVENT}},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				_, err := repos.Redeemed.Upsert(ctx, tt.redeemed)
+				checkError(t, err, tt.err)
+				if events := outboxTypes(t, repos); !equalStrings(events, tt.wantEvents) {
+					t.Fatalf("outbox = %v, want %v", events, tt.wantEvents)
+				}
+			})
+		}
+
+		found, err := repos.Redeemed.GetByRedeemId(ctx, 2)
+		checkError(t, err, nil)
+		if found.LogIndex != 1 || found.Email != "s@example.com" {
+			t.Fatalf("redeem 2 = %+v", found)
+		}
+
+		removed, err := repos.Redeemed.MarkRemoved(ctx, "0x5", 0)
+		checkError(t, err, nil)
+		if !removed.Removed || removed.RedeemId != 1 {
+			t.Fatalf("removed %+v", removed)
+		}
+		if events := outboxTypes(t, repos); !equalStrings(events, []string{repository.REDEEMED_REMOVED_EVENT}) {
+			t.Fatalf("outbox = %v", events)
+		}
+		_, err = repos.Redeemed.MarkRemoved(ctx, "0x5", 0)
+		checkError(t, err, repository.ErrNotFound)
+		_, err = repos.Redeemed.MarkRemoved(ctx, "0x5", 7)
+		checkError(t, err, repository.ErrNotFound)
+
+		// the only event left takes details without a log index
+		_, err = repos.Redeemed.Upsert(ctx, repository.Redeemed{TxHash: "0x6", LogIndex: repository.UNKNOWN_LOG_INDEX, Email: "b@example.com"})
+		checkError(t, err, nil)
+		outboxTypes(t, repos)
+
+		iterated := []repository.Redeemed{}
+		err = repos.Redeemed.Iterate(ctx, repository.RedeemedFilter{FromBlock: 9, ToBlock: 10}, func(r repository.Redeemed) error {
+			iterated = append(iterated, r)
+			return nil
+		})
+		checkError(t, err, nil)
+		if ids := redeemIds(iterated); !equalInts(ids, []int{1, 2}) {
+			t.Fatalf("blocks 9-10 have %v", ids)
+		}
+	})
+}
+
+func TestRedeemedUpdateStatus(t *testing.T) {
+	eachBackend(t, func(t *testing.T, repos repository.Repositories) {
+		_, err := repos.Redeemed.Upsert(ctx, repository.Redeemed{TxHash: "0x1", RedeemId: 1})
+		checkError(t, err, nil)
+		outboxTypes(t, repos)
+
+		tests := []struct {
+			name   string
+			status string
+			note   string
+			err    error
+		}{
+			{"unknown status", "lost", "", repository.ErrInvalidStatus},
+			{"reject without reason", repository.REJECTED_STATUS, "", repository.ErrReasonRequired},
+			{"skip approval", repository.DELIVERED_STATUS, "", repository.ErrConflict},
+			{"pending", repository.PENDING_STATUS, "checking", nil},
+			{"approve", repository.APPROVED_STATUS, "", nil},
+			{"back to pending", repository.PENDING_STATUS, "", repository.ErrConflict},
+			{"deliver", repository.DELIVERED_STATUS, "", nil},
+			{"final", repository.REJECTED_STATUS, "too late", repository.ErrConflict},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				updated, err := repos.Redeemed.UpdateStatus(ctx, 1, tt.status, "admin", tt.note)
+				checkError(t, err, tt.err)
+				events := outboxTypes(t, repos)
+				if err != nil {
+					if len(events) != 0 {
+						t.Fatalf("outbox = %v", events)
+					}
+					return
+				}
+				if updated.ApproveStatus != tt.status {
+					t.Fatalf("status = %q", updated.ApproveStatus)
+				}
+				if !equalStrings(events, []string{repository.REDEEMED_STATUS_CHANGED_EVENT}) {
+					t.Fatalf("outbox = %v", events)
+				}
+			})
+		}
+		_, err = repos.Redeemed.UpdateStatus(ctx, 2, repository.APPROVED_STATUS, "admin", "")
+		checkError(t, err, repository.ErrNotFound)
+
+		history, err := repos.Redeemed.GetHistory(ctx, 1)
+		checkError(t, err, nil)
+		got := []string{}
+		for _, v := range history {
+			got = append(got, v.From+">"+v.To)
+		}
+		want := []string{"requested>pending", "pending>approved", "approved>delivered"}
+		if !equalStrings(got, want) {
+			t.Fatalf("history = %v, want %v", got, want)
+		}
+		_, err = repos.Redeemed.GetHistory(ctx, 2)
+		checkError(t, err, repository.ErrNotFound)
+	})
+}
+
+func TestRedeemedSetCertificate(t *testing.T) {
+	eachBackend(t, func(t *testing.T, repos repository.Repositories) {
+		for id := 1; id <= 2; id++ {
+			_, err := repos.Redeemed.Upsert(ctx, repository.Redeemed{TxHash: fmt.Sprintf("0x%d", id), RedeemId: id})
+			checkError(t, err, nil)
+		}
+
+		tests := []struct {
+			name     string
+			redeemId int
+			code     string
+			err      error
+		}{
+			{"first", 1, "CODE-1", nil},
+			{"code taken", 2, "CODE-1", repository.ErrDuplicate},
+			{"unknown redemption", 3, "CODE-3", repository.ErrNotFound},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				err := repos.Redeemed.SetCertificate(ctx, tt.redeemId, "certificates/1.pdf", tt.code)
+				checkError(t, err, tt.err)
+			})
+		}
+
+		found, err := repos.Redeemed.GetByVerificationCode(ctx, "CODE-1")
+		checkError(t, err, nil)
+		if found.RedeemId != 1 || found.CertificateObject != "certificates/1.pdf" {
+			t.Fatalf("found %+v", found)
+		}
+		found, err = repos.Redeemed.GetByRedeemId(ctx, 2)
+		checkError(t, err, nil)
+		if found.VerificationCode != "" {
+			t.Fatalf("code = %q", found.VerificationCode)
+		}
+		_, err = repos.Redeemed.GetByVerificationCode(ctx, "CODE-9")
+		checkError(t, err, repository.ErrNotFound)
+	})
+}
+
+func redeemIds(redeemed []repository.Redeemed) []int {
+	ids := []int{}
+	for _, v := range redeemed {
+		ids = append(ids, v.RedeemId)
+	}
+	return ids
+}
@@ -0,0 +1,45 @@
+package repository
+
+import (
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Repositories is every repository of the app, all on the same storage
+type Repositories struct {
+	Users             UserRepository
+	Authen            AuthenticationRepository
+	Sessions          SessionRepository
+	Nonces            NonceRepository
+	ApiKeys           ApiKeyRepository
+	Roles             RoleRepository
+	CertTypes         IDigitalCertTypeRepository
+	Metadata          IMetadataRepository
+	Redeemed          IRedeemedRepository
+	Outbox            OutboxRepository
+	Webhooks          WebhookRepository
+	WebhookDeliveries WebhookDeliveryRepository
+	Checkpoints       CheckpointRepository
+}
+
+// NewMongoRepositories builds the repositories on mongo. db holds the
+// accounts, certDb the certificates, redemptions and what is delivered
+// about them.
+func NewMongoRepositories(db *mongo.Database, certDb *mongo.Database) Repositories {
+	users := db.Collection(USER_COLLECTION_NAME)
+	sessions := NewSessionDb(db)
+	return Repositories{
+		Users:             NewUserDb(users),
+		Authen:            NewAuthDB(users, sessions),
+		Sessions:          sessions,
+		Nonces:            NewNonceDb(db),
+		ApiKeys:           NewApiKeyDb(db),
+		Roles:             NewRoleDb(db),
+		CertTypes:         NewDigitalCertTypeDb(certDb),
+		Metadata:          NewMetadataRepository(certDb),
+		Redeemed:          NewRedeemedDb(certDb),
+		Outbox:            NewOutboxDb(certDb),
+		Webhooks:          NewWebhookDb(certDb),
+		WebhookDeliveries: NewWebhookDeliveryDb(certDb),
+		Checkpoints:       NewCheckpointDb(certDb),
+	}
+}
@@ -0,0 +1,231 @@
+// Package repotest runs tests against every storage the repositories have.
+// The in-memory store is always there. Mongo is used too when MONGO_TEST_URI
+// points at a server, or when a mongod binary is on the PATH, then one is
+// started for the test run on a temporary data directory.
+package repotest
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/seenark/super-backend-temp/migration"
+	"github.com/seenark/super-backend-temp/repository"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	MONGO_URI_ENV    = "MONGO_TEST_URI"
+	MEMORY_BACKEND   = "memory"
+	MONGO_BACKEND    = "mongo"
+	mongoStartupTime = 20 * time.Second
+)
+
+// Backend is a storage to run a test on, New gives every test its own
+// empty repositories
+type Backend struct {
+	Name string
+	New  func(t *testing.T) repository.Repositories
+}
+
+var (
+	mongoOnce   sync.Once
+	mongoClient *mongo.Client
+	mongoErr    error
+	mongod      *exec.Cmd
+	mongodDir   string
+	dbCounter   int64
+)
+
+// Backends returns the memory backend and, when a server can be reached or
+// started, the mongo one. A MONGO_TEST_URI that does not answer fails the
+// test, a mongod that does not start is only logged.
+func Backends(t *testing.T) []Backend {
+	t.Helper()
+	backends := []Backend{Memory()}
+	client, err := mongoTestClient()
+	if err != nil {
+		if os.Getenv(MONGO_URI_ENV) != "" {
+			t.Fatalf("connect to %s: %v", MONGO_URI_ENV, err)
+		}
+		t.Logf("mongo backend skipped: %v", err)
+		return backends
+	}
+	if client == nil {
+		return backends
+	}
+	return append(backends, Backend{
+		Name: MONGO_BACKEND,
+		New: func(t *testing.T) repository.Repositories {
+			return newMongoRepositories(t, client)
+		},
+	})
+}
+
+// Memory is the backend that needs nothing installed
+func Memory() Backend {
+	return Backend{
+		Name: MEMORY_BACKEND,
+		New: func(t *testing.T) repository.Repositories {
+			return repository.NewMemoryRepositories(repository.NewMemoryStore())
+		},
+	}
+}
+
+// Run runs the tests of a package and stops the mongod it started, call it
+// from TestMain
+func Run(m *testing.M) int {
+	code := m.Run()
+	stopMongo()
+	return code
+}
+
+// MongoDatabases returns empty databases of their own for t, they are
+// dropped after it. The test is skipped when there is no mongo.
+func MongoDatabases(t *testing.T) migration.Databases {
+	t.Helper()
+	client, err := mongoTestClient()
+	if err != nil && os.Getenv(MONGO_URI_ENV) != "" {
+		t.Fatalf("connect to %s: %v", MONGO_URI_ENV, err)
+	}
+	if err != nil || client == nil {
+		t.Skip("no mongo to test against")
+	}
+	return newMongoDatabases(t, client)
+}
+
+func newMongoDatabases(t *testing.T, client *mongo.Client) migration.Databases {
+	n := atomic.AddInt64(&dbCounter, 1)
+	prefix := fmt.Sprintf("repotest_%d_%d", os.Getpid(), n)
+	dbs := migration.Databases{
+		Accounts:     client.Database(prefix + "_accounts"),
+		Certificates: client.Database(prefix + "_certificates"),
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		dbs.Accounts.Drop(ctx)
+		dbs.Certificates.Drop(ctx)
+	})
+	return dbs
+}
+
+// newMongoRepositories uses databases of its own, migrated to the latest
+// version as the repositories rely on the indexes made by the migrations
+func newMongoRepositories(t *testing.T, client *mongo.Client) repository.Repositories {
+	t.Helper()
+	dbs := newMongoDatabases(t, client)
+	migrator, err := migration.NewMigrator(dbs, migration.All)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	_, err = migrator.Up(ctx, 0)
+	if err != nil {
+		t.Fatalf("migrate %s: %v", dbs.Accounts.Name(), err)
+	}
+	return repository.NewMongoRepositories(dbs.Accounts, dbs.Certificates)
+}
+
+// mongoTestClient connects once per test binary, a nil client without an
+// error means there is no mongo to test against
+func mongoTestClient() (*mongo.Client, error) {
+	mongoOnce.Do(func() {
+		uri := os.Getenv(MONGO_URI_ENV)
+		if uri == "" {
+			path, err := exec.LookPath("mongod")
+			if err != nil {
+				return
+			}
+			uri, mongoErr = startMongo(path)
+			if mongoErr != nil {
+				return
+			}
+		}
+		mongoClient, mongoErr = connect(uri)
+	})
+	return mongoClient, mongoErr
+}
+
+func connect(uri string) (*mongo.Client, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoStartupTime)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		return nil, err
+	}
+	// mongod takes a moment to accept connections after it starts
+	for {
+		err = client.Ping(ctx, nil)
+		if err == nil {
+			return client, nil
+		}
+		select {
+		case <-ctx.Done():
+			client.Disconnect(context.Background())
+			return nil, err
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+}
+
+// startMongo starts a throwaway mongod on a free port
+func startMongo(path string) (string, error) {
+	dir, err := ioutil.TempDir("", "repotest-mongod")
+	if err != nil {
+		return "", err
+	}
+	port, err := freePort()
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	cmd := exec.Command(path,
+		"--dbpath", dir,
+		"--port", fmt.Sprint(port),
+		"--bind_ip", "127.0.0.1",
+		"--quiet",
+	)
+	err = cmd.Start()
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	mongod = cmd
+	mongodDir = dir
+	return fmt.Sprintf("mongodb://127.0.0.1:%d", port), nil
+}
+
+func stopMongo() {
+	if mongoClient != nil {
+		mongoClient.Disconnect(context.Background())
+	}
+	if mongod == nil {
+		return
+	}
+	err := mongod.Process.Kill()
+	if err != nil {
+		log.Printf("stop mongod: %v", err)
+	}
+	mongod.Wait()
+	os.RemoveAll(mongodDir)
+}
+
+func freePort() (int, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port, nil
+}
@@ -0,0 +1,207 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	ROLE_COLLECTION_NAME = "roles"
+)
+
+// permissions a role can be granted
+const (
+	USER_READ_PERMISSION       = "user:read"
+	USER_WRITE_PERMISSION      = "user:write"
+	CERT_TYPE_WRITE_PERMISSION = "certtype:write"
+	METADATA_WRITE_PERMISSION  = "metadata:write"
+	REDEEM_CREATE_PERMISSION   = "redeem:create"
+	REDEEM_READ_PERMISSION     = "redeem:read"
+	REDEEM_INGEST_PERMISSION   = "redeem:ingest"
+	REDEEM_APPROVE_PERMISSION  = "redeem:approve"
+	API_KEY_MANAGE_PERMISSION  = "apikey:manage"
+	REDEEM_EXPORT_PERMISSION   = "redeem:export"
+	WEBHOOK_MANAGE_PERMISSION  = "webhook:manage"
+)
+
+var AllPermissions = []string{
+	USER_READ_PERMISSION,
+	USER_WRITE_PERMISSION,
+	CERT_TYPE_WRITE_PERMISSION,
+	METADATA_WRITE_PERMISSION,
+	REDEEM_CREATE_PERMISSION,
+	REDEEM_READ_PERMISSION,
+	REDEEM_INGEST_PERMISSION,
+	REDEEM_APPROVE_PERMISSION,
+	API_KEY_MANAGE_PERMISSION,
+	REDEEM_EXPORT_PERMISSION,
+	WEBHOOK_MANAGE_PERMISSION,
+}
+
+// DefaultRoles are created on startup when missing. Permissions added to a
+// default role later are granted once, edits made by super admins are never
+// overwritten.
+var DefaultRoles = []Role{
+	{Name: ADMIN_ROLE, Permissions: AllPermissions},
+	{Name: EVENT_LOGGER_ROLE, Permissions: []string{REDEEM_INGEST_PERMISSION}},
+	{Name: USER_ROLE, Permissions: []string{REDEEM_CREATE_PERMISSION}},
+}
+
+type Role struct {
+	Name        string    `bson:"name" json:"name"`
+	Permissions []string  `bson:"permissions" json:"permissions"`
+	UpdatedAt   time.Time `bson:"updated_at" json:"updated_at"`
+	// every permission that existed when the role was last seeded
+	KnownPermissions []string `bson:"known_permissions,omitempty" json:"-"`
+}
+
+// HasPermission reports whether the role is granted permission
+func (r Role) HasPermission(permission string) bool {
+	for _, v := range r.Permissions {
+		if v == permission {
+			return true
+		}
+	}
+	return false
+}
+
+func IsValidPermission(permission string) bool {
+	for _, v := range AllPermissions {
+		if v == permission {
+			return true
+		}
+	}
+	return false
+}
+
+type RoleRepository interface {
+	GetAll(ctx context.Context) []Role
+	GetByName(ctx context.Context, name string) (*Role, error)
+	Upsert(ctx context.Context, role Role) (*Role, error)
+	Delete(ctx context.Context, name string) (*Role, error)
+	SeedDefaults(ctx context.Context) error
+}
+
+type RoleDb struct {
+	col *mongo.Collection
+}
+
+func NewRoleDb(db *mongo.Database) RoleRepository {
+	col := db.Collection(ROLE_COLLECTION_NAME)
+	return RoleDb{
+		col: col,
+	}
+}
+
+// GetAll implements RoleRepository
+func (r RoleDb) GetAll(ctx context.Context) []Role {
+	roles := []Role{}
+	opts := options.Find().SetSort(bson.D{{Key: "name", Value: 1}})
+	cur, err := r.col.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		fmt.Printf("err: %v\n", err)
+		return roles
+	}
+	for cur.Next(ctx) {
+		role := Role{}
+		err := cur.Decode(&role)
+		if err != nil {
+			continue
+		}
+		roles = append(roles, role)
+	}
+	return roles
+}
+
+// GetByName implements RoleRepository
+func (r RoleDb) GetByName(ctx context.Context, name string) (*Role, error) {
+	res := r.col.FindOne(ctx, genfilter("name", name))
+	role := Role{}
+	err := res.Decode(&role)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	return &role, nil
+}
+
+// Upsert implements RoleRepository
+func (r RoleDb) Upsert(ctx context.Context, role Role) (*Role, error) {
+	role.UpdatedAt = time.Now()
+	if role.Permissions == nil {
+		role.Permissions = []string{}
+	}
+	update := bson.M{"$set": bson.M{
+		"name":        role.Name,
+		"permissions": role.Permissions,
+		"updated_at":  role.UpdatedAt,
+	}}
+	_, err := r.col.UpdateOne(ctx, genfilter("name", role.Name), update, options.Update().SetUpsert(true))
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	return &role, nil
+}
+
+// Delete implements RoleRepository
+func (r RoleDb) Delete(ctx context.Context, name string) (*Role, error) {
+	res := r.col.FindOneAndDelete(ctx, genfilter("name", name))
+	role := Role{}
+	err := res.Decode(&role)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	return &role, nil
+}
+
+// SeedDefaults implements RoleRepository
+func (r RoleDb) SeedDefaults(ctx context.Context) error {
+	for _, role := range DefaultRoles {
+		found, err := r.GetByName(ctx, role.Name)
+		if errors.Is(err, ErrNotFound) {
+			role.UpdatedAt = time.Now()
+			role.KnownPermissions = AllPermissions
+			update := bson.M{"$setOnInsert": role}
+			_, err := r.col.UpdateOne(ctx, genfilter("name", role.Name), update, options.Update().SetUpsert(true))
+			if err != nil {
+				return wrapError(err)
+			}
+			continue
+		}
+		if err != nil {
+			return wrapError(err)
+		}
+
+		// grant default permissions that did not exist when the role was
+		// seeded, one a super admin removed is in KnownPermissions
+		added := []string{}
+		for _, v := range role.Permissions {
+			if !found.HasPermission(v) && !contains(found.KnownPermissions, v) {
+				added = append(added, v)
+			}
+		}
+		update := bson.M{"$set": bson.M{"known_permissions": AllPermissions}}
+		if len(added) > 0 {
+			update["$addToSet"] = bson.M{"permissions": bson.M{"$each": added}}
+		}
+		_, err = r.col.UpdateOne(ctx, genfilter("name", role.Name), update)
+		if err != nil {
+			return wrapError(err)
+		}
+	}
+	return nil
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,125 @@
+package repository_test
+
+import (
+	"testing"
+
+	"github.com/seenark/super-backend-temp/repository"
+)
+
+func TestRoleSeedDefaults(t *testing.T) {
+	eachBackend(t, func(t *testing.T, repos repository.Repositories) {
+		tests := []struct {
+			name  string
+			setup func(t *testing.T)
+			role  string
+			want  []string
+		}{
+			{
+				name: "creates the default roles",
+				role: repository.ADMIN_ROLE,
+				want: repository.AllPermissions,
+			},
+			{
+				name: "seeding again changes nothing",
+				role: repository.USER_ROLE,
+				want: []string{repository.REDEEM_CREATE_PERMISSION},
+			},
+			{
+				name: "a permission removed by a super admin is not granted again",
+				setup: func(t *testing.T) {
+					_, err := repos.Roles.Upsert(ctx, repository.Role{Name: repository.USER_ROLE, Permissions: []string{repository.REDEEM_READ_PERMISSION}})
+					checkError(t, err, nil)
+				},
+				role: repository.USER_ROLE,
+				want: []string{repository.REDEEM_READ_PERMISSION},
+			},
+			{
+				// as when a role was created before its default permissions
+				// were known
+				name: "permissions of a role made by hand are granted once",
+				setup: func(t *testing.T) {
+					_, err := repos.Roles.Delete(ctx, repository.EVENT_LOGGER_ROLE)
+					checkError(t, err, nil)
+					_, err = repos.Roles.Upsert(ctx, repository.Role{Name: repository.EVENT_LOGGER_ROLE, Permissions: []string{repository.REDEEM_READ_PERMISSION}})
+					checkError(t, err, nil)
+				},
+				role: repository.EVENT_LOGGER_ROLE,
+				want: []string{repository.REDEEM_READ_PERMISSION, repository.REDEEM_INGEST_PERMISSION},
+			},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				if tt.setup != nil {
+					tt.setup(t)
+				}
+				checkError(t, repos.Roles.SeedDefaults(ctx), nil)
+				if roles := repos.Roles.GetAll(ctx); len(roles) != len(repository.DefaultRoles) {
+					t.Fatalf("%d roles, want %d", len(roles), len(repository.DefaultRoles))
+				}
+				role, err := repos.Roles.GetByName(ctx, tt.role)
+				checkError(t, err, nil)
+				if !equalStrings(role.Permissions, tt.want) {
+					t.Fatalf("%s permissions = %v, want %v", tt.role, role.Permissions, tt.want)
+				}
+			})
+		}
+	})
+}
+
+func TestRole(t *testing.T) {
+	eachBackend(t, func(t *testing.T, repos repository.Repositories) {
+		checkError(t, repos.Roles.SeedDefaults(ctx), nil)
+		roles := repos.Roles.GetAll(ctx)
+		names := []string{}
+		for _, v := range roles {
+			names = append(names, v.Name)
+		}
+		want := []string{repository.ADMIN_ROLE, repository.EVENT_LOGGER_ROLE, repository.USER_ROLE}
+		if !equalStrings(names, want) {
+			t.Fatalf("roles = %v, want %v", names, want)
+		}
+
+		upsertTests := []struct {
+			name string
+			role repository.Role
+		}{
+			{"new role", repository.Role{Name: "auditor", Permissions: []string{repository.REDEEM_READ_PERMISSION}}},
+			{"changed role", repository.Role{Name: "auditor", Permissions: []string{repository.REDEEM_READ_PERMISSION, repository.REDEEM_EXPORT_PERMISSION}}},
+			{"without permissions", repository.Role{Name: "guest"}},
+		}
+		for _, tt := range upsertTests {
+			t.Run(tt.name, func(t *testing.T) {
+				_, err := repos.Roles.Upsert(ctx, tt.role)
+				checkError(t, err, nil)
+				found, err := repos.Roles.GetByName(ctx, tt.role.Name)
+				checkError(t, err, nil)
+				if found.Permissions == nil || !equalStrings(found.Permissions, tt.role.Permissions) {
+					t.Fatalf("permissions = %#v, want %v", found.Permissions, tt.role.Permissions)
+				}
+			})
+		}
+		auditor, err := repos.Roles.GetByName(ctx, "auditor")
+		checkError(t, err, nil)
+		if !auditor.HasPermission(repository.REDEEM_EXPORT_PERMISSION) || auditor.HasPermission(repository.REDEEM_APPROVE_PERMISSION) {
+			t.Fatalf("auditor = %+v", auditor)
+		}
+
+		deleteTests := []struct {
+			name string
+			role string
+			err  error
+		}{
+			{"custom role", "auditor", nil},
+			{"deleted role", "auditor", repository.ErrNotFound},
+			{"unknown role", "nobody", repository.ErrNotFound},
+		}
+		for _, tt := range deleteTests {
+			t.Run(tt.name, func(t *testing.T) {
+				_, err := repos.Roles.Delete(ctx, tt.role)
+				checkError(t, err, tt.err)
+			})
+		}
+		_, err = repos.Roles.GetByName(ctx, "auditor")
+		checkError(t, err, repository.ErrNotFound)
+	})
+}
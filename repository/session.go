@@ -0,0 +1,178 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	SESSION_COLLECTION_NAME = "sessions"
+)
+
+// Session is one login of a user. Its id is the refresh token family, it
+// stays the same while the refresh token rotates.
+type Session struct {
+	Id           string             `bson:"_id" json:"id"`
+	UserId       primitive.ObjectID `bson:"user_id" json:"user_id"`
+	TokenId      string             `bson:"token_id" json:"-"` // jti of the only valid refresh token
+	Device       string             `bson:"device" json:"device"`
+	IP           string             `bson:"ip" json:"ip"`
+	CreatedAt    time.Time          `bson:"created_at" json:"created_at"`
+	LastUsedAt   time.Time          `bson:"last_used_at" json:"last_used_at"`
+	ExpiresAt    time.Time          `bson:"expires_at" json:"expires_at"`
+	RevokedAt    *time.Time         `bson:"revoked_at" json:"revoked_at,omitempty"`
+	RevokeReason string             `bson:"revoke_reason,omitempty" json:"revoke_reason,omitempty"`
+}
+
+// ClientInfo describes where a login comes from
+type ClientInfo struct {
+	Device string
+	IP     string
+}
+
+type SessionRepository interface {
+	Create(ctx context.Context, session Session) (*Session, error)
+	GetById(ctx context.Context, sessionId string) (*Session, error)
+	GetActiveByUserId(ctx context.Context, userId string) []Session
+	Rotate(ctx context.Context, sessionId string, oldTokenId string, newTokenId string, client ClientInfo, expiresAt time.Time) (*Session, error)
+	Revoke(ctx context.Context, userId string, sessionId string, reason string) error
+	RevokeFamily(ctx context.Context, sessionId string, reason string) error
+	RevokeAllByUserId(ctx context.Context, userId string, reason string) error
+}
+
+type SessionDb struct {
+	col *mongo.Collection
+}
+
+func NewSessionDb(db *mongo.Database) SessionRepository {
+	col := db.Collection(SESSION_COLLECTION_NAME)
+	return SessionDb{
+		col: col,
+	}
+}
+
+// Create implements SessionRepository
+func (s SessionDb) Create(ctx context.Context, session Session) (*Session, error) {
+	now := time.Now()
+	session.CreatedAt = now
+	session.LastUsedAt = now
+	_, err := s.col.InsertOne(ctx, session)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	return &session, nil
+}
+
+// GetById implements SessionRepository
+func (s SessionDb) GetById(ctx context.Context, sessionId string) (*Session, error) {
+	res := s.col.FindOne(ctx, genfilter("_id", sessionId))
+	session := Session{}
+	err := res.Decode(&session)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	return &session, nil
+}
+
+// GetActiveByUserId implements SessionRepository
+func (s SessionDb) GetActiveByUserId(ctx context.Context, userId string) []Session {
+	sessions := []Session{}
+	id, err := parseObjectId(userId)
+	if err != nil {
+		return sessions
+	}
+	filter := bson.M{
+		"user_id":    id,
+		"revoked_at": nil,
+		"expires_at": bson.M{"$gt": time.Now()},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "last_used_at", Value: -1}})
+	cur, err := s.col.Find(ctx, filter, opts)
+	if err != nil {
+		fmt.Printf("err: %v\n", err)
+		return sessions
+	}
+	for cur.Next(ctx) {
+		session := Session{}
+		err := cur.Decode(&session)
+		if err != nil {
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions
+}
+
+// Rotate implements SessionRepository. It only succeeds when oldTokenId is
+// still the current token of a live session, so each refresh token can be
+// used once.
+func (s SessionDb) Rotate(ctx context.Context, sessionId string, oldTokenId string, newTokenId string, client ClientInfo, expiresAt time.Time) (*Session, error) {
+	filter := bson.M{
+		"_id":        sessionId,
+		"token_id":   oldTokenId,
+		"revoked_at": nil,
+	}
+	update := bson.M{"$set": bson.M{
+		"token_id":     newTokenId,
+		"device":       client.Device,
+		"ip":           client.IP,
+		"last_used_at": time.Now(),
+		"expires_at":   expiresAt,
+	}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	res := s.col.FindOneAndUpdate(ctx, filter, update, opts)
+	session := Session{}
+	err := res.Decode(&session)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	return &session, nil
+}
+
+// Revoke implements SessionRepository
+func (s SessionDb) Revoke(ctx context.Context, userId string, sessionId string, reason string) error {
+	id, err := parseObjectId(userId)
+	if err != nil {
+		return err
+	}
+	filter := bson.M{"_id": sessionId, "user_id": id, "revoked_at": nil}
+	updateRes, err := s.col.UpdateOne(ctx, filter, revokeUpdate(reason))
+	if err != nil {
+		return wrapError(err)
+	}
+	if updateRes.ModifiedCount <= 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// RevokeFamily implements SessionRepository
+func (s SessionDb) RevokeFamily(ctx context.Context, sessionId string, reason string) error {
+	filter := bson.M{"_id": sessionId, "revoked_at": nil}
+	_, err := s.col.UpdateOne(ctx, filter, revokeUpdate(reason))
+	return err
+}
+
+// RevokeAllByUserId implements SessionRepository
+func (s SessionDb) RevokeAllByUserId(ctx context.Context, userId string, reason string) error {
+	id, err := parseObjectId(userId)
+	if err != nil {
+		return err
+	}
+	filter := bson.M{"user_id": id, "revoked_at": nil}
+	_, err = s.col.UpdateMany(ctx, filter, revokeUpdate(reason))
+	return err
+}
+
+func revokeUpdate(reason string) bson.M {
+	return bson.M{"$set": bson.M{
+		"revoked_at":    time.Now(),
+		"revoke_reason": reason,
+	}}
+}
@@ -0,0 +1,107 @@
+package repository_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/seenark/super-backend-temp/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestSessionRotate(t *testing.T) {
+	eachBackend(t, func(t *testing.T, repos repository.Repositories) {
+		userId := primitive.NewObjectID()
+		expiresAt := time.Now().Add(time.Hour)
+		_, err := repos.Sessions.Create(ctx, repository.Session{Id: "s1", UserId: userId, TokenId: "t1", ExpiresAt: expiresAt})
+		checkError(t, err, nil)
+		_, err = repos.Sessions.Create(ctx, repository.Session{Id: "s1", UserId: userId, TokenId: "t1", ExpiresAt: expiresAt})
+		checkError(t, err, repository.ErrDuplicate)
+
+		client := repository.ClientInfo{Device: "phone", IP: "10.0.0.1"}
+		tests := []struct {
+			name      string
+			sessionId string
+			oldToken  string
+			newToken  string
+			err       error
+		}{
+			{"current token", "s1", "t1", "t2", nil},
+			{"token already rotated", "s1", "t1", "t3", repository.ErrNotFound},
+			{"unknown session", "s2", "t2", "t3", repository.ErrNotFound},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				session, err := repos.Sessions.Rotate(ctx, tt.sessionId, tt.oldToken, tt.newToken, client, expiresAt)
+				checkError(t, err, tt.err)
+				if err == nil && (session.TokenId != tt.newToken || session.Device != client.Device) {
+					t.Fatalf("session = %+v", session)
+				}
+			})
+		}
+
+		found, err := repos.Sessions.GetById(ctx, "s1")
+		checkError(t, err, nil)
+		if found.TokenId != "t2" || found.IP != client.IP {
+			t.Fatalf("session = %+v", found)
+		}
+		_, err = repos.Sessions.GetById(ctx, "s2")
+		checkError(t, err, repository.ErrNotFound)
+	})
+}
+
+func TestSessionRevoke(t *testing.T) {
+	eachBackend(t, func(t *testing.T, repos repository.Repositories) {
+		userId := primitive.NewObjectID()
+		otherUserId := primitive.NewObjectID()
+		for _, session := range []repository.Session{
+			{Id: "a", UserId: userId, ExpiresAt: time.Now().Add(time.Hour)},
+			{Id: "b", UserId: userId, ExpiresAt: time.Now().Add(time.Hour)},
+			{Id: "c", UserId: userId, ExpiresAt: time.Now().Add(time.Hour)},
+			{Id: "expired", UserId: userId, ExpiresAt: time.Now().Add(-time.Hour)},
+			{Id: "other", UserId: otherUserId, ExpiresAt: time.Now().Add(time.Hour)},
+		} {
+			_, err := repos.Sessions.Create(ctx, session)
+			checkError(t, err, nil)
+		}
+		if active := repos.Sessions.GetActiveByUserId(ctx, userId.Hex()); len(active) != 3 {
+			t.Fatalf("%d active sessions, want 3", len(active))
+		}
+
+		tests := []struct {
+			name      string
+			userId    string
+			sessionId string
+			err       error
+		}{
+			{"own session", userId.Hex(), "a", nil},
+			{"already revoked", userId.Hex(), "a", repository.ErrNotFound},
+			{"session of someone else", userId.Hex(), "other", repository.ErrNotFound},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				err := repos.Sessions.Revoke(ctx, tt.userId, tt.sessionId, "test")
+				checkError(t, err, tt.err)
+			})
+		}
+		revoked, err := repos.Sessions.GetById(ctx, "a")
+		checkError(t, err, nil)
+		if revoked.RevokedAt == nil || revoked.RevokeReason != "test" {
+			t.Fatalf("session = %+v", revoked)
+		}
+
+		err = repos.Sessions.RevokeFamily(ctx, "b", "reused")
+		checkError(t, err, nil)
+		if active := repos.Sessions.GetActiveByUserId(ctx, userId.Hex()); len(active) != 1 || active[0].Id != "c" {
+			t.Fatalf("active sessions = %v", active)
+		}
+
+		err = repos.Sessions.RevokeAllByUserId(ctx, userId.Hex(), "password changed")
+		checkError(t, err, nil)
+		if active := repos.Sessions.GetActiveByUserId(ctx, userId.Hex()); len(active) != 0 {
+			t.Fatalf("active sessions = %v", active)
+		}
+		if active := repos.Sessions.GetActiveByUserId(ctx, otherUserId.Hex()); len(active) != 1 {
+			t.Fatal("the session of another user was revoked")
+		}
+	})
+}
@@ -2,7 +2,6 @@ package repository
 
 import (
 	"context"
-	"fmt"
 
 	"github.com/seenark/super-backend-temp/authen"
 	"go.mongodb.org/mongo-driver/bson"
@@ -10,6 +9,16 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
+const (
+	USER_COLLECTION_NAME = "users"
+)
+
+const (
+	USER_ROLE         = "user"
+	ADMIN_ROLE        = "admin"
+	EVENT_LOGGER_ROLE = "eventLogger"
+)
+
 type User struct {
 	Id              primitive.ObjectID `bson:"_id" json:"id"`
 	Name            string             `bson:"name" json:"name"`
@@ -20,70 +29,81 @@ type User struct {
 	Tel             string             `bson:"tel" json:"tel"`
 	Password        string             `bson:"password" json:"password"`
 	SuperAdmin      bool               `bson:"super_admin,omitempty" json:"super_admin"`
-	Refreshtoken    string             `bson:"refresh_token" json:"refresh_token"`
+	// MetamaskAddress was proven with SIWE, only then it signs the user in
+	WalletVerified bool `bson:"wallet_verified,omitempty" json:"wallet_verified,omitempty"`
 }
 
 type UserRepository interface {
-	GetAll() (users []User)
-	GetById(userId string) (user *User, err error)
-	Create(newUser User) (user *User, err error)
-	Update(newUser User) (user *User, err error)
-	Delete(userId string) (user *User, err error)
+	GetPage(ctx context.Context, query PageQuery) ([]User, *Page, error)
+	GetById(ctx context.Context, userId string) (user *User, err error)
+	GetByEmail(ctx context.Context, email string) (user *User, err error)
+	Create(ctx context.Context, newUser User) (user *User, err error)
+	Update(ctx context.Context, newUser User) (user *User, err error)
+	Delete(ctx context.Context, userId string) (user *User, err error)
 }
 
 type UserDb struct {
 	collection *mongo.Collection
-	ctx        context.Context
 }
 
 func NewUserDb(collection *mongo.Collection) UserRepository {
 	return &UserDb{
 		collection: collection,
-		ctx:        context.Background(),
 	}
 }
 
-func (u *UserDb) GetAll() (users []User) {
-	cur, _ := u.collection.Find(u.ctx, bson.M{})
-	for cur.Next(u.ctx) {
-		user := User{}
-		err := cur.Decode(&user)
-		if err != nil {
-			continue
-		}
-		users = append(users, user)
+func (u *UserDb) GetPage(ctx context.Context, query PageQuery) ([]User, *Page, error) {
+	users := []User{}
+	page, err := findPage(ctx, u.collection, bson.M{}, query, UserSortFields, "_id", &users)
+	if err != nil {
+		return nil, nil, err
 	}
-	return users
+	return users, page, nil
 }
-func (u *UserDb) GetById(userId string) (user *User, err error) {
-	userIdObjectId, err := primitive.ObjectIDFromHex(userId)
+func (u *UserDb) GetById(ctx context.Context, userId string) (user *User, err error) {
+	userIdObjectId, err := parseObjectId(userId)
 	if err != nil {
 		return nil, err
 	}
 	filter := bson.D{bson.E{Key: "_id", Value: userIdObjectId}}
-	res := u.collection.FindOne(u.ctx, filter)
+	res := u.collection.FindOne(ctx, filter)
 	err = res.Decode(&user)
 	if err != nil {
-		return nil, err
+		return nil, wrapError(err)
 	}
 	return user, nil
 }
-func (u *UserDb) Create(newUser User) (user *User, err error) {
+
+// GetByEmail implements UserRepository, wallet users have no email and are
+// never found
+func (u *UserDb) GetByEmail(ctx context.Context, email string) (*User, error) {
+	if email == "" {
+		return nil, ErrNotFound
+	}
+	user := User{}
+	err := u.collection.FindOne(ctx, bson.M{"email": email}).Decode(&user)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	return &user, nil
+}
+
+func (u *UserDb) Create(ctx context.Context, newUser User) (user *User, err error) {
 	user = &newUser
 	user.Id = primitive.NewObjectID()
 	user.Password, err = authen.HashPassword(newUser.Password)
 	if err != nil {
 		return nil, err
 	}
-	_, err = u.collection.InsertOne(u.ctx, user)
+	_, err = u.collection.InsertOne(ctx, user)
 	if err != nil {
-		return nil, err
+		return nil, wrapError(err)
 	}
 	return user, nil
 }
 
-func (u *UserDb) Update(newUser User) (user *User, err error) {
-	user, err = u.GetById(newUser.Id.Hex())
+func (u *UserDb) Update(ctx context.Context, newUser User) (user *User, err error) {
+	user, err = u.GetById(ctx, newUser.Id.Hex())
 	if err != nil {
 		return nil, err
 	}
@@ -112,30 +132,29 @@ func (u *UserDb) Update(newUser User) (user *User, err error) {
 		}
 		user.Password = newPass
 	}
-	user.Refreshtoken = ""
 	filter := genfilter("_id", newUser.Id)
 	update := bson.D{bson.E{Key: "$set", Value: user}}
-	res, err := u.collection.UpdateOne(u.ctx, filter, update)
+	res, err := u.collection.UpdateOne(ctx, filter, update)
 	if err != nil {
-		return nil, err
+		return nil, wrapError(err)
 	}
 	if res.ModifiedCount == 0 {
-		return nil, fmt.Errorf("no item updated")
+		return nil, ErrNoChange
 	}
 	return user, nil
 }
-func (u *UserDb) Delete(userId string) (user *User, err error) {
-	user, err = u.GetById(userId)
+func (u *UserDb) Delete(ctx context.Context, userId string) (user *User, err error) {
+	user, err = u.GetById(ctx, userId)
 	if err != nil {
 		return nil, err
 	}
 	filter := bson.D{bson.E{Key: "_id", Value: user.Id}}
-	delete, err := u.collection.DeleteOne(u.ctx, filter)
+	delete, err := u.collection.DeleteOne(ctx, filter)
 	if err != nil {
-		return nil, err
+		return nil, wrapError(err)
 	}
 	if delete.DeletedCount == 0 {
-		return nil, fmt.Errorf("no item deleted")
+		return nil, ErrNotFound
 	}
 	return user, nil
 }
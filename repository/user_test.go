@@ -0,0 +1,276 @@
+package repository_test
+
+import (
+	"testing"
+
+	"github.com/seenark/super-backend-temp/authen"
+	"github.com/seenark/super-backend-temp/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestUserCreate(t *testing.T) {
+	eachBackend(t, func(t *testing.T, repos repository.Repositories) {
+		_, err := repos.Users.Create(ctx, repository.User{Name: "wallet only"})
+		checkError(t, err, nil)
+
+		tests := []struct {
+			name string
+			user repository.User
+			err  error
+		}{
+			{"new email", repository.User{Name: "a", Email: "a@example.com", Password: "secret"}, nil},
+			{"taken email", repository.User{Name: "b", Email: "a@example.com", Password: "secret"}, repository.ErrDuplicate},
+			// emails are only unique when set
+			{"second user without email", repository.User{Name: "c"}, nil},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				user, err := repos.Users.Create(ctx, tt.user)
+				checkError(t, err, tt.err)
+				if err != nil {
+					return
+				}
+				if user.Id.IsZero() {
+					t.Fatal("id was not set")
+				}
+				if tt.user.Password != "" && !authen.VerifyPassword(user.Password, tt.user.Password) {
+					t.Fatal("password was not hashed")
+				}
+			})
+		}
+	})
+}
+
+func TestUserGetById(t *testing.T) {
+	eachBackend(t, func(t *testing.T, repos repository.Repositories) {
+		created, err := repos.Users.Create(ctx, repository.User{Name: "a", Email: "a@example.com"})
+		checkError(t, err, nil)
+
+		tests := []struct {
+			name string
+			id   string
+			err  error
+		}{
+			{"found", created.Id.Hex(), nil},
+			{"unknown id", primitive.NewObjectID().Hex(), repository.ErrNotFound},
+			{"malformed id", "nope", repository.ErrNotFound},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				user, err := repos.Users.GetById(ctx, tt.id)
+				checkError(t, err, tt.err)
+				if err == nil && user.Email != created.Email {
+					t.Fatalf("email = %q, want %q", user.Email, created.Email)
+				}
+			})
+		}
+	})
+}
+
+func TestUserGetByEmail(t *testing.T) {
+	eachBackend(t, func(t *testing.T, repos repository.Repositories) {
+		created, err := repos.Users.Create(ctx, repository.User{Name: "a", Email: "a@example.com"})
+		checkError(t, err, nil)
+		_, err = repos.Users.Create(ctx, repository.User{Name: "wallet", MetamaskAddress: "0x01"})
+		checkError(t, err, nil)
+
+		tests := []struct {
+			name  string
+			email string
+			err   error
+		}{
+			{"found", "a@example.com", nil},
+			{"unknown email", "b@example.com", repository.ErrNotFound},
+			{"empty email of wallet users", "", repository.ErrNotFound},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				user, err := repos.Users.GetByEmail(ctx, tt.email)
+				checkError(t, err, tt.err)
+				if err == nil && user.Id != created.Id {
+					t.Fatalf("id = %s, want %s", user.Id.Hex(), created.Id.Hex())
+				}
+			})
+		}
+	})
+}
+
+func TestUserUpdate(t *testing.T) {
+	eachBackend(t, func(t *testing.T, repos repository.Repositories) {
+		a, err := repos.Users.Create(ctx, repository.User{Name: "a", Email: "a@example.com", Role: repository.USER_ROLE})
+		checkError(t, err, nil)
+		_, err = repos.Users.Create(ctx, repository.User{Name: "b", Email: "b@example.com"})
+		checkError(t, err, nil)
+
+		tests := []struct {
+			name     string
+			update   repository.User
+			err      error
+			wantName string
+			wantRole string
+		}{
+			{"empty fields are kept", repository.User{Id: a.Id, Name: "renamed"}, nil, "renamed", repository.USER_ROLE},
+			{"role", repository.User{Id: a.Id, Role: repository.ADMIN_ROLE}, nil, "renamed", repository.ADMIN_ROLE},
+			{"nothing changed", repository.User{Id: a.Id, Name: "renamed"}, repository.ErrNoChange, "", ""},
+			{"taken email", repository.User{Id: a.Id, Email: "b@example.com"}, repository.ErrDuplicate, "", ""},
+			{"unknown user", repository.User{Id: primitive.NewObjectID(), Name: "x"}, repository.ErrNotFound, "", ""},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				user, err := repos.Users.Update(ctx, tt.update)
+				checkError(t, err, tt.err)
+				if err != nil {
+					return
+				}
+				if user.Name != tt.wantName || user.Role != tt.wantRole {
+					t.Fatalf("got %q %q, want %q %q", user.Name, user.Role, tt.wantName, tt.wantRole)
+				}
+				if user.Email != a.Email {
+					t.Fatalf("email changed to %q", user.Email)
+				}
+			})
+		}
+	})
+}
+
+func TestUserDelete(t *testing.T) {
+	eachBackend(t, func(t *testing.T, repos repository.Repositories) {
+		created, err := repos.Users.Create(ctx, repository.User{Name: "a"})
+		checkError(t, err, nil)
+
+		_, err = repos.Users.Delete(ctx, created.Id.Hex())
+		checkError(t, err, nil)
+		_, err = repos.Users.GetById(ctx, created.Id.Hex())
+		checkError(t, err, repository.ErrNotFound)
+		_, err = repos.Users.Delete(ctx, created.Id.Hex())
+		checkError(t, err, repository.ErrNotFound)
+	})
+}
+
+func TestUserGetPage(t *testing.T) {
+	eachBackend(t, func(t *testing.T, repos repository.Repositories) {
+		for _, name := range []string{"carol", "alice", "bob"} {
+			_, err := repos.Users.Create(ctx, repository.User{Name: name})
+			checkError(t, err, nil)
+		}
+
+		tests := []struct {
+			name      string
+			query     repository.PageQuery
+			err       error
+			wantNames []string
+			wantNext  bool
+		}{
+			{"default sort is insertion order", repository.PageQuery{}, nil, []string{"carol", "alice", "bob"}, false},
+			{"by name", repository.PageQuery{Sort: "name"}, nil, []string{"alice", "bob", "carol"}, false},
+			{"by name descending", repository.PageQuery{Sort: "-name"}, nil, []string{"carol", "bob", "alice"}, false},
+			{"first page", repository.PageQuery{Sort: "name", Limit: 2}, nil, []string{"alice", "bob"}, true},
+			{"second page", repository.PageQuery{Sort: "name", Limit: 2, Page: 2}, nil, []string{"carol"}, false},
+			{"unknown sort field", repository.PageQuery{Sort: "password"}, repository.ErrInvalidSort, nil, false},
+			{"bad cursor", repository.PageQuery{Cursor: "x"}, repository.ErrInvalidCursor, nil, false},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				users, page, err := repos.Users.GetPage(ctx, tt.query)
+				checkError(t, err, tt.err)
+				if err != nil {
+					return
+				}
+				names := []string{}
+				for _, v := range users {
+					names = append(names, v.Name)
+				}
+				if !equalStrings(names, tt.wantNames) {
+					t.Fatalf("names = %v, want %v", names, tt.wantNames)
+				}
+				if page.Total != 3 {
+					t.Fatalf("total = %d, want 3", page.Total)
+				}
+				if (page.NextCursor != "") != tt.wantNext {
+					t.Fatalf("next cursor = %q", page.NextCursor)
+				}
+			})
+		}
+
+		// the cursor of a page leads to the next one
+		_, page, err := repos.Users.GetPage(ctx, repository.PageQuery{Sort: "name", Limit: 2})
+		checkError(t, err, nil)
+		users, _, err := repos.Users.GetPage(ctx, repository.PageQuery{Sort: "name", Limit: 2, Cursor: page.NextCursor})
+		checkError(t, err, nil)
+		if len(users) != 1 || users[0].Name != "carol" {
+			t.Fatalf("page after cursor = %v", users)
+		}
+		_, _, err = repos.Users.GetPage(ctx, repository.PageQuery{Sort: "-name", Limit: 2, Cursor: page.NextCursor})
+		checkError(t, err, repository.ErrInvalidCursor)
+	})
+}
+
+func TestUserGetPageCursor(t *testing.T) {
+	eachBackend(t, func(t *testing.T, repos repository.Repositories) {
+		for _, name := range []string{"bob", "alice", "bob", "dave", "bob"} {
+			_, err := repos.Users.Create(ctx, repository.User{Name: name})
+			checkError(t, err, nil)
+		}
+
+		for _, sort := range []string{"name", "-name", "_id", "-_id"} {
+			t.Run(sort, func(t *testing.T) {
+				all, _, err := repos.Users.GetPage(ctx, repository.PageQuery{Sort: sort})
+				checkError(t, err, nil)
+				// pages of 2 split the three bobs
+				seen := []string{}
+				query := repository.PageQuery{Sort: sort, Limit: 2}
+				for pages := 0; ; pages++ {
+					users, page, err := repos.Users.GetPage(ctx, query)
+					checkError(t, err, nil)
+					if page.Total != int64(len(all)) {
+						t.Fatalf("page %d total = %d, want %d", pages, page.Total, len(all))
+					}
+					for _, v := range users {
+						seen = append(seen, v.Id.Hex())
+					}
+					if page.NextCursor == "" {
+						break
+					}
+					query.Cursor = page.NextCursor
+				}
+				want := []string{}
+				for _, v := range all {
+					want = append(want, v.Id.Hex())
+				}
+				if !equalStrings(seen, want) {
+					t.Fatalf("pages %v, want %v", seen, want)
+				}
+			})
+		}
+
+		// a user added before the cursor does not shift the next page
+		users, page, err := repos.Users.GetPage(ctx, repository.PageQuery{Sort: "name", Limit: 2})
+		checkError(t, err, nil)
+		if users[1].Name != "bob" {
+			t.Fatalf("first page %v", users)
+		}
+		_, err = repos.Users.Create(ctx, repository.User{Name: "aaron"})
+		checkError(t, err, nil)
+		users, _, err = repos.Users.GetPage(ctx, repository.PageQuery{Sort: "name", Limit: 2, Cursor: page.NextCursor})
+		checkError(t, err, nil)
+		names := []string{}
+		for _, v := range users {
+			names = append(names, v.Name)
+		}
+		if !equalStrings(names, []string{"bob", "bob"}) {
+			t.Fatalf("next page %v, want the other two bobs", names)
+		}
+	})
+}
+
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
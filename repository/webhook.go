@@ -0,0 +1,234 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	WEBHOOK_COLLECTION_NAME          = "webhooks"
+	WEBHOOK_DELIVERY_COLLECTION_NAME = "webhook_deliveries"
+)
+
+// WebhookEvents are the outbox event types a webhook can subscribe to
+var WebhookEvents = []string{
+	REDEEMED_CREATED_EVENT,
+	REDEEMED_RECORDED_EVENT,
+	REDEEMED_DETAILS_SUBMITTED_EVENT,
+	REDEEMED_STATUS_CHANGED_EVENT,
+	REDEEMED_REMOVED_EVENT,
+	METADATA_UPDATED_EVENT,
+	CERT_TYPE_CREATED_EVENT,
+}
+
+var WebhookDeliverySortFields = []string{"created_at", "status_code"}
+
+// Webhook is an endpoint of a partner that is sent the events it subscribes
+// to, signed with Secret
+type Webhook struct {
+	Id          primitive.ObjectID `bson:"_id" json:"id"`
+	Url         string             `bson:"url" json:"url"`
+	Description string             `bson:"description" json:"description"`
+	Events      []string           `bson:"events" json:"events"`
+	Secret      string             `bson:"secret" json:"-"`
+	Active      bool               `bson:"active" json:"active"`
+	CreatedBy   string             `bson:"created_by" json:"created_by"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt   time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// WebhookDelivery is one attempt to deliver an event to a webhook
+type WebhookDelivery struct {
+	Id            primitive.ObjectID  `bson:"_id" json:"id"`
+	WebhookId     primitive.ObjectID  `bson:"webhook_id" json:"webhook_id"`
+	OutboxEventId primitive.ObjectID  `bson:"outbox_event_id" json:"outbox_event_id"`
+	EventId       string              `bson:"event_id" json:"event_id"` // id in the payload, the same for retries and replays
+	EventType     string              `bson:"event_type" json:"event_type"`
+	Url           string              `bson:"url" json:"url"`
+	Attempt       int                 `bson:"attempt" json:"attempt"`
+	Payload       string              `bson:"payload" json:"payload"`
+	StatusCode    int                 `bson:"status_code" json:"status_code"`
+	ResponseBody  string              `bson:"response_body" json:"response_body"`
+	Error         string              `bson:"error,omitempty" json:"error,omitempty"`
+	Success       bool                `bson:"success" json:"success"`
+	DurationMs    int64               `bson:"duration_ms" json:"duration_ms"`
+	ReplayOf      *primitive.ObjectID `bson:"replay_of,omitempty" json:"replay_of,omitempty"`
+	CreatedAt     time.Time           `bson:"created_at" json:"created_at"`
+}
+
+type WebhookRepository interface {
+	Create(ctx context.Context, webhook Webhook) (*Webhook, error)
+	GetAll(ctx context.Context) []Webhook
+	GetById(ctx context.Context, id string) (*Webhook, error)
+	GetByEvent(ctx context.Context, eventType string) ([]Webhook, error)
+	Update(ctx context.Context, id string, webhook Webhook) (*Webhook, error)
+	Delete(ctx context.Context, id string) (*Webhook, error)
+}
+
+type WebhookDeliveryRepository interface {
+	Create(ctx context.Context, delivery WebhookDelivery) (*WebhookDelivery, error)
+	GetById(ctx context.Context, id string) (*WebhookDelivery, error)
+	GetPage(ctx context.Context, webhookId primitive.ObjectID, query PageQuery) ([]WebhookDelivery, *Page, error)
+}
+
+type WebhookDb struct {
+	col *mongo.Collection
+}
+
+func NewWebhookDb(db *mongo.Database) WebhookRepository {
+	col := db.Collection(WEBHOOK_COLLECTION_NAME)
+	return WebhookDb{
+		col: col,
+	}
+}
+
+// Create implements WebhookRepository
+func (w WebhookDb) Create(ctx context.Context, webhook Webhook) (*Webhook, error) {
+	webhook.Id = primitive.NewObjectID()
+	webhook.CreatedAt = time.Now()
+	webhook.UpdatedAt = webhook.CreatedAt
+	if webhook.Events == nil {
+		webhook.Events = []string{}
+	}
+	_, err := w.col.InsertOne(ctx, webhook)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	return &webhook, nil
+}
+
+// GetAll implements WebhookRepository
+func (w WebhookDb) GetAll(ctx context.Context) []Webhook {
+	webhooks := []Webhook{}
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	cur, err := w.col.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		fmt.Printf("err: %v\n", err)
+		return webhooks
+	}
+	err = cur.All(ctx, &webhooks)
+	if err != nil {
+		fmt.Printf("err: %v\n", err)
+	}
+	return webhooks
+}
+
+// GetById implements WebhookRepository
+func (w WebhookDb) GetById(ctx context.Context, id string) (*Webhook, error) {
+	objectId, err := parseObjectId(id)
+	if err != nil {
+		return nil, err
+	}
+	res := w.col.FindOne(ctx, genfilter("_id", objectId))
+	webhook := Webhook{}
+	err = res.Decode(&webhook)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	return &webhook, nil
+}
+
+// GetByEvent implements WebhookRepository, only active webhooks are returned
+func (w WebhookDb) GetByEvent(ctx context.Context, eventType string) ([]Webhook, error) {
+	webhooks := []Webhook{}
+	cur, err := w.col.Find(ctx, bson.M{"active": true, "events": eventType})
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	err = cur.All(ctx, &webhooks)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	return webhooks, nil
+}
+
+// Update implements WebhookRepository, the secret can not be changed
+func (w WebhookDb) Update(ctx context.Context, id string, webhook Webhook) (*Webhook, error) {
+	objectId, err := parseObjectId(id)
+	if err != nil {
+		return nil, err
+	}
+	update := bson.M{"$set": bson.M{
+		"url":         webhook.Url,
+		"description": webhook.Description,
+		"events":      webhook.Events,
+		"active":      webhook.Active,
+		"updated_at":  time.Now(),
+	}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	res := w.col.FindOneAndUpdate(ctx, genfilter("_id", objectId), update, opts)
+	updated := Webhook{}
+	err = res.Decode(&updated)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	return &updated, nil
+}
+
+// Delete implements WebhookRepository
+func (w WebhookDb) Delete(ctx context.Context, id string) (*Webhook, error) {
+	objectId, err := parseObjectId(id)
+	if err != nil {
+		return nil, err
+	}
+	res := w.col.FindOneAndDelete(ctx, genfilter("_id", objectId))
+	webhook := Webhook{}
+	err = res.Decode(&webhook)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	return &webhook, nil
+}
+
+type WebhookDeliveryDb struct {
+	col *mongo.Collection
+}
+
+func NewWebhookDeliveryDb(db *mongo.Database) WebhookDeliveryRepository {
+	col := db.Collection(WEBHOOK_DELIVERY_COLLECTION_NAME)
+	return WebhookDeliveryDb{
+		col: col,
+	}
+}
+
+// Create implements WebhookDeliveryRepository
+func (w WebhookDeliveryDb) Create(ctx context.Context, delivery WebhookDelivery) (*WebhookDelivery, error) {
+	delivery.Id = primitive.NewObjectID()
+	delivery.CreatedAt = time.Now()
+	_, err := w.col.InsertOne(ctx, delivery)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	return &delivery, nil
+}
+
+// GetById implements WebhookDeliveryRepository
+func (w WebhookDeliveryDb) GetById(ctx context.Context, id string) (*WebhookDelivery, error) {
+	objectId, err := parseObjectId(id)
+	if err != nil {
+		return nil, err
+	}
+	res := w.col.FindOne(ctx, genfilter("_id", objectId))
+	delivery := WebhookDelivery{}
+	err = res.Decode(&delivery)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	return &delivery, nil
+}
+
+// GetPage implements WebhookDeliveryRepository
+func (w WebhookDeliveryDb) GetPage(ctx context.Context, webhookId primitive.ObjectID, query PageQuery) ([]WebhookDelivery, *Page, error) {
+	deliveries := []WebhookDelivery{}
+	page, err := findPage(ctx, w.col, bson.M{"webhook_id": webhookId}, query, WebhookDeliverySortFields, "-created_at", &deliveries)
+	if err != nil {
+		return nil, nil, err
+	}
+	return deliveries, page, nil
+}
@@ -0,0 +1,127 @@
+package repository_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/seenark/super-backend-temp/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestWebhook(t *testing.T) {
+	eachBackend(t, func(t *testing.T, repos repository.Repositories) {
+		active, err := repos.Webhooks.Create(ctx, repository.Webhook{Url: "https://a.example.com", Events: []string{repository.REDEEMED_CREATED_EVENT}, Secret: "s", Active: true})
+		checkError(t, err, nil)
+		time.Sleep(2 * time.Millisecond)
+		inactive, err := repos.Webhooks.Create(ctx, repository.Webhook{Url: "https://b.example.com", Events: []string{repository.REDEEMED_CREATED_EVENT}})
+		checkError(t, err, nil)
+
+		all := repos.Webhooks.GetAll(ctx)
+		if len(all) != 2 || all[0].Id != inactive.Id {
+			t.Fatalf("webhooks = %v, want newest first", all)
+		}
+		subscribed, err := repos.Webhooks.GetByEvent(ctx, repository.REDEEMED_CREATED_EVENT)
+		checkError(t, err, nil)
+		if len(subscribed) != 1 || subscribed[0].Id != active.Id {
+			t.Fatalf("subscribed = %v", subscribed)
+		}
+
+		tests := []struct {
+			name string
+			id   string
+			err  error
+		}{
+			{"known", inactive.Id.Hex(), nil},
+			{"unknown", primitive.NewObjectID().Hex(), repository.ErrNotFound},
+			{"malformed", "nope", repository.ErrNotFound},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				_, err := repos.Webhooks.GetById(ctx, tt.id)
+				checkError(t, err, tt.err)
+				update := repository.Webhook{Url: "https://c.example.com", Events: []string{repository.METADATA_UPDATED_EVENT}, Active: true, Secret: "changed"}
+				updated, err := repos.Webhooks.Update(ctx, tt.id, update)
+				checkError(t, err, tt.err)
+				if err == nil && (updated.Url != update.Url || updated.Secret != "" || !updated.Active) {
+					t.Fatalf("updated %+v", updated)
+				}
+			})
+		}
+		subscribed, err = repos.Webhooks.GetByEvent(ctx, repository.METADATA_UPDATED_EVENT)
+		checkError(t, err, nil)
+		if len(subscribed) != 1 || subscribed[0].Id != inactive.Id {
+			t.Fatalf("subscribed = %v", subscribed)
+		}
+
+		_, err = repos.Webhooks.Delete(ctx, active.Id.Hex())
+		checkError(t, err, nil)
+		_, err = repos.Webhooks.Delete(ctx, active.Id.Hex())
+		checkError(t, err, repository.ErrNotFound)
+	})
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	eachBackend(t, func(t *testing.T, repos repository.Repositories) {
+		webhookId := primitive.NewObjectID()
+		var last *repository.WebhookDelivery
+		for attempt := 1; attempt <= 3; attempt++ {
+			delivery, err := repos.WebhookDeliveries.Create(ctx, repository.WebhookDelivery{WebhookId: webhookId, Attempt: attempt, StatusCode: 500})
+			checkError(t, err, nil)
+			last = delivery
+			time.Sleep(2 * time.Millisecond)
+		}
+		_, err := repos.WebhookDeliveries.Create(ctx, repository.WebhookDelivery{WebhookId: primitive.NewObjectID()})
+		checkError(t, err, nil)
+
+		getTests := []struct {
+			name string
+			id   string
+			err  error
+		}{
+			{"known", last.Id.Hex(), nil},
+			{"unknown", primitive.NewObjectID().Hex(), repository.ErrNotFound},
+			{"malformed", "nope", repository.ErrNotFound},
+		}
+		for _, tt := range getTests {
+			t.Run(tt.name, func(t *testing.T) {
+				found, err := repos.WebhookDeliveries.GetById(ctx, tt.id)
+				checkError(t, err, tt.err)
+				if err == nil && (found.Attempt != 3 || found.CreatedAt.IsZero()) {
+					t.Fatalf("found %+v", found)
+				}
+			})
+		}
+
+		// newest first, a page at a time, without the other webhook
+		pageTests := []struct {
+			name         string
+			query        repository.PageQuery
+			wantAttempts []int
+			wantNext     bool
+		}{
+			{"first page", repository.PageQuery{Limit: 2}, []int{3, 2}, true},
+			{"second page", repository.PageQuery{Limit: 2, Page: 2}, []int{1}, false},
+			{"oldest first", repository.PageQuery{Sort: "created_at"}, []int{1, 2, 3}, false},
+		}
+		for _, tt := range pageTests {
+			t.Run(tt.name, func(t *testing.T) {
+				deliveries, page, err := repos.WebhookDeliveries.GetPage(ctx, webhookId, tt.query)
+				checkError(t, err, nil)
+				attempts := []int{}
+				for _, v := range deliveries {
+					attempts = append(attempts, v.Attempt)
+				}
+				if !equalInts(attempts, tt.wantAttempts) || page.Total != 3 || (page.NextCursor != "") != tt.wantNext {
+					t.Fatalf("attempts %v of page %+v, want %v", attempts, page, tt.wantAttempts)
+				}
+			})
+		}
+		_, page, err := repos.WebhookDeliveries.GetPage(ctx, webhookId, repository.PageQuery{Limit: 2})
+		checkError(t, err, nil)
+		deliveries, page, err := repos.WebhookDeliveries.GetPage(ctx, webhookId, repository.PageQuery{Limit: 2, Cursor: page.NextCursor})
+		checkError(t, err, nil)
+		if len(deliveries) != 1 || deliveries[0].Attempt != 1 || page.NextCursor != "" {
+			t.Fatalf("page after the cursor %+v of %d", page, len(deliveries))
+		}
+	})
+}
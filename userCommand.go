@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/seenark/super-backend-temp/config"
+	"github.com/seenark/super-backend-temp/handler"
+	"github.com/seenark/super-backend-temp/repository"
+	"github.com/seenark/super-backend-temp/validation"
+)
+
+const userUsage = `usage: user <command> [flags]
+
+commands:
+  create           create a user, such as the first admin
+  reset-password   set the password of a user and sign out their sessions
+
+the password is read from stdin when -password is not given`
+
+// userPassword has the password rule of handler.CreateUserRequest
+type userPassword struct {
+	Password string `json:"password" validate:"required,min=8,max=72"`
+}
+
+func userCommand(cfg config.Configuration, args []string) error {
+	name, args, err := subcommand(args, userUsage)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+
+	switch name {
+	case "create":
+		flags := flag.NewFlagSet("user create", flag.ExitOnError)
+		storageFlags(flags, &cfg)
+		email := flags.String("email", "", "email to sign in with")
+		password := flags.String("password", "", "password, read from stdin when empty")
+		userName := flags.String("name", "", "display name")
+		role := flags.String("role", repository.USER_ROLE, "role of the user")
+		superAdmin := flags.Bool("super-admin", false, "may manage roles, only with -role admin")
+		flags.Parse(args)
+
+		if *superAdmin && *role != repository.ADMIN_ROLE {
+			return errors.New("-super-admin needs -role admin")
+		}
+		// the same rules as users created through the api
+		body := handler.CreateUserRequest{Name: *userName, Email: *email}
+		body.Normalize()
+		body.Password, err = readPassword(*password)
+		if err != nil {
+			return err
+		}
+		err = validation.Struct(body)
+		if err != nil {
+			return err
+		}
+		repos := newRepositories(cfg)
+		err = repos.Roles.SeedDefaults(ctx)
+		if err != nil {
+			return fmt.Errorf("seed default roles: %w", err)
+		}
+		_, err = repos.Roles.GetByName(ctx, *role)
+		if err != nil {
+			return fmt.Errorf("role %s: %w", *role, err)
+		}
+		created, err := repos.Users.Create(ctx, repository.User{
+			Name:       body.Name,
+			Email:      body.Email,
+			Password:   body.Password,
+			Role:       *role,
+			SuperAdmin: *superAdmin,
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("created %s user %s %s\n", created.Role, created.Id.Hex(), created.Email)
+		return nil
+
+	case "reset-password":
+		flags := flag.NewFlagSet("user reset-password", flag.ExitOnError)
+		storageFlags(flags, &cfg)
+		email := flags.String("email", "", "email of the user")
+		password := flags.String("password", "", "new password, read from stdin when empty")
+		flags.Parse(args)
+
+		if *email == "" {
+			return errors.New("-email is required")
+		}
+		body := userPassword{}
+		body.Password, err = readPassword(*password)
+		if err != nil {
+			return err
+		}
+		err = validation.Struct(body)
+		if err != nil {
+			return err
+		}
+		repos := newRepositories(cfg)
+		user, err := repos.Users.GetByEmail(ctx, strings.TrimSpace(*email))
+		if err != nil {
+			return fmt.Errorf("user %s: %w", *email, err)
+		}
+		user.Password = body.Password
+		_, err = repos.Users.Update(ctx, *user)
+		if err != nil {
+			return err
+		}
+		// a new password signs out every device
+		err = repos.Sessions.RevokeAllByUserId(ctx, user.Id.Hex(), "password reset")
+		if err != nil {
+			return fmt.Errorf("sign out sessions: %w", err)
+		}
+		fmt.Printf("password of %s is reset, their sessions are signed out\n", user.Email)
+		return nil
+	}
+	return fmt.Errorf("unknown user command %q\n%s", name, userUsage)
+}
@@ -0,0 +1,213 @@
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/go-playground/validator/v10"
+)
+
+// vintage years before this are refused
+const MIN_VINTAGE_YEAR = 1990
+
+var txHashRegex = regexp.MustCompile(`^0x[0-9a-fA-F]{64}$`)
+
+var validate = validator.New()
+
+// messages of the registered rules, %s is the field
+var messages = map[string]string{}
+
+// FieldError is why one field of a request is invalid
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Errors is returned by Struct when fields are invalid
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	texts := []string{}
+	for _, v := range e {
+		texts = append(texts, v.Message)
+	}
+	return strings.Join(texts, ", ")
+}
+
+func init() {
+	// fields are named as clients send them
+	validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		for _, tag := range []string{"json", "form"} {
+			name := strings.SplitN(field.Tag.Get(tag), ",", 2)[0]
+			if name == "-" {
+				return ""
+			}
+			if name != "" {
+				return name
+			}
+		}
+		return field.Name
+	})
+	Register("thai_tax_id", "%s must be a 13 digit Thai tax ID with a valid check digit", IsThaiTaxID)
+	Register("evm_address", "%s must be a 0x address with a valid EIP-55 checksum", IsEVMAddress)
+	Register("tx_hash", "%s must be a 0x transaction hash", txHashRegex.MatchString)
+	Register("http_url", "%s must be an http or https url", IsHTTPURL)
+	// the message depends on the year, see message
+	Register("vintage_year", "", IsVintageYear)
+}
+
+// Register adds a rule for string fields, it is used as a validate tag.
+// message is shown when the rule is broken, %s is the field.
+func Register(tag string, message string, fn func(value string) bool) {
+	if message != "" {
+		messages[tag] = message
+	}
+	err := validate.RegisterValidation(tag, func(fl validator.FieldLevel) bool {
+		return fn(fl.Field().String())
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Struct checks the validate tags of s, a pointer to a request struct. Broken
+// rules are returned as Errors.
+func Struct(s interface{}) error {
+	err := validate.Struct(s)
+	if err == nil {
+		return nil
+	}
+	validationErrors := validator.ValidationErrors{}
+	if !errors.As(err, &validationErrors) {
+		return err
+	}
+	fields := Errors{}
+	for _, v := range validationErrors {
+		fields = append(fields, FieldError{
+			Field:   fieldName(v),
+			Rule:    v.Tag(),
+			Message: message(v),
+		})
+	}
+	return fields
+}
+
+// fieldName is the path of the field without the struct name, e.g.
+// "routes[0]"
+func fieldName(fe validator.FieldError) string {
+	namespace := fe.Namespace()
+	if i := strings.Index(namespace, "."); i >= 0 {
+		return namespace[i+1:]
+	}
+	return fe.Field()
+}
+
+func message(fe validator.FieldError) string {
+	field := fieldName(fe)
+	if format, ok := messages[fe.Tag()]; ok {
+		return fmt.Sprintf(format, field)
+	}
+	switch fe.Tag() {
+	case "required", "required_if":
+		return fmt.Sprintf("%s is required", field)
+	case "email":
+		return fmt.Sprintf("%s must be an email address", field)
+	case "e164":
+		return fmt.Sprintf("%s must be a phone number in E.164 format such as +66812345678", field)
+	case "vintage_year":
+		return fmt.Sprintf("%s must be a year from %d to %d", field, MIN_VINTAGE_YEAR, time.Now().Year())
+	case "oneof":
+		return fmt.Sprintf("%s must be one of %s", field, strings.ReplaceAll(fe.Param(), " ", ", "))
+	case "min", "max":
+		bound := "at least"
+		if fe.Tag() == "max" {
+			bound = "at most"
+		}
+		switch fe.Kind() {
+		case reflect.String:
+			return fmt.Sprintf("%s must have %s %s characters", field, bound, fe.Param())
+		case reflect.Slice, reflect.Map:
+			return fmt.Sprintf("%s must have %s %s items", field, bound, fe.Param())
+		}
+		return fmt.Sprintf("%s must be %s %s", field, bound, fe.Param())
+	case "number":
+		return fmt.Sprintf("%s must contain only digits", field)
+	case "url":
+		return fmt.Sprintf("%s must be an http or https url", field)
+	case "hexadecimal":
+		return fmt.Sprintf("%s must be hexadecimal", field)
+	}
+	return fmt.Sprintf("%s is invalid", field)
+}
+
+// IsThaiTaxID checks the check digit of a 13 digit Thai tax ID, which is
+// also the citizen ID of a person
+func IsThaiTaxID(taxID string) bool {
+	if len(taxID) != 13 {
+		return false
+	}
+	sum := 0
+	for i := 0; i < 13; i++ {
+		if taxID[i] < '0' || taxID[i] > '9' {
+			return false
+		}
+		if i < 12 {
+			sum += int(taxID[i]-'0') * (13 - i)
+		}
+	}
+	return (11-sum%11)%10 == int(taxID[12]-'0')
+}
+
+// IsEVMAddress accepts all lowercase or all uppercase addresses, mixed case
+// must match the EIP-55 checksum
+func IsEVMAddress(address string) bool {
+	if !common.IsHexAddress(address) || !strings.HasPrefix(address, "0x") {
+		return false
+	}
+	hex := address[2:]
+	if hex == strings.ToLower(hex) || hex == strings.ToUpper(hex) {
+		return true
+	}
+	return common.HexToAddress(address).Hex() == address
+}
+
+// IsHTTPURL accepts absolute http and https urls
+func IsHTTPURL(rawURL string) bool {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	return (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+}
+
+// IsVintageYear accepts years from MIN_VINTAGE_YEAR to this year
+func IsVintageYear(year string) bool {
+	number, err := strconv.Atoi(year)
+	if err != nil || len(year) != 4 {
+		return false
+	}
+	return number >= MIN_VINTAGE_YEAR && number <= time.Now().Year()
+}
+
+// NormalizeTaxID drops the dashes and spaces people type in tax IDs
+func NormalizeTaxID(taxID string) string {
+	return strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(taxID))
+}
+
+// NormalizePhone drops separators and turns a Thai local number such as
+// 081-234-5678 into +66812345678, other numbers are returned as they are
+func NormalizePhone(phone string) string {
+	phone = strings.NewReplacer("-", "", " ", "", "(", "", ")", "").Replace(strings.TrimSpace(phone))
+	if strings.HasPrefix(phone, "0") && (len(phone) == 9 || len(phone) == 10) {
+		return "+66" + phone[1:]
+	}
+	return phone
+}
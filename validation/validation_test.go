@@ -0,0 +1,110 @@
+package validation_test
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/seenark/super-backend-temp/validation"
+)
+
+func TestIsThaiTaxID(t *testing.T) {
+	tests := []struct {
+		taxID string
+		want  bool
+	}{
+		{"1234567890121", true},
+		{"0105536000313", true},
+		{"1234567890120", false}, // bad check digit
+		{"123456789012", false},
+		{"12345678901210", false},
+		{"123456789012a", false},
+		{"1-2345-67890-12-1", false}, // not normalized
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := validation.IsThaiTaxID(tt.taxID); got != tt.want {
+			t.Errorf("IsThaiTaxID(%q) = %v, want %v", tt.taxID, got, tt.want)
+		}
+	}
+}
+
+func TestIsEVMAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		want    bool
+	}{
+		{"0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed", true},
+		{"0x5AAEB6053F3E94C9B9A09F33669435E7EF1BEAED", true},
+		{"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", true},
+		{"0x5aAeb6053f3E94C9b9A09f33669435E7Ef1BeAed", false}, // broken checksum
+		{"5aaeb6053f3e94c9b9a09f33669435e7ef1beaed", false},
+		{"0x5aaeb6053f3e94c9b9a09f33669435e7ef1bea", false},
+		{"0x5aaeb6053f3e94c9b9a09f33669435e7ef1beazz", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := validation.IsEVMAddress(tt.address); got != tt.want {
+			t.Errorf("IsEVMAddress(%q) = %v, want %v", tt.address, got, tt.want)
+		}
+	}
+}
+
+func TestIsVintageYear(t *testing.T) {
+	thisYear := time.Now().Year()
+	tests := []struct {
+		year string
+		want bool
+	}{
+		{strconv.Itoa(validation.MIN_VINTAGE_YEAR), true},
+		{strconv.Itoa(validation.MIN_VINTAGE_YEAR - 1), false},
+		{strconv.Itoa(thisYear), true},
+		{strconv.Itoa(thisYear + 1), false},
+		{"02022", false},
+		{"22", false},
+		{"year", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := validation.IsVintageYear(tt.year); got != tt.want {
+			t.Errorf("IsVintageYear(%q) = %v, want %v", tt.year, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizePhone(t *testing.T) {
+	tests := []struct {
+		phone string
+		want  string
+	}{
+		{"081-234-5678", "+66812345678"},
+		{" 02 123 4567 ", "+6621234567"},
+		{"(081) 234 5678", "+66812345678"},
+		{"+66812345678", "+66812345678"},
+		{"+1 (415) 555-0100", "+14155550100"},
+		{"0812", "0812"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := validation.NormalizePhone(tt.phone); got != tt.want {
+			t.Errorf("NormalizePhone(%q) = %q, want %q", tt.phone, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeTaxID(t *testing.T) {
+	tests := []struct {
+		taxID string
+		want  string
+	}{
+		{"1-2345-67890-12-1", "1234567890121"},
+		{" 1 2345 67890 12 1 ", "1234567890121"},
+		{"1234567890121", "1234567890121"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := validation.NormalizeTaxID(tt.taxID); got != tt.want {
+			t.Errorf("NormalizeTaxID(%q) = %q, want %q", tt.taxID, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/seenark/super-backend-temp/config"
+	"github.com/seenark/super-backend-temp/repository"
+)
+
+// only the start of a response is kept in the delivery log
+const maxResponseBody = 2048
+
+// Payload is the JSON body of every delivery
+type Payload struct {
+	Id        string      `json:"id"`
+	Type      string      `json:"type"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// Dispatcher is the outbox handler of webhooks. A subscribed event is fanned
+// out to one webhook.delivery event per webhook, so every webhook is retried
+// with backoff by the outbox worker on its own.
+type Dispatcher struct {
+	webhookDb  repository.WebhookRepository
+	deliveryDb repository.WebhookDeliveryRepository
+	outboxDb   repository.OutboxRepository
+	client     *http.Client
+}
+
+func NewDispatcher(cfg config.WebhookConfig, webhookDb repository.WebhookRepository, deliveryDb repository.WebhookDeliveryRepository, outboxDb repository.OutboxRepository) *Dispatcher {
+	timeout := time.Duration(cfg.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	return &Dispatcher{
+		webhookDb:  webhookDb,
+		deliveryDb: deliveryDb,
+		outboxDb:   outboxDb,
+		client:     &http.Client{Timeout: timeout},
+	}
+}
+
+// Name implements outbox.Handler
+func (d *Dispatcher) Name() string {
+	return "webhook"
+}
+
+// Handle implements outbox.Handler
+func (d *Dispatcher) Handle(ctx context.Context, event repository.OutboxEvent) error {
+	if event.Type == repository.WEBHOOK_DELIVERY_EVENT {
+		return d.deliver(ctx, event)
+	}
+	if !IsWebhookEvent(event.Type) {
+		return nil
+	}
+	return d.fanOut(ctx, event)
+}
+
+// IsWebhookEvent reports whether webhooks can subscribe to eventType
+func IsWebhookEvent(eventType string) bool {
+	for _, v := range repository.WebhookEvents {
+		if v == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Dispatcher) fanOut(ctx context.Context, event repository.OutboxEvent) error {
+	webhooks, err := d.webhookDb.GetByEvent(ctx, event.Type)
+	if err != nil {
+		return err
+	}
+	if len(webhooks) == 0 {
+		return nil
+	}
+	payload, err := NewPayload(event)
+	if err != nil {
+		return err
+	}
+	deliveries := []repository.OutboxEvent{}
+	for _, v := range webhooks {
+		delivery, err := repository.NewOutboxEvent(repository.WEBHOOK_DELIVERY_EVENT, v.Id.Hex(), repository.WebhookDeliveryData{
+			WebhookId: v.Id,
+			EventId:   event.Id.Hex(),
+			EventType: event.Type,
+			Payload:   string(payload),
+		})
+		if err != nil {
+			return err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return d.outboxDb.Add(ctx, deliveries...)
+}
+
+// NewPayload is the body sent for an outbox event, data is encoded with the
+// json names the API uses
+func NewPayload(event repository.OutboxEvent) ([]byte, error) {
+	var data interface{}
+	switch event.Type {
+	case repository.METADATA_UPDATED_EVENT:
+		data = &repository.Metadata{}
+	case repository.CERT_TYPE_CREATED_EVENT:
+		data = &repository.DigitalCertType{}
+	default:
+		data = &repository.RedeemedEventData{}
+	}
+	err := event.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(Payload{
+		Id:        event.Id.Hex(),
+		Type:      event.Type,
+		CreatedAt: event.CreatedAt,
+		Data:      data,
+	})
+}
+
+// deliver posts one webhook.delivery event and logs the attempt, an error
+// makes the outbox worker retry it
+func (d *Dispatcher) deliver(ctx context.Context, event repository.OutboxEvent) error {
+	data := repository.WebhookDeliveryData{}
+	err := event.Decode(&data)
+	if err != nil {
+		return err
+	}
+	webhook, err := d.webhookDb.GetById(ctx, data.WebhookId.Hex())
+	if err != nil || !webhook.Active {
+		// deleted or disabled since, nothing to deliver
+		return nil
+	}
+
+	delivery := repository.WebhookDelivery{
+		WebhookId:     webhook.Id,
+		OutboxEventId: event.Id,
+		EventId:       data.EventId,
+		EventType:     data.EventType,
+		Url:           webhook.Url,
+		Attempt:       event.Attempts,
+		Payload:       data.Payload,
+		ReplayOf:      data.ReplayOf,
+	}
+	start := time.Now()
+	deliverErr := d.post(ctx, *webhook, event.Id.Hex(), data, &delivery)
+	delivery.DurationMs = time.Since(start).Milliseconds()
+	delivery.Success = deliverErr == nil
+	if deliverErr != nil {
+		delivery.Error = deliverErr.Error()
+	}
+	_, err = d.deliveryDb.Create(ctx, delivery)
+	if err != nil {
+		fmt.Printf("log webhook delivery: %v\n", err)
+	}
+	return deliverErr
+}
+
+func (d *Dispatcher) post(ctx context.Context, webhook repository.Webhook, deliveryId string, data repository.WebhookDeliveryData, delivery *repository.WebhookDelivery) error {
+	body := []byte(data.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.Url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "super-energy-webhook")
+	req.Header.Set(EVENT_HEADER, data.EventType)
+	req.Header.Set(ID_HEADER, deliveryId)
+	req.Header.Set(TIMESTAMP_HEADER, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SIGNATURE_HEADER, Sign(webhook.Secret, timestamp, body))
+
+	res, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	response, _ := ioutil.ReadAll(io.LimitReader(res.Body, maxResponseBody))
+	delivery.StatusCode = res.StatusCode
+	delivery.ResponseBody = string(response)
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("webhook answered %d", res.StatusCode)
+	}
+	return nil
+}
+
+// Replay sends a logged delivery again, with a fresh signature and the same
+// payload
+func (d *Dispatcher) Replay(ctx context.Context, delivery repository.WebhookDelivery) (*repository.OutboxEvent, error) {
+	replayOf := delivery.Id
+	event, err := repository.NewOutboxEvent(repository.WEBHOOK_DELIVERY_EVENT, delivery.WebhookId.Hex(), repository.WebhookDeliveryData{
+		WebhookId: delivery.WebhookId,
+		EventId:   delivery.EventId,
+		EventType: delivery.EventType,
+		Payload:   delivery.Payload,
+		ReplayOf:  &replayOf,
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = d.outboxDb.Add(ctx, event)
+	if err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
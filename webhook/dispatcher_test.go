@@ -0,0 +1,286 @@
+package webhook_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/seenark/super-backend-temp/config"
+	"github.com/seenark/super-backend-temp/outbox"
+	"github.com/seenark/super-backend-temp/repository"
+	"github.com/seenark/super-backend-temp/webhook"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var ctx = context.Background()
+
+// received is one request the receiver got
+type received struct {
+	header http.Header
+	body   []byte
+}
+
+// receiver is a partner endpoint that answers the status codes of statuses
+// in turn, then 200
+type receiver struct {
+	*httptest.Server
+	mu       sync.Mutex
+	statuses []int
+	requests []received
+}
+
+func newReceiver(t *testing.T, statuses ...int) *receiver {
+	r := &receiver{statuses: statuses}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.requests = append(r.requests, received{header: req.Header.Clone(), body: body})
+		status := http.StatusOK
+		if len(r.statuses) > 0 {
+			status, r.statuses = r.statuses[0], r.statuses[1:]
+		}
+		w.WriteHeader(status)
+		w.Write([]byte("status " + strconv.Itoa(status)))
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *receiver) received() []received {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]received{}, r.requests...)
+}
+
+// retryNow retries a failed event at once instead of after a backoff
+type retryNow struct {
+	repository.OutboxRepository
+}
+
+func (r retryNow) Retry(ctx context.Context, id primitive.ObjectID, nextAttemptAt time.Time, lastError string) error {
+	return r.OutboxRepository.Retry(ctx, id, time.Now(), lastError)
+}
+
+type fixture struct {
+	repos      repository.Repositories
+	dispatcher *webhook.Dispatcher
+	worker     *outbox.Worker
+	receiver   *receiver
+	webhook    *repository.Webhook
+}
+
+func newFixture(t *testing.T, statuses ...int) *fixture {
+	t.Helper()
+	repos := repository.NewMemoryRepositories(repository.NewMemoryStore())
+	f := &fixture{repos: repos, receiver: newReceiver(t, statuses...)}
+	secret, err := webhook.NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.webhook, err = repos.Webhooks.Create(ctx, repository.Webhook{
+		Url:    f.receiver.URL,
+		Events: []string{repository.REDEEMED_CREATED_EVENT},
+		Secret: secret,
+		Active: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// not subscribed, it must never be called
+	_, err = repos.Webhooks.Create(ctx, repository.Webhook{
+		Url:    f.receiver.URL + "/other",
+		Events: []string{repository.METADATA_UPDATED_EVENT},
+		Secret: secret,
+		Active: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.dispatcher = webhook.NewDispatcher(config.WebhookConfig{Timeout: 5}, repos.Webhooks, repos.WebhookDeliveries, repos.Outbox)
+	f.worker = outbox.NewWorker(retryNow{repos.Outbox}, config.OutboxConfig{MaxAttempts: 3}, f.dispatcher)
+	return f
+}
+
+func (f *fixture) deliveries(t *testing.T) []repository.WebhookDelivery {
+	t.Helper()
+	deliveries, _, err := f.repos.WebhookDeliveries.GetPage(ctx, f.webhook.Id, repository.PageQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return deliveries
+}
+
+// checkSigned verifies a request like a partner would and returns its payload
+func checkSigned(t *testing.T, secret string, r received) webhook.Payload {
+	t.Helper()
+	if r.header.Get(webhook.EVENT_HEADER) != repository.REDEEMED_CREATED_EVENT || r.header.Get(webhook.ID_HEADER) == "" {
+		t.Fatalf("headers %v", r.header)
+	}
+	if r.header.Get("Content-Type") != "application/json" {
+		t.Fatalf("content type %q", r.header.Get("Content-Type"))
+	}
+	err := webhook.Verify(secret, r.header.Get(webhook.TIMESTAMP_HEADER), r.header.Get(webhook.SIGNATURE_HEADER), r.body, 5*time.Minute)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	payload := webhook.Payload{}
+	err = json.Unmarshal(r.body, &payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return payload
+}
+
+func TestDeliver(t *testing.T) {
+	f := newFixture(t, http.StatusInternalServerError)
+	_, err := f.repos.Redeemed.Upsert(ctx, repository.Redeemed{TxHash: "0x1", RedeemId: 7, WalletAddress: "0xabc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.worker.ProcessDue(ctx)
+	if err != nil {
+		t.Fatalf("ProcessDue: %v", err)
+	}
+
+	// the 500 is retried with the same body and delivery id
+	requests := f.receiver.received()
+	if len(requests) != 2 {
+		t.Fatalf("%d requests, want 2", len(requests))
+	}
+	first := checkSigned(t, f.webhook.Secret, requests[0])
+	checkSigned(t, f.webhook.Secret, requests[1])
+	if string(requests[0].body) != string(requests[1].body) || requests[0].header.Get(webhook.ID_HEADER) != requests[1].header.Get(webhook.ID_HEADER) {
+		t.Fatal("the retry is not the same delivery")
+	}
+	if first.Type != repository.REDEEMED_CREATED_EVENT || first.Id == "" {
+		t.Fatalf("payload %+v", first)
+	}
+	data := first.Data.(map[string]interface{})["redeemed"].(map[string]interface{})
+	if data["redeem_id"] != float64(7) || data["tx_hash"] != "0x1" {
+		t.Fatalf("payload data %v", data)
+	}
+
+	deliveries := f.deliveries(t)
+	if len(deliveries) != 2 {
+		t.Fatalf("%d deliveries logged, want 2", len(deliveries))
+	}
+	byAttempt := map[int]repository.WebhookDelivery{}
+	for _, v := range deliveries {
+		byAttempt[v.Attempt] = v
+		if v.EventId != first.Id || v.Url != f.receiver.URL || v.Payload != string(requests[0].body) {
+			t.Fatalf("delivery %+v", v)
+		}
+	}
+	if failed := byAttempt[1]; failed.Success || failed.StatusCode != 500 || failed.ResponseBody != "status 500" || failed.Error == "" {
+		t.Fatalf("attempt 1 = %+v", failed)
+	}
+	if ok := byAttempt[2]; !ok.Success || ok.StatusCode != 200 || ok.Error != "" {
+		t.Fatalf("attempt 2 = %+v", ok)
+	}
+
+	// a replay is signed again and logged as one
+	_, err = f.dispatcher.Replay(ctx, byAttempt[2])
+	if err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	err = f.worker.ProcessDue(ctx)
+	if err != nil {
+		t.Fatalf("ProcessDue: %v", err)
+	}
+	requests = f.receiver.received()
+	if len(requests) != 3 {
+		t.Fatalf("%d requests, want 3", len(requests))
+	}
+	replayed := checkSigned(t, f.webhook.Secret, requests[2])
+	if replayed.Id != first.Id || string(requests[2].body) != string(requests[0].body) {
+		t.Fatalf("replayed %+v", replayed)
+	}
+	if requests[2].header.Get(webhook.ID_HEADER) == requests[0].header.Get(webhook.ID_HEADER) {
+		t.Fatal("a replay has the delivery id of the original")
+	}
+	deliveries = f.deliveries(t)
+	replays := 0
+	for _, v := range deliveries {
+		if v.ReplayOf != nil {
+			replays++
+			if *v.ReplayOf != byAttempt[2].Id || !v.Success {
+				t.Fatalf("replay %+v", v)
+			}
+		}
+	}
+	if len(deliveries) != 3 || replays != 1 {
+		t.Fatalf("deliveries %+v", deliveries)
+	}
+}
+
+func TestDeliverGivesUp(t *testing.T) {
+	f := newFixture(t, http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway)
+	_, err := f.repos.Redeemed.Upsert(ctx, repository.Redeemed{TxHash: "0x1", RedeemId: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.worker.ProcessDue(ctx)
+	if err != nil {
+		t.Fatalf("ProcessDue: %v", err)
+	}
+	if n := len(f.receiver.received()); n != 3 {
+		t.Fatalf("%d requests, want 3", n)
+	}
+	for _, v := range f.deliveries(t) {
+		if v.Success || v.StatusCode != http.StatusBadGateway {
+			t.Fatalf("delivery %+v", v)
+		}
+	}
+}
+
+func TestFanOutSkipsOtherEvents(t *testing.T) {
+	f := newFixture(t)
+	_, err := f.repos.CertTypes.Create(ctx, "REC", "Renewable energy", "energy", "MWh", "2021", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.worker.ProcessDue(ctx)
+	if err != nil {
+		t.Fatalf("ProcessDue: %v", err)
+	}
+	if n := len(f.receiver.received()); n != 0 {
+		t.Fatalf("%d requests for an event nobody subscribed to", n)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	body := []byte(`{"id":"1"}`)
+	now := time.Now().Unix()
+	signature := webhook.Sign("whsec_1", now, body)
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp string
+		signature string
+		body      []byte
+		err       error
+	}{
+		{"valid", "whsec_1", strconv.FormatInt(now, 10), signature, body, nil},
+		{"other secret", "whsec_2", strconv.FormatInt(now, 10), signature, body, webhook.ErrInvalidSignature},
+		{"changed body", "whsec_1", strconv.FormatInt(now, 10), signature, []byte(`{"id":"2"}`), webhook.ErrInvalidSignature},
+		{"changed timestamp", "whsec_1", strconv.FormatInt(now-1, 10), signature, body, webhook.ErrInvalidSignature},
+		{"too old", "whsec_1", strconv.FormatInt(now-600, 10), webhook.Sign("whsec_1", now-600, body), body, webhook.ErrInvalidSignature},
+		{"not a timestamp", "whsec_1", "yesterday", signature, body, webhook.ErrInvalidSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := webhook.Verify(tt.secret, tt.timestamp, tt.signature, tt.body, 5*time.Minute)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Verify = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
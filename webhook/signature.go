@@ -0,0 +1,63 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// headers of every delivery
+const (
+	EVENT_HEADER     = "X-Webhook-Event"
+	ID_HEADER        = "X-Webhook-Id"
+	TIMESTAMP_HEADER = "X-Webhook-Timestamp"
+	SIGNATURE_HEADER = "X-Webhook-Signature"
+)
+
+const secretPrefix = "whsec_"
+
+var ErrInvalidSignature = errors.New("webhook signature is invalid")
+
+// NewSecret returns a random signing secret for a new webhook
+func NewSecret() (string, error) {
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", err
+	}
+	return secretPrefix + hex.EncodeToString(secret), nil
+}
+
+// Sign returns the SIGNATURE_HEADER value of body sent at timestamp:
+// "sha256=" and the hex HMAC-SHA256 of "<timestamp>.<body>". The timestamp is
+// signed too so a captured delivery can not be replayed later.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a delivery the way a receiver should, it rejects signatures
+// older than tolerance
+func Verify(secret string, timestampHeader string, signatureHeader string, body []byte, tolerance time.Duration) error {
+	timestamp, err := strconv.ParseInt(timestampHeader, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	age := time.Since(time.Unix(timestamp, 0))
+	if tolerance > 0 && (age > tolerance || age < -tolerance) {
+		return ErrInvalidSignature
+	}
+	expected := Sign(secret, timestamp, body)
+	if !hmac.Equal([]byte(expected), []byte(strings.TrimSpace(signatureHeader))) {
+		return ErrInvalidSignature
+	}
+	return nil
+}